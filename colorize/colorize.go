@@ -1,6 +1,9 @@
 package colorize
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+)
 
 const (
 	esc   = "\u001B["
@@ -9,8 +12,20 @@ const (
 	reset = esc + "m"
 )
 
-func Bold(s string) string { return bold + s + reset }
-func Warn(s string) string { return warn + s + reset }
+// Colors are disabled when NO_COLOR is set to a non-empty value.
+//
+// See https://no-color.org.
+func enabled() bool { return os.Getenv("NO_COLOR") == "" }
+
+func Bold(s string) string { return style(bold, s) }
+func Warn(s string) string { return style(warn, s) }
+
+func style(code, s string) string {
+	if !enabled() {
+		return s
+	}
+	return code + s + reset
+}
 
 func Boldf(msg string, a ...any) string { return Bold(fmt.Sprintf(msg, a...)) }
 func Warnf(msg string, a ...any) string { return Warn(fmt.Sprintf(msg, a...)) }
@@ -6,6 +6,7 @@ require (
 	github.com/Masterminds/semver/v3 v3.2.0
 	github.com/briandowns/spinner v1.20.0
 	github.com/hexops/autogold/v2 v2.2.1
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/pulumi/pulumi/sdk/v3 v3.87.0
 	github.com/spf13/cobra v1.7.0
 	github.com/spf13/pflag v1.0.5
@@ -42,12 +43,11 @@ require (
 	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/mitchellh/mapstructure v1.5.0 // indirect
 	github.com/pelletier/go-toml/v2 v2.0.6 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/spf13/afero v1.9.5 // indirect
 	github.com/spf13/cast v1.5.0 // indirect
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/subosito/gotenv v1.4.2 // indirect
 	golang.org/x/sys v0.20.0 // indirect
-	golang.org/x/term v0.11.0 // indirect
+	golang.org/x/term v0.11.0
 	golang.org/x/text v0.12.0 // indirect
 )
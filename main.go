@@ -6,9 +6,15 @@ import (
 	"fmt"
 	"go/build"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime/debug"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	semver "github.com/Masterminds/semver/v3"
 	"github.com/spf13/cobra"
@@ -18,6 +24,9 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
 
 	"github.com/pulumi/upgrade-provider/colorize"
+	"github.com/pulumi/upgrade-provider/step/redact"
+	"github.com/pulumi/upgrade-provider/step/trace"
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
 	"github.com/pulumi/upgrade-provider/upgrade"
 )
 
@@ -98,17 +107,29 @@ func cmd() *cobra.Command {
 	var repoName string
 	var repoOrg string
 	var repoPath string
+	var stepTimeouts []string
+	var prTitleTemplate, prBodyTemplate, branchTemplate string
+	var redactPatterns []string
 
 	ctx := context.Background()
 	context := upgrade.Context{GoPath: gopath}
 
+	reportError := func(err error) {
+		var diagnostics upgrade.DiagnosticsError
+		switch {
+		case !errors.Is(err, upgrade.ErrHandled):
+			fmt.Printf("error: %s\n", err.Error())
+		case errors.As(err, &diagnostics):
+			// The error itself has already been displayed, but we still need to
+			// tell the user where to find the diagnostics.
+			fmt.Printf("diagnostics written to %s\n", diagnostics.Path)
+		}
+	}
 	exitOnError := func(err error) {
 		if err == nil {
 			return
 		}
-		if !errors.Is(err, upgrade.ErrHandled) {
-			fmt.Printf("error: %s\n", err.Error())
-		}
+		reportError(err)
 		os.Exit(1)
 	}
 
@@ -117,107 +138,172 @@ func cmd() *cobra.Command {
 	// displayed, we can set failedPreRun and return. Run will immediately fail with
 	// this error.
 	var failedPreRun error
-	cmd := &cobra.Command{
-		Use:   "upgrade-provider <provider>",
-		Short: "upgrade-provider automates the process of upgrading a TF-bridged provider",
-		Long: fmt.Sprintf(
-			"upgrade-provider automates the process of upgrading a TF-bridged provider\n\nVersion: %s",
-			buildVersion()),
-		Version: buildVersion(),
-		Args:    cobra.ExactArgs(1),
-		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			err := initializeConfig(cmd)
-			if err != nil {
-				failedPreRun = err
-				return nil
+	configure := func(cmd *cobra.Command, args []string) error {
+		err := initializeConfig(cmd)
+		if err != nil {
+			failedPreRun = err
+			return nil
+		}
+		// Validate argument is {org}/{repo}
+		tok := strings.Split(args[0], "/")
+		if len(tok) != 2 {
+			return errors.New("argument must be provided as {org}/{repo}")
+		}
+		repoOrg, repoName = tok[0], tok[1]
+		// repo name should start with 'pulumi-'
+		if !strings.HasPrefix(repoName, "pulumi-") {
+			return errors.New("{repo} must start with `pulumi-`")
+		}
+		// Require `upstream-provider-name` to be set
+		if context.UpstreamProviderName == "" {
+			return errors.New("`upstream-provider-name` must be provided")
+		} else if strings.ContainsRune(context.UpstreamProviderName, '/') {
+			var s string
+			if split := strings.Split(context.UpstreamProviderName, "/"); len(split) > 1 {
+				s = fmt.Sprintf(": try %q", split[len(split)-1])
 			}
-			// Validate argument is {org}/{repo}
-			tok := strings.Split(args[0], "/")
-			if len(tok) != 2 {
-				return errors.New("argument must be provided as {org}/{repo}")
+			return fmt.Errorf(`"upstream-provider-name" must not be fully qualified%s`, s)
+		}
+
+		// Validate that targetVersion is a valid version
+		if targetVersion != "" {
+			context.TargetVersion, err = semver.NewVersion(targetVersion)
+			if err != nil {
+				return fmt.Errorf("--target-version=%s: %w",
+					targetVersion, err)
 			}
-			repoOrg, repoName = tok[0], tok[1]
-			// repo name should start with 'pulumi-'
-			if !strings.HasPrefix(repoName, "pulumi-") {
-				return errors.New("{repo} must start with `pulumi-`")
+		}
+
+		// This can happen by calling `upgrade-provider --kind=""`
+		if len(upgradeKind) == 0 {
+			return fmt.Errorf("--kind=\"\" is invalid. Must be one of `all`, " +
+				"`bridge`, `provider`, or `pulumi`")
+		}
+
+		// Validate the kind switch
+		var warnedAll bool
+		for _, kind := range upgradeKind {
+			warn := func(msg string, a ...any) {
+				fmt.Println(colorize.Warn(fmt.Sprintf(msg, a...)))
 			}
-			// Require `upstream-provider-name` to be set
-			if context.UpstreamProviderName == "" {
-				return errors.New("`upstream-provider-name` must be provided")
-			} else if strings.ContainsRune(context.UpstreamProviderName, '/') {
-				var s string
-				if split := strings.Split(context.UpstreamProviderName, "/"); len(split) > 1 {
-					s = fmt.Sprintf(": try %q", split[len(split)-1])
+			set := func(v *bool) {
+				if *v && !warnedAll {
+					warn("Duplicate `--kind` argument: %s", kind)
 				}
-				return fmt.Errorf(`"upstream-provider-name" must not be fully qualified%s`, s)
+				*v = true
 			}
 
-			// Validate that targetVersion is a valid version
-			if targetVersion != "" {
-				context.TargetVersion, err = semver.NewVersion(targetVersion)
-				if err != nil {
-					return fmt.Errorf("--target-version=%s: %w",
-						targetVersion, err)
+			switch kind {
+			case "all":
+				context.UpgradeBridgeVersion = true
+				context.UpgradeProviderVersion = true
+			case "bridge":
+				set(&context.UpgradeBridgeVersion)
+			case "provider":
+				set(&context.UpgradeProviderVersion)
+			case "pulumi":
+			case "check-upstream-version":
+				if targetVersion != "" {
+					return fmt.Errorf(
+						"--kind check-upstream-version is incompatible with --target-version, cannot set both",
+					)
 				}
-			}
+				set(&context.UpgradeProviderVersion)
+				set(&context.OnlyCheckUpstream)
 
-			// This can happen by calling `upgrade-provider --kind=""`
-			if len(upgradeKind) == 0 {
-				return fmt.Errorf("--kind=\"\" is invalid. Must be one of `all`, " +
-					"`bridge`, `provider`, or `pulumi`")
+			default:
+				return fmt.Errorf(
+					"--kind=%s invalid. Must be one of `all`, `bridge`, `provider`, or `pulumi`",
+					upgradeKind)
 			}
+		}
+		// Set repoPath if specified
+		context.SetRepoPath(repoPath)
 
-			// Validate the kind switch
-			var warnedAll bool
-			for _, kind := range upgradeKind {
-				warn := func(msg string, a ...any) {
-					fmt.Println(colorize.Warn(fmt.Sprintf(msg, a...)))
-				}
-				set := func(v *bool) {
-					if *v && !warnedAll {
-						warn("Duplicate `--kind` argument: %s", kind)
-					}
-					*v = true
-				}
+		context.StepTimeouts, err = parseStepTimeouts(stepTimeouts)
+		if err != nil {
+			return err
+		}
 
-				switch kind {
-				case "all":
-					context.UpgradeBridgeVersion = true
-					context.UpgradeProviderVersion = true
-				case "bridge":
-					set(&context.UpgradeBridgeVersion)
-				case "provider":
-					set(&context.UpgradeProviderVersion)
-				case "pulumi":
-				case "check-upstream-version":
-					if targetVersion != "" {
-						return fmt.Errorf(
-							"--kind check-upstream-version is incompatible with --target-version, cannot set both",
-						)
-					}
-					set(&context.UpgradeProviderVersion)
-					set(&context.OnlyCheckUpstream)
-
-				default:
-					return fmt.Errorf(
-						"--kind=%s invalid. Must be one of `all`, `bridge`, `provider`, or `pulumi`",
-						upgradeKind)
-				}
+		for _, host := range []struct{ flag, value string }{
+			{"provider-host", context.ProviderHost},
+			{"upstream-host", context.UpstreamHost},
+			{"bridge-host", context.BridgeHost},
+			{"raw-host", context.RawHost},
+		} {
+			if host.value == "" || strings.Contains(host.value, "://") {
+				return fmt.Errorf("--%s=%s invalid. Must be a host without a scheme, such as `github.com`",
+					host.flag, host.value)
+			}
+		}
+
+		if context.PushRemote != "" {
+			owner, name, hasName := strings.Cut(context.PushRemote, "/")
+			if owner == "" || (hasName && (name == "" || strings.Contains(name, "/"))) {
+				return fmt.Errorf("--push-remote=%s invalid. Must be `owner` or `owner/name`",
+					context.PushRemote)
 			}
-			// Set repoPath if specified
-			context.SetRepoPath(repoPath)
+		}
 
-			if context.TargetVersion != nil && !context.UpgradeProviderVersion {
-				return fmt.Errorf(
-					"cannot specify the provider version unless the provider will be upgraded")
+		for _, t := range []struct {
+			flag, path string
+			text       *string
+		}{
+			{"pr-title-template", prTitleTemplate, &context.PRTitleTemplate},
+			{"pr-body-template", prBodyTemplate, &context.PRBodyTemplate},
+			{"branch-template", branchTemplate, &context.BranchTemplate},
+		} {
+			if t.path == "" {
+				continue
 			}
-			return nil
-		},
-		Run: func(_ *cobra.Command, args []string) {
-			exitOnError(failedPreRun)
-			err := upgrade.UpgradeProvider(context.Wrap(ctx), repoOrg, repoName)
-			exitOnError(err)
-		},
+			text, err := os.ReadFile(t.path)
+			if err != nil {
+				return fmt.Errorf("--%s=%s: %w", t.flag, t.path, err)
+			}
+			if err := upgrade.ParseTemplate(t.flag, string(text)); err != nil {
+				return fmt.Errorf("--%s=%s: %w", t.flag, t.path, err)
+			}
+			*t.text = string(text)
+		}
+
+		context.Redactor, err = redact.New(redactPatterns...)
+		if err != nil {
+			return fmt.Errorf("--redact: %w", err)
+		}
+
+		if !slices.Contains(trace.Formats, context.TraceFormat) {
+			return fmt.Errorf("--trace-format=%s invalid. Must be one of `%s`",
+				context.TraceFormat, strings.Join(trace.Formats, "`, `"))
+		}
+
+		if context.TargetVersion != nil && !context.UpgradeProviderVersion {
+			return fmt.Errorf(
+				"cannot specify the provider version unless the provider will be upgraded")
+		}
+		return nil
+	}
+
+	// runUpgrade runs the upgrade that configure validated, within envs. args is the
+	// upgrade's command line, as in os.Args.
+	runUpgrade := func(args []string, envs ...stepv2.Env) error {
+		if failedPreRun != nil {
+			return failedPreRun
+		}
+		ctx, stop := interruptContext(stepv2.WithEnv(ctx, envs...))
+		defer stop()
+		return upgrade.UpgradeProvider(context.Wrap(ctx), repoOrg, repoName, args)
+	}
+
+	cmd := &cobra.Command{
+		Use:   "upgrade-provider <provider>",
+		Short: "upgrade-provider automates the process of upgrading a TF-bridged provider",
+		Long: fmt.Sprintf(
+			"upgrade-provider automates the process of upgrading a TF-bridged provider\n\nVersion: %s",
+			buildVersion()),
+		Version:           buildVersion(),
+		Args:              cobra.ExactArgs(1),
+		PersistentPreRunE: configure,
+		Run:               func(_ *cobra.Command, args []string) { exitOnError(runUpgrade(os.Args)) },
 	}
 
 	pulumiDev, _ := strconv.ParseBool(os.Getenv("PULUMI_DEV"))
@@ -251,6 +337,14 @@ we take '--target-version' to cap the inferred version. [Hidden behind PULUMI_DE
 	boolFlag(cmd.PersistentFlags(), &context.AllowMajorVersionBump, "allow-major", false,
 		`Allow the provider to upgrade to a new major version when one is available.`)
 
+	boolFlag(cmd.PersistentFlags(), &context.BlockBreakingChanges, "block-breaking-changes", false,
+		`Fail when the regenerated schema has breaking changes but the provider's major version
+is not bumped. Otherwise, the breaking changes are only reported.`)
+
+	boolFlag(cmd.PersistentFlags(), &context.BlockUnmappedResources, "block-unmapped-resources", false,
+		`Fail when tfgen reports upstream resources or data sources that the provider does not
+map. Otherwise, they are only reported.`)
+
 	kindMsg := `The kind of upgrade to perform:
 
 - "all": Upgrade the upstream provider and the bridge. Shorthand for "bridge,provider".
@@ -270,6 +364,20 @@ Required unless running from provider root and set in upgrade-config.yml.`)
 	cmd.PersistentFlags().StringVar(&context.UpstreamProviderOrg, "upstream-provider-org", "",
 		`The name of the upstream provider's GitHub organization'.`)
 
+	cmd.PersistentFlags().StringVar(&context.ProviderHost, "provider-host", "github.com",
+		`The host of the provider's repository, such as a GitHub Enterprise host. The GitHub
+API is reached through this host.`)
+
+	cmd.PersistentFlags().StringVar(&context.UpstreamHost, "upstream-host", "github.com",
+		`The host of the upstream provider's repository.`)
+
+	cmd.PersistentFlags().StringVar(&context.BridgeHost, "bridge-host", "github.com",
+		`The host of the pulumi/pulumi-terraform-bridge repository.`)
+
+	cmd.PersistentFlags().StringVar(&context.RawHost, "raw-host", "raw.githubusercontent.com",
+		`The host, optionally followed by a path, that serves raw files from the bridge
+repository. For GitHub Enterprise, this is "{host}/raw".`)
+
 	cmd.PersistentFlags().StringVar(&context.PrReviewers, "pr-reviewers", "",
 		`A comma separated list of reviewers to assign the upgrade PR to.`)
 
@@ -290,6 +398,23 @@ This is equivalent to setting PULUMI_MISSING_DOCS_ERROR=${! VALUE}.`)
 	cmd.PersistentFlags().StringVar(&context.PRTitlePrefix, "pr-title-prefix", "",
 		`The prefix to insert in the generated pull request title.`)
 
+	cmd.PersistentFlags().StringVar(&prTitleTemplate, "pr-title-template", "",
+		`A file holding a Go text/template for the pull request title.
+See the README for the data available to templates. The built-in title is used when unset.`)
+
+	cmd.PersistentFlags().StringVar(&prBodyTemplate, "pr-body-template", "",
+		`A file holding a Go text/template for the pull request body.
+See the README for the data available to templates. The built-in body is used when unset.`)
+
+	cmd.PersistentFlags().StringVar(&branchTemplate, "branch-template", "",
+		`A file holding a Go text/template for the name of the working branch.
+See the README for the data available to templates. The built-in name is used when unset.`)
+
+	cmd.PersistentFlags().StringVar(&context.PushRemote, "push-remote", "",
+		`A fork to push the upgrade branch to and open the PR from, as "owner" or "owner/name".
+The name defaults to the provider repository's name. The fork is created if it does not exist.
+By default, the branch is pushed to the provider repository itself.`)
+
 	// Both names write to the same field so the legacy alias cannot diverge from
 	// the canonical no-submit behavior.
 	boolFlag(cmd.PersistentFlags(), &context.NoSubmit, "no-submit", false,
@@ -300,6 +425,58 @@ This still modifies the local checkout, creates commits, and prints proposed sub
 		`Alias for --no-submit. This still modifies the local checkout and creates commits;
 it only skips remote submission.`)
 
+	cmd.PersistentFlags().DurationVar(&context.Timeout, "timeout", 0,
+		`The maximum duration of the whole upgrade, such as "2h". No limit is applied when unset.`)
+
+	cmd.PersistentFlags().StringSliceVar(&stepTimeouts, "step-timeout", nil,
+		`The maximum duration of a step, given as "name=duration", such as "make tfgen=45m".
+
+The name may be a step name or a command line. A command line matches every command it is
+a prefix of, so "make=1h" applies to each "make" invocation. May be repeated.`)
+
+	cmd.PersistentFlags().IntVar(&context.NetworkRetries, "network-retries", 4,
+		`The number of times to retry network operations (git ls-remote, git fetch, GitHub API
+calls and other HTTP requests) that fail with a transient error.`)
+
+	cmd.PersistentFlags().DurationVar(&context.NetworkRetryBackoff, "network-retry-backoff", time.Second,
+		`The delay before the first retry of a network operation. The nth retry waits n² times as long.`)
+
+	cmd.PersistentFlags().StringVar(&context.DiagnosticsDir, "diagnostics-dir", "",
+		`The directory to write a diagnostics archive to when the upgrade fails.
+Defaults to the system's temporary directory.`)
+
+	cmd.PersistentFlags().StringVar(&context.LogDir, "log-dir", "",
+		`The directory to write command logs to. Each run writes the full output of each
+command to its own file in a new subdirectory. Defaults to the system's temporary directory.`)
+
+	boolFlag(cmd.PersistentFlags(), &context.Verbose, "verbose", false,
+		`Show the latest output of running commands.`)
+
+	cmd.PersistentFlags().StringVar(&context.Events, "events", "",
+		`Write progress as newline-delimited JSON events to a file, or to an open file
+descriptor given as "fd:N". See the README for the event schema.`)
+
+	cmd.PersistentFlags().StringVar(&context.TraceOut, "trace-out", "",
+		`Write a trace of the time spent in each pipeline and step to a file, and print the
+slowest steps when the run ends.`)
+
+	cmd.PersistentFlags().StringVar(&context.TraceFormat, "trace-format", trace.FormatChrome,
+		`The format of --trace-out: "chrome" for the Chrome trace event format (viewable in
+Perfetto), or "otlp" for an OTLP JSON file.`)
+
+	cmd.PersistentFlags().StringVar(&context.SchemaDiffOut, "schema-diff-out", "",
+		`Write the changes to the provider's Pulumi schema to a file, as JSON. The changes are
+always summarized in the PR body.`)
+
+	cmd.PersistentFlags().StringArrayVar(&redactPatterns, "redact", nil,
+		`A regular expression whose matches are masked in replay recordings, command logs,
+diagnostics, --events and --trace-out. May be repeated. The values of GITHUB_TOKEN, GH_TOKEN and any *_TOKEN or
+*_SECRET environment variable are always masked.`)
+
+	cmd.AddCommand(replayCmd(configure, runUpgrade, reportError, &context.Verbose))
+	// The only subcommand is `replay`, so shell completion is not worth a command.
+	cmd.CompletionOptions.DisableDefaultCmd = true
+
 	// Print just the version string for `--version`/`-v`, matching the format
 	// shown in `--help` (e.g. "v0.0.1-3212adb3").
 	cmd.SetVersionTemplate("{{.Version}}\n")
@@ -307,6 +484,49 @@ it only skips remote submission.`)
 	return cmd
 }
 
+// parseStepTimeouts parses values of the form "name=duration".
+func parseStepTimeouts(values []string) (map[string]time.Duration, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	timeouts := make(map[string]time.Duration, len(values))
+	for _, v := range values {
+		name, duration, ok := strings.Cut(v, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf(`--step-timeout=%s: expected "name=duration"`, v)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil {
+			return nil, fmt.Errorf("--step-timeout=%s: %w", v, err)
+		}
+		timeouts[name] = d
+	}
+	return timeouts, nil
+}
+
+// interruptContext returns a context that is canceled on the first SIGINT or SIGTERM, so
+// the running pipeline can halt and clean up. A second signal terminates the process
+// immediately.
+func interruptContext(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			// Restore the default behavior, so that a second signal kills the process.
+			signal.Stop(signals)
+			cancel(fmt.Errorf("received %s", sig))
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel(nil)
+	}
+}
+
 func main() {
 	err := cmd().Execute()
 	if err != nil {
@@ -356,14 +576,39 @@ func initializeConfig(cmd *cobra.Command) error {
 	return nil
 }
 
+// The flags whose values are paths to files, which are relative to the config file when
+// they are set there.
+var configPathFlags = map[string]bool{
+	"pr-title-template": true,
+	"pr-body-template":  true,
+	"branch-template":   true,
+}
+
+// envKey returns the environment variable that sets the flag called name.
+func envKey(name string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
 // Bind each cobra flag to its associated viper configuration (config file and environment variable)
 func bindFlags(cmd *cobra.Command, v *viper.Viper) {
 	bindFlagSet := func(flags *pflag.FlagSet) {
 		flags.VisitAll(func(f *pflag.Flag) {
 			// Apply the viper config value to the flag when the flag is not set and viper has a value
 			if !f.Changed && v.IsSet(f.Name) {
-				val := v.Get(f.Name)
-				err := flags.Set(f.Name, fmt.Sprintf("%v", val))
+				// List flags can be given as lists (or maps of "key=value") in the
+				// config file, which don't survive being formatted with %v.
+				if slice, ok := f.Value.(pflag.SliceValue); ok {
+					err := slice.Replace(configSlice(v, f))
+					contract.AssertNoErrorf(err, "error setting flag")
+					return
+				}
+				val := fmt.Sprintf("%v", v.Get(f.Name))
+				// Paths in the config file are relative to the file, wherever it is read from.
+				if configPathFlags[f.Name] && v.InConfig(f.Name) && !filepath.IsAbs(val) &&
+					os.Getenv(envKey(f.Name)) == "" {
+					val = filepath.Join(filepath.Dir(v.ConfigFileUsed()), val)
+				}
+				err := flags.Set(f.Name, val)
 				contract.AssertNoErrorf(err, "error setting flag")
 			}
 		})
@@ -371,3 +616,33 @@ func bindFlags(cmd *cobra.Command, v *viper.Viper) {
 	bindFlagSet(cmd.Flags())
 	bindFlagSet(cmd.PersistentFlags())
 }
+
+// configSlice reads the value of f from v as a list of strings.
+//
+// Maps are read as a list of "key=value" strings, sorted by key.
+func configSlice(v *viper.Viper, f *pflag.Flag) []string {
+	key := f.Name
+	switch val := v.Get(key).(type) {
+	case map[string]any:
+		m := v.GetStringMapString(key)
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		list := make([]string, len(keys))
+		for i, k := range keys {
+			list[i] = k + "=" + m[k]
+		}
+		return list
+	case string:
+		// Like on the command line, a string array takes each value whole, since values
+		// such as regular expressions may contain commas. Other lists are comma separated.
+		if f.Value.Type() == "stringArray" {
+			return []string{val}
+		}
+		return strings.Split(val, ",")
+	default:
+		return v.GetStringSlice(key)
+	}
+}
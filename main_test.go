@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
 )
 
 func TestHelpShowsDefaultFlagValues(t *testing.T) {
@@ -52,12 +57,7 @@ func TestInitializeConfigBindsAllowMajor(t *testing.T) {
 	dir := t.TempDir()
 	require.NoError(t, os.WriteFile(filepath.Join(dir, ".upgrade-config.yml"), []byte("allow-major: true\n"), 0o600))
 
-	previous, err := os.Getwd()
-	require.NoError(t, err)
-	require.NoError(t, os.Chdir(dir))
-	t.Cleanup(func() {
-		require.NoError(t, os.Chdir(previous))
-	})
+	chdir(t, dir)
 
 	command := cmd()
 	require.NoError(t, initializeConfig(command))
@@ -114,3 +114,189 @@ func TestCmdReportsVersion(t *testing.T) {
 	require.Equal(t, "v1.2.3-3212adb3", command.Version)
 	require.Contains(t, command.Long, "Version: v1.2.3-3212adb3")
 }
+
+func TestParseStepTimeouts(t *testing.T) {
+	t.Parallel()
+
+	timeouts, err := parseStepTimeouts([]string{"make tfgen=45m", " gh = 30s"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]time.Duration{
+		"make tfgen": 45 * time.Minute,
+		"gh":         30 * time.Second,
+	}, timeouts)
+
+	_, err = parseStepTimeouts([]string{"make tfgen"})
+	require.ErrorContains(t, err, `expected "name=duration"`)
+
+	_, err = parseStepTimeouts([]string{"make=forever"})
+	require.ErrorContains(t, err, "--step-timeout=make=forever")
+}
+
+func TestInitializeConfigBindsStepTimeouts(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".upgrade-config.yml"), []byte(`timeout: 2h
+step-timeout:
+  make tfgen: 45m
+  gh: 30s
+`), 0o600))
+
+	chdir(t, dir)
+
+	command := cmd()
+	require.NoError(t, initializeConfig(command))
+
+	require.Equal(t, "2h0m0s", command.PersistentFlags().Lookup("timeout").Value.String())
+	require.Equal(t, "[gh=30s,make tfgen=45m]", command.PersistentFlags().Lookup("step-timeout").Value.String())
+}
+
+func TestInitializeConfigBindsHosts(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".upgrade-config.yml"), []byte(`provider-host: github.example.com
+raw-host: github.example.com/raw
+`), 0o600))
+
+	chdir(t, dir)
+
+	command := cmd()
+	require.NoError(t, initializeConfig(command))
+
+	require.Equal(t, "github.example.com", command.PersistentFlags().Lookup("provider-host").Value.String())
+	require.Equal(t, "github.com", command.PersistentFlags().Lookup("bridge-host").Value.String())
+	require.Equal(t, "github.example.com/raw", command.PersistentFlags().Lookup("raw-host").Value.String())
+}
+
+func TestInitializeConfigResolvesTemplatePaths(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".upgrade-config.yml"), []byte(`pr-body-template: templates/body.md
+pr-title-template: /etc/upgrade/title.tmpl
+`), 0o600))
+	t.Setenv("UPGRADE_BRANCH_TEMPLATE", "branch.tmpl")
+
+	chdir(t, dir)
+
+	command := cmd()
+	require.NoError(t, initializeConfig(command))
+
+	flag := func(name string) string { return command.PersistentFlags().Lookup(name).Value.String() }
+	// Relative paths in the config file are relative to it, rather than to where the
+	// command runs.
+	require.Equal(t, filepath.Join(dir, "templates", "body.md"), flag("pr-body-template"))
+	require.Equal(t, "/etc/upgrade/title.tmpl", flag("pr-title-template"))
+	// Environment variables are not in the config file.
+	require.Equal(t, "branch.tmpl", flag("branch-template"))
+}
+
+func TestInitializeConfigBindsRedact(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".upgrade-config.yml"),
+		[]byte("redact: 'ghp_[A-Za-z0-9]{36,40}'\nkind: bridge,provider\n"), 0o600))
+
+	chdir(t, dir)
+
+	command := cmd()
+	require.NoError(t, initializeConfig(command))
+
+	// Patterns may contain commas, unlike the values of other lists.
+	redact, err := command.PersistentFlags().GetStringArray("redact")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ghp_[A-Za-z0-9]{36,40}"}, redact)
+	kind, err := command.PersistentFlags().GetStringSlice("kind")
+	require.NoError(t, err)
+	require.Equal(t, []string{"bridge", "provider"}, kind)
+}
+
+func TestReplayShow(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+
+	command := cmd()
+	buf := new(bytes.Buffer)
+	command.SetOut(buf)
+	command.SetErr(buf)
+	// The replay commands don't take the arguments of an upgrade.
+	command.SetArgs([]string{"replay", "show", "--width", "30",
+		filepath.Join("upgrade", "testdata", "replay", "update_repository_shimmed.json")})
+	require.NoError(t, command.Execute())
+
+	out := buf.String()
+	require.True(t, strings.HasPrefix(out, "=== Update Repository ===\n"), out)
+	require.Contains(t, out, "\n  - go (impure)\n")
+	require.Contains(t, out, `env:     cd "/work/pulumi-example/provi…`)
+	require.Contains(t, out, `inputs:  ["go",["mod","tidy"]]`)
+}
+
+func TestReplayDiff(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+
+	command := cmd()
+	buf := new(bytes.Buffer)
+	command.SetOut(buf)
+	command.SetErr(buf)
+	command.SetArgs([]string{"replay", "diff",
+		filepath.Join("upgrade", "testdata", "replay", "update_repository_shimmed.json"),
+		filepath.Join("upgrade", "testdata", "replay", "update_repository_patched.json")})
+	require.EqualError(t, command.Execute(), "the recordings differ")
+	require.NotContains(t, buf.String(), "Usage:")
+}
+
+func TestReplayRun(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	// The recording was made with the provider cloned into /work/gopath. Its steps are
+	// not run, so the clone doesn't need to exist.
+	t.Setenv("GOPATH", "/work/gopath")
+
+	// The upgrade's command line, which the PR body and branch name are made from, is
+	// the replay's without "replay run <file>".
+	file := filepath.Join("upgrade", "testdata", "replay", "bridge_upgrade.json")
+	args := []string{"replay", "run", file, "pulumi/pulumi-sample",
+		"--kind", "bridge", "--upstream-provider-name", "terraform-provider-sample"}
+	osArgs := os.Args
+	os.Args = append([]string{"upgrade-provider"}, args...)
+	t.Cleanup(func() { os.Args = osArgs })
+
+	command := cmd()
+	buf := new(bytes.Buffer)
+	command.SetOut(buf)
+	command.SetErr(buf)
+	command.SetArgs(args)
+	require.NoError(t, command.Execute(), buf.String())
+}
+
+func TestReplayedArgs(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t,
+		[]string{"upgrade-provider", "pulumi/pulumi-x", "--kind", "bridge"},
+		replayedArgs([]string{"upgrade-provider", "replay", "run", "replay.json",
+			"pulumi/pulumi-x", "--kind", "bridge"}, "replay.json"))
+	// Flags may come before the subcommands, and the file may also be an argument of the
+	// upgrade.
+	require.Equal(t,
+		[]string{"upgrade-provider", "--kind", "bridge", "pulumi/pulumi-x", "--repo-path", "run"},
+		replayedArgs([]string{"upgrade-provider", "--kind", "bridge", "replay", "run", "run",
+			"pulumi/pulumi-x", "--repo-path", "run"}, "run"))
+}
+
+func TestReplayTFailNow(t *testing.T) {
+	replay := &replayT{out: io.Discard}
+	var after bool
+	err := stepv2.Pipeline("test", func(ctx context.Context) {
+		stepv2.Func00("diverges", func(context.Context) {
+			replay.Fatalf("cannot continue")
+		})(ctx)
+		after = true
+	})
+	require.ErrorContains(t, err, `step "diverges" stopped before it returned`)
+	require.True(t, replay.Failed())
+	require.False(t, after)
+}
+
+// chdir changes the working directory to dir until the test finishes.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	previous, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(previous))
+	})
+}
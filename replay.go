@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulumi/upgrade-provider/colorize"
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
+)
+
+// replayCmd returns the `replay` command, which works with the recordings written when
+// PULUMI_REPLAY is set.
+//
+// configure validates the flags and arguments of an upgrade, as the root command does.
+// runUpgrade runs the upgrade they describe with its command line and within envs, and
+// reportError displays the error it fails with.
+func replayCmd(
+	configure func(cmd *cobra.Command, args []string) error,
+	runUpgrade func(args []string, envs ...stepv2.Env) error,
+	reportError func(error),
+	verbose *bool,
+) *cobra.Command {
+	replay := &cobra.Command{
+		Use:   "replay",
+		Short: "Inspect, compare and run recordings made with PULUMI_REPLAY",
+		// Only `replay run` upgrades a provider, so the arguments of an upgrade are
+		// not validated by default.
+		PersistentPreRunE: func(*cobra.Command, []string) error { return nil },
+	}
+
+	var width int
+	show := &cobra.Command{
+		Use:   "show <file>",
+		Short: "Show the pipelines and steps of a recording, with their inputs and outputs",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r, err := readReplay(args[0])
+			if err != nil {
+				return err
+			}
+			return r.WriteTree(cmd.OutOrStdout(), width)
+		},
+	}
+	show.Flags().IntVar(&width, "width", 120,
+		`The number of characters to show of each value. Use 0 to show values in full.`)
+
+	diff := &cobra.Command{
+		Use:   "diff <a> <b>",
+		Short: "Show how the steps of two recordings differ",
+		Long: `Show how the steps of two recordings differ.
+
+Steps are aligned by name, and the inputs, outputs, errors and envs of steps that appear in
+both recordings are compared. Exits with status 1 if the recordings differ.`,
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := readReplay(args[0])
+			if err != nil {
+				return err
+			}
+			b, err := readReplay(args[1])
+			if err != nil {
+				return err
+			}
+			differ, err := stepv2.DiffReplays(cmd.OutOrStdout(), a, b)
+			if err != nil {
+				return err
+			}
+			if differ {
+				return errors.New("the recordings differ")
+			}
+			return nil
+		},
+	}
+
+	run := &cobra.Command{
+		Use:   "run <file> <provider>",
+		Short: "Run an upgrade against a recording, replaying its commands and GitHub calls",
+		Long: `Run an upgrade against a recording, replaying its commands and GitHub calls.
+
+Impure steps, such as running commands and calling GitHub, are not run: they return the
+outputs that were recorded. Pure steps run as usual. Pass the same flags that the recorded
+upgrade was run with: the upgrade sees its command line without "replay run <file>". Each
+step that does not match the recording is reported, and the command fails if any step
+diverged.
+
+Work done outside of steps still happens: the upgrade reads its configuration, creates its
+log directory, looks for mise, runs the git commands that check the git identity and
+inspect the local checkout, and writes diagnostics if it fails.`,
+		Args: cobra.ExactArgs(2),
+		// Errors are reported as the upgrade reports them.
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return configure(cmd, args[1:])
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			source, err := os.ReadFile(args[0])
+			if err != nil {
+				reportError(err)
+				return err
+			}
+			t := &replayT{out: cmd.ErrOrStderr(), verbose: *verbose}
+			err = runUpgrade(replayedArgs(os.Args, args[0]), stepv2.NewReplay(t, source))
+			if err != nil {
+				reportError(err)
+			}
+			if t.Failed() {
+				fmt.Fprintln(cmd.ErrOrStderr(), colorize.Warn("The upgrade diverged from the recording"))
+				return errReplayDiverged
+			}
+			return err
+		},
+	}
+
+	replay.AddCommand(show, diff, run)
+	return replay
+}
+
+// replayedArgs returns the command line of the upgrade that `replay run <file>` runs:
+// osArgs without "replay run <file>", as if the upgrade had been run directly.
+func replayedArgs(osArgs []string, file string) []string {
+	args := slices.Clone(osArgs)
+	i := min(1, len(args))
+	for _, word := range []string{"replay", "run", file} {
+		j := slices.Index(args[i:], word)
+		if j < 0 {
+			break
+		}
+		i += j
+		args = slices.Delete(args, i, i+1)
+	}
+	return args
+}
+
+// errReplayDiverged is returned from `replay run` when the upgrade does not match the
+// recording.
+var errReplayDiverged = errors.New("the upgrade diverged from the recording")
+
+func readReplay(path string) (*stepv2.ReplayV2, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := stepv2.ParseReplay(source)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// replayT reports the progress of a replay to the user of `replay run`.
+type replayT struct {
+	out     io.Writer
+	verbose bool
+	failed  bool
+}
+
+func (t *replayT) Helper() {}
+
+func (t *replayT) Logf(format string, args ...any) {
+	if t.verbose {
+		fmt.Fprintf(t.out, format+"\n", args...)
+	}
+}
+
+func (t *replayT) Errorf(format string, args ...any) {
+	t.failed = true
+	fmt.Fprintf(t.out, "%s %s\n", colorize.Warn("replay:"), fmt.Sprintf(format, args...))
+}
+
+func (t *replayT) Fatalf(format string, args ...any) {
+	t.Errorf(format, args...)
+	t.FailNow()
+}
+
+func (t *replayT) Fail() { t.failed = true }
+
+// FailNow stops the step that failed, which fails the upgrade.
+func (t *replayT) FailNow() {
+	t.failed = true
+	runtime.Goexit()
+}
+
+func (t *replayT) Failed() bool { return t.failed }
@@ -0,0 +1,46 @@
+// Package ci detects when upgrade-provider is not attached to an interactive terminal,
+// such as when it runs in CI, so that progress can be displayed as plain lines of text
+// instead of animated spinners.
+//
+// It also detects GitHub Actions, where progress can be grouped and failures annotated
+// with workflow commands, and escapes the text of those commands.
+package ci
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// IsInteractive reports if progress should be displayed with animations.
+//
+// Output is not interactive when stdout is not a terminal or when CI is set to a true
+// value.
+func IsInteractive() bool {
+	if ci, _ := strconv.ParseBool(os.Getenv("CI")); ci {
+		return false
+	}
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// IsGitHubActions reports if upgrade-provider is running in GitHub Actions, and so can
+// use workflow commands such as ::group::.
+//
+// See https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions.
+func IsGitHubActions() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// EscapeData escapes s for use as the message of a workflow command.
+func EscapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// EscapeProperty escapes s for use as a property of a workflow command, such as title.
+func EscapeProperty(s string) string {
+	return strings.NewReplacer(
+		"%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C",
+	).Replace(s)
+}
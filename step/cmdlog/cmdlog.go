@@ -0,0 +1,291 @@
+// Package cmdlog keeps a record of the commands that upgrade-provider runs, so that a
+// failed upgrade can be diagnosed after the fact and long running commands can be followed
+// as they run.
+//
+// Run runs a command and records it in the History and Dir of its context: the History
+// keeps the full output of the last few commands for diagnostics, and the Dir streams the
+// output of every command to its own log file as it runs. The latest line of output can
+// also be shown while a command runs with NewTail.
+package cmdlog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/pulumi/upgrade-provider/step/redact"
+)
+
+// An Entry describes a single command that has finished running.
+type Entry struct {
+	// The command line that was run.
+	Command string `json:"command"`
+	// The directory the command was run in.
+	Dir string `json:"dir"`
+	// When the command started.
+	Start time.Time `json:"start"`
+	// How long the command ran for.
+	Duration time.Duration `json:"duration"`
+	// The full output of the command.
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	// The error the command failed with, if any.
+	Err string `json:"error,omitempty"`
+	// The path of the log file the output was written to, if any.
+	Log string `json:"log,omitempty"`
+}
+
+// A History holds the most recent commands that were run.
+//
+// A History is safe for concurrent use.
+type History struct {
+	size int
+
+	m       sync.Mutex
+	entries []Entry
+}
+
+// NewHistory returns a History that retains the last size commands.
+func NewHistory(size int) *History {
+	return &History{size: size}
+}
+
+// Add an entry to the history, evicting the oldest entry if the history is full.
+func (h *History) Add(e Entry) {
+	if h == nil {
+		return
+	}
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.entries = append(h.entries, e)
+	if over := len(h.entries) - h.size; over > 0 {
+		h.entries = append([]Entry(nil), h.entries[over:]...)
+	}
+}
+
+// Entries returns the retained entries, oldest first.
+func (h *History) Entries() []Entry {
+	if h == nil {
+		return nil
+	}
+	h.m.Lock()
+	defer h.m.Unlock()
+	return append([]Entry(nil), h.entries...)
+}
+
+type historyKey struct{}
+
+// WithHistory returns a context whose commands are recorded in h.
+func WithHistory(ctx context.Context, h *History) context.Context {
+	return context.WithValue(ctx, historyKey{}, h)
+}
+
+// FromContext returns the History associated with ctx.
+//
+// If ctx has no History, then nil is returned. It is safe to call Add on a nil History.
+func FromContext(ctx context.Context) *History {
+	h, _ := ctx.Value(historyKey{}).(*History)
+	return h
+}
+
+// A Dir writes the output of each command to its own file within a directory.
+//
+// The directory is created with the first file, so that runs that don't run commands,
+// such as replays, leave nothing behind.
+//
+// A Dir is safe for concurrent use.
+type Dir struct {
+	parent string
+
+	m    sync.Mutex
+	path string
+	n    int
+}
+
+// NewDir returns a Dir that writes logs to a uniquely named directory within parent.
+//
+// If parent is empty, the system's temporary directory is used.
+func NewDir(parent string) *Dir {
+	if parent == "" {
+		parent = os.TempDir()
+	}
+	return &Dir{parent: parent}
+}
+
+// The path of the directory that logs are written to, or "" if no log has been written.
+func (d *Dir) Path() string {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.path
+}
+
+// Create a log file for command, which should be a short command line such as
+// "make tfgen".
+//
+// Files are numbered in the order they are created, so that listing the directory gives
+// the order that commands were run in.
+func (d *Dir) Create(command string) (*os.File, error) {
+	d.m.Lock()
+	if d.path == "" {
+		if err := os.MkdirAll(d.parent, 0o700); err != nil {
+			d.m.Unlock()
+			return nil, err
+		}
+		path, err := os.MkdirTemp(d.parent, "upgrade-provider-logs-*")
+		if err != nil {
+			d.m.Unlock()
+			return nil, err
+		}
+		d.path = path
+	}
+	d.n++
+	n, path := d.n, d.path
+	d.m.Unlock()
+	return os.OpenFile(filepath.Join(path, fmt.Sprintf("%03d-%s.log", n, slug(command))),
+		os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+}
+
+// slug converts command into a short string that is safe to use in a file name.
+func slug(command string) string {
+	const maxLen = 60
+	var s strings.Builder
+	dash := false
+	for _, r := range command {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			s.WriteRune(unicode.ToLower(r))
+			dash = false
+		} else if !dash && s.Len() > 0 {
+			s.WriteRune('-')
+			dash = true
+		}
+		if s.Len() >= maxLen {
+			break
+		}
+	}
+	return strings.TrimSuffix(s.String(), "-")
+}
+
+type dirKey struct{}
+
+// WithDir returns a context whose commands write their output to d.
+func WithDir(ctx context.Context, d *Dir) context.Context {
+	return context.WithValue(ctx, dirKey{}, d)
+}
+
+// DirFromContext returns the Dir associated with ctx, or nil if there is none.
+func DirFromContext(ctx context.Context) *Dir {
+	d, _ := ctx.Value(dirKey{}).(*Dir)
+	return d
+}
+
+type verboseKey struct{}
+
+// WithVerbose requests that the latest output of commands run within ctx is shown to the
+// user while they run.
+func WithVerbose(ctx context.Context) context.Context {
+	return context.WithValue(ctx, verboseKey{}, true)
+}
+
+// IsVerbose reports if WithVerbose was applied to ctx.
+func IsVerbose(ctx context.Context) bool {
+	v, _ := ctx.Value(verboseKey{}).(bool)
+	return v
+}
+
+// The Result of a command run with Run.
+type Result struct {
+	Stdout, Stderr string
+	// The path of the log file the output was written to, if any.
+	Log string
+}
+
+// Run cmd, recording it in the History and Dir associated with ctx.
+//
+// The output of cmd is returned. It is also streamed to the log file of the command and to
+// tail, if tail is non-nil. The caller must not set cmd.Stdout or cmd.Stderr.
+//
+// Secrets are masked by the redact.Redactor associated with ctx in everything that is
+// logged, but not in the returned output.
+//
+// Logging is best effort: a failure to write the log file does not fail the command.
+func Run(ctx context.Context, cmd *exec.Cmd, tail io.Writer) (Result, error) {
+	redactor := redact.FromContext(ctx)
+	var stdout, stderr bytes.Buffer
+	outputs := []*redact.Writer{}
+	if tail != nil {
+		outputs = append(outputs, redactor.Writer(tail))
+	}
+
+	var log *os.File
+	if d := DirFromContext(ctx); d != nil {
+		name := append([]string{filepath.Base(cmd.Args[0])}, cmd.Args[1:]...)
+		var err error
+		log, err = d.Create(strings.Join(name, " "))
+		if err == nil {
+			defer log.Close()
+			fmt.Fprintf(log, "$ %s\n", redactor.String(cmd.String()))
+			if dir := cmd.Dir; dir != "" {
+				fmt.Fprintf(log, "# in %s\n", dir)
+			} else if dir, err := os.Getwd(); err == nil {
+				fmt.Fprintf(log, "# in %s\n", dir)
+			}
+			fmt.Fprintln(log)
+			outputs = append(outputs, redactor.Writer(log))
+		}
+	}
+
+	stdoutWriters, stderrWriters := []io.Writer{&stdout}, []io.Writer{&stderr}
+	for _, w := range outputs {
+		stdoutWriters = append(stdoutWriters, w)
+		stderrWriters = append(stderrWriters, w)
+	}
+	cmd.Stdout = io.MultiWriter(stdoutWriters...)
+	cmd.Stderr = io.MultiWriter(stderrWriters...)
+	start := time.Now()
+	err := cmd.Run()
+	duration := time.Since(start)
+	for _, w := range outputs {
+		_ = w.Flush()
+	}
+	if exit, ok := err.(*exec.ExitError); ok {
+		// cmd.Run only captures stderr when cmd.Stderr is nil, so we fill it in
+		// ourselves for callers that inspect it.
+		exit.Stderr = stderr.Bytes()
+	}
+
+	result := Result{Stdout: stdout.String(), Stderr: stderr.String()}
+	entry := Entry{
+		Command:  redactor.String(cmd.String()),
+		Dir:      cmd.Dir,
+		Start:    start,
+		Duration: duration,
+		Stdout:   redactor.String(result.Stdout),
+		Stderr:   redactor.String(result.Stderr),
+	}
+	if entry.Dir == "" {
+		entry.Dir, _ = os.Getwd()
+	}
+	if err != nil {
+		entry.Err = redactor.String(err.Error())
+	}
+	if log != nil {
+		if err != nil {
+			fmt.Fprintf(log, "\n# failed after %s: %s\n", duration.Round(time.Millisecond), entry.Err)
+		} else {
+			fmt.Fprintf(log, "\n# finished in %s\n", duration.Round(time.Millisecond))
+		}
+		result.Log = log.Name()
+		entry.Log = log.Name()
+	}
+	FromContext(ctx).Add(entry)
+
+	return result, err
+}
@@ -0,0 +1,126 @@
+package cmdlog
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/upgrade-provider/step/redact"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	h := NewHistory(2)
+	ctx := WithHistory(context.Background(), h)
+	FromContext(ctx).Add(Entry{Command: "one"})
+	FromContext(ctx).Add(Entry{Command: "two"})
+	FromContext(ctx).Add(Entry{Command: "three"})
+
+	assert.Equal(t, []Entry{{Command: "two"}, {Command: "three"}}, h.Entries())
+
+	// A context without a history silently drops entries.
+	assert.NotPanics(t, func() { FromContext(context.Background()).Add(Entry{Command: "four"}) })
+	assert.Nil(t, FromContext(context.Background()).Entries())
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	dir := NewDir(t.TempDir())
+	h := NewHistory(10)
+	ctx := WithDir(WithHistory(context.Background(), h), dir)
+
+	var tailed []string
+	tail := NewTail(func(line string) { tailed = append(tailed, line) })
+	result, err := Run(ctx, exec.Command("sh", "-c", "echo out; echo err >&2; exit 2"), tail)
+	require.Error(t, err)
+	assert.Equal(t, "out\n", result.Stdout)
+	assert.Equal(t, "err\n", result.Stderr)
+
+	var exit *exec.ExitError
+	require.ErrorAs(t, err, &exit)
+	assert.Equal(t, "err\n", string(exit.Stderr))
+
+	assert.Equal(t, filepath.Join(dir.Path(), "001-sh-c-echo-out-echo-err-2-exit-2.log"), result.Log)
+	log, err := os.ReadFile(result.Log)
+	require.NoError(t, err)
+	assert.Contains(t, string(log), "out\n")
+	assert.Contains(t, string(log), "err\n")
+	assert.Contains(t, string(log), "# failed after")
+
+	assert.NotEmpty(t, tailed)
+
+	entries := h.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, result.Log, entries[0].Log)
+	assert.Equal(t, "exit status 2", entries[0].Err)
+}
+
+// The directory is only created for the first log.
+func TestDirIsCreatedLazily(t *testing.T) {
+	t.Parallel()
+
+	parent := filepath.Join(t.TempDir(), "logs")
+	dir := NewDir(parent)
+	assert.Empty(t, dir.Path())
+	assert.NoDirExists(t, parent)
+
+	f, err := dir.Create("echo")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.DirExists(t, dir.Path())
+	assert.Equal(t, parent, filepath.Dir(dir.Path()))
+}
+
+func TestRunRedacts(t *testing.T) {
+	t.Parallel()
+
+	dir := NewDir(t.TempDir())
+	h := NewHistory(10)
+	r, err := redact.New(`hunter[0-9]+`)
+	require.NoError(t, err)
+	ctx := redact.WithRedactor(WithDir(WithHistory(context.Background(), h), dir), r)
+
+	result, err := Run(ctx, exec.Command("echo", "hunter22"), nil)
+	require.NoError(t, err)
+	// The caller still sees the output of the command.
+	assert.Equal(t, "hunter22\n", result.Stdout)
+
+	log, err := os.ReadFile(result.Log)
+	require.NoError(t, err)
+	assert.NotContains(t, string(log), "hunter22")
+
+	entries := h.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "<redacted>\n", entries[0].Stdout)
+	assert.NotContains(t, entries[0].Command, "hunter22")
+}
+
+func TestTail(t *testing.T) {
+	t.Parallel()
+
+	var lines []string
+	w := NewTail(func(line string) { lines = append(lines, line) })
+
+	_, err := w.Write([]byte("first\nsec"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first"}, lines)
+
+	// Updates are throttled.
+	_, err = w.Write([]byte("ond\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first"}, lines)
+
+	time.Sleep(tailInterval)
+	_, err = w.Write([]byte("50%\r" + strings.Repeat("x", 100) + "\r"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", strings.Repeat("x", 77) + "..."}, lines)
+}
@@ -0,0 +1,65 @@
+package cmdlog
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// The minimum time between calls to the callback of a Tail, so fast output doesn't
+// overwhelm the display.
+const tailInterval = 100 * time.Millisecond
+
+// The maximum length of a line passed to the callback of a Tail.
+const tailWidth = 80
+
+// NewTail returns an io.Writer that calls show with the latest complete, non-empty line
+// written to it.
+//
+// Calls to show are throttled, and long lines are truncated. Carriage returns are treated
+// as line breaks, so progress bars show their latest state.
+//
+// The returned writer is safe for concurrent use, and show is never called concurrently.
+func NewTail(show func(line string)) io.Writer {
+	return &tail{show: show}
+}
+
+type tail struct {
+	show func(string)
+
+	m       sync.Mutex
+	partial []byte
+	updated time.Time
+}
+
+func (w *tail) Write(b []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.partial = append(w.partial, b...)
+	lines := bytes.FieldsFunc(w.partial, func(r rune) bool { return r == '\n' || r == '\r' })
+	if end := bytes.LastIndexAny(w.partial, "\r\n"); end >= 0 {
+		w.partial = append([]byte(nil), w.partial[end+1:]...)
+	}
+	if len(w.partial) > 0 {
+		// The last line is incomplete.
+		lines = lines[:len(lines)-1]
+	}
+	if over := len(w.partial) - 4096; over > 0 {
+		w.partial = w.partial[over:]
+	}
+	var line string
+	for i := len(lines) - 1; i >= 0 && line == ""; i-- {
+		line = string(bytes.TrimSpace(lines[i]))
+	}
+	if line == "" || time.Since(w.updated) < tailInterval {
+		return len(b), nil
+	}
+	w.updated = time.Now()
+	if len(line) > tailWidth {
+		line = line[:tailWidth-3] + "..."
+	}
+	w.show(line)
+	return len(b), nil
+}
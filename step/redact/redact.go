@@ -0,0 +1,222 @@
+// Package redact masks secrets, such as GitHub tokens, in the recordings and logs that
+// upgrade-provider writes, so that they can be shared without leaking credentials.
+//
+// A Redactor masks the values of secret environment variables, the secrets registered
+// with it and the matches of its patterns. It redacts text, JSON, and streams written
+// through a Writer, and is passed to the code that writes them with WithRedactor.
+package redact
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces each secret that is redacted.
+const Mask = "<redacted>"
+
+// The names of the environment variables whose values are secret, as path.Match patterns.
+//
+// Names are matched case insensitively.
+var SecretNames = []string{"GITHUB_TOKEN", "GH_TOKEN", "*_TOKEN", "*_SECRET"}
+
+// Values shorter than this are not redacted where they appear in other text, since they
+// are too likely to occur by chance (for example, a token set to "1").
+const minSecretLen = 6
+
+// A Redactor masks secrets in text.
+//
+// A secret is:
+//
+//   - The value of an environment variable whose name matches SecretNames, wherever it
+//     appears.
+//   - A value assigned to such a name, as in `GH_TOKEN=value` or `"GH_TOKEN": "value"`.
+//   - A value registered with AddSecret.
+//   - A match of one of the Redactor's patterns.
+//
+// A Redactor is safe for concurrent use.
+type Redactor struct {
+	patterns []*regexp.Regexp
+
+	m       sync.Mutex
+	secrets map[string]struct{}
+}
+
+// New returns a Redactor that also masks each match of patterns, which are regular
+// expressions.
+func New(patterns ...string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// IsSecret reports if name is the name of an environment variable whose value is secret.
+func IsSecret(name string) bool {
+	name = strings.ToUpper(name)
+	for _, pattern := range SecretNames {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AddSecret registers value as a secret, so that it is masked wherever it appears.
+//
+// This is used for secrets that are not in the environment of the process, such as
+// environment variables that are only set for a single command.
+func (r *Redactor) AddSecret(value string) {
+	if len(value) < minSecretLen {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.secrets == nil {
+		r.secrets = map[string]struct{}{}
+	}
+	r.secrets[value] = struct{}{}
+}
+
+// The secret values to mask, longest first, so that a secret which contains another is
+// masked whole.
+func (r *Redactor) values() []string {
+	var values []string
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if len(v) >= minSecretLen && IsSecret(k) {
+			values = append(values, v)
+		}
+	}
+	r.m.Lock()
+	for v := range r.secrets {
+		values = append(values, v)
+	}
+	r.m.Unlock()
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values
+}
+
+// Matches a value assigned to a secret name, such as `GH_TOKEN=value`, `GH_TOKEN: value`
+// or `"GH_TOKEN":"value"`.
+//
+// The groups are the name, the assignment and the value.
+var assignment = func() *regexp.Regexp {
+	names := make([]string, len(SecretNames))
+	for i, name := range SecretNames {
+		names[i] = strings.ReplaceAll(regexp.QuoteMeta(name), `\*`, `[A-Za-z0-9_]*`)
+	}
+	return regexp.MustCompile(`(?i)\b(` + strings.Join(names, "|") +
+		`)(["']?\s*[:=]\s*)("[^"]*"|'[^']*'|[^"'\s,;}]+)`)
+}()
+
+// String returns s with each secret masked.
+func (r *Redactor) String(s string) string {
+	return r.redact(s, r.values())
+}
+
+func (r *Redactor) redact(s string, values []string) string {
+	for _, v := range values {
+		s = strings.ReplaceAll(s, v, Mask)
+	}
+	s = assignment.ReplaceAllStringFunc(s, func(match string) string {
+		m := assignment.FindStringSubmatch(match)
+		value := m[3]
+		if quote := value[0]; quote == '"' || quote == '\'' {
+			return m[1] + m[2] + string(quote) + Mask + string(quote)
+		}
+		return m[1] + m[2] + Mask
+	})
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, Mask)
+	}
+	return s
+}
+
+// JSON returns raw with each secret in its strings masked.
+//
+// The value of an object field whose name is a secret name is masked in full. If raw
+// contains no secrets, or is not valid JSON, then it is returned as is.
+func (r *Redactor) JSON(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return raw
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return raw
+	}
+
+	values := r.values()
+	changed := false
+	var walk func(v any) any
+	walk = func(v any) any {
+		switch v := v.(type) {
+		case string:
+			if redacted := r.redact(v, values); redacted != v {
+				changed = true
+				return redacted
+			}
+		case []any:
+			for i, e := range v {
+				v[i] = walk(e)
+			}
+		case map[string]any:
+			for k, e := range v {
+				if s, ok := e.(string); ok && s != Mask && IsSecret(k) {
+					changed = true
+					v[k] = Mask
+					continue
+				}
+				v[k] = walk(e)
+			}
+		}
+		return v
+	}
+	v = walk(v)
+	if !changed {
+		return raw
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return raw
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
+
+type redactorKey struct{}
+
+// WithRedactor returns a context whose recordings and logs are redacted by r.
+func WithRedactor(ctx context.Context, r *Redactor) context.Context {
+	return context.WithValue(ctx, redactorKey{}, r)
+}
+
+// The Redactor used when a context has none, which masks only the default secrets.
+var defaultRedactor = &Redactor{}
+
+// FromContext returns the Redactor associated with ctx.
+//
+// If ctx has no Redactor, then a Redactor without patterns is returned: secrets in the
+// environment are always redacted.
+func FromContext(ctx context.Context) *Redactor {
+	if r, ok := ctx.Value(redactorKey{}).(*Redactor); ok {
+		return r
+	}
+	return defaultRedactor
+}
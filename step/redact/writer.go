@@ -0,0 +1,53 @@
+package redact
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// A Writer writes to an underlying io.Writer with each secret masked.
+//
+// Secrets are masked a line at a time, so output is held back until a line break (a
+// newline or carriage return) is written. Call Flush to write the final, incomplete line.
+//
+// A Writer is safe for concurrent use.
+type Writer struct {
+	r *Redactor
+	w io.Writer
+
+	m   sync.Mutex
+	buf []byte
+}
+
+// Writer returns a Writer that writes to w with each secret masked.
+func (r *Redactor) Writer(w io.Writer) *Writer {
+	return &Writer{r: r, w: w}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.buf = append(w.buf, p...)
+	if i := bytes.LastIndexAny(w.buf, "\r\n"); i >= 0 {
+		line := w.r.String(string(w.buf[:i+1]))
+		w.buf = append(w.buf[:0], w.buf[i+1:]...)
+		if _, err := io.WriteString(w.w, line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes any incomplete line that has been held back.
+func (w *Writer) Flush() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := w.r.String(string(w.buf))
+	w.buf = w.buf[:0]
+	_, err := io.WriteString(w.w, line)
+	return err
+}
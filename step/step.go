@@ -7,6 +7,7 @@ package step
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"sort"
@@ -15,7 +16,10 @@ import (
 
 	"github.com/briandowns/spinner"
 
+	"github.com/pulumi/upgrade-provider/step/ci"
+	"github.com/pulumi/upgrade-provider/step/cmdlog"
 	"github.com/pulumi/upgrade-provider/step/gitenv"
+	"github.com/pulumi/upgrade-provider/step/trace"
 )
 
 // A Step represents an atomic (pass/fail) piece of computation that should be displayed
@@ -81,6 +85,9 @@ func commandEnv(ctx context.Context) []string {
 	return env
 }
 
+// The spinner of the currently running step.
+type spinnerKey struct{}
+
 func (ds step) run(ctx context.Context, prefix string) bool {
 	options := []string{"|", "/", "-", "\\"}
 	for i, o := range options {
@@ -88,19 +95,41 @@ func (ds step) run(ctx context.Context, prefix string) bool {
 	}
 	spinner := spinner.New(options, time.Millisecond*250,
 		spinner.WithHiddenCursor(true))
-	spinner.Start()
-	result, err := runIn(ctx, ds.path, ds.f)
+	// Without a terminal, the spinner can't redraw itself, so we only print the
+	// final result of the step.
+	interactive := ci.IsInteractive()
+	if interactive {
+		spinner.Start()
+	}
+	ctx, endSpan := trace.Start(ctx, trace.KindStep, ds.description)
+	var result string
+	// Don't start new steps once ctx has been interrupted.
+	err := ctx.Err()
+	if err == nil {
+		result, err = runIn(context.WithValue(ctx, spinnerKey{}, spinner), ds.path, ds.f)
+	}
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("interrupted during %q: %w", ds.description, context.Cause(ctx))
+	}
+	endSpan(err == nil)
+	mark := "✓"
 	if err != nil {
-		spinner.FinalMSG = prefix + "X"
+		mark = "X"
 		result = err.Error()
+	} else if result == "" {
+		result = "done"
+	}
+	if interactive {
+		spinner.FinalMSG = prefix + mark
+		spinner.Stop()
 	} else {
-		spinner.FinalMSG = prefix + "✓"
-		if result == "" {
-			result = "done"
-		}
+		fmt.Printf("[%s] %s", time.Now().Format("15:04:05"), prefix+mark)
 	}
-	spinner.Stop()
 	fmt.Printf(" %s: %s\n", ds.description, result)
+	if err != nil && ci.IsGitHubActions() {
+		fmt.Printf("::error title=%s::%s\n",
+			ci.EscapeProperty(ds.description), ci.EscapeData(err.Error()))
+	}
 	return err == nil
 }
 
@@ -127,6 +156,9 @@ func Cmd(name string, args ...string) Step {
 	}
 	return F(description, func(ctx context.Context) (string, error) {
 		command := exec.CommandContext(ctx, name, args...)
+		// Give the command a chance to clean up after itself before it is killed.
+		command.Cancel = func() error { return command.Process.Signal(os.Interrupt) }
+		command.WaitDelay = 10 * time.Second
 		env := commandEnv(ctx)
 		if name == "git" {
 			// Network-touching git commands (e.g. fetch, submodule update)
@@ -138,10 +170,21 @@ func Cmd(name string, args ...string) Step {
 		if env != nil {
 			command.Env = env
 		}
-		out, err := command.Output()
-		output = string(out)
-		if exit, ok := err.(*exec.ExitError); ok {
-			err = fmt.Errorf("%s:\n%s", err.Error(), string(exit.Stderr))
+		var tail io.Writer
+		if s, ok := ctx.Value(spinnerKey{}).(*spinner.Spinner); ok && cmdlog.IsVerbose(ctx) {
+			tail = cmdlog.NewTail(func(line string) {
+				s.Lock()
+				defer s.Unlock()
+				s.Suffix = ": " + line
+			})
+		}
+		result, err := cmdlog.Run(ctx, command, tail)
+		output = result.Stdout
+		if _, ok := err.(*exec.ExitError); ok {
+			err = fmt.Errorf("%s:\n%s", err.Error(), result.Stderr)
+		}
+		if err != nil && result.Log != "" {
+			err = fmt.Errorf("%w\nfull log: %s", err, result.Log)
 		}
 		return "", err
 	}).Return(&output)
@@ -304,8 +347,19 @@ func (c combined) run(ctx context.Context, prefix string) bool {
 	description := prefix + c.description
 	if prefix == "" {
 		description = "---- " + description + " ----"
+		if ci.IsGitHubActions() {
+			fmt.Printf("::group::%s\n", ci.EscapeData(c.description))
+			defer fmt.Println("::endgroup::")
+		}
 	}
 	fmt.Println(description)
+	kind := trace.KindStep
+	if prefix == "" {
+		kind = trace.KindPipeline
+	}
+	ctx, endSpan := trace.Start(ctx, kind, c.description)
+	ok := true
+	defer func() { endSpan(ok) }()
 	subPrefix := strings.Repeat(" ", len(prefix))
 	for _, s := range c.steps {
 		if s == nil {
@@ -319,7 +373,7 @@ func (c combined) run(ctx context.Context, prefix string) bool {
 				s = s.AssignTo(lvalue)
 			}
 		}
-		ok := s.run(ctx, subPrefix+"- ")
+		ok = s.run(ctx, subPrefix+"- ")
 		if !ok {
 			return false
 		}
@@ -2,6 +2,7 @@ package step
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -90,3 +91,20 @@ func unsetenvForTest(t *testing.T, key string) {
 		t.Cleanup(func() { require.NoError(t, os.Setenv(key, orig)) })
 	}
 }
+
+func TestRunInterrupted(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errors.New("received interrupt"))
+
+	var ran bool
+	ok := Run(ctx, Combined("interrupted",
+		F("step", func(context.Context) (string, error) {
+			ran = true
+			return "", nil
+		})))
+
+	assert.False(t, ok)
+	assert.False(t, ran, "steps should not start after an interrupt")
+}
@@ -0,0 +1,206 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// The formats that Write can export.
+const (
+	FormatChrome = "chrome"
+	FormatOTLP   = "otlp"
+)
+
+// Formats lists the formats that Write supports.
+var Formats = []string{FormatChrome, FormatOTLP}
+
+// Write the spans of t to w in format, one of Formats.
+func (t *Tracer) Write(w io.Writer, format string) error {
+	var v any
+	switch format {
+	case FormatChrome:
+		v = chromeTrace(t.Spans())
+	case FormatOTLP:
+		var err error
+		v, err = otlpTrace(t.Spans())
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown trace format %q", format)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// chromeTrace converts spans to the Chrome trace event format, which can be viewed in
+// Perfetto (https://ui.perfetto.dev) and chrome://tracing.
+//
+// See https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU.
+func chromeTrace(spans []Span) any {
+	type event struct {
+		Name string         `json:"name"`
+		Cat  string         `json:"cat"`
+		Ph   string         `json:"ph"`
+		Ts   int64          `json:"ts"`
+		Dur  int64          `json:"dur"`
+		Pid  int            `json:"pid"`
+		Tid  int            `json:"tid"`
+		Args map[string]any `json:"args"`
+	}
+	events := make([]event, 0, len(spans))
+	for _, s := range spans {
+		events = append(events, event{
+			Name: s.Name,
+			Cat:  s.Kind,
+			// A complete event, which has both a start time and a duration.
+			Ph:   "X",
+			Ts:   s.Start.UnixMicro(),
+			Dur:  s.Duration().Microseconds(),
+			Pid:  1,
+			Tid:  1,
+			Args: map[string]any{"success": s.Success},
+		})
+	}
+	return struct {
+		TraceEvents     []event `json:"traceEvents"`
+		DisplayTimeUnit string  `json:"displayTimeUnit"`
+	}{events, "ms"}
+}
+
+// otlpTrace converts spans to the OTLP JSON encoding of an ExportTraceServiceRequest,
+// as written by the OpenTelemetry Collector's file exporter.
+//
+// See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+func otlpTrace(spans []Span) (any, error) {
+	type keyValue struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+	type status struct {
+		Code int `json:"code"`
+	}
+	type span struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes"`
+		Status            status     `json:"status"`
+	}
+
+	traceID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	spanIDs := map[int]string{}
+	for _, s := range spans {
+		if spanIDs[s.ID], err = randomHex(8); err != nil {
+			return nil, err
+		}
+	}
+	stringAttr := func(key, value string) keyValue {
+		kv := keyValue{Key: key}
+		kv.Value.StringValue = value
+		return kv
+	}
+
+	out := make([]span, 0, len(spans))
+	for _, s := range spans {
+		st := status{Code: 1} // STATUS_CODE_OK
+		if !s.Success {
+			st.Code = 2 // STATUS_CODE_ERROR
+		}
+		out = append(out, span{
+			TraceID:           traceID,
+			SpanID:            spanIDs[s.ID],
+			ParentSpanID:      spanIDs[s.Parent],
+			Name:              s.Name,
+			Kind:              1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        []keyValue{stringAttr("upgrade_provider.kind", s.Kind)},
+			Status:            st,
+		})
+	}
+
+	type scopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []span `json:"spans"`
+	}
+	type resourceSpans struct {
+		Resource struct {
+			Attributes []keyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	var scope scopeSpans
+	scope.Scope.Name = "github.com/pulumi/upgrade-provider"
+	scope.Spans = out
+	var resource resourceSpans
+	resource.Resource.Attributes = []keyValue{stringAttr("service.name", "upgrade-provider")}
+	resource.ScopeSpans = []scopeSpans{scope}
+	return struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}{[]resourceSpans{resource}}, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// WriteSummary writes a table of the n steps that took the longest to w.
+//
+// Steps are ranked by their self time: the time spent in the step that was not spent in
+// a nested step or pipeline. This keeps steps that only call other steps from crowding
+// out the steps that do the work.
+func (t *Tracer) WriteSummary(w io.Writer, n int) error {
+	spans := t.Spans()
+	self := map[int]time.Duration{}
+	for _, s := range spans {
+		self[s.ID] += s.Duration()
+		if s.Parent != 0 {
+			self[s.Parent] -= s.Duration()
+		}
+	}
+	steps := []Span{}
+	for _, s := range spans {
+		if s.Kind == KindStep {
+			steps = append(steps, s)
+		}
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return self[steps[i].ID] > self[steps[j].ID] })
+	if len(steps) > n {
+		steps = steps[:n]
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SELF\tTOTAL\tSTEP")
+	for _, s := range steps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n",
+			self[s.ID].Round(time.Millisecond), s.Duration().Round(time.Millisecond), s.Name)
+	}
+	return tw.Flush()
+}
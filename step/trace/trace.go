@@ -0,0 +1,114 @@
+// Package trace records when each pipeline and step of an upgrade starts and finishes, so
+// that the time an upgrade takes can be attributed to the steps that took it.
+//
+// Start opens a Span in the Tracer of its context, and the span ends when the returned
+// function is called. Once the upgrade is done, the spans can be written as a Chrome
+// trace or as OTLP JSON, or summarized as the slowest steps.
+package trace
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pulumi/upgrade-provider/step/redact"
+)
+
+// The kinds of Span.
+const (
+	KindPipeline = "pipeline"
+	KindStep     = "step"
+)
+
+// A Span is a single pipeline or step that has finished running.
+type Span struct {
+	// The ID of the span, unique within its Tracer. IDs start at 1.
+	ID int
+	// The ID of the span that encloses this span, or 0 if there is none.
+	Parent int
+	// The name of the pipeline or step.
+	Name string
+	// KindPipeline or KindStep.
+	Kind    string
+	Start   time.Time
+	End     time.Time
+	Success bool
+}
+
+// The Duration of the span.
+func (s Span) Duration() time.Duration { return s.End.Sub(s.Start) }
+
+// A Tracer collects spans.
+//
+// A Tracer is safe for concurrent use. A nil *Tracer discards spans.
+type Tracer struct {
+	m      sync.Mutex
+	nextID int
+	spans  []Span
+}
+
+// New returns an empty Tracer.
+func New() *Tracer { return &Tracer{} }
+
+type tracerKey struct{}
+type spanKey struct{}
+
+// WithTracer returns a context whose pipelines and steps are traced by t.
+func WithTracer(ctx context.Context, t *Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+// FromContext returns the Tracer associated with ctx, or nil if there is none.
+func FromContext(ctx context.Context) *Tracer {
+	t, _ := ctx.Value(tracerKey{}).(*Tracer)
+	return t
+}
+
+// Start a span called name as a child of the span in ctx.
+//
+// The returned context holds the new span, so that spans started within it are its
+// children. Call end when the span finishes.
+//
+// Names are often command lines, so secrets in name are masked by the redact.Redactor
+// associated with ctx.
+func Start(ctx context.Context, kind, name string) (_ context.Context, end func(success bool)) {
+	t := FromContext(ctx)
+	if t == nil {
+		return ctx, func(bool) {}
+	}
+	parent, _ := ctx.Value(spanKey{}).(int)
+	name = redact.FromContext(ctx).String(name)
+
+	t.m.Lock()
+	t.nextID++
+	id := t.nextID
+	t.m.Unlock()
+
+	start := time.Now()
+	return context.WithValue(ctx, spanKey{}, id), func(success bool) {
+		t.m.Lock()
+		defer t.m.Unlock()
+		t.spans = append(t.spans, Span{
+			ID:      id,
+			Parent:  parent,
+			Name:    name,
+			Kind:    kind,
+			Start:   start,
+			End:     time.Now(),
+			Success: success,
+		})
+	}
+}
+
+// Spans returns the finished spans, ordered by when they started.
+func (t *Tracer) Spans() []Span {
+	if t == nil {
+		return nil
+	}
+	t.m.Lock()
+	spans := append([]Span(nil), t.spans...)
+	t.m.Unlock()
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].ID < spans[j].ID })
+	return spans
+}
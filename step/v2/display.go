@@ -3,11 +3,14 @@ package step
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/briandowns/spinner"
+
+	"github.com/pulumi/upgrade-provider/step/ci"
 )
 
 // A display shows an executing pipeline to the user.
@@ -23,6 +26,13 @@ type Display interface {
 	EnterStep(ctx context.Context, name string) error
 	ExitStep(ctx context.Context, success bool) error
 
+	// Enter and exit a nested pipeline.
+	//
+	// Steps entered between EnterPipeline and ExitPipeline belong to the nested
+	// pipeline.
+	EnterPipeline(ctx context.Context, title string) error
+	ExitPipeline(ctx context.Context, success bool) error
+
 	// Draw the current state to the screen.
 	Refresh(ctx context.Context, envs []Env) error
 
@@ -42,6 +52,12 @@ type spinnerDisplay struct {
 	callstack []string
 	labels    []string
 	failed    bool
+
+	// The indexes into callstack of the nested pipelines that are currently running.
+	sections []int
+	// The indexes into callstack of the nested pipelines that have completed
+	// successfully. Their steps are hidden.
+	collapsed map[int]bool
 }
 
 func (s *spinnerDisplay) Start(ctx context.Context, title string) error {
@@ -78,6 +94,37 @@ func (s *spinnerDisplay) ExitStep(ctx context.Context, success bool) error {
 
 }
 
+func (s *spinnerDisplay) EnterPipeline(ctx context.Context, title string) error {
+	s.sections = append(s.sections, len(s.callstack))
+	return s.EnterStep(ctx, title)
+}
+
+func (s *spinnerDisplay) ExitPipeline(ctx context.Context, success bool) error {
+	section := s.sections[len(s.sections)-1]
+	s.sections = s.sections[:len(s.sections)-1]
+	if !success {
+		// Leave the section expanded, so the user can see which step failed.
+		return s.ExitStep(ctx, success)
+	}
+
+	// Collapse the section by dropping the frames of its steps, including those of its
+	// own nested pipelines.
+	s.callstack = s.callstack[:section+1]
+	if len(s.labels) > section+1 {
+		s.labels = s.labels[:section+1]
+	}
+	if s.collapsed == nil {
+		s.collapsed = map[int]bool{}
+	}
+	for i := range s.collapsed {
+		if i > section {
+			delete(s.collapsed, i)
+		}
+	}
+	s.collapsed[section] = true
+	return s.ExitStep(ctx, success)
+}
+
 func (s *spinnerDisplay) Finish(ctx context.Context, success bool) error {
 	var msg string
 	if success {
@@ -90,10 +137,29 @@ func (s *spinnerDisplay) Finish(ctx context.Context, success bool) error {
 	return nil
 }
 
+// Display the pipeline with a spinner when attached to an interactive terminal, and as
+// plain lines of text otherwise (see LineDisplay).
 func DefaultDisplay(opts *options) {
+	if ci.IsInteractive() {
+		SpinnerDisplay(opts)
+	} else {
+		LineDisplay(opts)
+	}
+}
+
+// Display the pipeline as a call tree with an animated spinner.
+func SpinnerDisplay(opts *options) {
 	opts.display = &spinnerDisplay{}
 }
 
+// Display the pipeline as timestamped lines of text, one line per event.
+//
+// When running in GitHub Actions, each pipeline is shown as a collapsible group and the
+// step that failed is annotated with its error.
+func LineDisplay(opts *options) {
+	opts.display = newLineDisplay()
+}
+
 func (p *spinnerDisplay) Refresh(_ context.Context, envs []Env) error {
 	prefix := "--- " + p.title + " --- \n"
 	prefix += p.callTree()
@@ -129,7 +195,11 @@ func (p *spinnerDisplay) callTree() string {
 		} else {
 			indent += 2
 		}
-		prefix := strings.Repeat(" ", indent-2) + "- "
+		bullet := "- "
+		if p.collapsed[i] {
+			bullet = "+ "
+		}
+		prefix := strings.Repeat(" ", indent-2) + bullet
 		if current == i {
 			if p.failed {
 				prefix = prefix[:indent-2] + "X "
@@ -172,11 +242,89 @@ var nullDisplay = nullDisplayType{}
 
 type nullDisplayType struct{}
 
-func (nullDisplayType) Start(ctx context.Context, title string) error    { return nil }
-func (nullDisplayType) SetLabel(ctx context.Context, label string) error { return nil }
-func (nullDisplayType) EnterStep(ctx context.Context, name string) error { return nil }
-func (nullDisplayType) ExitStep(ctx context.Context, success bool) error { return nil }
-func (nullDisplayType) Refresh(ctx context.Context, envs []Env) error    { return nil }
-func (nullDisplayType) Finish(ctx context.Context, success bool) error   { return nil }
-func (nullDisplayType) Pause(context.Context) error                      { return nil }
-func (nullDisplayType) Resume(context.Context) error                     { return nil }
+func (nullDisplayType) Start(ctx context.Context, title string) error         { return nil }
+func (nullDisplayType) SetLabel(ctx context.Context, label string) error      { return nil }
+func (nullDisplayType) EnterStep(ctx context.Context, name string) error      { return nil }
+func (nullDisplayType) ExitStep(ctx context.Context, success bool) error      { return nil }
+func (nullDisplayType) EnterPipeline(ctx context.Context, title string) error { return nil }
+func (nullDisplayType) ExitPipeline(ctx context.Context, success bool) error  { return nil }
+func (nullDisplayType) Refresh(ctx context.Context, envs []Env) error         { return nil }
+func (nullDisplayType) Finish(ctx context.Context, success bool) error        { return nil }
+func (nullDisplayType) Pause(context.Context) error                           { return nil }
+func (nullDisplayType) Resume(context.Context) error                          { return nil }
+
+type displaysKey struct{}
+
+// WithDisplay returns a context whose pipelines also show their progress on d, in
+// addition to the display selected by their options.
+//
+// d is shared by every pipeline launched within ctx, such as an event display created by
+// NewEventDisplay.
+func WithDisplay(ctx context.Context, d Display) context.Context {
+	return context.WithValue(ctx, displaysKey{}, append(getDisplays(ctx), d))
+}
+
+func getDisplays(ctx context.Context) []Display {
+	displays, _ := ctx.Value(displaysKey{}).([]Display)
+	return displays[:len(displays):len(displays)]
+}
+
+// A display that forwards each call to every display in the list.
+type multiDisplay []Display
+
+func (m multiDisplay) each(f func(d Display) error) error {
+	var errs []error
+	for _, d := range m {
+		errs = append(errs, f(d))
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiDisplay) Start(ctx context.Context, title string) error {
+	return m.each(func(d Display) error { return d.Start(ctx, title) })
+}
+
+func (m multiDisplay) SetLabel(ctx context.Context, label string) error {
+	return m.each(func(d Display) error { return d.SetLabel(ctx, label) })
+}
+
+func (m multiDisplay) EnterStep(ctx context.Context, name string) error {
+	return m.each(func(d Display) error { return d.EnterStep(ctx, name) })
+}
+
+func (m multiDisplay) ExitStep(ctx context.Context, success bool) error {
+	return m.each(func(d Display) error { return d.ExitStep(ctx, success) })
+}
+
+func (m multiDisplay) EnterPipeline(ctx context.Context, title string) error {
+	return m.each(func(d Display) error { return d.EnterPipeline(ctx, title) })
+}
+
+func (m multiDisplay) ExitPipeline(ctx context.Context, success bool) error {
+	return m.each(func(d Display) error { return d.ExitPipeline(ctx, success) })
+}
+
+func (m multiDisplay) Refresh(ctx context.Context, envs []Env) error {
+	return m.each(func(d Display) error { return d.Refresh(ctx, envs) })
+}
+
+func (m multiDisplay) Pause(ctx context.Context) error {
+	return m.each(func(d Display) error { return d.Pause(ctx) })
+}
+
+func (m multiDisplay) Resume(ctx context.Context) error {
+	return m.each(func(d Display) error { return d.Resume(ctx) })
+}
+
+func (m multiDisplay) ReportFailure(ctx context.Context, err error) error {
+	return m.each(func(d Display) error {
+		if d, ok := d.(failureDisplay); ok {
+			return d.ReportFailure(ctx, err)
+		}
+		return nil
+	})
+}
+
+func (m multiDisplay) Finish(ctx context.Context, success bool) error {
+	return m.each(func(d Display) error { return d.Finish(ctx, success) })
+}
@@ -0,0 +1,190 @@
+package step
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pulumi/upgrade-provider/colorize"
+	"github.com/pulumi/upgrade-provider/step/ci"
+)
+
+// A display that writes one line per event, for output that is not an interactive
+// terminal, such as CI logs.
+//
+// Each line is prefixed with a timestamp. When running in GitHub Actions, each pipeline is
+// shown as a collapsible group and the failed step is annotated with its error.
+type lineDisplay struct {
+	out    io.Writer
+	now    func() time.Time
+	github bool
+
+	// The paths of the pipelines that are currently running, outermost first.
+	pipelines [][]string
+	frames    []lineFrame
+	paused    int
+	reported  bool
+}
+
+type lineFrame struct {
+	name  string
+	label string
+	start time.Time
+	// If the frame was entered while the display was paused, then it is not shown.
+	hidden bool
+}
+
+// A Display that can be told why a pipeline failed.
+//
+// If a pipeline's display implements failureDisplay, then ReportFailure is called before
+// Finish when the pipeline fails.
+type failureDisplay interface {
+	ReportFailure(ctx context.Context, err error) error
+}
+
+func newLineDisplay() *lineDisplay {
+	return &lineDisplay{
+		out:    os.Stdout,
+		now:    time.Now,
+		github: ci.IsGitHubActions(),
+	}
+}
+
+func (l *lineDisplay) printf(format string, a ...any) {
+	fmt.Fprintf(l.out, "[%s] %s\n", l.now().Format("15:04:05"), fmt.Sprintf(format, a...))
+}
+
+// Open a group for the pipeline at path.
+//
+// GitHub Actions does not support nested groups, so only the innermost pipeline has an
+// open group: callers must close the group of the enclosing pipeline first.
+func (l *lineDisplay) openGroup(path []string) {
+	title := strings.Join(path, " > ")
+	if l.github {
+		fmt.Fprintf(l.out, "::group::%s\n", ci.EscapeData(title))
+	}
+	l.printf("--- %s ---", colorize.Bold(title))
+}
+
+func (l *lineDisplay) closeGroup() {
+	if l.github {
+		fmt.Fprintln(l.out, "::endgroup::")
+	}
+}
+
+func (l *lineDisplay) indent() string {
+	return strings.Repeat("  ", len(l.frames))
+}
+
+func (l *lineDisplay) Start(ctx context.Context, title string) error {
+	l.pipelines = [][]string{{title}}
+	l.openGroup(l.pipelines[0])
+	return nil
+}
+
+func (l *lineDisplay) SetLabel(ctx context.Context, label string) error {
+	if len(l.frames) == 0 {
+		return nil
+	}
+	f := &l.frames[len(l.frames)-1]
+	if f.label == label {
+		return nil
+	}
+	f.label = label
+	if !f.hidden && label != "" {
+		l.printf("%s%s: %s", l.indent(), f.name, label)
+	}
+	return nil
+}
+
+func (l *lineDisplay) EnterStep(ctx context.Context, name string) error {
+	hidden := l.paused > 0
+	if !hidden {
+		l.printf("%s- %s", l.indent(), name)
+	}
+	l.frames = append(l.frames, lineFrame{name: name, start: l.now(), hidden: hidden})
+	return nil
+}
+
+func (l *lineDisplay) ExitStep(ctx context.Context, success bool) error {
+	if len(l.frames) == 0 {
+		return nil
+	}
+	f := l.frames[len(l.frames)-1]
+	l.frames = l.frames[:len(l.frames)-1]
+	if f.hidden {
+		return nil
+	}
+	mark := "✓"
+	if !success {
+		mark = colorize.Warn("X")
+	}
+	l.printf("%s%s %s (%s)", l.indent(), mark, f.name, l.now().Sub(f.start).Round(time.Millisecond))
+	return nil
+}
+
+func (l *lineDisplay) EnterPipeline(ctx context.Context, title string) error {
+	parent := l.pipelines[len(l.pipelines)-1]
+	path := append(append([]string{}, parent...), title)
+	l.pipelines = append(l.pipelines, path)
+	if l.paused == 0 {
+		l.closeGroup()
+		l.openGroup(path)
+	}
+	return l.EnterStep(ctx, title)
+}
+
+func (l *lineDisplay) ExitPipeline(ctx context.Context, success bool) error {
+	if err := l.ExitStep(ctx, success); err != nil {
+		return err
+	}
+	l.pipelines = l.pipelines[:len(l.pipelines)-1]
+	if l.paused == 0 && success {
+		// On failure, the group is left open so that the failed step is visible
+		// when the log is first loaded.
+		l.closeGroup()
+		l.openGroup(l.pipelines[len(l.pipelines)-1])
+	}
+	return nil
+}
+
+// Refresh is a no-op: lines are written as events happen.
+func (l *lineDisplay) Refresh(ctx context.Context, envs []Env) error { return nil }
+
+func (l *lineDisplay) Pause(context.Context) error { l.paused++; return nil }
+
+func (l *lineDisplay) Resume(context.Context) error {
+	if l.paused > 0 {
+		l.paused--
+	}
+	return nil
+}
+
+func (l *lineDisplay) ReportFailure(ctx context.Context, err error) error {
+	if l.reported || !l.github {
+		return nil
+	}
+	l.reported = true
+	l.closeGroup()
+	title := strings.Join(l.pipelines[0], " > ")
+	if path, ok := FailedStep(err); ok {
+		title = strings.Join(path, " > ")
+	}
+	fmt.Fprintf(l.out, "::error title=%s::%s\n", ci.EscapeProperty(title), ci.EscapeData(err.Error()))
+	return nil
+}
+
+func (l *lineDisplay) Finish(ctx context.Context, success bool) error {
+	if !l.reported {
+		l.closeGroup()
+	}
+	msg := "done"
+	if !success {
+		msg = colorize.Warn("failed")
+	}
+	l.printf("--- %s ---", msg)
+	return nil
+}
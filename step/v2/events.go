@@ -0,0 +1,252 @@
+package step
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pulumi/upgrade-provider/step/redact"
+)
+
+// The version of the event schema written by NewEventDisplay.
+//
+// The version is incremented whenever a field is removed or its meaning changes. Adding
+// a field or an event type does not change the version, so consumers should ignore
+// fields and event types they don't recognize.
+const EventsVersion = 1
+
+// The types of Event written by NewEventDisplay.
+const (
+	// A top level pipeline started.
+	EventPipelineStart = "pipeline.start"
+	// A top level pipeline finished. Success and DurationMs are set, and Error is set
+	// if the pipeline failed.
+	EventPipelineFinish = "pipeline.finish"
+	// A nested pipeline started.
+	EventPipelineEnter = "pipeline.enter"
+	// A nested pipeline finished. Success and DurationMs are set.
+	EventPipelineExit = "pipeline.exit"
+	// A step started. Envs holds the environments in scope of the step.
+	EventStepEnter = "step.enter"
+	// The label of the running step changed. Label is set.
+	EventStepLabel = "step.label"
+	// A step finished. Success, DurationMs and the step's final Label are set.
+	EventStepExit = "step.exit"
+)
+
+// An Event is a single line written by NewEventDisplay.
+type Event struct {
+	// The version of the event schema. See EventsVersion.
+	Version int `json:"version"`
+	// The type of the event, such as "step.enter".
+	Type string `json:"type"`
+	// When the event happened.
+	Time time.Time `json:"time"`
+	// The names of the pipelines and steps enclosing the event, outermost first and
+	// ending with the pipeline or step the event is about.
+	Path []string `json:"path"`
+	// The label of the step.
+	Label string `json:"label,omitempty"`
+	// The String() of each Env in scope of the step.
+	Envs []string `json:"envs,omitempty"`
+	// If the pipeline or step succeeded.
+	Success *bool `json:"success,omitempty"`
+	// How long the pipeline or step ran for, in milliseconds.
+	DurationMs *int64 `json:"durationMs,omitempty"`
+	// The error that a failed pipeline returned.
+	Error string `json:"error,omitempty"`
+}
+
+// NewEventDisplay returns a Display that writes each event as a line of JSON (an Event)
+// to w.
+//
+// Unlike other displays, an event display may be shared by any number of pipelines that
+// run one after another, so that all the events of a program are written to w. Use
+// WithDisplay to send the events of all pipelines within a context to an event display.
+//
+// Secrets are masked in labels, envs and errors by the redact.Redactor associated with
+// the context of each event.
+//
+// The display is safe for concurrent use. Errors writing to w are returned from the
+// method that caused the write.
+func NewEventDisplay(w io.Writer) Display {
+	return &eventDisplay{enc: json.NewEncoder(w), now: time.Now}
+}
+
+type eventDisplay struct {
+	m   sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+
+	frames []eventFrame
+	// A step.enter event that has not been written yet.
+	//
+	// step.enter events are held until the next Refresh, which reports the step's
+	// envs.
+	pending *Event
+	// The error reported by ReportFailure.
+	err string
+}
+
+type eventFrame struct {
+	name  string
+	label string
+	start time.Time
+}
+
+func (e *eventDisplay) path() []string {
+	path := make([]string, len(e.frames))
+	for i, f := range e.frames {
+		path[i] = f.name
+	}
+	return path
+}
+
+// Write an event about the innermost frame.
+func (e *eventDisplay) write(typ string, success *bool) error {
+	if err := e.flush(); err != nil {
+		return err
+	}
+	event := e.event(typ)
+	if success != nil {
+		f := e.frames[len(e.frames)-1]
+		duration := event.Time.Sub(f.start).Milliseconds()
+		event.Success = success
+		event.DurationMs = &duration
+	}
+	return e.enc.Encode(event)
+}
+
+func (e *eventDisplay) event(typ string) Event {
+	event := Event{
+		Version: EventsVersion,
+		Type:    typ,
+		Time:    e.now(),
+		Path:    e.path(),
+	}
+	if len(e.frames) > 0 {
+		event.Label = e.frames[len(e.frames)-1].label
+	}
+	return event
+}
+
+func (e *eventDisplay) flush() error {
+	if e.pending == nil {
+		return nil
+	}
+	event := *e.pending
+	e.pending = nil
+	return e.enc.Encode(event)
+}
+
+func (e *eventDisplay) push(name string) {
+	e.frames = append(e.frames, eventFrame{name: name, start: e.now()})
+}
+
+func (e *eventDisplay) pop() {
+	if len(e.frames) > 0 {
+		e.frames = e.frames[:len(e.frames)-1]
+	}
+}
+
+func (e *eventDisplay) Start(ctx context.Context, title string) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.frames = nil
+	e.pending = nil
+	e.err = ""
+	e.push(title)
+	return e.write(EventPipelineStart, nil)
+}
+
+func (e *eventDisplay) SetLabel(ctx context.Context, label string) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if len(e.frames) == 0 {
+		return nil
+	}
+	label = redact.FromContext(ctx).String(label)
+	f := &e.frames[len(e.frames)-1]
+	if f.label == label {
+		return nil
+	}
+	f.label = label
+	return e.write(EventStepLabel, nil)
+}
+
+func (e *eventDisplay) EnterStep(ctx context.Context, name string) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.flush(); err != nil {
+		return err
+	}
+	e.push(name)
+	event := e.event(EventStepEnter)
+	e.pending = &event
+	return nil
+}
+
+func (e *eventDisplay) ExitStep(ctx context.Context, success bool) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.pop()
+	return e.write(EventStepExit, &success)
+}
+
+func (e *eventDisplay) EnterPipeline(ctx context.Context, title string) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.push(title)
+	return e.write(EventPipelineEnter, nil)
+}
+
+func (e *eventDisplay) ExitPipeline(ctx context.Context, success bool) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	defer e.pop()
+	return e.write(EventPipelineExit, &success)
+}
+
+func (e *eventDisplay) Refresh(ctx context.Context, envs []Env) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.pending != nil {
+		redactor := redact.FromContext(ctx)
+		for _, env := range envs {
+			e.pending.Envs = append(e.pending.Envs, redactEnv(redactor, env))
+		}
+	}
+	return e.flush()
+}
+
+func (e *eventDisplay) Pause(context.Context) error  { return nil }
+func (e *eventDisplay) Resume(context.Context) error { return nil }
+
+func (e *eventDisplay) ReportFailure(ctx context.Context, err error) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.err = redact.FromContext(ctx).String(err.Error())
+	return nil
+}
+
+func (e *eventDisplay) Finish(ctx context.Context, success bool) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if err := e.flush(); err != nil {
+		return err
+	}
+	// Every step has exited by the time the pipeline finishes, leaving only the
+	// pipeline's own frame.
+	e.frames = e.frames[:1]
+	defer func() { e.frames = nil }()
+	event := e.event(EventPipelineFinish)
+	duration := event.Time.Sub(e.frames[0].start).Milliseconds()
+	event.Success = &success
+	event.DurationMs = &duration
+	if !success {
+		event.Error = e.err
+	}
+	return e.enc.Encode(event)
+}
@@ -0,0 +1,52 @@
+// Migrate upgrades replay files to the current replay version, in place.
+//
+// Usage:
+//
+//	go run ./step/v2/migrate upgrade/testdata/replay/*.json
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	step "github.com/pulumi/upgrade-provider/step/v2"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: migrate FILE...")
+		os.Exit(2)
+	}
+	for _, path := range os.Args[1:] {
+		exitOnError(migrate(path))
+	}
+}
+
+func migrate(path string) error {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	replay, err := step.ParseReplay(source)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	// Keep values such as "Tfgen & Build SDKs" readable in the migrated file.
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(replay); err != nil {
+		return err
+	}
+	return os.WriteFile(path, bytes.TrimSuffix(b.Bytes(), []byte("\n")), 0600)
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -8,57 +8,122 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/upgrade-provider/step/redact"
 )
 
-type Step struct {
-	Name    string          `json:"name"`
-	Inputs  json.RawMessage `json:"inputs"`
-	Outputs json.RawMessage `json:"outputs"`
-	Impure  bool            `json:"impure,omitempty"`
+// TestingT is the subset of *testing.T that a Replay reports to.
+//
+// A Replay is normally driven by a test, but it can be driven by any TestingT, such as
+// one that reports to the user of a command line tool.
+type TestingT interface {
+	Helper()
+	Logf(format string, args ...any)
+	Errorf(format string, args ...any)
+	Fatalf(format string, args ...any)
+	Fail()
+	FailNow()
+	Failed() bool
 }
 
-func (s *Step) String() string {
-	if s == nil {
-		return "step.Step(nil)"
-	}
-	type Step struct {
-		Name    string
-		Inputs  string
-		Outputs string
-		Impure  bool
-	}
-	return fmt.Sprintf("%#v", Step{
-		Name:    s.Name,
-		Inputs:  string(s.Inputs),
-		Outputs: string(s.Outputs),
-		Impure:  s.Impure,
-	})
-}
+var _ TestingT = (*testing.T)(nil)
 
 type Replay struct {
-	t       *testing.T
-	pending []struct {
-		name   string
-		inputs json.RawMessage
-		index  int
-	}
+	t       TestingT
+	pending []pendingStep
 
 	pipeline int // The index of the current pipline
-	r        *ReplayV1
+	r        *ReplayV2
 
 	next  int // The index of the next step the replay should contain
-	steps []Step
+	steps []StepV2
+	// Which of steps have been matched to a step that ran.
+	matched []bool
+
+	opts replayOptions
+
+	// The enclosing steps of the nested pipelines currently being replayed, innermost
+	// last.
+	nested []replayFrame
+
+	// When the replay is updating its recording, update records the steps that are
+	// replayed and updates tracks where they belong in the recording. See
+	// NewReplayFromFile.
+	update  *record
+	updates []replayUpdate
+
+	// How many steps each of opts.faults has matched, and if any fault was injected.
+	// See Inject.
+	faultCounts []int
+	faulted     bool
+}
+
+type replayFrame struct {
+	next    int
+	steps   []StepV2
+	matched []bool
 }
 
-func NewReplay(t *testing.T, source []byte) *Replay {
+// A ReplayOption configures how a Replay matches the steps that run to the recording.
+type ReplayOption func(*replayOptions)
+
+type replayOptions struct {
+	unordered bool
+	optional  map[string]bool
+	faults    []Fault
+}
+
+// Unordered matches each step that runs to a recorded step of the same pipeline with the
+// same name and inputs, regardless of the order the steps were recorded in.
+//
+// This keeps replays robust to reordering independent steps. If no recorded step has the
+// same inputs, the first unmatched step with the same name is used, so that the
+// difference is reported. Recorded steps that are never matched are reported when their
+// pipeline returns.
+func Unordered() ReplayOption {
+	return func(o *replayOptions) { o.unordered = true }
+}
+
+// Optional allows the steps and nested pipelines called names to be missing from either
+// the recording or the steps that run.
+func Optional(names ...string) ReplayOption {
+	return func(o *replayOptions) {
+		if o.optional == nil {
+			o.optional = map[string]bool{}
+		}
+		for _, name := range names {
+			o.optional[name] = true
+		}
+	}
+}
+
+// A step that the replay has entered but not yet exited.
+type pendingStep struct {
+	name   string
+	envs   []string
+	inputs json.RawMessage
+	index  int
+	// If the outputs of the step were injected by a Fault.
+	injected bool
+}
+
+// NewReplay creates a replay from source, a recording made by WithRecord.
+//
+// source may be of any replay version: see ParseReplay. By default, steps must run in the
+// order they were recorded in: see ReplayOption for other ways to match them.
+func NewReplay(t TestingT, source []byte, opts ...ReplayOption) *Replay {
 	t.Helper()
-	var s ReplayV1
-	err := json.Unmarshal(source, &s)
+	s, err := ParseReplay(source)
 	require.NoError(t, err)
-	return &Replay{t: t, r: &s}
+	r := &Replay{t: t, r: s}
+	for _, o := range opts {
+		o(&r.opts)
+	}
+	return r
 }
 
 func (r *Replay) setPipeline(name string) {
@@ -72,12 +137,14 @@ func (r *Replay) setPipeline(name string) {
 		if p.Name == name {
 			// We need to set up Replay for this pipline.
 			if r.pipeline != i || (i == 0 && r.steps == nil) {
-				r.steps = make([]Step, len(p.Steps))
-				for i, v := range p.Steps {
-					r.steps[i] = *v
-					r.next = 0
-					r.pipeline = i
+				r.reportUnmatched()
+				r.steps = make([]StepV2, len(p.Steps))
+				for j, v := range p.Steps {
+					r.steps[j] = *v
 				}
+				r.matched = make([]bool, len(r.steps))
+				r.next = 0
+				r.pipeline = i
 			}
 			r.t.Logf("Found pipeline %q", name)
 			return
@@ -88,8 +155,27 @@ func (r *Replay) setPipeline(name string) {
 	r.t.Logf("Failed to find pipline %q", name)
 }
 
-func (r *Replay) findNextStep(name string) int {
+// findNextStep returns the index of the recorded step that matches the step called name
+// with inputs, or -1 if there is none.
+//
+// inputs is nil for nested pipelines, which are matched by name alone.
+func (r *Replay) findNextStep(name string, inputs json.RawMessage) int {
 	r.t.Helper()
+	if r.opts.unordered {
+		return r.findAnyStep(name, inputs)
+	}
+	if r.opts.optional[name] {
+		// An optional step may not have been recorded, so we don't skip required
+		// steps to look for it.
+		for i := r.next; i < len(r.steps) && r.opts.optional[r.steps[i].Name]; i++ {
+			if r.steps[i].Name == name {
+				r.t.Logf("Found optional step: %q", name)
+				return i
+			}
+		}
+		r.t.Logf("Optional step %q was not recorded", name)
+		return -1
+	}
 	current := r.next
 	for {
 		// We are attempting to find the next step, but there is no next step.
@@ -102,41 +188,182 @@ func (r *Replay) findNextStep(name string) int {
 			return current
 		}
 		// The replay has a recorded step that didn't show up. This indicates an
-		// error.
-		r.t.Logf("FAIL: Required step %q skipped.", r.steps[current].Name)
-		r.t.Fail()
+		// error, unless the step is optional.
+		if !r.opts.optional[r.steps[current].Name] {
+			r.errorf("Required step %q skipped.", r.steps[current].Name)
+		}
 
 		current++
 	}
 }
 
-func (r *Replay) Enter(_ context.Context, info StepInfo) error {
+// findAnyStep finds the step called name with inputs among the steps that have not yet
+// been matched, regardless of order. See Unordered.
+func (r *Replay) findAnyStep(name string, inputs json.RawMessage) int {
 	r.t.Helper()
-	r.setPipeline(info.Pipeline())
+	fallback := -1
+	for i, s := range r.steps {
+		if r.matched[i] || s.Name != name {
+			continue
+		}
+		if inputs == nil || equalJSON(s.Inputs, inputs) {
+			r.t.Logf("Found step: %q (step %d)", name, i)
+			return i
+		}
+		if fallback == -1 {
+			fallback = i
+		}
+	}
+	if fallback != -1 {
+		r.t.Logf("Found step %q with different inputs (step %d)", name, fallback)
+	}
+	return fallback
+}
+
+// match notes that the recorded step at index has been matched to a step that ran.
+func (r *Replay) match(index int) {
+	r.matched[index] = true
+	r.next = index + 1
+}
+
+// reportUnmatched reports the required steps of the current pipeline that were never
+// matched.
+//
+// The replay only does so for Unordered replays, since ordered replays report each
+// step that was skipped as they pass it.
+func (r *Replay) reportUnmatched() {
+	r.t.Helper()
+	if !r.opts.unordered {
+		return
+	}
+	for i, s := range r.steps {
+		if !r.matched[i] && !r.opts.optional[s.Name] {
+			r.errorf("Required step %q skipped.", s.Name)
+		}
+	}
+}
+
+// exitTopPipeline reports the unmatched steps of the top-level pipeline called name,
+// which has finished running.
+func (r *Replay) exitTopPipeline(name string) {
+	r.t.Helper()
+	r.setPipeline(name)
+	if r.pipeline >= len(r.r.Pipelines) || r.r.Pipelines[r.pipeline].Name != name {
+		return
+	}
+	r.reportUnmatched()
+	// The steps have been reported, so they are not reported again when the replay
+	// moves on to the next pipeline.
+	for i := range r.matched {
+		r.matched[i] = true
+	}
+}
+
+// enterPipeline moves the replay into the nested pipeline called name, which is called
+// from the pipeline parent.
+func (r *Replay) enterPipeline(ctx context.Context, parent, name string) {
+	r.t.Helper()
+	if len(r.nested) == 0 {
+		r.setPipeline(parent)
+	}
+	r.t.Logf("Searching for nested pipeline: %q (from step %d)", name, r.next)
+	current := r.findNextStep(name, nil)
+	if r.update != nil {
+		r.update.enterPipeline(ctx, parent, name)
+		if len(r.nested) == 0 {
+			r.trackUpdate(name, current)
+		}
+	}
+	if current != -1 {
+		r.match(current)
+	}
+	frame := replayFrame{next: r.next, steps: r.steps, matched: r.matched}
+	var steps []StepV2
+	switch {
+	case current == -1:
+		if !r.opts.optional[name] {
+			r.errorf("Expected no nested pipeline, found %q", name)
+		}
+	case r.steps[current].Pipeline == nil:
+		r.errorf("Expected step %q, found nested pipeline", name)
+	default:
+		steps = make([]StepV2, len(r.steps[current].Pipeline.Steps))
+		for i, v := range r.steps[current].Pipeline.Steps {
+			steps[i] = *v
+		}
+	}
+	r.nested = append(r.nested, frame)
+	r.steps, r.next, r.matched = steps, 0, make([]bool, len(steps))
+}
+
+// exitPipeline returns the replay to the pipeline enclosing the current nested pipeline.
+func (r *Replay) exitPipeline() {
+	if r.update != nil {
+		r.update.exitPipeline()
+	}
+	r.reportUnmatched()
+	frame := r.nested[len(r.nested)-1]
+	r.nested = r.nested[:len(r.nested)-1]
+	r.steps, r.next, r.matched = frame.steps, frame.next, frame.matched
+}
+
+func (r *Replay) Enter(ctx context.Context, info StepInfo) error {
+	r.t.Helper()
+	if len(r.nested) == 0 {
+		r.setPipeline(info.Pipeline())
+	}
 	r.t.Logf("Searching for step: %q (from step %d)", info.Name(), r.next)
-	current := r.findNextStep(info.Name())
-	inputBytes, err := json.Marshal(info.Inputs())
+	inputBytes, err := encodeInputs(ctx, info.Inputs())
 	if err != nil {
 		return err
 	}
-	r.pending = append(r.pending, struct {
-		name   string
-		inputs json.RawMessage
-		index  int
-	}{info.Name(), inputBytes, current})
+	current := r.findNextStep(info.Name(), inputBytes)
+	r.pending = append(r.pending, pendingStep{
+		name:   info.Name(),
+		envs:   envStrings(ctx),
+		inputs: inputBytes,
+		index:  current,
+	})
+	if r.update != nil {
+		if err := r.update.Enter(ctx, info); err != nil {
+			return err
+		}
+		if len(r.nested) == 0 {
+			r.trackUpdate(info.Name(), current)
+		}
+		if current != -1 {
+			// Impure steps are not run, so they can't mark themselves impure or
+			// retry.
+			s := r.update.current()
+			s.Impure = r.steps[current].Impure
+			s.Retries = r.steps[current].Retries
+		}
+	}
+
+	if out, ok := r.inject(info, current); ok {
+		r.match(current)
+		r.pending[len(r.pending)-1].injected = true
+		return ReturnImmediatly{Out: out}
+	}
 
 	if current != -1 {
 		// We have found a step, so move on to the next step
-		r.next = current + 1
+		r.match(current)
 
 		// If a step is impure, we can't test it, so just have it return what is
 		// expected.
 		if r.steps[current].Impure {
+			for i, retry := range r.steps[current].Retries {
+				r.t.Logf("Replaying retry %d of %q: %s", i+1, info.Name(), retry)
+			}
 			var out []any
 			err := json.Unmarshal(r.steps[current].Outputs, &out)
 			if err != nil {
 				return fmt.Errorf("failed to unmarshal replay outputs: %w", err)
 			}
+			if stepErr := r.steps[current].Error; stepErr != nil && len(out) > 0 {
+				out[len(out)-1] = *stepErr
+			}
 			return ReturnImmediatly{Out: out}
 		}
 	}
@@ -144,7 +371,7 @@ func (r *Replay) Enter(_ context.Context, info StepInfo) error {
 	return nil
 }
 
-func (r *Replay) Exit(_ context.Context, output []any) error {
+func (r *Replay) Exit(ctx context.Context, output []any) error {
 	r.t.Helper()
 	if len(r.pending) == 0 {
 		return fmt.Errorf("internal: exit without entry")
@@ -152,33 +379,49 @@ func (r *Replay) Exit(_ context.Context, output []any) error {
 	exiting := r.pending[len(r.pending)-1]
 	r.pending = r.pending[:len(r.pending)-1]
 
-	if exiting.index == -1 {
-		outputBytes, err := json.Marshal(output)
+	outputBytes, stepErr, err := encodeOutputs(ctx, output)
+	if err != nil {
+		return err
+	}
+	if r.update != nil {
+		s, err := r.update.exit(ctx, output)
 		if err != nil {
 			return err
 		}
+		if exiting.index != -1 {
+			// The duration of a replayed step says nothing about how long the step
+			// takes, so we keep the recorded duration.
+			s.DurationMs = r.steps[exiting.index].DurationMs
+		}
+	}
 
-		r.t.Logf("Expected no step, found %s", &Step{
+	if exiting.index == -1 {
+		if r.opts.optional[exiting.name] {
+			return nil
+		}
+		r.errorf("Expected no step, found %s", &StepV2{
 			Name:    exiting.name,
+			Envs:    exiting.envs,
 			Inputs:  exiting.inputs,
 			Outputs: outputBytes,
+			Error:   stepErr,
 		})
-		r.t.Fail()
 
 		return nil
 	}
 
-	outputBytes, err := json.Marshal(output)
-	if err != nil {
-		return err
-	}
-
 	expected := r.steps[exiting.index]
-	if assert.JSONEqf(r.t, string(expected.Inputs), string(exiting.inputs), "%s: inputs", exiting.name) &&
-		assert.JSONEqf(r.t, string(expected.Outputs), string(outputBytes), "%s: outputs", exiting.name) {
-		// Inputs and outputs match: no violation
-		return nil
+	if exiting.injected {
+		// The outputs of the step are the fault's, not the recording's.
+		expected.Outputs, expected.Error = outputBytes, stepErr
+	}
+	// Steps migrated from V1 replays have no recorded envs to check against.
+	if expected.Envs != nil {
+		assert.Equalf(r.checks(), expected.Envs, exiting.envs, "%s: envs", exiting.name)
 	}
+	assert.JSONEqf(r.checks(), string(expected.Inputs), string(exiting.inputs), "%s: inputs", exiting.name)
+	assert.JSONEqf(r.checks(), string(expected.Outputs), string(outputBytes), "%s: outputs", exiting.name)
+	assert.Equalf(r.checks(), expected.Error, stepErr, "%s: error", exiting.name)
 
 	return nil
 }
@@ -197,6 +440,9 @@ type FinishRecord func() error
 // WithRecord embeds a recorder in the context. The recorder will write a re-playable
 // context to filePath.
 //
+// If filePath is empty, then the recording is only kept in memory. Use Recording to
+// access it.
+//
 // Example usage:
 //
 //	if file := os.Getenv("STEP_RECORD"); file != "" {
@@ -217,88 +463,177 @@ func WithRecord(ctx context.Context, filePath string) (context.Context, io.Close
 
 type record struct {
 	filePath  string
-	pipelines []*replayPipeline
+	pipelines []*RecordV2
+
+	// The nested pipelines currently being recorded, innermost last.
+	nested []*RecordV2
+	// The steps and nested pipelines currently running, innermost last.
+	running []runningStep
+	// The ID of the last step recorded.
+	lastID int
 }
 
-type replayPipeline struct {
-	name         string
-	steps        []*Step
-	partialSteps []*Step
+type runningStep struct {
+	*StepV2
+	start time.Time
 }
 
-func (r *record) Close() error { return os.WriteFile(r.filePath, r.Marshal(), 0600) }
+func (r *record) Close() error {
+	if r.filePath == "" {
+		return nil
+	}
+	return os.WriteFile(r.filePath, r.Marshal(), 0600)
+}
+
+// Recording returns the recording made so far by the recorder embedded in ctx by
+// WithRecord.
+//
+// Steps that have not yet finished are included without outputs. If ctx has no
+// recorder, then Recording returns false.
+func Recording(ctx context.Context) ([]byte, bool) {
+	r, ok := ctx.Value(recordKey{}).(*record)
+	if !ok {
+		return nil, false
+	}
+	return r.Marshal(), true
+}
 
 func (r ReturnImmediatly) Error() string {
 	return "a signal error for an immediate return"
 }
 
-func (r *record) pipeline(name string) *replayPipeline {
-	latest := func() *replayPipeline { return r.pipelines[len(r.pipelines)-1] }
+func (r *record) pipeline(name string) *RecordV2 {
+	if len(r.nested) > 0 {
+		return r.nested[len(r.nested)-1]
+	}
+	latest := func() *RecordV2 { return r.pipelines[len(r.pipelines)-1] }
 	if name == "" {
 		return latest()
 	}
-	if len(r.pipelines) == 0 || latest().name != name {
-		p := &replayPipeline{
-			name: name,
-		}
+	if len(r.pipelines) == 0 || latest().Name != name {
+		p := &RecordV2{Name: name}
 		r.pipelines = append(r.pipelines, p)
 		return p
 	}
 	return latest()
 }
 
-func (r *record) Enter(_ context.Context, info StepInfo) error {
-	result, err := json.Marshal(info.Inputs())
+// push starts recording s as the next step of p.
+func (r *record) push(p *RecordV2, s *StepV2) {
+	r.lastID++
+	s.ID = r.lastID
+	if current := r.current(); current != nil {
+		s.Parent = current.ID
+	}
+	p.Steps = append(p.Steps, s)
+	r.running = append(r.running, runningStep{StepV2: s, start: time.Now()})
+}
+
+// pop finishes recording the innermost running step.
+func (r *record) pop() *StepV2 {
+	s := r.running[len(r.running)-1]
+	r.running = r.running[:len(r.running)-1]
+	s.DurationMs = time.Since(s.start).Milliseconds()
+	return s.StepV2
+}
+
+// current returns the innermost running step, or nil if no step is running.
+func (r *record) current() *StepV2 {
+	if len(r.running) == 0 {
+		return nil
+	}
+	return r.running[len(r.running)-1].StepV2
+}
+
+// enterPipeline starts recording the nested pipeline called name as the next step of
+// parent.
+func (r *record) enterPipeline(ctx context.Context, parent, name string) {
+	s := &StepV2{
+		Name:     name,
+		Envs:     envStrings(ctx),
+		Pipeline: &RecordV2{Name: name},
+	}
+	r.push(r.pipeline(parent), s)
+	r.nested = append(r.nested, s.Pipeline)
+}
+
+// exitPipeline finishes recording the current nested pipeline.
+func (r *record) exitPipeline() {
+	r.nested = r.nested[:len(r.nested)-1]
+	r.pop()
+}
+
+func (r *record) Enter(ctx context.Context, info StepInfo) error {
+	result, err := encodeInputs(ctx, info.Inputs())
 	if err != nil {
 		return fmt.Errorf("cannot record: %w", err)
 	}
-	s := &Step{
+	r.push(r.pipeline(info.Pipeline()), &StepV2{
 		Name:   info.Name(),
+		Envs:   envStrings(ctx),
 		Inputs: result,
-	}
-	p := r.pipeline(info.Pipeline())
-	p.partialSteps = append(p.partialSteps, s)
-	p.steps = append(p.steps, s)
+	})
 	return nil
 }
 
-func (r *record) Exit(_ context.Context, output []any) error {
-	p := r.pipeline("")
-	topInput := p.partialSteps[len(p.partialSteps)-1]
-	p.partialSteps = p.partialSteps[:len(p.partialSteps)-1]
+func (r *record) Exit(ctx context.Context, output []any) error {
+	_, err := r.exit(ctx, output)
+	return err
+}
 
-	result, err := json.Marshal(output)
+// exit finishes recording the innermost running step, which returned output.
+func (r *record) exit(ctx context.Context, output []any) (*StepV2, error) {
+	s := r.pop()
+	result, stepErr, err := encodeOutputs(ctx, output)
 	if err != nil {
-		return fmt.Errorf("cannot record: %w", err)
+		return nil, fmt.Errorf("cannot record: %w", err)
 	}
-	topInput.Outputs = json.RawMessage(result)
+	s.Outputs = result
+	s.Error = stepErr
 
-	return nil
+	return s, nil
 }
 
 func (r *record) String() string { return "Recording" }
 
-type ReplayV1 struct {
-	Pipelines []RecordV1 `json:"pipelines"`
-}
-
-type RecordV1 struct {
-	Name  string  `json:"name"`
-	Steps []*Step `json:"steps"`
-}
-
 func (r *record) Marshal() []byte {
-	pipelines := make([]RecordV1, len(r.pipelines))
+	pipelines := make([]RecordV2, len(r.pipelines))
 
 	for i, p := range r.pipelines {
-		pipelines[i] = RecordV1{p.name, p.steps}
+		pipelines[i] = *p
 	}
 
-	m, err := json.MarshalIndent(ReplayV1{pipelines}, "", "  ")
-	if err != nil {
-		panic(err)
+	return (&ReplayV2{Version: ReplayVersion, Pipelines: pipelines}).marshal()
+}
+
+// envStrings returns the String() of each env that a step running in ctx runs in, leaving
+// out the envs that record and replay steps.
+//
+// The values of secret environment variables are masked, and registered as secrets so
+// that they are masked wherever else they appear.
+func envStrings(ctx context.Context) []string {
+	redactor := redact.FromContext(ctx)
+	strs := []string{}
+	for _, env := range getEnvs(ctx) {
+		switch env.(type) {
+		case *record, *Replay:
+			continue
+		}
+		strs = append(strs, redactEnv(redactor, env))
+	}
+	return strs
+}
+
+// redactEnv returns the String() of env with secrets masked by redactor.
+//
+// The value of a secret environment variable is masked, and registered as a secret so
+// that it is masked wherever else it appears.
+func redactEnv(redactor *redact.Redactor, env Env) string {
+	if env, ok := env.(*EnvVar); ok && redact.IsSecret(env.Key) {
+		redactor.AddSecret(env.Value)
+		return (&EnvVar{Key: env.Key, Value: redact.Mask}).String()
 	}
-	return m
+	return redactor.String(env.String())
 }
 
 type recordKey struct{}
@@ -322,9 +657,7 @@ func MarkImpure(ctx context.Context) {
 		// If we are not run in a record context, we do nothing here.
 		return
 	}
-	p := r.pipeline("")
-	current := p.partialSteps[len(p.partialSteps)-1]
-	current.Impure = true
+	r.current().Impure = true
 }
 
 func IsReplay(ctx context.Context) bool {
@@ -361,7 +694,7 @@ func CallWithReplay(ctx context.Context, pipeline, stepName string, f any) error
 		r.t.Fatalf("f must be a func")
 	}
 
-	stepN := r.findNextStep(stepName)
+	stepN := r.findNextStep(stepName, nil)
 	if stepN == -1 {
 		r.t.Fatalf("Could not find replay for %q to call", stepName)
 	}
@@ -0,0 +1,100 @@
+package step
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// A Fault overrides the outputs of a recorded step when it is replayed, so that tests can
+// exercise failures that were never recorded. See Inject.
+type Fault struct {
+	// The step to override. Step is matched against the name of a step or, for steps
+	// created by Cmd, against the command line being run, as for WithStepTimeouts: "git
+	// push" matches `git push origin HEAD ...` and "git" matches every `git` invocation.
+	Step string
+	// Which of the steps that Step matches to override, counting from 1 in the order they
+	// run. If N is 0, every step that Step matches is overridden.
+	N int
+
+	// The outputs the step returns instead of its recorded outputs, not including its
+	// error. If Outputs is nil and Err is set, the step returns zero values.
+	Outputs []any
+	// The error the step returns.
+	Err error
+}
+
+// Inject overrides the recorded outputs of the steps that faults match.
+//
+// A step that a fault overrides is not run, even if it was recorded as pure. The step
+// must be in the recording, which determines how many outputs it has.
+//
+// The steps that run after a fault are expected to depart from the recording, so
+// mismatches between them and the recording are logged instead of failing the test. An
+// impure step that was not recorded still halts its pipeline.
+func Inject(faults ...Fault) ReplayOption {
+	return func(o *replayOptions) { o.faults = append(o.faults, faults...) }
+}
+
+// inject returns the outputs that a fault injects into the step described by info, which
+// was recorded at index, or false if no fault applies.
+func (r *Replay) inject(info StepInfo, index int) ([]any, bool) {
+	r.t.Helper()
+	if len(r.opts.faults) == 0 {
+		return nil, false
+	}
+	if r.faultCounts == nil {
+		r.faultCounts = make([]int, len(r.opts.faults))
+	}
+	line := commandLine(info)
+	var fault *Fault
+	for i := range r.opts.faults {
+		f := &r.opts.faults[i]
+		if f.Step != line && !strings.HasPrefix(line, f.Step+" ") {
+			continue
+		}
+		r.faultCounts[i]++
+		if fault == nil && (f.N == 0 || f.N == r.faultCounts[i]) {
+			fault = f
+		}
+	}
+	if fault == nil {
+		return nil, false
+	}
+	if index == -1 {
+		r.t.Errorf("Cannot inject a fault into %q: the step was not recorded", line)
+		return nil, false
+	}
+
+	var out []any
+	if err := json.Unmarshal(r.steps[index].Outputs, &out); err != nil || len(out) == 0 {
+		r.t.Fatalf("Cannot inject a fault into %q: invalid recorded outputs %s",
+			line, r.steps[index].Outputs)
+	}
+	switch {
+	case fault.Outputs != nil:
+		if len(fault.Outputs) != len(out)-1 {
+			r.t.Fatalf("Fault for %q has %d outputs, but the step returns %d",
+				line, len(fault.Outputs), len(out)-1)
+		}
+		copy(out, fault.Outputs)
+	case fault.Err != nil:
+		clear(out)
+	}
+	out[len(out)-1] = fault.Err
+	r.t.Logf("Injecting fault into %q: outputs %v, error %v", line, fault.Outputs, fault.Err)
+	r.faulted = true
+	return out, true
+}
+
+// commandLine returns the command line run by a step created by Cmd, or the name of any
+// other step.
+func commandLine(info StepInfo) string {
+	if in := info.Inputs(); len(in) == 2 {
+		name, _ := in[0].(string)
+		args, ok := in[1].([]string)
+		if ok && name == info.Name() {
+			return strings.Join(append([]string{name}, args...), " ")
+		}
+	}
+	return info.Name()
+}
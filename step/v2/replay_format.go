@@ -0,0 +1,267 @@
+package step
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/pulumi/upgrade-provider/step/redact"
+)
+
+// The version of the replay format written by WithRecord.
+//
+// NewReplay reads replays of this version and of every earlier version.
+const ReplayVersion = 2
+
+// ReplayV1 is the original replay format.
+//
+// A V1 replay records only the name, inputs and outputs of each step. Use MigrateV1 to
+// convert it to the current format.
+type ReplayV1 struct {
+	Pipelines []RecordV1 `json:"pipelines"`
+}
+
+type RecordV1 struct {
+	Name  string  `json:"name"`
+	Steps []*Step `json:"steps"`
+}
+
+// A Step of a ReplayV1.
+type Step struct {
+	Name    string          `json:"name"`
+	Inputs  json.RawMessage `json:"inputs,omitempty"`
+	Outputs json.RawMessage `json:"outputs,omitempty"`
+	Impure  bool            `json:"impure,omitempty"`
+	// The errors of failed attempts that were retried before the step produced Outputs.
+	Retries []string `json:"retries,omitempty"`
+
+	// Pipeline is set when the step is a nested pipeline, in which case it holds the
+	// steps of the nested pipeline.
+	Pipeline *RecordV1 `json:"pipeline,omitempty"`
+}
+
+func (s *Step) String() string {
+	if s == nil {
+		return "step.Step(nil)"
+	}
+	type Step struct {
+		Name     string
+		Inputs   string
+		Outputs  string
+		Impure   bool
+		Pipeline bool
+	}
+	return fmt.Sprintf("%#v", Step{
+		Name:     s.Name,
+		Inputs:   string(s.Inputs),
+		Outputs:  string(s.Outputs),
+		Impure:   s.Impure,
+		Pipeline: s.Pipeline != nil,
+	})
+}
+
+// ReplayV2 is the current replay format.
+//
+// On top of a ReplayV1, a V2 replay records the envs that each step ran in, how long it
+// took, the error it failed with and which step called it.
+type ReplayV2 struct {
+	// Always ReplayVersion.
+	Version   int        `json:"version"`
+	Pipelines []RecordV2 `json:"pipelines"`
+}
+
+func (r *ReplayV2) marshal() []byte {
+	// Keep values such as "Tfgen & Build SDKs" readable.
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		panic(err)
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
+
+type RecordV2 struct {
+	Name  string    `json:"name"`
+	Steps []*StepV2 `json:"steps"`
+}
+
+// A StepV2 is a single step of a ReplayV2.
+type StepV2 struct {
+	// The ID of the step, unique within its replay. IDs start at 1.
+	ID int `json:"id"`
+	// The ID of the step or nested pipeline that was running when this step was
+	// called, or 0 if there was none.
+	Parent int    `json:"parent,omitempty"`
+	Name   string `json:"name"`
+	// The String() of each Env in scope of the step, outermost first, such as
+	// `cd "/path/to/repo"`.
+	//
+	// Envs is nil for steps migrated from a ReplayV1, which did not record envs.
+	Envs    []string        `json:"envs"`
+	Inputs  json.RawMessage `json:"inputs,omitempty"`
+	Outputs json.RawMessage `json:"outputs,omitempty"`
+	// The error that the step returned. The error is recorded here instead of in
+	// Outputs, where it would be null.
+	Error  *ReplayError `json:"error,omitempty"`
+	Impure bool         `json:"impure,omitempty"`
+	// The errors of failed attempts that were retried before the step produced Outputs.
+	Retries []string `json:"retries,omitempty"`
+	// How long the step ran for, in milliseconds.
+	DurationMs int64 `json:"durationMs,omitempty"`
+
+	// Pipeline is set when the step is a nested pipeline, in which case it holds the
+	// steps of the nested pipeline.
+	Pipeline *RecordV2 `json:"pipeline,omitempty"`
+}
+
+func (s *StepV2) String() string {
+	if s == nil {
+		return "step.StepV2(nil)"
+	}
+	type Step struct {
+		Name     string
+		Envs     []string
+		Inputs   string
+		Outputs  string
+		Error    string
+		Impure   bool
+		Pipeline bool
+	}
+	var err string
+	if s.Error != nil {
+		err = s.Error.String()
+	}
+	return fmt.Sprintf("%#v", Step{
+		Name:     s.Name,
+		Envs:     s.Envs,
+		Inputs:   string(s.Inputs),
+		Outputs:  string(s.Outputs),
+		Error:    err,
+		Impure:   s.Impure,
+		Pipeline: s.Pipeline != nil,
+	})
+}
+
+// A ReplayError is an error recorded in a replay.
+//
+// When a replay substitutes the outputs of an impure step that failed, the step returns
+// its recorded ReplayError.
+type ReplayError struct {
+	Message string `json:"message"`
+	// The Go type of the error that was recorded, such as "*fs.PathError".
+	Type string `json:"type"`
+}
+
+func (err ReplayError) Error() string { return err.Message }
+
+func (err ReplayError) String() string { return fmt.Sprintf("%s: %s", err.Type, err.Message) }
+
+// newReplayError records err, which may be nil.
+func newReplayError(err error) *ReplayError {
+	if err == nil {
+		return nil
+	}
+	var replayed ReplayError
+	if errors.As(err, &replayed) && replayed.Message == err.Error() {
+		// err was replayed, so we keep the type that was originally recorded.
+		return &replayed
+	}
+	return &ReplayError{Message: err.Error(), Type: fmt.Sprintf("%T", err)}
+}
+
+// encodeInputs returns the JSON encoding of the inputs of a step, with secrets masked.
+func encodeInputs(ctx context.Context, inputs []any) (json.RawMessage, error) {
+	b, err := json.Marshal(inputs)
+	if err != nil {
+		return nil, err
+	}
+	return redact.FromContext(ctx).JSON(b), nil
+}
+
+// encodeOutputs splits the outputs of a step into their JSON encoding and the error the
+// step returned, with secrets masked.
+func encodeOutputs(ctx context.Context, outputs []any) (json.RawMessage, *ReplayError, error) {
+	redactor := redact.FromContext(ctx)
+	var stepErr *ReplayError
+	if len(outputs) > 0 {
+		if err, ok := outputs[len(outputs)-1].(error); ok {
+			stepErr = newReplayError(err)
+			stepErr.Message = redactor.String(stepErr.Message)
+			outputs = append(outputs[:len(outputs)-1:len(outputs)-1], nil)
+		}
+	}
+	b, err := json.Marshal(outputs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return redactor.JSON(b), stepErr, nil
+}
+
+// ParseReplay reads a replay of any version, migrating it to a ReplayV2.
+func ParseReplay(source []byte) (*ReplayV2, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(source, &header); err != nil {
+		return nil, err
+	}
+	switch header.Version {
+	// V1 replays have no version field.
+	case 0, 1:
+		var v1 ReplayV1
+		if err := json.Unmarshal(source, &v1); err != nil {
+			return nil, err
+		}
+		return MigrateV1(v1), nil
+	case 2:
+		var v2 ReplayV2
+		if err := json.Unmarshal(source, &v2); err != nil {
+			return nil, err
+		}
+		return &v2, nil
+	default:
+		return nil, fmt.Errorf("unsupported replay version %d (expected at most %d)",
+			header.Version, ReplayVersion)
+	}
+}
+
+// MigrateV1 converts a V1 replay to a V2 replay.
+//
+// V1 replays don't record envs, durations or errors, so these are left empty. V1 replays
+// don't record which step called which either, so the only parents of migrated steps are
+// nested pipelines.
+func MigrateV1(r ReplayV1) *ReplayV2 {
+	var nextID int
+	var migrate func(r RecordV1, parent int) RecordV2
+	migrate = func(r RecordV1, parent int) RecordV2 {
+		out := RecordV2{Name: r.Name, Steps: make([]*StepV2, len(r.Steps))}
+		for i, s := range r.Steps {
+			nextID++
+			step := &StepV2{
+				ID:      nextID,
+				Parent:  parent,
+				Name:    s.Name,
+				Inputs:  s.Inputs,
+				Outputs: s.Outputs,
+				Impure:  s.Impure,
+				Retries: s.Retries,
+			}
+			if s.Pipeline != nil {
+				p := migrate(*s.Pipeline, step.ID)
+				step.Pipeline = &p
+			}
+			out.Steps[i] = step
+		}
+		return out
+	}
+
+	out := &ReplayV2{Version: ReplayVersion, Pipelines: make([]RecordV2, len(r.Pipelines))}
+	for i, p := range r.Pipelines {
+		out.Pipelines[i] = migrate(p, 0)
+	}
+	return out
+}
@@ -0,0 +1,219 @@
+package step
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/pulumi/upgrade-provider/colorize"
+)
+
+// WriteTree writes the pipelines of r to w as a tree, showing each step with its envs,
+// inputs, outputs and error.
+//
+// Steps are nested under the step that called them. Values longer than width are
+// truncated, unless width is 0.
+func (r *ReplayV2) WriteTree(w io.Writer, width int) error {
+	depths := map[int]int{}
+	var err error
+	printf := func(depth int, format string, a ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%s%s\n", strings.Repeat("  ", depth), fmt.Sprintf(format, a...))
+		}
+	}
+	var writeSteps func(steps []*StepV2)
+	writeSteps = func(steps []*StepV2) {
+		for _, s := range steps {
+			depth := 0
+			if d, ok := depths[s.Parent]; ok {
+				depth = d + 1
+			}
+			depths[s.ID] = depth
+
+			var notes []string
+			if s.Pipeline != nil {
+				notes = append(notes, "pipeline")
+			}
+			if s.Impure {
+				notes = append(notes, "impure")
+			}
+			if s.DurationMs > 0 {
+				notes = append(notes, fmt.Sprintf("%dms", s.DurationMs))
+			}
+			if len(s.Retries) > 0 {
+				notes = append(notes, fmt.Sprintf("%d retries", len(s.Retries)))
+			}
+			header := "- " + colorize.Bold(s.Name)
+			if len(notes) > 0 {
+				header += " (" + strings.Join(notes, ", ") + ")"
+			}
+			printf(depth, "%s", header)
+			for _, env := range s.Envs {
+				printf(depth+2, "env:     %s", truncate(env, width))
+			}
+			if s.Pipeline == nil {
+				printf(depth+2, "inputs:  %s", truncate(compactJSON(s.Inputs), width))
+				printf(depth+2, "outputs: %s", truncate(compactJSON(s.Outputs), width))
+			}
+			if s.Error != nil {
+				printf(depth+2, "error:   %s", colorize.Warn(truncate(s.Error.String(), width)))
+			}
+			if s.Pipeline != nil {
+				writeSteps(s.Pipeline.Steps)
+			}
+		}
+	}
+	for i, p := range r.Pipelines {
+		if i > 0 {
+			printf(0, "")
+		}
+		printf(0, "=== %s ===", colorize.Bold(p.Name))
+		writeSteps(p.Steps)
+	}
+	return err
+}
+
+// DiffReplays writes the differences between the steps of a and b to w.
+//
+// Steps are aligned by their name and the pipelines that enclose them. For steps that
+// appear in both replays, DiffReplays shows the envs, inputs, outputs and errors that
+// differ. Envs are only compared when both replays recorded them.
+//
+// DiffReplays reports if a and b differ.
+func DiffReplays(w io.Writer, a, b *ReplayV2) (bool, error) {
+	as, bs := flattenReplay(a), flattenReplay(b)
+	keys := func(steps []pathStep) []string {
+		k := make([]string, len(steps))
+		for i, s := range steps {
+			k[i] = s.path
+		}
+		return k
+	}
+
+	var err error
+	printf := func(format string, a ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format+"\n", a...)
+		}
+	}
+	var changed, removed, added int
+	m := difflib.NewMatcher(keys(as), keys(bs))
+	for _, op := range m.GetOpCodes() {
+		if op.Tag != 'e' {
+			for _, s := range as[op.I1:op.I2] {
+				removed++
+				printf("%s %s", colorize.Warn("-"), s.path)
+			}
+			for _, s := range bs[op.J1:op.J2] {
+				added++
+				printf("%s %s", colorize.Warn("+"), s.path)
+			}
+			continue
+		}
+		for i := 0; i < op.I2-op.I1; i++ {
+			sa, sb := as[op.I1+i], bs[op.J1+i]
+			diffs := diffSteps(sa.step, sb.step)
+			if len(diffs) == 0 {
+				continue
+			}
+			changed++
+			printf("%s %s", colorize.Warn("~"), colorize.Bold(sa.path))
+			for _, d := range diffs {
+				printf("    %s:", d.field)
+				printf("      %s %s", colorize.Warn("-"), d.a)
+				printf("      %s %s", colorize.Warn("+"), d.b)
+			}
+		}
+	}
+
+	differ := changed+removed+added > 0
+	if differ {
+		printf("\n%d changed, %d removed, %d added", changed, removed, added)
+	} else {
+		printf("The recordings match (%d steps)", len(as))
+	}
+	return differ, err
+}
+
+// A step of a replay, with the path of pipelines that enclose it.
+type pathStep struct {
+	path string
+	step *StepV2
+}
+
+func flattenReplay(r *ReplayV2) []pathStep {
+	var out []pathStep
+	var flatten func(path []string, steps []*StepV2)
+	flatten = func(path []string, steps []*StepV2) {
+		for _, s := range steps {
+			stepPath := append(append([]string{}, path...), s.Name)
+			out = append(out, pathStep{path: strings.Join(stepPath, " > "), step: s})
+			if s.Pipeline != nil {
+				flatten(stepPath, s.Pipeline.Steps)
+			}
+		}
+	}
+	for _, p := range r.Pipelines {
+		flatten([]string{p.Name}, p.Steps)
+	}
+	return out
+}
+
+type fieldDiff struct{ field, a, b string }
+
+func diffSteps(a, b *StepV2) []fieldDiff {
+	var diffs []fieldDiff
+	if a.Envs != nil && b.Envs != nil && !reflect.DeepEqual(a.Envs, b.Envs) {
+		diffs = append(diffs, fieldDiff{"envs", strings.Join(a.Envs, "; "), strings.Join(b.Envs, "; ")})
+	}
+	if !equalJSON(a.Inputs, b.Inputs) {
+		diffs = append(diffs, fieldDiff{"inputs", compactJSON(a.Inputs), compactJSON(b.Inputs)})
+	}
+	if !equalJSON(a.Outputs, b.Outputs) {
+		diffs = append(diffs, fieldDiff{"outputs", compactJSON(a.Outputs), compactJSON(b.Outputs)})
+	}
+	if !reflect.DeepEqual(a.Error, b.Error) {
+		errString := func(err *ReplayError) string {
+			if err == nil {
+				return "<none>"
+			}
+			return err.String()
+		}
+		diffs = append(diffs, fieldDiff{"error", errString(a.Error), errString(b.Error)})
+	}
+	if a.Impure != b.Impure {
+		diffs = append(diffs, fieldDiff{"impure", fmt.Sprint(a.Impure), fmt.Sprint(b.Impure)})
+	}
+	return diffs
+}
+
+func equalJSON(a, b json.RawMessage) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func compactJSON(v json.RawMessage) string {
+	if len(v) == 0 {
+		return "<none>"
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, v); err != nil {
+		return string(v)
+	}
+	return b.String()
+}
+
+func truncate(s string, width int) string {
+	if r := []rune(s); width > 0 && len(r) > width {
+		return string(r[:width]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,219 @@
+package step
+
+import (
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The environment variable that puts replays created by NewReplayFromFile into update
+// mode.
+const UpdateReplaysEnv = "UPDATE_REPLAYS"
+
+// NewReplayFromFile creates a replay from the recording at path, configured by opts.
+//
+// If the UPDATE_REPLAYS environment variable is set to a true value (such as "1"), then
+// the replay is in update mode: instead of failing the test when the steps that run
+// differ from the recording, the recording at path is rewritten with the steps that ran
+// once the test finishes, and a diff of the changes is logged.
+//
+// Impure steps keep their recorded outputs, since they are not run, while pure steps are
+// recorded with the outputs of the current code. Steps of the recording that the test
+// did not reach, such as the steps after the last step that ran, are kept as they are. A
+// test that fails for any other reason does not update its recording.
+func NewReplayFromFile(t *testing.T, path string, opts ...ReplayOption) *Replay {
+	t.Helper()
+	source, err := os.ReadFile(path)
+	require.NoError(t, err)
+	r := NewReplay(t, source, opts...)
+	if update, _ := strconv.ParseBool(os.Getenv(UpdateReplaysEnv)); !update {
+		return r
+	}
+
+	r.update = &record{}
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("Not updating %s: the test failed", path)
+			return
+		}
+		if len(r.opts.faults) > 0 {
+			// The outputs of the steps would be the faults', not the recording's.
+			t.Logf("Not updating %s: the replay injects faults", path)
+			return
+		}
+		updated := r.updated().marshal()
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(source)),
+			B:        difflib.SplitLines(string(updated)),
+			FromFile: path,
+			ToFile:   path + " (updated)",
+			Context:  3,
+		})
+		require.NoError(t, err)
+		if diff == "" {
+			t.Logf("%s is up to date", path)
+			return
+		}
+		require.NoError(t, os.WriteFile(path, updated, 0600))
+		t.Logf("Updated %s:\n%s", path, diff)
+	})
+	return r
+}
+
+// errorf reports that the steps that ran don't match the recording, which fails the
+// test unless the replay is updating its recording.
+func (r *Replay) errorf(format string, args ...any) {
+	r.t.Helper()
+	r.checks().Errorf(format, args...)
+}
+
+// checks returns where the replay should report mismatches between the steps that ran
+// and the recording.
+//
+// Mismatches are expected when the replay is updating its recording, or once it has
+// injected a Fault, so they are only logged.
+func (r *Replay) checks() assert.TestingT {
+	switch {
+	case r.update != nil:
+		return logOnly{r.t, "Updating: "}
+	case r.faulted:
+		return logOnly{r.t, "After fault: "}
+	default:
+		return r.t
+	}
+}
+
+type logOnly struct {
+	t      TestingT
+	prefix string
+}
+
+func (l logOnly) Errorf(format string, args ...any) {
+	l.t.Helper()
+	l.t.Logf(l.prefix+format, args...)
+}
+
+// A run of a top level pipeline that the replay is updating.
+type replayUpdate struct {
+	// The index of the pipeline in the recording, or -1 if it was not recorded.
+	pipeline int
+	// The first and last steps of the recorded pipeline that were replayed, or -1 if
+	// no recorded steps were replayed.
+	first, last int
+	// The steps that ran in place of steps first through last.
+	record *RecordV2
+}
+
+// trackUpdate notes that the step called name, found at index in the current top level
+// pipeline, has just been entered by the update recorder.
+func (r *Replay) trackUpdate(name string, index int) {
+	p := r.update.pipelines[len(r.update.pipelines)-1]
+	if len(r.updates) == 0 || r.updates[len(r.updates)-1].record != p {
+		pipeline := -1
+		if r.pipeline < len(r.r.Pipelines) && r.r.Pipelines[r.pipeline].Name == p.Name {
+			pipeline = r.pipeline
+		}
+		r.updates = append(r.updates, replayUpdate{
+			pipeline: pipeline, first: -1, last: -1, record: p,
+		})
+	}
+	if index == -1 {
+		return
+	}
+	// Steps are replayed in order unless the replay is Unordered, in which case the
+	// replayed steps span from the first to the last of them.
+	u := &r.updates[len(r.updates)-1]
+	if u.first == -1 || index < u.first {
+		u.first = index
+	}
+	if index > u.last {
+		u.last = index
+	}
+}
+
+// updated returns the recording with the steps that were replayed replaced by the steps
+// that ran.
+func (r *Replay) updated() *ReplayV2 {
+	pipelines := make([]RecordV2, len(r.r.Pipelines))
+	for i, p := range r.r.Pipelines {
+		pipelines[i] = RecordV2{Name: p.Name, Steps: slices.Clone(p.Steps)}
+	}
+	updated := map[*StepV2]bool{}
+
+	// Splice the steps that ran into their pipelines from back to front, so that
+	// the indices of the remaining updates stay valid.
+	updates := slices.Clone(r.updates)
+	sort.SliceStable(updates, func(i, j int) bool {
+		if updates[i].pipeline != updates[j].pipeline {
+			return updates[i].pipeline > updates[j].pipeline
+		}
+		return updates[i].first > updates[j].first
+	})
+	for _, u := range updates {
+		walkSteps(u.record.Steps, func(s *StepV2) { updated[s] = true })
+		switch {
+		case u.pipeline == -1:
+			pipelines = append(pipelines, *u.record)
+		case u.first == -1:
+			p := &pipelines[u.pipeline]
+			p.Steps = append(p.Steps, u.record.Steps...)
+		default:
+			p := &pipelines[u.pipeline]
+			p.Steps = slices.Replace(p.Steps, u.first, u.last+1, u.record.Steps...)
+		}
+	}
+
+	// The IDs of the recorded steps and the steps that ran overlap, so renumber them.
+	type key struct {
+		updated bool
+		id      int
+	}
+	ids := map[key]int{}
+	var steps []*StepV2
+	for _, p := range pipelines {
+		walkSteps(p.Steps, func(s *StepV2) {
+			ids[key{updated[s], s.ID}] = len(ids) + 1
+			steps = append(steps, s)
+		})
+	}
+	renumbered := make(map[*StepV2]*StepV2, len(steps))
+	for _, s := range steps {
+		c := *s
+		c.ID = ids[key{updated[s], s.ID}]
+		if c.Parent != 0 {
+			c.Parent = ids[key{updated[s], s.Parent}]
+		}
+		renumbered[s] = &c
+	}
+	var rebuild func(steps []*StepV2) []*StepV2
+	rebuild = func(steps []*StepV2) []*StepV2 {
+		out := make([]*StepV2, len(steps))
+		for i, s := range steps {
+			out[i] = renumbered[s]
+			if s.Pipeline != nil {
+				out[i].Pipeline = &RecordV2{Name: s.Pipeline.Name, Steps: rebuild(s.Pipeline.Steps)}
+			}
+		}
+		return out
+	}
+	for i := range pipelines {
+		pipelines[i].Steps = rebuild(pipelines[i].Steps)
+	}
+	return &ReplayV2{Version: ReplayVersion, Pipelines: pipelines}
+}
+
+// walkSteps calls f on each step of steps, including the steps of nested pipelines.
+func walkSteps(steps []*StepV2, f func(*StepV2)) {
+	for _, s := range steps {
+		f(s)
+		if s.Pipeline != nil {
+			walkSteps(s.Pipeline.Steps, f)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package step
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/pulumi/upgrade-provider/step/redact"
+)
+
+// A RetryPolicy describes when a failed command run by Cmd should be retried.
+//
+// A failure is only retried when the command ran and exited with a non-zero exit code:
+// failing to start a command is never retried.
+type RetryPolicy struct {
+	// The maximum number of times to run the command, including the first attempt.
+	//
+	// A value less than 2 disables retries.
+	Attempts int
+	// The delay before the nth retry, starting at 1.
+	//
+	// If Backoff is nil, retries happen immediately.
+	Backoff func(retry int) time.Duration
+	// The exit codes that indicate a transient failure.
+	//
+	// If ExitCodes is empty, then any exit code may be retried.
+	ExitCodes []int
+	// Patterns that indicate a transient failure when matched against the command's
+	// stderr.
+	//
+	// If Stderr is empty, then any output may be retried.
+	Stderr []*regexp.Regexp
+}
+
+// QuadraticBackoff waits base * n² before the nth retry.
+func QuadraticBackoff(base time.Duration) func(retry int) time.Duration {
+	return func(retry int) time.Duration {
+		return time.Duration(retry*retry) * base
+	}
+}
+
+// Retryable reports if err describes a failure that the policy retries.
+func (p RetryPolicy) Retryable(err error) bool {
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) {
+		return false
+	}
+	if len(p.ExitCodes) > 0 {
+		var found bool
+		for _, code := range p.ExitCodes {
+			found = found || code == exit.ExitCode()
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(p.Stderr) == 0 {
+		return true
+	}
+	for _, pattern := range p.Stderr {
+		if pattern.Match(exit.Stderr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	if p.Backoff == nil {
+		return 0
+	}
+	return p.Backoff(retry)
+}
+
+type retryPolicyKey struct{}
+
+// WithRetry applies policy to commands run by Cmd within ctx.
+//
+// Each retry is shown in the label of the step, and recorded in the replay of the step.
+func WithRetry(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+func getRetryPolicy(ctx context.Context) RetryPolicy {
+	p, _ := ctx.Value(retryPolicyKey{}).(RetryPolicy)
+	return p
+}
+
+// recordRetry records that the current step was retried after failing with err.
+func recordRetry(ctx context.Context, err error) {
+	r, ok := ctx.Value(recordKey{}).(*record)
+	if !ok {
+		return
+	}
+	current := r.current()
+	current.Retries = append(current.Retries, redact.FromContext(ctx).String(err.Error()))
+}
+
+// sleep for d, returning early with false if ctx is canceled.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
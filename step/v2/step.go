@@ -11,8 +11,11 @@ import (
 	"fmt"
 	"reflect"
 	"runtime"
+	"slices"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+
+	"github.com/pulumi/upgrade-provider/step/trace"
 )
 
 //go:generate go run ./generate/calls.go
@@ -21,6 +24,11 @@ type pipeline struct {
 	title  string
 	failed error
 
+	// The names of the pipelines and steps enclosing this pipeline, ending with title.
+	path []string
+	// The names of the steps currently running in this pipeline, outermost first.
+	stack []string
+
 	// The display that the pipeline should use.
 	//
 	// display may be nil. Call p.getDisplay() for a non-nil display.
@@ -67,9 +75,17 @@ func Pipeline(name string, steps func(context.Context), opts ...Option) error {
 // The pipeline will call `steps` with the context necessary to call annotated functions.
 //
 // That context will inherit from `ctx`.
+//
+// If `ctx` is already within a pipeline, then `name` is run as a nested pipeline. A
+// nested pipeline shares the display of its parent, where it is shown as a section that
+// collapses on success. `opts` are ignored for nested pipelines.
+//
+// A failure in a nested pipeline halts the parent pipeline, so the error is returned from
+// the outermost pipeline. PipelineCtx always returns nil for a nested pipeline.
 func PipelineCtx(ctx context.Context, name string, steps func(context.Context), opts ...Option) error {
-	if getPipeline(ctx) != nil {
-		panic("Cannot call `PipelineCtx` when already in a pipeline")
+	if parent := getPipeline(ctx); parent != nil {
+		parent.nested(ctx, name, steps)
+		return nil
 	}
 
 	var options options
@@ -79,6 +95,7 @@ func PipelineCtx(ctx context.Context, name string, steps func(context.Context),
 
 	p := &pipeline{
 		title:   name,
+		path:    []string{name},
 		display: options.display,
 	}
 
@@ -91,24 +108,96 @@ func PipelineCtx(ctx context.Context, name string, steps func(context.Context),
 		}
 	}
 
+	if extra := getDisplays(ctx); len(extra) > 0 {
+		p.display = append(multiDisplay{p.getDisplay()}, extra...)
+	}
+
 	if err := p.getDisplay().Start(ctx, name); err != nil {
 		return fmt.Errorf("failed to start display: %w", err)
 	}
 	if err := p.getDisplay().Refresh(ctx, getEnvs(ctx)); err != nil {
 		return fmt.Errorf("failed initial redisplay: %w", err)
 	}
+	ctx, endSpan := trace.Start(ctx, trace.KindPipeline, name)
+	p.runSteps(ctx, steps)
+	endSpan(p.failed == nil)
+	if r := getReplay(ctx); r != nil {
+		r.exitTopPipeline(name)
+	}
+
+	var reportErr error
+	if d, ok := p.getDisplay().(failureDisplay); ok && p.failed != nil {
+		reportErr = d.ReportFailure(ctx, p.failed)
+	}
+
+	return errors.Join(
+		reportErr,
+		p.getDisplay().Refresh(ctx, getEnvs(ctx)),
+		p.getDisplay().Finish(ctx, p.failed == nil),
+		p.failed)
+}
+
+// Run steps as a pipeline nested within p.
+//
+// If the nested pipeline fails, the failure is propagated to p.
+func (p *pipeline) nested(ctx context.Context, name string, steps func(context.Context)) {
+	child := &pipeline{
+		title:   name,
+		path:    append(append(slices.Clone(p.path), p.stack...), name),
+		display: p.display,
+	}
+	for _, env := range getEnvs(ctx) {
+		if _, silent := env.(*Silent); silent {
+			child.display = nullDisplay
+			break
+		}
+	}
+
+	if r, ok := ctx.Value(recordKey{}).(*record); ok {
+		r.enterPipeline(ctx, p.title, name)
+		defer r.exitPipeline()
+	}
+	if r := getReplay(ctx); r != nil {
+		r.enterPipeline(ctx, p.title, name)
+		defer r.exitPipeline()
+	}
+
+	p.handleError([]any{child.getDisplay().EnterPipeline(ctx, name)})
+	p.handleError([]any{child.getDisplay().Refresh(ctx, getEnvs(ctx))})
+
+	ctx, endSpan := trace.Start(ctx, trace.KindPipeline, name)
+	child.runSteps(ctx, steps)
+	endSpan(child.failed == nil)
+
+	p.handleError([]any{child.getDisplay().ExitPipeline(ctx, child.failed == nil)})
+	p.handleError([]any{child.getDisplay().Refresh(ctx, getEnvs(ctx))})
+	if child.failed != nil {
+		p.errExit(child.failed)
+	}
+}
+
+// Run steps within p, on their own goroutine.
+//
+// steps stop when a step fails. If they stop for any other reason, such as a test helper
+// calling runtime.Goexit, p fails.
+func (p *pipeline) runSteps(ctx context.Context, steps func(context.Context)) {
 	done := make(chan struct{})
 	go func() {
 		defer func() { close(done) }()
+		returned := false
+		defer func() {
+			if !returned && p.failed == nil {
+				p.failed = StepError{
+					Path: slices.Clone(p.path),
+					Err:  fmt.Errorf("pipeline %q stopped before it returned", p.title),
+				}
+			}
+		}()
 
 		steps(withPipeline(ctx, p))
+		returned = true
 	}()
 	<-done
-
-	return errors.Join(
-		p.getDisplay().Refresh(ctx, getEnvs(ctx)),
-		p.getDisplay().Finish(ctx, p.failed == nil),
-		p.failed)
 }
 
 func mustGetPipeline(ctx context.Context, name string) *pipeline {
@@ -144,6 +233,7 @@ func SetLabelf(ctx context.Context, format string, a ...any) {
 // Run a function against arguments and set outputs.
 func run(ctx context.Context, name string, f any, inputs, outputs []any) {
 	p := mustGetPipeline(ctx, name)
+	p.stack = append(p.stack, name)
 	done := make(chan struct{})
 
 	handleErr := func(err error) {
@@ -157,60 +247,88 @@ func run(ctx context.Context, name string, f any, inputs, outputs []any) {
 
 	go func() {
 		defer func() { close(done) }()
-		envs := getEnvs(ctx)
-		var retImmediatly ReturnImmediatly
-		silent := false
-		for _, env := range envs {
-			env := env
-			if _, ok := env.(*Silent); ok {
-				silent = true
+		returned := false
+		defer func() {
+			// A step that stops without failing, such as when a test helper calls
+			// runtime.Goexit, has no outputs to continue with.
+			if !returned && p.failed == nil {
+				p.failed = StepError{
+					Path: append(slices.Clone(p.path), p.stack...),
+					Err:  fmt.Errorf("step %q stopped before it returned", name),
+				}
 			}
-			err := env.Enter(ctx, StepInfo{
-				name:     name,
-				inputs:   inputs,
-				pipeline: p.title,
-			})
-			if errors.As(err, &retImmediatly) {
-			} else if err != nil {
-				p.errExit(err)
+		}()
+		func() {
+			envs := getEnvs(ctx)
+			var retImmediatly ReturnImmediatly
+			silent := false
+			for _, env := range envs {
+				env := env
+				if _, ok := env.(*Silent); ok {
+					silent = true
+				}
+				err := env.Enter(ctx, StepInfo{
+					name:     name,
+					inputs:   inputs,
+					pipeline: p.title,
+				})
+				if errors.As(err, &retImmediatly) {
+				} else if err != nil {
+					p.errExit(err)
+				}
+				defer func() { handleErr(env.Exit(ctx, outputs)) }()
 			}
-			defer func() { handleErr(env.Exit(ctx, outputs)) }()
-		}
 
-		// If we have a silent function, disable the spinner
-		if silent {
-			handleErr(p.getDisplay().Pause(ctx))
-			defer func() { handleErr(p.getDisplay().Resume(ctx)) }()
-		}
+			// If we have a silent function, disable the spinner
+			if silent {
+				handleErr(p.getDisplay().Pause(ctx))
+				defer func() { handleErr(p.getDisplay().Resume(ctx)) }()
+			}
 
-		handleErr(p.getDisplay().EnterStep(ctx, name))
-		handleErr(p.getDisplay().Refresh(ctx, getEnvs(ctx)))
-		ins := make([]reflect.Value, len(inputs)+1)
-		ins[0] = reflect.ValueOf(ctx)
-		for i, v := range inputs {
-			if v == nil {
-				ins[i+1] = reflect.Zero(reflect.TypeOf(f).In(i + 1))
-			} else {
-				ins[i+1] = reflect.ValueOf(v)
+			handleErr(p.getDisplay().EnterStep(ctx, name))
+			handleErr(p.getDisplay().Refresh(ctx, getEnvs(ctx)))
+
+			// Don't start new steps once the pipeline has been interrupted.
+			ctx, cancel := withStepTimeout(ctx, name)
+			defer cancel()
+			if ctx.Err() != nil {
+				p.errExit(interrupted(ctx, fmt.Sprintf("step %q", name), ctx.Err()))
 			}
-		}
-		if retImmediatly.Out == nil {
-			outs := reflect.ValueOf(f).Call(ins)
-			contract.Assertf(len(outs) == len(outputs),
-				"internal error: This function should be typed to return the correct number of results")
-			for i, v := range outs {
-				outputs[i] = v.Interface()
+
+			ctx, endSpan := trace.Start(ctx, trace.KindStep, name)
+			defer func() { endSpan(p.failed == nil) }()
+
+			ins := make([]reflect.Value, len(inputs)+1)
+			ins[0] = reflect.ValueOf(ctx)
+			for i, v := range inputs {
+				if v == nil {
+					ins[i+1] = reflect.Zero(reflect.TypeOf(f).In(i + 1))
+				} else {
+					ins[i+1] = reflect.ValueOf(v)
+				}
+			}
+			if retImmediatly.Out == nil {
+				outs := reflect.ValueOf(f).Call(ins)
+				contract.Assertf(len(outs) == len(outputs),
+					"internal error: This function should be typed to return the correct number of results")
+				for i, v := range outs {
+					outputs[i] = v.Interface()
+				}
+				if err, ok := outputs[len(outputs)-1].(error); ok {
+					outputs[len(outputs)-1] = interrupted(ctx, fmt.Sprintf("step %q", name), err)
+				}
+			} else {
+				fType := reflect.TypeOf(f)
+				// Hydrate the saved outputs back from JSON.
+				o, err := hydrateTo(retImmediatly.Out, fType.Out)
+				p.handleError([]any{err})
+				// This call is mocked, so just set the output
+				copy(outputs, o)
 			}
-		} else {
-			fType := reflect.TypeOf(f)
-			// Hydrate the saved outputs back from JSON.
-			o, err := hydrateTo(retImmediatly.Out, fType.Out)
-			p.handleError([]any{err})
-			// This call is mocked, so just set the output
-			copy(outputs, o)
-		}
 
-		p.handleError(outputs)
+			p.handleError(outputs)
+		}()
+		returned = true
 	}()
 	<-done
 	handleErr(p.getDisplay().ExitStep(ctx, p.failed == nil))
@@ -219,6 +337,7 @@ func run(ctx context.Context, name string, f any, inputs, outputs []any) {
 	if p.failed != nil {
 		runtime.Goexit()
 	}
+	p.stack = p.stack[:len(p.stack)-1]
 }
 
 // Hydrate dst with the values from src, type-correcting as necessary.
@@ -280,10 +399,41 @@ func (p *pipeline) handleError(outputs []any) {
 }
 
 func (p *pipeline) errExit(err error) {
+	var stepErr StepError
+	if !errors.As(err, &stepErr) {
+		err = StepError{
+			Path: append(slices.Clone(p.path), p.stack...),
+			Err:  err,
+		}
+	}
 	p.failed = err
 	runtime.Goexit()
 }
 
+// A StepError is returned from a pipeline that failed.
+type StepError struct {
+	// The names of the pipelines and steps that enclose the step that failed, outermost
+	// first and ending with the step that failed.
+	Path []string
+	// The error that the step failed with.
+	Err error
+}
+
+func (err StepError) Error() string { return err.Err.Error() }
+
+func (err StepError) Unwrap() error { return err.Err }
+
+// FailedStep returns the path to the step that caused err, if err came from a pipeline.
+//
+// See StepError.Path for the shape of the path.
+func FailedStep(err error) ([]string, bool) {
+	var stepErr StepError
+	if !errors.As(err, &stepErr) {
+		return nil, false
+	}
+	return stepErr.Path, true
+}
+
 // cast performs a type cast from src to T.
 //
 // Unlike src.(T), this cast is valid when casting from an untyped nil.
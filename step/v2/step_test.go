@@ -1,13 +1,24 @@
 package step
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/upgrade-provider/step/cmdlog"
+	"github.com/pulumi/upgrade-provider/step/redact"
+	"github.com/pulumi/upgrade-provider/step/trace"
 )
 
 func TestPipeline(t *testing.T) {
@@ -44,6 +55,18 @@ func TestCmd(t *testing.T) {
 		})
 		require.Error(t, err)
 	})
+
+	t.Run("output", func(t *testing.T) {
+		t.Parallel()
+		var output string
+		err := Pipeline("cmd", func(ctx context.Context) {
+			output = CmdParse(ctx, func(stdout, stderr string) string {
+				return stdout + stderr
+			}, "sh", "-c", "echo out; echo err >&2")
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "out\nerr\n", output)
+	})
 }
 
 func TestNestedError(t *testing.T) {
@@ -61,6 +84,598 @@ func TestNestedError(t *testing.T) {
 	require.ErrorIs(t, err, expectedErr)
 }
 
+func TestStepStopped(t *testing.T) {
+	t.Parallel()
+
+	t.Run("step", func(t *testing.T) {
+		t.Parallel()
+		var after bool
+		err := Pipeline("test", func(ctx context.Context) {
+			Func00("stops", func(context.Context) { runtime.Goexit() })(ctx)
+			after = true
+		})
+		assert.ErrorContains(t, err, `step "stops" stopped before it returned`)
+		path, ok := FailedStep(err)
+		assert.True(t, ok)
+		assert.Equal(t, []string{"test", "stops"}, path)
+		assert.False(t, after, "the pipeline should not continue after a step stops")
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		t.Parallel()
+		err := Pipeline("test", func(ctx context.Context) {
+			PipelineCtx(ctx, "nested", func(context.Context) { runtime.Goexit() })
+		})
+		assert.ErrorContains(t, err, `pipeline "nested" stopped before it returned`)
+	})
+}
+
+func TestNestedPipeline(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+		var result int
+		err := Pipeline("test", func(ctx context.Context) {
+			one := Func01("one", func(context.Context) int { return 1 })(ctx)
+			err := PipelineCtx(ctx, "nested", func(ctx context.Context) {
+				result = Func11("+2", func(_ context.Context, i int) int {
+					return i + 2
+				})(ctx, one)
+			})
+			assert.NoError(t, err)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, result)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		t.Parallel()
+		expectedErr := fmt.Errorf("an error")
+		var continued bool
+		err := Pipeline("test", func(ctx context.Context) {
+			Func00("outer", func(ctx context.Context) {
+				_ = PipelineCtx(ctx, "nested", func(ctx context.Context) {
+					Func00E("inner", func(context.Context) error {
+						return expectedErr
+					})(ctx)
+				})
+			})(ctx)
+			continued = true
+		})
+		require.ErrorIs(t, err, expectedErr)
+		assert.False(t, continued, "a failed nested pipeline should halt its parent")
+
+		path, ok := FailedStep(err)
+		require.True(t, ok)
+		assert.Equal(t, []string{"test", "outer", "nested", "inner"}, path)
+	})
+}
+
+func TestNestedPipelineReplay(t *testing.T) {
+	t.Parallel()
+
+	pipeline := func(ctx context.Context) {
+		n := Func01("impure", func(ctx context.Context) int {
+			MarkImpure(ctx)
+			return 2
+		})(ctx)
+		err := PipelineCtx(ctx, "nested", func(ctx context.Context) {
+			n = Func11("double", func(_ context.Context, i int) int {
+				return i * 2
+			})(ctx, n)
+		})
+		require.NoError(t, err)
+		Func10("after", func(context.Context, int) {})(ctx, n)
+	}
+
+	file := filepath.Join(t.TempDir(), "replay.json")
+	ctx, closer := WithRecord(context.Background(), file)
+	require.NoError(t, PipelineCtx(ctx, "test", pipeline))
+	require.NoError(t, closer.Close())
+
+	b, err := os.ReadFile(file)
+	require.NoError(t, err)
+	var replay ReplayV2
+	require.NoError(t, json.Unmarshal(b, &replay))
+	require.Len(t, replay.Pipelines, 1)
+	steps := replay.Pipelines[0].Steps
+	require.Len(t, steps, 3)
+	assert.Equal(t, "nested", steps[1].Name)
+	require.NotNil(t, steps[1].Pipeline)
+	require.Len(t, steps[1].Pipeline.Steps, 1)
+	assert.Equal(t, "double", steps[1].Pipeline.Steps[0].Name)
+	assert.JSONEq(t, "[4, null]", string(steps[1].Pipeline.Steps[0].Outputs))
+
+	ctx = WithEnv(context.Background(), NewReplay(t, b))
+	require.NoError(t, PipelineCtx(ctx, "test", pipeline))
+}
+
+func TestReplayFailure(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	pipeline := func(ctx context.Context) {
+		Func10("outer", func(ctx context.Context, dir string) {
+			WithCwd(ctx, dir, func(ctx context.Context) {
+				ReadFile(ctx, "missing.txt")
+			})
+		})(ctx, dir)
+	}
+
+	ctx, _ := WithRecord(context.Background(), "")
+	err := PipelineCtx(ctx, "test", pipeline)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	b, ok := Recording(ctx)
+	require.True(t, ok)
+
+	var replay ReplayV2
+	require.NoError(t, json.Unmarshal(b, &replay))
+	assert.Equal(t, ReplayVersion, replay.Version)
+	steps := replay.Pipelines[0].Steps
+	require.Len(t, steps, 2)
+	assert.Equal(t, 1, steps[0].ID)
+	assert.Equal(t, []string{}, steps[0].Envs)
+	assert.Equal(t, 2, steps[1].ID)
+	assert.Equal(t, 1, steps[1].Parent, "missing.txt is called by outer")
+	assert.Equal(t, []string{fmt.Sprintf("cd %q", dir)}, steps[1].Envs)
+	assert.JSONEq(t, `["", null]`, string(steps[1].Outputs))
+	assert.Equal(t, &ReplayError{
+		Message: "open missing.txt: no such file or directory",
+		Type:    "*fs.PathError",
+	}, steps[1].Error)
+
+	// The replay fails in the same way, even though the file now exists.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "missing.txt"), nil, 0600))
+	ctx = WithEnv(context.Background(), NewReplay(t, b))
+	err = PipelineCtx(ctx, "test", pipeline)
+	assert.EqualError(t, err, "open missing.txt: no such file or directory")
+}
+
+func TestRecordRedactsSecrets(t *testing.T) {
+	t.Parallel()
+
+	const token = "ghp_0123456789abcdef"
+	pipeline := func(ctx context.Context) {
+		ctx = WithEnv(ctx, &EnvVar{Key: "GH_TOKEN", Value: token})
+		Func11("echo", func(ctx context.Context, s string) string {
+			return s
+		})(ctx, "token "+GetEnv(ctx, "GH_TOKEN"))
+	}
+
+	redactor, err := redact.New(`0123456789`)
+	require.NoError(t, err)
+	ctx, _ := WithRecord(redact.WithRedactor(context.Background(), redactor), "")
+	require.NoError(t, PipelineCtx(ctx, "test", pipeline))
+	b, ok := Recording(ctx)
+	require.True(t, ok)
+	assert.NotContains(t, string(b), "0123456789")
+
+	var replay ReplayV2
+	require.NoError(t, json.Unmarshal(b, &replay))
+	steps := replay.Pipelines[0].Steps
+	require.Len(t, steps, 2)
+	assert.Equal(t, []string{"GH_TOKEN=<redacted>"}, steps[0].Envs)
+	assert.JSONEq(t, `["<redacted>", null]`, string(steps[0].Outputs))
+	assert.JSONEq(t, `["token <redacted>"]`, string(steps[1].Inputs))
+
+	// The replay matches, since the secrets of the steps that run are masked the same
+	// way.
+	ctx = WithEnv(redact.WithRedactor(context.Background(), redactor), NewReplay(t, b))
+	require.NoError(t, PipelineCtx(ctx, "test", pipeline))
+}
+
+// reportT is a TestingT that collects the errors a replay reports, instead of failing the
+// test.
+type reportT struct {
+	*testing.T
+	errors []string
+}
+
+func (t *reportT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestReplayUnordered(t *testing.T) {
+	t.Parallel()
+
+	git := func(ctx context.Context, arg string) string {
+		return Func11("git", func(ctx context.Context, arg string) string {
+			MarkImpure(ctx)
+			return strings.ToUpper(arg)
+		})(ctx, arg)
+	}
+	record := func(pipeline func(context.Context)) []byte {
+		ctx, _ := WithRecord(context.Background(), "")
+		require.NoError(t, PipelineCtx(ctx, "test", pipeline))
+		b, ok := Recording(ctx)
+		require.True(t, ok)
+		return b
+	}
+	b := record(func(ctx context.Context) {
+		git(ctx, "a")
+		require.NoError(t, PipelineCtx(ctx, "nested", func(ctx context.Context) {
+			git(ctx, "c")
+			git(ctx, "d")
+		}))
+		git(ctx, "b")
+	})
+
+	var outputs []string
+	reordered := func(ctx context.Context) {
+		outputs = append(outputs, git(ctx, "b"))
+		require.NoError(t, PipelineCtx(ctx, "nested", func(ctx context.Context) {
+			outputs = append(outputs, git(ctx, "c"))
+		}))
+		outputs = append(outputs, git(ctx, "a"))
+	}
+
+	ordered := &reportT{T: t}
+	ctx := WithEnv(context.Background(), NewReplay(ordered, b))
+	assert.NoError(t, PipelineCtx(ctx, "test", reordered))
+	assert.NotEmpty(t, ordered.errors)
+
+	outputs = nil
+	unordered := &reportT{T: t}
+	ctx = WithEnv(context.Background(), NewReplay(unordered, b, Unordered()))
+	assert.NoError(t, PipelineCtx(ctx, "test", reordered))
+	assert.Equal(t, []string{"B", "C", "A"}, outputs, "each step gets the outputs recorded for its inputs")
+	assert.Equal(t, []string{`Required step "git" skipped.`}, unordered.errors,
+		`git(d) is reported when "nested" exits`)
+
+	topLevel := &reportT{T: t}
+	ctx = WithEnv(context.Background(), NewReplay(topLevel, b, Unordered()))
+	assert.NoError(t, PipelineCtx(ctx, "test", func(ctx context.Context) {
+		git(ctx, "a")
+		require.NoError(t, PipelineCtx(ctx, "nested", func(ctx context.Context) {
+			git(ctx, "d")
+			git(ctx, "c")
+		}))
+	}))
+	assert.Equal(t, []string{`Required step "git" skipped.`}, topLevel.errors,
+		`git(b) is reported when "test" returns`)
+}
+
+func TestReplayOptional(t *testing.T) {
+	t.Parallel()
+
+	step := func(ctx context.Context, name string) {
+		Func10(name, func(context.Context, int) {})(ctx, 1)
+	}
+	run := func(names ...string) func(context.Context) {
+		return func(ctx context.Context) {
+			for _, name := range names {
+				step(ctx, name)
+			}
+		}
+	}
+	ctx, _ := WithRecord(context.Background(), "")
+	require.NoError(t, PipelineCtx(ctx, "test", run("first", "maybe", "last")))
+	withMaybe, ok := Recording(ctx)
+	require.True(t, ok)
+	ctx, _ = WithRecord(context.Background(), "")
+	require.NoError(t, PipelineCtx(ctx, "test", run("first", "last")))
+	withoutMaybe, ok := Recording(ctx)
+	require.True(t, ok)
+
+	for _, tc := range []struct {
+		name      string
+		recording []byte
+		run       func(context.Context)
+		opts      []ReplayOption
+		errors    []string
+	}{
+		{
+			name:      "recorded but not run",
+			recording: withMaybe,
+			run:       run("first", "last"),
+			errors:    []string{`Required step "maybe" skipped.`},
+		},
+		{
+			name:      "recorded but not run, optional",
+			recording: withMaybe,
+			run:       run("first", "last"),
+			opts:      []ReplayOption{Optional("maybe")},
+		},
+		{
+			name:      "run but not recorded, optional",
+			recording: withoutMaybe,
+			run:       run("first", "maybe", "last"),
+			opts:      []ReplayOption{Optional("maybe")},
+		},
+		{
+			name:      "required step skipped, optional",
+			recording: withMaybe,
+			run:       run("maybe", "last"),
+			opts:      []ReplayOption{Optional("maybe")},
+			errors:    []string{`Required step "first" skipped.`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			report := &reportT{T: t}
+			ctx := WithEnv(context.Background(), NewReplay(report, tc.recording, tc.opts...))
+			require.NoError(t, PipelineCtx(ctx, "test", tc.run))
+			assert.Equal(t, tc.errors, report.errors)
+		})
+	}
+}
+
+func TestReplayInject(t *testing.T) {
+	t.Parallel()
+
+	var outputs []string
+	pipeline := func(ctx context.Context) {
+		outputs = nil
+		for _, arg := range []string{"one", "two"} {
+			out := Cmd(ctx, "echo", arg)
+			outputs = append(outputs, Func11("trim", func(_ context.Context, s string) string {
+				return strings.TrimSpace(s)
+			})(ctx, out))
+		}
+	}
+	ctx, _ := WithRecord(context.Background(), "")
+	require.NoError(t, PipelineCtx(ctx, "test", pipeline))
+	b, ok := Recording(ctx)
+	require.True(t, ok)
+	assert.Equal(t, []string{"one", "two"}, outputs)
+
+	for _, tc := range []struct {
+		name    string
+		fault   Fault
+		outputs []string
+		err     string
+	}{
+		{
+			name:    "outputs by command line",
+			fault:   Fault{Step: "echo two", Outputs: []any{"changed\n"}},
+			outputs: []string{"one", "changed"},
+		},
+		{
+			name:    "outputs by index",
+			fault:   Fault{Step: "echo", N: 1, Outputs: []any{"changed\n"}},
+			outputs: []string{"changed", "two"},
+		},
+		{
+			name:    "error",
+			fault:   Fault{Step: "echo", N: 2, Err: fmt.Errorf("boom")},
+			outputs: []string{"one"},
+			err:     "boom",
+		},
+		{
+			name:    "pure step",
+			fault:   Fault{Step: "trim", Outputs: []any{"injected"}},
+			outputs: []string{"injected", "injected"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			report := &reportT{T: t}
+			ctx := WithEnv(context.Background(), NewReplay(report, b, Inject(tc.fault)))
+			err := PipelineCtx(ctx, "test", pipeline)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.outputs, outputs)
+			assert.Empty(t, report.errors, "mismatches after a fault are only logged")
+		})
+	}
+}
+
+func TestMigrateV1(t *testing.T) {
+	t.Parallel()
+
+	replay := MigrateV1(ReplayV1{Pipelines: []RecordV1{{
+		Name: "test",
+		Steps: []*Step{
+			{Name: "first", Outputs: json.RawMessage(`[1]`), Impure: true},
+			{Name: "nested", Pipeline: &RecordV1{
+				Name:  "nested",
+				Steps: []*Step{{Name: "inner"}},
+			}},
+			{Name: "last"},
+		},
+	}}})
+
+	assert.Equal(t, ReplayVersion, replay.Version)
+	steps := replay.Pipelines[0].Steps
+	require.Len(t, steps, 3)
+	assert.Equal(t, &StepV2{
+		ID: 1, Name: "first", Outputs: json.RawMessage(`[1]`), Impure: true,
+	}, steps[0])
+	assert.Equal(t, 2, steps[1].ID)
+	assert.Equal(t, &StepV2{ID: 3, Parent: 2, Name: "inner"}, steps[1].Pipeline.Steps[0])
+	assert.Equal(t, &StepV2{ID: 4, Name: "last"}, steps[2])
+}
+
+func TestUpdateReplay(t *testing.T) {
+	pipeline := func(fetched int, middle string, f func(int) int) func(context.Context) {
+		return func(ctx context.Context) {
+			n := Func01("fetch", func(ctx context.Context) int {
+				MarkImpure(ctx)
+				return fetched
+			})(ctx)
+			n = Func11(middle, func(_ context.Context, n int) int { return f(n) })(ctx, n)
+			Func10("after", func(context.Context, int) {})(ctx, n)
+		}
+	}
+	double := func(n int) int { return n * 2 }
+	triple := func(n int) int { return n * 3 }
+
+	ctx, _ := WithRecord(context.Background(), "")
+	require.NoError(t, PipelineCtx(ctx, "test", pipeline(2, "double", double)))
+	require.NoError(t, PipelineCtx(ctx, "other", pipeline(2, "double", double)))
+	b, ok := Recording(ctx)
+	require.True(t, ok)
+	path := filepath.Join(t.TempDir(), "replay.json")
+	require.NoError(t, os.WriteFile(path, b, 0600))
+
+	t.Setenv(UpdateReplaysEnv, "1")
+	t.Run("update", func(t *testing.T) {
+		ctx := WithEnv(context.Background(), NewReplayFromFile(t, path))
+		require.NoError(t, PipelineCtx(ctx, "test", pipeline(3, "triple", triple)))
+	})
+
+	updated, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var replay ReplayV2
+	require.NoError(t, json.Unmarshal(updated, &replay))
+	require.Len(t, replay.Pipelines, 2)
+
+	steps := replay.Pipelines[0].Steps
+	require.Len(t, steps, 3)
+	// The impure step keeps its recorded output, while the pure step is re-run.
+	assert.Equal(t, "fetch", steps[0].Name)
+	assert.True(t, steps[0].Impure)
+	assert.JSONEq(t, "[2, null]", string(steps[0].Outputs))
+	assert.Equal(t, "triple", steps[1].Name)
+	assert.JSONEq(t, "[6, null]", string(steps[1].Outputs))
+	assert.Equal(t, "after", steps[2].Name)
+	assert.JSONEq(t, "[6]", string(steps[2].Inputs))
+
+	// The pipeline that the test didn't reach is left alone.
+	other := replay.Pipelines[1].Steps
+	require.Len(t, other, 3)
+	assert.Equal(t, "double", other[1].Name)
+	assert.Equal(t, []int{4, 5, 6}, []int{other[0].ID, other[1].ID, other[2].ID})
+
+	// Replaying the updated recording passes.
+	t.Setenv(UpdateReplaysEnv, "")
+	ctx = WithEnv(context.Background(), NewReplayFromFile(t, path))
+	require.NoError(t, PipelineCtx(ctx, "test", pipeline(3, "triple", triple)))
+}
+
+func TestDiffReplays(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+
+	a := MigrateV1(ReplayV1{Pipelines: []RecordV1{{
+		Name: "test",
+		Steps: []*Step{
+			{Name: "same", Inputs: json.RawMessage(`[1]`), Outputs: json.RawMessage(`[2]`)},
+			{Name: "changed", Inputs: json.RawMessage(`[1]`), Outputs: json.RawMessage(`[2]`)},
+			{Name: "removed"},
+		},
+	}}})
+	b := MigrateV1(ReplayV1{Pipelines: []RecordV1{{
+		Name: "test",
+		Steps: []*Step{
+			{Name: "same", Inputs: json.RawMessage(`[ 1 ]`), Outputs: json.RawMessage(`[2]`)},
+			{Name: "changed", Inputs: json.RawMessage(`[1]`), Outputs: json.RawMessage(`[3]`)},
+			{Name: "added"},
+		},
+	}}})
+
+	var out bytes.Buffer
+	differ, err := DiffReplays(&out, a, b)
+	require.NoError(t, err)
+	assert.True(t, differ)
+	assert.Equal(t, `~ test > changed
+    outputs:
+      - [2]
+      + [3]
+- test > removed
++ test > added
+
+1 changed, 1 removed, 1 added
+`, out.String())
+
+	out.Reset()
+	differ, err = DiffReplays(&out, a, a)
+	require.NoError(t, err)
+	assert.False(t, differ)
+	assert.Equal(t, "The recordings match (3 steps)\n", out.String())
+}
+
+func TestStepTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := WithStepTimeouts(context.Background(), map[string]time.Duration{
+		"sleep":    time.Hour,
+		"sleep 10": 10 * time.Millisecond,
+	})
+	start := time.Now()
+	err := PipelineCtx(ctx, "test", func(ctx context.Context) {
+		Cmd(ctx, "sleep", "10")
+	})
+	assert.Less(t, time.Since(start), 5*time.Second)
+	var interrupted InterruptedError
+	require.ErrorAs(t, err, &interrupted)
+	assert.Equal(t, "`sleep 10`", interrupted.Step)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, `step timeout of 10ms for "sleep 10"`)
+}
+
+// An interrupted command still reports its output and log.
+func TestStepTimeoutKeepsCommandError(t *testing.T) {
+	t.Parallel()
+
+	logs := cmdlog.NewDir(t.TempDir())
+	ctx := WithStepTimeouts(cmdlog.WithDir(context.Background(), logs),
+		map[string]time.Duration{"sh": 100 * time.Millisecond})
+	err := PipelineCtx(ctx, "test", func(ctx context.Context) {
+		Cmd(ctx, "sh", "-c", "echo oops >&2; exec sleep 10")
+	})
+	var interrupted InterruptedError
+	require.ErrorAs(t, err, &interrupted)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, `step timeout of 100ms for "sh"`)
+	assert.ErrorContains(t, err, "oops")
+	assert.ErrorContains(t, err, "full log: "+logs.Path())
+}
+
+func TestInterrupt(t *testing.T) {
+	// This test modifies the process environment, so it cannot be run in parallel.
+	const key = "STEP_TEST_INTERRUPT"
+
+	cause := fmt.Errorf("received interrupt")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	var ran bool
+	err := PipelineCtx(ctx, "test", func(ctx context.Context) {
+		Func00("first", func(ctx context.Context) {
+			Func00("cancel", func(context.Context) { cancel(cause) })(ctx)
+			Func00("second", func(context.Context) { ran = true })(ctx)
+		})(WithEnv(ctx, &EnvVar{Key: key, Value: "set"}))
+	})
+	assert.False(t, ran, "steps should not start after an interrupt")
+	assert.ErrorIs(t, err, cause)
+	assert.ErrorContains(t, err, `interrupted during step "second": received interrupt`)
+
+	_, set := os.LookupEnv(key)
+	assert.False(t, set, "env should be restored after an interrupt")
+}
+
+func TestTrace(t *testing.T) {
+	t.Parallel()
+
+	tracer := trace.New()
+	ctx := trace.WithTracer(context.Background(), tracer)
+	err := PipelineCtx(ctx, "outer", func(ctx context.Context) {
+		Func00("step", func(ctx context.Context) {
+			err := PipelineCtx(ctx, "nested", func(ctx context.Context) {
+				Func00E("fail", func(context.Context) error { return fmt.Errorf("boom") })(ctx)
+			})
+			assert.NoError(t, err)
+		})(ctx)
+	}, NullDisplay)
+	require.Error(t, err)
+
+	type span struct {
+		Parent  int
+		Name    string
+		Success bool
+	}
+	var spans []span
+	for _, s := range tracer.Spans() {
+		spans = append(spans, span{s.Parent, s.Name, s.Success})
+	}
+	assert.Equal(t, []span{
+		{0, "outer", false},
+		{1, "step", false},
+		{2, "nested", false},
+		{3, "fail", false},
+	}, spans)
+}
+
 func TestEnv(t *testing.T) {
 	var result string
 	err := Pipeline("test", func(ctx context.Context) {
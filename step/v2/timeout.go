@@ -0,0 +1,101 @@
+package step
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type stepTimeoutsKey struct{}
+
+// WithStepTimeouts limits how long individual steps may run.
+//
+// Each key is matched against the name of a step or, for steps created by Cmd, against the
+// command line being run. A key matches when it is equal to the name or is a word-wise
+// prefix of it, so "make tfgen" matches `make tfgen` and "make" matches every `make`
+// invocation. When several keys match, the longest wins.
+//
+// A step that exceeds its timeout fails the pipeline.
+func WithStepTimeouts(ctx context.Context, timeouts map[string]time.Duration) context.Context {
+	if len(timeouts) == 0 {
+		return ctx
+	}
+	merged := map[string]time.Duration{}
+	for k, v := range getStepTimeouts(ctx) {
+		merged[k] = v
+	}
+	for k, v := range timeouts {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, stepTimeoutsKey{}, merged)
+}
+
+func getStepTimeouts(ctx context.Context) map[string]time.Duration {
+	timeouts, _ := ctx.Value(stepTimeoutsKey{}).(map[string]time.Duration)
+	return timeouts
+}
+
+// withStepTimeout applies the timeout configured for name, if any.
+func withStepTimeout(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	var key string
+	var timeout time.Duration
+	for k, v := range getStepTimeouts(ctx) {
+		if k != name && !strings.HasPrefix(name, k+" ") {
+			continue
+		}
+		if len(k) > len(key) {
+			key, timeout = k, v
+		}
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, timeout,
+		fmt.Errorf("%w: step timeout of %s for %q", context.DeadlineExceeded, timeout, key))
+}
+
+// InterruptedError is returned from a pipeline when a step was halted because its context
+// was canceled, either by an interrupt or by a timeout.
+type InterruptedError struct {
+	// A description of the step that was interrupted.
+	Step string
+	// The reason the step was interrupted.
+	Cause error
+	// The error the step failed with when it was interrupted, such as the output of a
+	// command that was killed. Nil if the step only reported the cancellation.
+	Err error
+}
+
+func (err InterruptedError) Error() string {
+	msg := fmt.Sprintf("interrupted during %s: %s", err.Step, err.Cause)
+	if err.Err != nil {
+		msg += "\n" + err.Err.Error()
+	}
+	return msg
+}
+
+func (err InterruptedError) Unwrap() []error {
+	if err.Err == nil {
+		return []error{err.Cause}
+	}
+	return []error{err.Cause, err.Err}
+}
+
+// interrupted wraps err in an InterruptedError for step if err was caused by the
+// cancellation of ctx.
+func interrupted(ctx context.Context, step string, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	var alreadyInterrupted InterruptedError
+	if errors.As(err, &alreadyInterrupted) {
+		return err
+	}
+	interrupted := InterruptedError{Step: step, Cause: context.Cause(ctx)}
+	if err != ctx.Err() {
+		interrupted.Err = err
+	}
+	return interrupted
+}
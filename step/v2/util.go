@@ -3,12 +3,16 @@ package step
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
+	"github.com/pulumi/upgrade-provider/step/cmdlog"
 	"github.com/pulumi/upgrade-provider/step/gitenv"
+	"github.com/pulumi/upgrade-provider/step/redact"
 )
 
 // Name a value so it is viable to the user.
@@ -38,26 +42,84 @@ func WriteFile(ctx context.Context, path, content string) {
 }
 
 // Run a shell command, halting the pipeline on error.
+//
+// If ctx has a RetryPolicy (see WithRetry), then transient failures are retried.
 func Cmd(ctx context.Context, name string, args ...string) string {
 	return Func21E(name, func(ctx context.Context, _ string, _ []string) (string, error) {
-		MarkImpure(ctx)
-		cmd := exec.CommandContext(ctx, name, args...)
-		if name == "git" {
-			cmd.Env = gitenv.NonInteractive(ctx, nil)
+		result, err := cmd(ctx, name, args)
+		return result.Stdout, err
+	})(ctx, name, args)
+}
+
+// Run a shell command like Cmd, returning what parse makes of its stdout and stderr.
+//
+// Only the parsed result is the output of the step, so commands with a lot of output don't
+// bloat recordings.
+func CmdParse[T any](ctx context.Context, parse func(stdout, stderr string) T, name string, args ...string) T {
+	return Func21E(name, func(ctx context.Context, _ string, _ []string) (T, error) {
+		result, err := cmd(ctx, name, args)
+		return parse(result.Stdout, result.Stderr), err
+	})(ctx, name, args)
+}
+
+// Run a command for Cmd or CmdParse, from within its step.
+func cmd(ctx context.Context, name string, args []string) (cmdlog.Result, error) {
+	MarkImpure(ctx)
+	prettyCmd := strings.Join(append([]string{name}, args...), " ")
+	ctx, cancel := withStepTimeout(ctx, prettyCmd)
+	defer cancel()
+	policy := getRetryPolicy(ctx)
+
+	var result cmdlog.Result
+	var err error
+	for attempt := 1; ; attempt++ {
+		var label string
+		if attempt > 1 {
+			label = fmt.Sprintf(" (retry %d/%d)", attempt-1, policy.Attempts-1)
 		}
-		SetLabel(ctx, cmd.String())
-		out, err := cmd.Output()
-		if exit, ok := err.(*exec.ExitError); ok {
-			err = fmt.Errorf("%s:\n%s", err.Error(), string(exit.Stderr))
+		result, err = runCmd(ctx, name, args, label)
+		if err == nil || attempt >= policy.Attempts || !policy.Retryable(err) ||
+			ctx.Err() != nil {
+			break
 		}
+		recordRetry(ctx, err)
+		if !sleep(ctx, policy.backoff(attempt)) {
+			break
+		}
+	}
 
-		if err != nil {
-			prettyCmd := strings.Join(append([]string{name}, args...), " ")
-			err = fmt.Errorf("upgrade-provider executed `%s` which failed: %w", prettyCmd, err)
+	if exit, ok := err.(*exec.ExitError); ok {
+		err = fmt.Errorf("%s:\n%s", err.Error(), string(exit.Stderr))
+	}
+
+	if err != nil {
+		err = fmt.Errorf("upgrade-provider executed `%s` which failed: %w", prettyCmd, err)
+		if result.Log != "" {
+			err = fmt.Errorf("%w\nfull log: %s", err, result.Log)
 		}
+		err = interrupted(ctx, "`"+prettyCmd+"`", err)
+	}
 
-		return string(out), err
-	})(ctx, name, args)
+	return result, err
+}
+
+// Run a single attempt of a command for Cmd, returning its output and the path to its log
+// file.
+func runCmd(ctx context.Context, name string, args []string, label string) (cmdlog.Result, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Give the command a chance to clean up after itself before it is killed.
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = 10 * time.Second
+	if name == "git" {
+		cmd.Env = gitenv.NonInteractive(ctx, nil)
+	}
+	SetLabel(ctx, cmd.String()+label)
+
+	var tail io.Writer
+	if cmdlog.IsVerbose(ctx) {
+		tail = labelTail(ctx, cmd.String()+label)
+	}
+	return cmdlog.Run(ctx, cmd, tail)
 }
 
 // Halt the pipeline if err is non-nil.
@@ -78,9 +140,13 @@ func WithCwd(ctx context.Context, dir string, f func(context.Context)) {
 	err := c.Enter(ctx, StepInfo{})
 	HaltOnError(ctx, err)
 	c.depth--
+	// Steps within f re-enter c, which overwrites c.restore with dir, so we hold on
+	// to the original directory ourselves.
+	restore := c.restore
 
 	defer func() {
 		c.depth++
+		c.restore = restore
 		err := c.Exit(ctx, nil)
 		HaltOnError(ctx, err)
 	}()
@@ -148,7 +214,7 @@ func GetEnv(ctx context.Context, key string) string {
 	return Func11("GetEnv", func(ctx context.Context, key string) string {
 		MarkImpure(ctx)
 		result := os.Getenv(key)
-		SetLabel(ctx, key+"="+result)
+		SetLabel(ctx, redact.FromContext(ctx).String(key+"="+result))
 		return result
 	})(ctx, key)
 }
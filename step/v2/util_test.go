@@ -2,14 +2,18 @@ package step_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/pulumi/upgrade-provider/step/cmdlog"
 	"github.com/pulumi/upgrade-provider/step/v2"
 )
 
@@ -118,3 +122,123 @@ func TestEnvScoping(t *testing.T) {
 		})
 	})
 }
+
+func TestCmdRetry(t *testing.T) {
+	t.Parallel()
+
+	// flaky fails with a transient error until it has been called `succeedOn` times.
+	flaky := func(t *testing.T, succeedOn int) string {
+		dir := t.TempDir()
+		script := filepath.Join(dir, "flaky")
+		err := os.WriteFile(script, []byte(fmt.Sprintf(`#!/bin/sh
+count=$(cat "%[1]s/count" 2>/dev/null || echo 0)
+count=$((count + 1))
+echo $count > "%[1]s/count"
+if [ $count -lt %[2]d ]; then
+  echo "fatal: unable to access: Could not resolve host" >&2
+  exit 128
+fi
+echo "attempt $count"
+`, dir, succeedOn)), 0700)
+		require.NoError(t, err)
+		return script
+	}
+	policy := step.RetryPolicy{
+		Attempts:  3,
+		ExitCodes: []int{128},
+		Stderr:    []*regexp.Regexp{regexp.MustCompile(`Could not resolve host`)},
+	}
+
+	t.Run("success after retry", func(t *testing.T) {
+		t.Parallel()
+		script := flaky(t, 3)
+		file := filepath.Join(t.TempDir(), "replay.json")
+		ctx, closer := step.WithRecord(context.Background(), file)
+
+		var out string
+		err := step.PipelineCtx(ctx, "test", func(ctx context.Context) {
+			out = step.Cmd(step.WithRetry(ctx, policy), script)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "attempt 3\n", out)
+
+		require.NoError(t, closer.Close())
+		b, err := os.ReadFile(file)
+		require.NoError(t, err)
+		var replay step.ReplayV2
+		require.NoError(t, json.Unmarshal(b, &replay))
+		assert.Equal(t, []string{"exit status 128", "exit status 128"},
+			replay.Pipelines[0].Steps[0].Retries)
+
+		// Replaying the recording gives the same result, without running the command.
+		ctx = step.WithEnv(context.Background(), step.NewReplay(t, b))
+		err = step.PipelineCtx(ctx, "test", func(ctx context.Context) {
+			out = step.Cmd(step.WithRetry(ctx, policy), script)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "attempt 3\n", out)
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		t.Parallel()
+		script := flaky(t, 4)
+		err := step.Pipeline("test", func(ctx context.Context) {
+			step.Cmd(step.WithRetry(ctx, policy), script)
+		})
+		assert.ErrorContains(t, err, "Could not resolve host")
+	})
+
+	t.Run("not retryable", func(t *testing.T) {
+		t.Parallel()
+		script := flaky(t, 2)
+		policy := policy
+		policy.ExitCodes = []int{1}
+		err := step.Pipeline("test", func(ctx context.Context) {
+			step.Cmd(step.WithRetry(ctx, policy), script)
+		})
+		assert.ErrorContains(t, err, "Could not resolve host")
+	})
+}
+
+func TestCmdLog(t *testing.T) {
+	t.Parallel()
+
+	logs := cmdlog.NewDir(t.TempDir())
+	ctx := cmdlog.WithVerbose(cmdlog.WithDir(context.Background(), logs))
+
+	pipelineErr := step.PipelineCtx(ctx, "test", func(ctx context.Context) {
+		step.Cmd(ctx, "echo", "hello")
+		step.Cmd(ctx, "sh", "-c", "echo failing; exit 1")
+	})
+	require.Error(t, pipelineErr)
+
+	entries, err := os.ReadDir(logs.Path())
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "001-echo-hello.log", entries[0].Name())
+	failedLog := filepath.Join(logs.Path(), entries[1].Name())
+	assert.ErrorContains(t, pipelineErr, "full log: "+failedLog)
+
+	b, err := os.ReadFile(failedLog)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "failing\n")
+}
+
+// Not parallel: the test changes the working directory of the process.
+func TestWithCwdRestores(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	err = step.Pipeline("cwd", func(ctx context.Context) {
+		step.WithCwd(ctx, dir, func(ctx context.Context) {
+			step.Cmd(ctx, "true")
+			step.Cmd(ctx, "true")
+		})
+	}, step.NullDisplay)
+	require.NoError(t, err)
+
+	after, err := os.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, wd, after)
+}
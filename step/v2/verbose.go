@@ -0,0 +1,26 @@
+package step
+
+import (
+	"context"
+	"io"
+
+	"github.com/pulumi/upgrade-provider/step/cmdlog"
+)
+
+// labelTail returns an io.Writer that displays the last line written to it in the label of
+// the current step, after prefix.
+//
+// Cmd uses labelTail when cmdlog.IsVerbose is set.
+func labelTail(ctx context.Context, prefix string) io.Writer {
+	return cmdlog.NewTail(func(line string) {
+		p := getPipeline(ctx)
+		if p == nil {
+			return
+		}
+		// We are not running on the pipeline's goroutine, so we can't halt the
+		// pipeline on a display error. The label is only informative, so we ignore
+		// errors.
+		_ = p.getDisplay().SetLabel(ctx, prefix+": "+line)
+		_ = p.getDisplay().Refresh(ctx, getEnvs(ctx))
+	})
+}
@@ -0,0 +1,243 @@
+package upgrade
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pulumi/upgrade-provider/step/cmdlog"
+	"github.com/pulumi/upgrade-provider/step/redact"
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
+)
+
+// The number of commands whose output is retained for diagnostics.
+const diagnosticsHistorySize = 20
+
+// A DiagnosticsError is an upgrade failure that has a diagnostics archive.
+type DiagnosticsError struct {
+	Err error
+	// The path to the diagnostics archive.
+	Path string
+}
+
+func (err DiagnosticsError) Error() string {
+	return fmt.Sprintf("%s\n\ndiagnostics written to %s", err.Err, err.Path)
+}
+
+func (err DiagnosticsError) Unwrap() error { return err.Err }
+
+// withDiagnostics wraps a failed upgrade with the path to a diagnostics archive written by
+// writeDiagnostics.
+//
+// If the archive cannot be written, then the original error is returned alongside the
+// reason.
+func withDiagnostics(ctx context.Context, repo *ProviderRepo, failure error) error {
+	path, err := writeDiagnostics(ctx, repo, failure)
+	if err != nil {
+		return fmt.Errorf("%w\n\nfailed to write diagnostics: %w", failure, err)
+	}
+	return DiagnosticsError{Err: failure, Path: path}
+}
+
+// writeDiagnostics writes an archive describing failure to GetContext(ctx).DiagnosticsDir,
+// returning the path of the archive.
+//
+// The archive contains:
+//
+//   - error.txt: the error and the path of the step that failed.
+//   - commands.json: the last commands run, with their full output.
+//   - go-env.txt: the output of `go env`.
+//   - git/: the `git status` and `git log` of the provider repo and its upstream submodule.
+//   - context.json: the resolved upgrade configuration.
+//   - versions.txt: the versions of the tools the upgrade depends on.
+//   - replay.json: the replay recording of the upgrade so far.
+//
+// Secrets are masked in each file by the redact.Redactor associated with ctx.
+func writeDiagnostics(ctx context.Context, repo *ProviderRepo, failure error) (string, error) {
+	// The upgrade may have failed because ctx was canceled, but we still want to
+	// collect diagnostics.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancel()
+
+	files := []struct {
+		name    string
+		content []byte
+	}{}
+	redactor := redact.FromContext(ctx)
+	add := func(name string, content []byte) {
+		files = append(files, struct {
+			name    string
+			content []byte
+		}{name, []byte(redactor.String(string(content)))})
+	}
+	addJSON := func(name string, v any) {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			b = []byte(fmt.Sprintf("failed to marshal: %s", err))
+		}
+		add(name, b)
+	}
+
+	var errorTxt strings.Builder
+	if path, ok := stepv2.FailedStep(failure); ok {
+		fmt.Fprintf(&errorTxt, "failed step: %s\n\n", strings.Join(path, " > "))
+	}
+	errorTxt.WriteString(failure.Error())
+	errorTxt.WriteRune('\n')
+	if logs := cmdlog.DirFromContext(ctx); logs != nil && logs.Path() != "" {
+		fmt.Fprintf(&errorTxt, "\ncommand logs: %s\n", logs.Path())
+	}
+	add("error.txt", []byte(errorTxt.String()))
+
+	addJSON("commands.json", cmdlog.FromContext(ctx).Entries())
+	add("go-env.txt", diagnosticCmd(ctx, "", "go", "env"))
+
+	if repo.root != "" {
+		for _, dir := range []struct{ name, path string }{
+			{"repo", repo.root},
+			{"upstream", filepath.Join(repo.root, "upstream")},
+		} {
+			if _, err := os.Stat(dir.path); err != nil {
+				continue
+			}
+			add(filepath.Join("git", dir.name+"-status.txt"),
+				diagnosticCmd(ctx, dir.path, "git", "status"))
+			add(filepath.Join("git", dir.name+"-log.txt"),
+				diagnosticCmd(ctx, dir.path, "git", "log", "--max-count=20", "--decorate"))
+		}
+	}
+
+	addJSON("context.json", diagnosticsContext(GetContext(ctx), repo))
+
+	var versions bytes.Buffer
+	for _, tool := range [][]string{
+		{"go", "version"},
+		{"git", "--version"},
+		{"pulumi", "version"},
+		{"make", "--version"},
+	} {
+		fmt.Fprintf(&versions, "$ %s\n", strings.Join(tool, " "))
+		versions.Write(diagnosticCmd(ctx, "", tool[0], tool[1:]...))
+		versions.WriteRune('\n')
+	}
+	add("versions.txt", versions.Bytes())
+
+	if recording, ok := stepv2.Recording(ctx); ok {
+		add("replay.json", recording)
+	}
+
+	dir := GetContext(ctx).DiagnosticsDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, fmt.Sprintf("upgrade-provider-diagnostics-%s-*.tar.gz", repo.Name))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, file := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    file.name,
+			Mode:    0o600,
+			Size:    int64(len(file.content)),
+			ModTime: now,
+		})
+		if err != nil {
+			return "", err
+		}
+		if _, err := tw.Write(file.content); err != nil {
+			return "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// Run a command for diagnostics, returning its combined output.
+//
+// Failures are included in the output instead of being returned, since diagnostics
+// should be collected on a best effort basis.
+func diagnosticCmd(ctx context.Context, dir, name string, args ...string) []byte {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		out = append(out, fmt.Sprintf("\n%s failed: %s\n", name, err)...)
+	}
+	return out
+}
+
+// The JSON representation of the resolved Context and the discovered provider repo.
+func diagnosticsContext(c *Context, repo *ProviderRepo) any {
+	ref := func(r Ref) string {
+		if r == nil {
+			return ""
+		}
+		return r.String()
+	}
+	type providerRepo struct {
+		Name                   string
+		Org                    string
+		Root                   string
+		DefaultBranch          string
+		WorkingBranch          string
+		PRTitle                string
+		PRAlreadyExists        bool
+		CurrentVersion         string
+		CurrentUpstreamVersion string
+	}
+	r := providerRepo{
+		Name:            repo.Name,
+		Org:             repo.Org,
+		Root:            repo.root,
+		DefaultBranch:   repo.defaultBranch,
+		WorkingBranch:   repo.workingBranch,
+		PRTitle:         repo.prTitle,
+		PRAlreadyExists: repo.prAlreadyExists,
+	}
+	if repo.currentVersion != nil {
+		r.CurrentVersion = repo.currentVersion.Original()
+	}
+	if repo.currentUpstreamVersion != nil {
+		r.CurrentUpstreamVersion = repo.currentUpstreamVersion.Original()
+	}
+	var targetVersion string
+	if c.TargetVersion != nil {
+		targetVersion = c.TargetVersion.Original()
+	}
+	return struct {
+		*Context
+		RepoPath            string
+		TargetVersion       string
+		TargetBridgeRef     string
+		TargetPulumiVersion string
+		Repo                providerRepo
+	}{
+		Context:             c,
+		RepoPath:            c.repoPath,
+		TargetVersion:       targetVersion,
+		TargetBridgeRef:     ref(c.TargetBridgeRef),
+		TargetPulumiVersion: ref(c.TargetPulumiVersion),
+		Repo:                r,
+	}
+}
@@ -0,0 +1,82 @@
+package upgrade
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/upgrade-provider/step/cmdlog"
+	"github.com/pulumi/upgrade-provider/step/v2"
+)
+
+func TestWriteDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	out, err := exec.Command("git", "init", root).CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	ctx := (&Context{
+		DiagnosticsDir:       t.TempDir(),
+		UpstreamProviderName: "terraform-provider-test",
+	}).Wrap(context.Background())
+	ctx, _ = step.WithRecord(ctx, "")
+	ctx = cmdlog.WithHistory(ctx, cmdlog.NewHistory(diagnosticsHistorySize))
+
+	failure := step.PipelineCtx(ctx, "Discover Provider", func(ctx context.Context) {
+		step.Cmd(ctx, "sh", "-c", "echo some output; echo some error >&2; exit 3")
+	})
+	require.Error(t, failure)
+
+	repo := &ProviderRepo{Name: "pulumi-test", Org: "pulumi", root: root}
+	err = withDiagnostics(ctx, repo, failure)
+	var diagnostics DiagnosticsError
+	require.ErrorAs(t, err, &diagnostics)
+	assert.Contains(t, err.Error(), "diagnostics written to "+diagnostics.Path)
+
+	files := readTarGz(t, diagnostics.Path)
+	assert.Contains(t, files["error.txt"], "failed step: Discover Provider > sh\n")
+	assert.Contains(t, files, "go-env.txt")
+	assert.Contains(t, files, "git/repo-status.txt")
+	assert.Contains(t, files, "git/repo-log.txt")
+	assert.NotContains(t, files, "git/upstream-status.txt")
+	assert.Contains(t, files["versions.txt"], "$ go version\n")
+	assert.Contains(t, files["context.json"], `"UpstreamProviderName": "terraform-provider-test"`)
+	assert.Contains(t, files["replay.json"], `"name": "Discover Provider"`)
+
+	var commands []cmdlog.Entry
+	require.NoError(t, json.Unmarshal([]byte(files["commands.json"]), &commands))
+	require.Len(t, commands, 1)
+	assert.Equal(t, "some output\n", commands[0].Stdout)
+	assert.Equal(t, "some error\n", commands[0].Stderr)
+	assert.Equal(t, "exit status 3", commands[0].Err)
+}
+
+func readTarGz(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		}
+		require.NoError(t, err)
+		b, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[h.Name] = string(b)
+	}
+}
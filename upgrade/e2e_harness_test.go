@@ -0,0 +1,389 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	goSemver "golang.org/x/mod/semver"
+
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
+)
+
+// When set, the test binary runs as the fake tool it names instead of running tests. The
+// end-to-end harness installs scripts on PATH that re-execute the test binary this way.
+const fakeToolEnv = "UPGRADE_PROVIDER_FAKE_TOOL"
+
+// The tools that the end-to-end harness replaces. git and sh are the only real tools an
+// upgrade runs.
+var fakeTools = map[string]func(args []string, stdout io.Writer) error{
+	"go":     runFakeGo,
+	"make":   runFakeMake,
+	"pulumi": runFakePulumi,
+	"mise":   runFakeMise,
+}
+
+func TestMain(m *testing.M) {
+	if tool := os.Getenv(fakeToolEnv); tool != "" {
+		if err := fakeTools[tool](os.Args[1:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", tool, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runFakeGo edits the go.mod of the current directory for `go get` and `go mod edit`,
+// without downloading any modules. `go mod tidy` does nothing.
+func runFakeGo(args []string, stdout io.Writer) error {
+	command := strings.Join(args[:min(len(args), 2)], " ")
+	switch {
+	case command == "version":
+		_, err := fmt.Fprintf(stdout, "go version go0.0.0 (fake) %s/%s\n", runtime.GOOS, runtime.GOARCH)
+		return err
+	case command == "env", command == "mod tidy":
+		return nil
+	case command == "get" || strings.HasPrefix(command, "get "):
+		return editGoMod(func(f *modfile.File) error {
+			for _, arg := range args[1:] {
+				path, version, ok := strings.Cut(arg, "@")
+				if !ok {
+					// The fake has no newer version to offer.
+					continue
+				}
+				if !goSemver.IsValid(version) {
+					version = resolveGoVersion(path, version)
+				}
+				if err := f.AddRequire(path, version); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case command == "mod edit":
+		return editGoMod(func(f *modfile.File) error {
+			for i := 2; i+1 < len(args); i += 2 {
+				var err error
+				switch args[i] {
+				case "-droprequire":
+					err = f.DropRequire(args[i+1])
+				case "-require":
+					path, version, _ := strings.Cut(args[i+1], "@")
+					err = f.AddRequire(path, version)
+				case "-replace":
+					old, new, _ := strings.Cut(args[i+1], "=")
+					newPath, newVersion, _ := strings.Cut(new, "@")
+					err = f.AddReplace(old, "", newPath, newVersion)
+				default:
+					err = fmt.Errorf("unsupported flag %s", args[i])
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	default:
+		return fmt.Errorf("unsupported command: go %s", strings.Join(args, " "))
+	}
+}
+
+func editGoMod(edit func(*modfile.File) error) error {
+	data, err := os.ReadFile("go.mod")
+	if err != nil {
+		return err
+	}
+	f, err := modfile.Parse("go.mod", data, nil)
+	if err != nil {
+		return err
+	}
+	if err := edit(f); err != nil {
+		return err
+	}
+	f.Cleanup()
+	data, err = f.Format()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile("go.mod", data, 0o600)
+}
+
+// Resolve a commit of the module at path to the version it is tagged with, as `go get`
+// does, or to a pseudo-version if it is not tagged.
+func resolveGoVersion(path, rev string) string {
+	out, _ := exec.Command("git", "ls-remote", "--tags",
+		"https://"+modPathWithoutVersion(path)).Output()
+	for _, line := range strings.Split(string(out), "\n") {
+		sha, ref, ok := strings.Cut(line, "\t")
+		if ok && strings.HasPrefix(sha, rev) {
+			return strings.TrimSuffix(strings.TrimPrefix(ref, "refs/tags/"), "^{}")
+		}
+	}
+	return module.PseudoVersion("", "", time.Time{}, rev[:min(len(rev), 12)])
+}
+
+// runFakeMake records each target it is asked to build by writing .make/<target>, which
+// the sample providers ignore. It fails for targets that upgrade-provider does not use.
+func runFakeMake(args []string, _ io.Writer) error {
+	for _, target := range args {
+		switch target {
+		case "tfgen", "generate_sdks", "upstream", "ci-mgmt":
+		default:
+			return fmt.Errorf("no rule to make target '%s'", target)
+		}
+		if err := os.MkdirAll(".make", 0o700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(".make", target), nil, 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runFakePulumi(args []string, stdout io.Writer) error {
+	switch strings.Join(args, " ") {
+	case "version":
+		_, err := fmt.Fprintln(stdout, "v0.0.0-fake")
+		return err
+	case "plugin rm --all --yes":
+		return nil
+	default:
+		return fmt.Errorf("unsupported command: pulumi %s", strings.Join(args, " "))
+	}
+}
+
+// runFakeMise accepts every command, and reports an empty environment.
+func runFakeMise(args []string, stdout io.Writer) error {
+	if strings.Join(args, " ") == "env --json" {
+		_, err := fmt.Fprintln(stdout, "{}")
+		return err
+	}
+	return nil
+}
+
+// An e2eHarness runs UpgradeProvider end to end, entirely offline.
+//
+// The harness serves bare git repositories as https://github.com/<owner>/<name>, through
+// git's url.<base>.insteadOf, and installs fakes for go, make, pulumi and mise on PATH.
+// GitHub's issues, PRs, releases and labels are served by a fakeGitHub.
+//
+// The harness changes the environment of the test process, so tests that use it cannot
+// run in parallel.
+type e2eHarness struct {
+	t *testing.T
+	// The directory of the bare repositories.
+	remotes string
+	// The GOPATH that providers are cloned into.
+	gopath string
+	// The directory of the fake tools, which is first on PATH.
+	bin string
+	// The GitHub that upgrades talk to.
+	gh *fakeGitHub
+}
+
+func newE2EHarness(t *testing.T) *e2eHarness {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake tools require a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	h := &e2eHarness{
+		t:       t,
+		remotes: filepath.Join(dir, "remotes"),
+		gopath:  filepath.Join(dir, "gopath"),
+		bin:     filepath.Join(dir, "bin"),
+	}
+
+	gitconfig := filepath.Join(dir, "gitconfig")
+	require.NoError(t, os.WriteFile(gitconfig, []byte(fmt.Sprintf(`[url "file://%s/"]
+	insteadOf = https://github.com/
+[user]
+	name = Upgrade Bot
+	email = bot@example.com
+[init]
+	defaultBranch = main
+[advice]
+	detachedHead = false
+[protocol "file"]
+	allow = always
+`, h.remotes)), 0o600))
+	t.Setenv("GIT_CONFIG_GLOBAL", gitconfig)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_TERMINAL_PROMPT", "0")
+	// Commit with the identity in the config, rather than one from the environment.
+	for _, key := range gitIdentityEnvironmentKeys() {
+		t.Setenv(key, "")
+		require.NoError(t, os.Unsetenv(key))
+	}
+
+	self, err := os.Executable()
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(h.bin, 0o700))
+	for tool := range fakeTools {
+		script := fmt.Sprintf("#!/bin/sh\n%s=%s exec '%s' \"$@\"\n", fakeToolEnv, tool, self)
+		require.NoError(t, os.WriteFile(filepath.Join(h.bin, tool), []byte(script), 0o700))
+	}
+	t.Setenv("PATH", h.bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// The upgrade sets these for itself, and they change its behavior when they are
+	// already set. Restore them after the test.
+	for _, key := range []string{"GOWORK", "PULUMI_MISSING_DOCS_ERROR", "CI", "PULUMI_REPLAY"} {
+		t.Setenv(key, "")
+	}
+	return h
+}
+
+// git runs git in dir, returning its trimmed output.
+func (h *e2eHarness) git(dir string, args ...string) string {
+	h.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(h.t, err, "git %s: %s", strings.Join(args, " "), out)
+	return strings.TrimSpace(string(out))
+}
+
+// newRemote creates the bare repository served as https://github.com/<slug>. build
+// populates it from a working copy, whose branches and tags are then pushed.
+func (h *e2eHarness) newRemote(slug string, build func(work string)) {
+	h.t.Helper()
+	bare := filepath.Join(h.remotes, slug+".git")
+	require.NoError(h.t, os.MkdirAll(bare, 0o700))
+	h.git(bare, "init", "--bare", "--quiet")
+
+	work := h.t.TempDir()
+	h.git(work, "clone", "--quiet", "https://github.com/"+slug+".git", ".")
+	build(work)
+	h.git(work, "push", "--quiet", "--all", "origin")
+	h.git(work, "push", "--quiet", "--tags", "origin")
+}
+
+// commit writes files to the working copy at work and commits them, tagging the commit
+// if tag is not empty.
+func (h *e2eHarness) commit(work string, files map[string]string, tag string) {
+	h.t.Helper()
+	for name, content := range files {
+		path := filepath.Join(work, name)
+		require.NoError(h.t, os.MkdirAll(filepath.Dir(path), 0o700))
+		mode := os.FileMode(0o600)
+		if strings.HasSuffix(name, ".sh") {
+			mode = 0o700
+		}
+		require.NoError(h.t, os.WriteFile(path, []byte(content), mode))
+	}
+	h.git(work, "add", "--all")
+	msg := "Update"
+	if tag != "" {
+		msg = "Release " + tag
+	}
+	h.git(work, "commit", "--quiet", "-m", msg)
+	if tag != "" {
+		h.git(work, "tag", tag)
+	}
+}
+
+// remoteGit runs git in the bare repository served as https://github.com/<slug>.
+func (h *e2eHarness) remoteGit(slug string, args ...string) string {
+	h.t.Helper()
+	return h.git(filepath.Join(h.remotes, slug+".git"), args...)
+}
+
+// setGitHub sets the GitHub that upgrades talk to. Pull requests can only be opened for
+// branches that have been pushed to the harness's repositories, and forks are served
+// like any other repository.
+func (h *e2eHarness) setGitHub(gh *fakeGitHub) {
+	gh.checkPushed = func(repo, branch string) error {
+		_, err := exec.Command("git", "ls-remote", "--exit-code", "--heads",
+			"https://github.com/"+repo, branch).Output()
+		return err
+	}
+	gh.onFork = func(repo, fork string) error {
+		return exec.Command("git", "clone", "--bare", "--quiet",
+			filepath.Join(h.remotes, repo+".git"), filepath.Join(h.remotes, fork+".git")).Run()
+	}
+	h.gh = gh
+}
+
+// Serves https://raw.githubusercontent.com/<owner>/<name>/<ref>/<path> from the
+// repositories of the harness.
+var rawGitHubURL = regexp.MustCompile(`^https://raw\.githubusercontent\.com/([^/]+/[^/]+)/([^/]+)/(.+)$`)
+
+func (h *e2eHarness) getHTTP(url string) ([]byte, error) {
+	m := rawGitHubURL.FindStringSubmatch(url)
+	if m == nil {
+		return nil, fmt.Errorf("the end-to-end harness is offline: GET %s", url)
+	}
+	cmd := exec.Command("git", "show", m[2]+":"+m[3])
+	cmd.Dir = filepath.Join(h.remotes, m[1]+".git")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Non-200 code for GET %v: 404", url)
+	}
+	return out, nil
+}
+
+// upgrade runs UpgradeProvider for https://github.com/<org>/<name> with c.
+//
+// The provider is cloned into the harness's GOPATH, and logs and diagnostics are written
+// to temporary directories.
+func (h *e2eHarness) upgrade(c Context, org, name string) error {
+	h.t.Helper()
+	return h.upgradeWith(c, org, name, []string{"upgrade-provider", org + "/" + name}, &stepv2.Silent{})
+}
+
+// upgradeWith runs an upgrade like upgrade, with the command line args and within envs.
+func (h *e2eHarness) upgradeWith(c Context, org, name string, args []string, envs ...stepv2.Env) error {
+	h.t.Helper()
+	c.GoPath = h.gopath
+	c.LogDir = h.t.TempDir()
+	c.DiagnosticsDir = h.t.TempDir()
+	ctx := context.WithValue(context.Background(), httpHandlerKey, h)
+	ctx = WithGitHub(ctx, h.gh)
+	ctx = stepv2.WithEnv(ctx, envs...)
+	return UpgradeProvider(c.Wrap(ctx), org, name, args)
+}
+
+// checkout returns the path of the provider's clone in the harness's GOPATH.
+func (h *e2eHarness) checkout(org, name string) string {
+	return filepath.Join(h.gopath, "src", "github.com", org, name)
+}
+
+// The content of a go.mod file that requires each module in requires, given as "path
+// version".
+func goModFile(module string, requires ...string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "module %s\n\ngo 1.22\n", module)
+	if len(requires) > 0 {
+		b.WriteString("\nrequire (\n")
+		for _, r := range requires {
+			fmt.Fprintf(&b, "\t%s\n", r)
+		}
+		b.WriteString(")\n")
+	}
+	return b.String()
+}
+
+// requiredVersion returns the version of path required by the go.mod file at ref in the
+// bare repository served as https://github.com/<slug>.
+func (h *e2eHarness) requiredVersion(slug, ref, file, path string) string {
+	h.t.Helper()
+	data := h.remoteGit(slug, "show", ref+":"+file)
+	mod, found, err := requiredVersionOf(file, []byte(data), path)
+	require.NoError(h.t, err)
+	require.True(h.t, found, "%s does not require %s", file, path)
+	return mod.Version
+}
@@ -0,0 +1,423 @@
+package upgrade
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/modfile"
+
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
+)
+
+const (
+	e2eProvider = "pulumi/pulumi-sample"
+	e2eUpstream = "sample-org/terraform-provider-sample"
+	e2eBridge   = "pulumi/pulumi-terraform-bridge"
+
+	e2eUpstreamModule = "github.com/sample-org/terraform-provider-sample"
+	e2eBridgeModule   = "github.com/pulumi/pulumi-terraform-bridge/v3"
+	e2ePluginSDK      = "github.com/hashicorp/terraform-plugin-sdk/v2"
+)
+
+// The plugin SDK fork required by each bridge release.
+var e2ePluginSDKVersions = map[string]string{
+	"v3.89.0": "v2.0.0-20240101000000-aaaaaaaaaaaa",
+	"v3.90.0": "v2.0.0-20240201000000-bbbbbbbbbbbb",
+}
+
+// newE2ERemotes creates the upstream provider, with releases v1.0.0 to v1.2.0, and the
+// bridge, with releases v3.89.0 and v3.90.0.
+func newE2ERemotes(h *e2eHarness) {
+	h.newRemote(e2eUpstream, func(work string) {
+		for _, v := range []string{"v1.0.0", "v1.1.0", "v1.2.0"} {
+			h.commit(work, map[string]string{
+				"go.mod":  goModFile(e2eUpstreamModule),
+				"main.go": "package main\n\n// " + v + "\nfunc main() {}\n",
+			}, v)
+		}
+	})
+	h.newRemote(e2eBridge, func(work string) {
+		for _, v := range []string{"v3.89.0", "v3.90.0"} {
+			h.commit(work, map[string]string{
+				"go.mod": goModFile(e2eBridgeModule) + "\nreplace " + e2ePluginSDK +
+					" => github.com/pulumi/terraform-plugin-sdk/v2 " + e2ePluginSDKVersions[v] + "\n",
+			}, v)
+		}
+	})
+}
+
+// The files of the sample provider that are the same in every layout.
+func e2eProviderFiles(providerGoMod string) map[string]string {
+	return map[string]string{
+		".gitignore":      ".make/\n",
+		"provider/go.mod": providerGoMod,
+		"sdk/go.mod": goModFile("github.com/pulumi/pulumi-sample/sdk",
+			"github.com/pulumi/pulumi/sdk/v3 v3.100.0"),
+		"examples/go.mod": goModFile("github.com/pulumi/pulumi-sample/examples",
+			"github.com/pulumi/pulumi/pkg/v3 v3.100.0",
+			"github.com/pulumi/pulumi/sdk/v3 v3.100.0"),
+	}
+}
+
+// The requirements of provider/go.mod, other than the upstream provider.
+var e2eProviderRequires = []string{
+	e2eBridgeModule + " v3.89.0",
+	"github.com/pulumi/pulumi/sdk/v3 v3.100.0",
+}
+
+var e2ePluginSDKReplace = "\nreplace " + e2ePluginSDK +
+	" => github.com/pulumi/terraform-plugin-sdk/v2 " + e2ePluginSDKVersions["v3.89.0"] + "\n"
+
+// The state of GitHub before the upgrade: an issue asking for the upgrade, open bridge
+// upgrade PRs by the bot and by someone else, and the upstream provider's releases.
+func e2eGitHub() *fakeGitHub {
+	published := time.Now().Add(-7 * 24 * time.Hour).UTC()
+	return &fakeGitHub{
+		User: "upgrade-bot",
+		Repos: map[string]*fakeGitHubRepo{
+			e2eUpstream: {
+				Releases: []Release{
+					{Name: "v1.1.0", TagName: "v1.1.0", Body: "- Initial release"},
+					{Name: "v1.1.1", TagName: "v1.1.1", Body: "- Fix a crash\n- `old_field` is deprecated"},
+					{Name: "v1.2.0", TagName: "v1.2.0", Body: "- Add `new_resource`"},
+				},
+			},
+			e2eProvider: {
+				Labels: []string{
+					"kind/enhancement",
+					"needs-release/patch", "needs-release/minor", "needs-release/major",
+				},
+				Issues: []*fakeIssue{{
+					Number: 1,
+					Title:  "Upgrade terraform-provider-sample to v1.2.0",
+					Body:   upgradeIssueBodyTemplate,
+					State:  "open",
+					Author: "upgrade-bot",
+					Labels: []string{"kind/enhancement"},
+				}},
+				PullRequests: []*fakePullRequest{
+					{
+						fakeIssue: fakeIssue{
+							Number: 2,
+							Title:  "Upgrade pulumi-terraform-bridge to v3.89.0",
+							State:  "open",
+							Author: "upgrade-bot",
+						},
+						BaseRefName: "main",
+						HeadRefName: "upgrade-pulumi-terraform-bridge-to-v3.89.0",
+					},
+					{
+						fakeIssue: fakeIssue{
+							Number: 3,
+							Title:  "Upgrade pulumi-terraform-bridge to v3.89.0",
+							State:  "open",
+							Author: "someone-else",
+						},
+						BaseRefName: "main",
+						HeadRefName: "manual-bridge-upgrade",
+					},
+				},
+				Releases: []Release{{Name: "v0.5.0", TagName: "v0.5.0", PublishedAt: published}},
+			},
+		},
+	}
+}
+
+func buildPlainE2EProvider(h *e2eHarness, work string) {
+	providerGoMod := goModFile("github.com/pulumi/pulumi-sample/provider",
+		append(e2eProviderRequires, e2eUpstreamModule+" v1.1.0")...) + e2ePluginSDKReplace
+	h.commit(work, e2eProviderFiles(providerGoMod), "")
+}
+
+// TestUpgradeProviderEndToEnd upgrades the upstream provider and the bridge of a sample
+// provider in each layout, against local git remotes and a fake GitHub.
+func TestUpgradeProviderEndToEnd(t *testing.T) {
+	tests := []struct {
+		name string
+		// Populate the sample provider's repository.
+		build func(h *e2eHarness, work string)
+		// Check the upstream provider version on the pushed branch.
+		checkUpstream func(t *testing.T, h *e2eHarness, branch string)
+	}{
+		{
+			name:  "plain",
+			build: buildPlainE2EProvider,
+			checkUpstream: func(t *testing.T, h *e2eHarness, branch string) {
+				assert.Equal(t, "v1.2.0",
+					h.requiredVersion(e2eProvider, branch, "provider/go.mod", e2eUpstreamModule))
+			},
+		},
+		{
+			name: "shimmed",
+			build: func(h *e2eHarness, work string) {
+				files := e2eProviderFiles(goModFile("github.com/pulumi/pulumi-sample/provider",
+					e2eProviderRequires...) + e2ePluginSDKReplace)
+				files["provider/shim/go.mod"] = goModFile("github.com/pulumi/pulumi-sample/provider/shim",
+					e2eUpstreamModule+" v1.1.0")
+				h.commit(work, files, "")
+			},
+			checkUpstream: func(t *testing.T, h *e2eHarness, branch string) {
+				assert.Equal(t, "v1.2.0",
+					h.requiredVersion(e2eProvider, branch, "provider/shim/go.mod", e2eUpstreamModule))
+			},
+		},
+		{
+			name: "patched",
+			build: func(h *e2eHarness, work string) {
+				h.git(work, "submodule", "add", "--quiet",
+					"https://github.com/"+e2eUpstream+".git", "upstream")
+				h.git(filepath.Join(work, "upstream"), "checkout", "--quiet", "v1.1.0")
+
+				providerGoMod := goModFile("github.com/pulumi/pulumi-sample/provider",
+					append(e2eProviderRequires, e2eUpstreamModule+" v1.1.0")...) +
+					e2ePluginSDKReplace + "\nreplace " + e2eUpstreamModule + " => ../upstream\n"
+				files := e2eProviderFiles(providerGoMod)
+				files["scripts/upstream.sh"] = `#!/bin/sh
+set -e
+case "$1" in
+checkout) ;;
+rebase) git -C upstream checkout --quiet --detach "$3" ;;
+check_in) git add upstream ;;
+*) echo "unknown command $1" >&2; exit 1 ;;
+esac
+`
+				h.commit(work, files, "")
+			},
+			checkUpstream: func(t *testing.T, h *e2eHarness, branch string) {
+				assert.Equal(t,
+					h.remoteGit(e2eUpstream, "rev-parse", "v1.2.0^{commit}"),
+					h.remoteGit(e2eProvider, "ls-tree", branch, "upstream", "--object-only"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newE2EHarness(t)
+			newE2ERemotes(h)
+			h.newRemote(e2eProvider, func(work string) { tt.build(h, work) })
+			h.setGitHub(e2eGitHub())
+
+			err := h.upgrade(Context{
+				InferVersion:           true,
+				UpgradeProviderVersion: true,
+				UpgradeBridgeVersion:   true,
+				TargetBridgeRef:        &Latest{},
+				UpstreamProviderName:   "terraform-provider-sample",
+				PrReviewers:            "sample-reviewer",
+				PrAssign:               "@me",
+			}, "pulumi", "pulumi-sample")
+			require.NoError(t, err)
+
+			// The branch is pushed with the upgraded dependencies.
+			branch := "upgrade-terraform-provider-sample-to-v1.2.0"
+			tt.checkUpstream(t, h, branch)
+			assert.Equal(t, "v3.90.0",
+				h.requiredVersion(e2eProvider, branch, "provider/go.mod", e2eBridgeModule))
+			providerGoMod, err := modfile.Parse("go.mod",
+				[]byte(h.remoteGit(e2eProvider, "show", branch+":provider/go.mod")), nil)
+			require.NoError(t, err)
+			for _, r := range providerGoMod.Replace {
+				if r.Old.Path == e2ePluginSDK {
+					assert.Equal(t, e2ePluginSDKVersions["v3.90.0"], r.New.Version)
+				}
+			}
+
+			// The SDKs were regenerated.
+			for _, target := range []string{"tfgen", "generate_sdks"} {
+				_, err := os.Stat(filepath.Join(h.checkout("pulumi", "pulumi-sample"), ".make", target))
+				assert.NoError(t, err, "make %s did not run", target)
+			}
+
+			repo := h.gh.Repos[e2eProvider]
+			require.Len(t, repo.PullRequests, 3)
+
+			// The upgrade PR is opened, closes the upgrade issue and is labeled for a
+			// minor release.
+			pr := repo.PullRequests[2]
+			assert.Equal(t, 4, pr.Number)
+			assert.Equal(t, "open", pr.State)
+			assert.Equal(t, "Upgrade terraform-provider-sample to v1.2.0", pr.Title)
+			assert.Equal(t, "main", pr.BaseRefName)
+			assert.Equal(t, branch, pr.HeadRefName)
+			assert.Equal(t, []string{"needs-release/minor"}, pr.Labels)
+			assert.Equal(t, []string{"sample-reviewer"}, pr.Reviewers)
+			assert.Equal(t, []string{"upgrade-bot"}, pr.Assignees)
+			assert.Contains(t, pr.Body, "to 1.2.0.\n\tFixes #1\n")
+			assert.Contains(t, pr.Body, "- Upgrading pulumi-terraform-bridge from v3.89.0 to v3.90.0.\n")
+
+			// The notes of the upstream releases since v1.1.0 are included.
+			assert.Contains(t, pr.Body, "<summary>v1.2.0</summary>\n\n- Add `new_resource`\n")
+			assert.Contains(t, pr.Body, "<summary>v1.1.1 (:warning: 1 notable)</summary>\n\n"+
+				"- Fix a crash\n- :warning: `old_field` is deprecated\n")
+			assert.NotContains(t, pr.Body, "Initial release")
+
+			// The upgrade issue is assigned like the PR.
+			assert.Equal(t, []string{"upgrade-bot"}, repo.Issues[0].Assignees)
+
+			// The bot's superseded bridge upgrade PR is closed, but not other people's.
+			assert.Equal(t, "closed", repo.PullRequests[0].State)
+			assert.Equal(t, []string{"Superseded by https://github.com/pulumi/pulumi-sample/pull/4"},
+				repo.PullRequests[0].Comments)
+			assert.Equal(t, "open", repo.PullRequests[1].State)
+		})
+	}
+}
+
+// TestUpgradeProviderFromForkEndToEnd pushes the upgrade to a fork that it creates, and
+// opens the PR from there.
+func TestUpgradeProviderFromForkEndToEnd(t *testing.T) {
+	h := newE2EHarness(t)
+	newE2ERemotes(h)
+	h.newRemote(e2eProvider, func(work string) { buildPlainE2EProvider(h, work) })
+	gh := e2eGitHub()
+	// The bot's previous bridge upgrade was also opened from its fork.
+	gh.Repos[e2eProvider].PullRequests[0].HeadRepositoryOwner = "upgrade-bot"
+	h.setGitHub(gh)
+
+	err := h.upgrade(Context{
+		UpgradeBridgeVersion: true,
+		TargetBridgeRef:      &Latest{},
+		UpstreamProviderName: "terraform-provider-sample",
+		PushRemote:           "upgrade-bot",
+	}, "pulumi", "pulumi-sample")
+	require.NoError(t, err)
+
+	const fork = "upgrade-bot/pulumi-sample"
+	branch := "upgrade-pulumi-terraform-bridge-to-v3.90.0"
+	require.Contains(t, h.gh.Repos, fork)
+	assert.Equal(t, e2eProvider, h.gh.Repos[fork].Parent)
+
+	// The branch is pushed to the fork only.
+	assert.Equal(t, "v3.90.0", h.requiredVersion(fork, branch, "provider/go.mod", e2eBridgeModule))
+	assert.Empty(t, h.remoteGit(e2eProvider, "branch", "--list", branch))
+
+	repo := h.gh.Repos[e2eProvider]
+	require.Len(t, repo.PullRequests, 3)
+	pr := repo.PullRequests[2]
+	assert.Equal(t, "Upgrade pulumi-terraform-bridge to v3.90.0", pr.Title)
+	assert.Equal(t, branch, pr.HeadRefName)
+	assert.Equal(t, "upgrade-bot", pr.HeadRepositoryOwner)
+
+	// The superseded PR from the fork is closed.
+	assert.Equal(t, "closed", repo.PullRequests[0].State)
+	assert.Equal(t, "open", repo.PullRequests[1].State)
+}
+
+// TestRerunUpgradeEndToEnd reruns an upgrade whose PR description has been edited, and
+// checks that only the generated part of the description is replaced.
+func TestRerunUpgradeEndToEnd(t *testing.T) {
+	h := newE2EHarness(t)
+	newE2ERemotes(h)
+	h.newRemote(e2eProvider, func(work string) { buildPlainE2EProvider(h, work) })
+	h.setGitHub(e2eGitHub())
+
+	c := Context{
+		UpgradeBridgeVersion: true,
+		TargetBridgeRef:      &Latest{},
+		UpstreamProviderName: "terraform-provider-sample",
+	}
+	require.NoError(t, h.upgrade(c, "pulumi", "pulumi-sample"))
+
+	repo := h.gh.Repos[e2eProvider]
+	require.Len(t, repo.PullRequests, 3)
+	pr := repo.PullRequests[2]
+	assert.True(t, strings.HasPrefix(pr.Body, prBodyStartMarker+"\n"), pr.Body)
+	assert.True(t, strings.HasSuffix(pr.Body, prBodyEndMarker), pr.Body)
+
+	// A reviewer adds notes around the generated description, and edits inside it.
+	generated := strings.Replace(pr.Body, "- Upgrading", "- Hand edited", 1)
+	pr.Body = "Reviewer notes\n\n" + generated + "\n\n- [ ] Migrate state\n"
+
+	require.NoError(t, h.upgrade(c, "pulumi", "pulumi-sample"))
+
+	require.Len(t, repo.PullRequests, 3, "the existing PR should be updated")
+	body := repo.PullRequests[2].Body
+	assert.True(t, strings.HasPrefix(body, "Reviewer notes\n\n"+prBodyStartMarker+"\n"), body)
+	assert.True(t, strings.HasSuffix(body, prBodyEndMarker+"\n\n- [ ] Migrate state\n"), body)
+	assert.Contains(t, body, "- Upgrading pulumi-terraform-bridge from v3.89.0 to v3.90.0.\n")
+	assert.NotContains(t, body, "Hand edited")
+}
+
+// TestCheckUpstreamEndToEnd opens an upgrade issue for the latest stable upstream
+// release, and only once.
+func TestCheckUpstreamEndToEnd(t *testing.T) {
+	h := newE2EHarness(t)
+	newE2ERemotes(h)
+	h.newRemote(e2eProvider, func(work string) { buildPlainE2EProvider(h, work) })
+
+	gh := e2eGitHub()
+	gh.Repos[e2eProvider].Issues = nil
+	gh.Repos[e2eUpstream] = &fakeGitHubRepo{Releases: []Release{
+		{Name: "v1.1.0", TagName: "v1.1.0", PublishedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "v1.2.0", TagName: "v1.2.0", PublishedAt: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{
+			Name: "v1.3.0-beta.1", TagName: "v1.3.0-beta.1",
+			PublishedAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), Prerelease: true,
+		},
+	}}
+	h.setGitHub(gh)
+
+	c := Context{
+		OnlyCheckUpstream:      true,
+		UpgradeProviderVersion: true,
+		UpstreamProviderName:   "terraform-provider-sample",
+	}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, h.upgrade(c, "pulumi", "pulumi-sample"))
+	}
+
+	issues := h.gh.Repos[e2eProvider].Issues
+	require.Len(t, issues, 1)
+	assert.Equal(t, "Upgrade terraform-provider-sample to v1.2.0", issues[0].Title)
+	assert.Equal(t, []string{"kind/enhancement"}, issues[0].Labels)
+	assert.Contains(t, issues[0].Body,
+		"https://github.com/sample-org/terraform-provider-sample/releases/tag/v1.2.0")
+}
+
+// TestRecordUpgradeEndToEnd records a bridge upgrade as PULUMI_REPLAY does. When
+// UPDATE_REPLAYS is set, the recording replaces testdata/replay/bridge_upgrade.json,
+// which the tests of the main package run with `upgrade-provider replay run`.
+func TestRecordUpgradeEndToEnd(t *testing.T) {
+	h := newE2EHarness(t)
+	newE2ERemotes(h)
+	h.newRemote(e2eProvider, func(work string) { buildPlainE2EProvider(h, work) })
+	h.setGitHub(e2eGitHub())
+	// The upgrade looks for mise outside of any step, so record it where mise is not
+	// installed, like the machines that replay it.
+	require.NoError(t, os.Remove(filepath.Join(h.bin, "mise")))
+
+	recording := filepath.Join(t.TempDir(), "replay.json")
+	t.Setenv("PULUMI_REPLAY", recording)
+	// The flags that replay run is given for the recording.
+	args := []string{"upgrade-provider", e2eProvider,
+		"--kind", "bridge", "--upstream-provider-name", "terraform-provider-sample"}
+	require.NoError(t, h.upgradeWith(Context{
+		UpgradeBridgeVersion: true,
+		TargetBridgeRef:      &Latest{},
+		UpstreamProviderName: "terraform-provider-sample",
+	}, "pulumi", "pulumi-sample", args))
+
+	data, err := os.ReadFile(recording)
+	require.NoError(t, err)
+	_, err = stepv2.ParseReplay(data)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "pulumi/pulumi-sample --kind bridge --upstream-provider-name terraform-provider-sample")
+
+	if update, _ := strconv.ParseBool(os.Getenv(stepv2.UpdateReplaysEnv)); !update {
+		return
+	}
+	// Replace the paths of the test, so that the recording can be replayed anywhere.
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	data = bytes.ReplaceAll(data, []byte(h.gopath), []byte("/work/gopath"))
+	data = bytes.ReplaceAll(data, []byte(strconv.Quote(cwd)), []byte(`"/work"`))
+	require.NoError(t, os.WriteFile(filepath.Join("testdata", "replay", "bridge_upgrade.json"), data, 0o600))
+}
@@ -0,0 +1,28 @@
+package upgrade
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// openEvents opens the destination of the event stream given by --events.
+//
+// target is either a file path, which is created or truncated, or "fd:N", which writes
+// to the already open file descriptor N.
+func openEvents(target string) (io.WriteCloser, error) {
+	if fd, ok := strings.CutPrefix(target, "fd:"); ok {
+		n, err := strconv.ParseUint(fd, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file descriptor %q: %w", fd, err)
+		}
+		f := os.NewFile(uintptr(n), "fd:"+fd)
+		if f == nil {
+			return nil, fmt.Errorf("invalid file descriptor %d", n)
+		}
+		return f, nil
+	}
+	return os.Create(target)
+}
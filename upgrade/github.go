@@ -0,0 +1,292 @@
+package upgrade
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
+)
+
+// GitHub is the part of the GitHub API that upgrade-provider uses.
+//
+// Repositories are named "owner/name". Wherever a user's login is expected, "@me" refers
+// to the authenticated user.
+type GitHub interface {
+	// ListPullRequests returns every pull request in repo that matches q.
+	ListPullRequests(ctx context.Context, repo string, q PullRequestQuery) ([]PullRequest, error)
+	// CreatePullRequest opens a pull request in repo.
+	CreatePullRequest(ctx context.Context, repo string, pr NewPullRequest) (PullRequest, error)
+	// EditPullRequest updates a pull request in repo.
+	EditPullRequest(ctx context.Context, repo string, number int, edit PullRequestEdit) (PullRequest, error)
+	// ClosePullRequest closes a pull request in repo, first commenting on it if comment
+	// is not empty.
+	ClosePullRequest(ctx context.Context, repo string, number int, comment string) error
+
+	// ListIssues returns every issue in repo that matches q. Pull requests are not
+	// included.
+	ListIssues(ctx context.Context, repo string, q IssueQuery) ([]Issue, error)
+	// CreateIssue opens an issue in repo.
+	CreateIssue(ctx context.Context, repo string, issue NewIssue) (Issue, error)
+	// AddAssignees assigns users to an issue or pull request in repo.
+	AddAssignees(ctx context.Context, repo string, number int, assignees []string) error
+
+	// ListReleases returns every release of repo, including drafts and prereleases.
+	ListReleases(ctx context.Context, repo string) ([]Release, error)
+	// LatestRelease returns the release of repo that GitHub marks as the latest, or nil
+	// if repo has no releases.
+	LatestRelease(ctx context.Context, repo string) (*Release, error)
+
+	// GetRepository returns repo, or nil if it does not exist.
+	GetRepository(ctx context.Context, repo string) (*Repository, error)
+	// CreateFork forks repo to fork, which names the owner and name of the new
+	// repository. Forks are created asynchronously, so fork may not be usable at once.
+	CreateFork(ctx context.Context, repo, fork string) (Repository, error)
+}
+
+// PullRequestQuery selects pull requests. Empty fields match every pull request.
+type PullRequestQuery struct {
+	// "open", "closed" or "all". The default is "open".
+	State string
+	// The head branch: "branch" in the repository itself or "owner:branch" in a fork.
+	Head string
+	// The login of the author.
+	Author string
+	// Text that the title contains, ignoring case.
+	Title string
+}
+
+// IssueQuery selects issues. Empty fields match every issue.
+type IssueQuery struct {
+	// "open", "closed" or "all". The default is "open".
+	State string
+	// Text to search for in the title and body, as in GitHub's issue search.
+	Search string
+}
+
+type PullRequest struct {
+	Number      int
+	Title       string
+	Body        string
+	URL         string
+	State       string
+	Author      string
+	BaseRefName string
+	HeadRefName string
+	// The owner of the repository that HeadRefName is in.
+	HeadRepositoryOwner string
+}
+
+// hasHead reports if pr merges head, given as in PullRequestQuery, into repo.
+func (pr PullRequest) hasHead(repo, head string) bool {
+	owner, branch, ok := strings.Cut(head, ":")
+	if !ok {
+		owner, _, _ = strings.Cut(repo, "/")
+		branch = head
+	}
+	return pr.HeadRefName == branch && strings.EqualFold(pr.HeadRepositoryOwner, owner)
+}
+
+type NewPullRequest struct {
+	Base string
+	// The head branch, as in PullRequestQuery.
+	Head        string
+	Title, Body string
+	// Reviewers are logins or "org/team" names.
+	Reviewers []string
+	Assignees []string
+	Labels    []string
+}
+
+// PullRequestEdit describes an update to a pull request. Empty fields are left
+// unchanged, and reviewers, assignees and labels are added to the existing ones.
+type PullRequestEdit struct {
+	Title, Body  string
+	AddReviewers []string
+	AddAssignees []string
+	AddLabels    []string
+}
+
+type Issue struct {
+	Number int
+	Title  string
+	Body   string
+	URL    string
+	State  string
+}
+
+type NewIssue struct {
+	Title, Body string
+	Labels      []string
+}
+
+type Release struct {
+	Name        string
+	TagName     string
+	PublishedAt time.Time
+	Draft       bool
+	Prerelease  bool
+	// The release notes, in Markdown.
+	Body string `json:",omitempty"`
+}
+
+type Repository struct {
+	// The "owner/name" of the repository.
+	FullName string
+	Fork     bool
+	// The "owner/name" of the repository this is a fork of, if it is a fork.
+	Parent string
+}
+
+type githubContextKey struct{}
+
+// WithGitHub returns a context in which upgrades use gh, instead of the GitHub REST API.
+func WithGitHub(ctx context.Context, gh GitHub) context.Context {
+	return context.WithValue(ctx, githubContextKey{}, gh)
+}
+
+// The REST API clients, keyed by host. Clients are shared so that the authenticated user
+// is only looked up once.
+var defaultGitHubs sync.Map
+
+func defaultGitHub(host string) GitHub {
+	if gh, ok := defaultGitHubs.Load(host); ok {
+		return gh.(GitHub)
+	}
+	gh, _ := defaultGitHubs.LoadOrStore(host, newRESTGitHub(githubAPIURL(host), http.DefaultClient,
+		sync.OnceValues(func() (string, error) { return githubToken(host) })))
+	return gh.(GitHub)
+}
+
+// getGitHub returns the GitHub set with WithGitHub or, by default, the REST API of the
+// provider's host.
+func getGitHub(ctx context.Context) GitHub {
+	if gh, ok := ctx.Value(githubContextKey{}).(GitHub); ok {
+		return gh
+	}
+	return defaultGitHub(GetContext(ctx).providerHost())
+}
+
+// getGitHubAt is getGitHub for repositories on host, such as the upstream provider's.
+func getGitHubAt(ctx context.Context, host string) GitHub {
+	if gh, ok := ctx.Value(githubContextKey{}).(GitHub); ok {
+		return gh
+	}
+	return defaultGitHub(host)
+}
+
+// Each GitHub call is an impure step, so that it is recorded and can be replayed.
+
+var listPullRequests = stepv2.Func21E("List Pull Requests", func(
+	ctx context.Context, repo string, q PullRequestQuery,
+) ([]PullRequest, error) {
+	stepv2.MarkImpure(ctx)
+	return getGitHub(ctx).ListPullRequests(ctx, repo, q)
+})
+
+var createPullRequest = stepv2.Func21E("Create Pull Request", func(
+	ctx context.Context, repo string, pr NewPullRequest,
+) (PullRequest, error) {
+	stepv2.MarkImpure(ctx)
+	return getGitHub(ctx).CreatePullRequest(ctx, repo, pr)
+})
+
+var editPullRequest = stepv2.Func31E("Edit Pull Request", func(
+	ctx context.Context, repo string, number int, edit PullRequestEdit,
+) (PullRequest, error) {
+	stepv2.MarkImpure(ctx)
+	return getGitHub(ctx).EditPullRequest(ctx, repo, number, edit)
+})
+
+var closePullRequest = stepv2.Func30E("Close Pull Request", func(
+	ctx context.Context, repo string, number int, comment string,
+) error {
+	stepv2.MarkImpure(ctx)
+	stepv2.SetLabelf(ctx, "#%d", number)
+	return getGitHub(ctx).ClosePullRequest(ctx, repo, number, comment)
+})
+
+var listIssues = stepv2.Func21E("List Issues", func(
+	ctx context.Context, repo string, q IssueQuery,
+) ([]Issue, error) {
+	stepv2.MarkImpure(ctx)
+	return getGitHub(ctx).ListIssues(ctx, repo, q)
+})
+
+var createIssue = stepv2.Func21E("Create Issue", func(
+	ctx context.Context, repo string, issue NewIssue,
+) (Issue, error) {
+	stepv2.MarkImpure(ctx)
+	return getGitHub(ctx).CreateIssue(ctx, repo, issue)
+})
+
+var addAssignees = stepv2.Func30E("Add Assignees", func(
+	ctx context.Context, repo string, number int, assignees []string,
+) error {
+	stepv2.MarkImpure(ctx)
+	stepv2.SetLabelf(ctx, "#%d", number)
+	return getGitHub(ctx).AddAssignees(ctx, repo, number, assignees)
+})
+
+// listReleases lists the releases of repo on host. Unlike the other calls, it is used for
+// the upstream provider's repository, which may be on a different host.
+//
+// The releases are returned without their notes, which can be long. upstreamReleaseNotes
+// records the notes that the PR body uses.
+var listReleases = stepv2.Func21E("List Releases", func(
+	ctx context.Context, host, repo string,
+) ([]Release, error) {
+	stepv2.MarkImpure(ctx)
+	releases, err := fetchReleases(ctx, host, repo)
+	if err != nil {
+		return nil, err
+	}
+	releases = slices.Clone(releases)
+	for i := range releases {
+		releases[i].Body = ""
+	}
+	return releases, nil
+})
+
+// fetchReleases lists the releases of repo on host, once per upgrade.
+func fetchReleases(ctx context.Context, host, repo string) ([]Release, error) {
+	c := GetContext(ctx)
+	key := host + "/" + repo
+	if releases, ok := c.releases[key]; ok {
+		return releases, nil
+	}
+	releases, err := getGitHubAt(ctx, host).ListReleases(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	if c.releases == nil {
+		c.releases = map[string][]Release{}
+	}
+	c.releases[key] = releases
+	return releases, nil
+}
+
+var latestRelease = stepv2.Func11E("Get Latest Release", func(
+	ctx context.Context, repo string,
+) (*Release, error) {
+	stepv2.MarkImpure(ctx)
+	return getGitHub(ctx).LatestRelease(ctx, repo)
+})
+
+var getRepository = stepv2.Func11E("Get Repository", func(
+	ctx context.Context, repo string,
+) (*Repository, error) {
+	stepv2.MarkImpure(ctx)
+	return getGitHub(ctx).GetRepository(ctx, repo)
+})
+
+var createFork = stepv2.Func21E("Create Fork", func(
+	ctx context.Context, repo, fork string,
+) (Repository, error) {
+	stepv2.MarkImpure(ctx)
+	stepv2.SetLabel(ctx, fork)
+	return getGitHub(ctx).CreateFork(ctx, repo, fork)
+})
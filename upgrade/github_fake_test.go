@@ -0,0 +1,467 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
+)
+
+// fakeGitHub is an in-memory GitHub. Install it with WithGitHub.
+//
+// Tests seed the state before an upgrade and assert on it afterwards. Failures are
+// reported as the REST client reports them.
+type fakeGitHub struct {
+	mu sync.Mutex
+
+	// The login of the authenticated user, which "@me" refers to.
+	User string
+	// Repositories by "owner/name".
+	Repos map[string]*fakeGitHubRepo
+
+	// If set, checkPushed reports an error if branch has not been pushed to repo, which
+	// is required to open a pull request for it.
+	checkPushed func(repo, branch string) error
+	// If set, onFork is called when repo is forked to fork, to copy its contents.
+	onFork func(repo, fork string) error
+}
+
+var _ GitHub = (*fakeGitHub)(nil)
+
+type fakeGitHubRepo struct {
+	// The "owner/name" of the repository this is a fork of, if it is a fork.
+	Parent string
+	// The labels that exist in the repository. Issues and PRs can only use these.
+	Labels       []string
+	Issues       []*fakeIssue
+	PullRequests []*fakePullRequest
+	Releases     []Release
+}
+
+type fakeIssue struct {
+	// Issues and PRs share numbers, as on GitHub.
+	Number    int
+	Title     string
+	Body      string
+	State     string
+	Author    string
+	Labels    []string
+	Assignees []string
+	Comments  []string
+}
+
+type fakePullRequest struct {
+	fakeIssue
+	BaseRefName string
+	HeadRefName string
+	// The owner of the repository that HeadRefName is in. Empty means the owner of
+	// the repository the PR is in.
+	HeadRepositoryOwner string
+	Reviewers           []string
+}
+
+func fakeGitHubError(method, path string, status int, message string) error {
+	return &githubError{Method: method, Path: path, StatusCode: status, Message: message}
+}
+
+func (gh *fakeGitHub) repo(method, name string) (*fakeGitHubRepo, error) {
+	if r, ok := gh.Repos[name]; ok {
+		return r, nil
+	}
+	return nil, fakeGitHubError(method, "/repos/"+name, http.StatusNotFound, "Not Found")
+}
+
+// Resolve "@me" to the authenticated user.
+func (gh *fakeGitHub) login(user string) string {
+	if user == "@me" {
+		return gh.User
+	}
+	return user
+}
+
+func (r *fakeGitHubRepo) nextNumber() int {
+	n := 0
+	for _, i := range r.Issues {
+		n = max(n, i.Number)
+	}
+	for _, pr := range r.PullRequests {
+		n = max(n, pr.Number)
+	}
+	return n + 1
+}
+
+func (r *fakeGitHubRepo) checkLabels(labels []string) error {
+	for _, l := range labels {
+		if !slices.Contains(r.Labels, l) {
+			return fakeGitHubError(http.MethodPost, "/labels", http.StatusUnprocessableEntity,
+				fmt.Sprintf("Validation Failed: label '%s' not found", l))
+		}
+	}
+	return nil
+}
+
+// Find an issue or a PR by number.
+func (r *fakeGitHubRepo) issue(number int) *fakeIssue {
+	for _, i := range r.Issues {
+		if i.Number == number {
+			return i
+		}
+	}
+	if pr := r.pullRequest(number); pr != nil {
+		return &pr.fakeIssue
+	}
+	return nil
+}
+
+func (r *fakeGitHubRepo) pullRequest(number int) *fakePullRequest {
+	for _, pr := range r.PullRequests {
+		if pr.Number == number {
+			return pr
+		}
+	}
+	return nil
+}
+
+func (r *fakeGitHubRepo) latestRelease() *Release {
+	var latest *Release
+	for i, rel := range r.Releases {
+		if rel.Draft || rel.Prerelease {
+			continue
+		}
+		if latest == nil || rel.PublishedAt.After(latest.PublishedAt) {
+			latest = &r.Releases[i]
+		}
+	}
+	return latest
+}
+
+func matchesState(state, filter string) bool {
+	switch filter {
+	case "", "open":
+		return state == "open"
+	case "all":
+		return true
+	default:
+		return state == filter
+	}
+}
+
+// Matches the terms of a GitHub search: quoted phrases and words.
+var searchTerm = regexp.MustCompile(`"([^"]*)"|(\S+)`)
+
+// matchesSearch reports if every term of query appears in the title or body of i.
+func matchesSearch(i *fakeIssue, query string) bool {
+	text := strings.ToLower(i.Title + "\n" + i.Body)
+	for _, m := range searchTerm.FindAllStringSubmatch(query, -1) {
+		if !strings.Contains(text, strings.ToLower(m[1]+m[2])) {
+			return false
+		}
+	}
+	return true
+}
+
+func (gh *fakeGitHub) toIssue(repo string, i *fakeIssue) Issue {
+	return Issue{
+		Number: i.Number,
+		Title:  i.Title,
+		Body:   i.Body,
+		URL:    fmt.Sprintf("https://github.com/%s/issues/%d", repo, i.Number),
+		State:  i.State,
+	}
+}
+
+func (gh *fakeGitHub) toPullRequest(repo string, pr *fakePullRequest) PullRequest {
+	headOwner := pr.HeadRepositoryOwner
+	if headOwner == "" {
+		headOwner, _, _ = strings.Cut(repo, "/")
+	}
+	return PullRequest{
+		Number:      pr.Number,
+		Title:       pr.Title,
+		Body:        pr.Body,
+		URL:         fmt.Sprintf("https://github.com/%s/pull/%d", repo, pr.Number),
+		State:       pr.State,
+		Author:      pr.Author,
+		BaseRefName: pr.BaseRefName,
+		HeadRefName: pr.HeadRefName,
+
+		HeadRepositoryOwner: headOwner,
+	}
+}
+
+// headRepo finds the repository that head, given as in PullRequestQuery, refers to in a
+// pull request in repo.
+func (gh *fakeGitHub) headRepo(repo, head string) (owner, name, branch string) {
+	owner, branch, ok := strings.Cut(head, ":")
+	if !ok {
+		owner, _, _ = strings.Cut(repo, "/")
+		return owner, repo, head
+	}
+	for fork, r := range gh.Repos {
+		if strings.HasPrefix(fork, owner+"/") && (fork == repo || r.Parent == repo) {
+			return owner, fork, branch
+		}
+	}
+	// Without a fork, assume one with the same name.
+	_, name, _ = strings.Cut(repo, "/")
+	return owner, owner + "/" + name, branch
+}
+
+func (gh *fakeGitHub) ListPullRequests(
+	_ context.Context, repoName string, q PullRequestQuery,
+) ([]PullRequest, error) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	repo, err := gh.repo(http.MethodGet, repoName)
+	if err != nil {
+		return nil, err
+	}
+	var prs []PullRequest
+	for _, pr := range repo.PullRequests {
+		if !matchesState(pr.State, q.State) ||
+			(q.Head != "" && !gh.toPullRequest(repoName, pr).hasHead(repoName, q.Head)) ||
+			(q.Author != "" && pr.Author != gh.login(q.Author)) ||
+			!strings.Contains(strings.ToLower(pr.Title), strings.ToLower(q.Title)) {
+			continue
+		}
+		prs = append(prs, gh.toPullRequest(repoName, pr))
+	}
+	return prs, nil
+}
+
+func (gh *fakeGitHub) CreatePullRequest(
+	_ context.Context, repoName string, pr NewPullRequest,
+) (PullRequest, error) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	repo, err := gh.repo(http.MethodPost, repoName)
+	if err != nil {
+		return PullRequest{}, err
+	}
+	path := "/repos/" + repoName + "/pulls"
+	headOwner, headRepo, branch := gh.headRepo(repoName, pr.Head)
+	if gh.checkPushed != nil {
+		if err := gh.checkPushed(headRepo, branch); err != nil {
+			return PullRequest{}, fakeGitHubError(http.MethodPost, path, http.StatusUnprocessableEntity,
+				fmt.Sprintf("Validation Failed: head %q is not a branch: %s", pr.Head, err))
+		}
+	}
+	for _, existing := range repo.PullRequests {
+		if gh.toPullRequest(repoName, existing).hasHead(repoName, pr.Head) && existing.State == "open" {
+			return PullRequest{}, fakeGitHubError(http.MethodPost, path, http.StatusUnprocessableEntity,
+				fmt.Sprintf("Validation Failed: A pull request already exists for %s.", pr.Head))
+		}
+	}
+	if err := repo.checkLabels(pr.Labels); err != nil {
+		return PullRequest{}, err
+	}
+	created := &fakePullRequest{
+		fakeIssue: fakeIssue{
+			Number: repo.nextNumber(),
+			Title:  pr.Title,
+			Body:   pr.Body,
+			State:  "open",
+			Author: gh.User,
+			Labels: slices.Clone(pr.Labels),
+		},
+		BaseRefName:         pr.Base,
+		HeadRefName:         branch,
+		HeadRepositoryOwner: headOwner,
+		Reviewers:           slices.Clone(pr.Reviewers),
+	}
+	for _, a := range pr.Assignees {
+		created.Assignees = appendNew(created.Assignees, gh.login(a))
+	}
+	repo.PullRequests = append(repo.PullRequests, created)
+	return gh.toPullRequest(repoName, created), nil
+}
+
+func (gh *fakeGitHub) EditPullRequest(
+	_ context.Context, repoName string, number int, edit PullRequestEdit,
+) (PullRequest, error) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	path := fmt.Sprintf("/repos/%s/pulls/%d", repoName, number)
+	repo, err := gh.repo(http.MethodPatch, repoName)
+	if err != nil {
+		return PullRequest{}, err
+	}
+	pr := repo.pullRequest(number)
+	if pr == nil {
+		return PullRequest{}, fakeGitHubError(http.MethodPatch, path, http.StatusNotFound, "Not Found")
+	}
+	if err := repo.checkLabels(edit.AddLabels); err != nil {
+		return PullRequest{}, err
+	}
+	if edit.Title != "" {
+		pr.Title = edit.Title
+	}
+	if edit.Body != "" {
+		pr.Body = edit.Body
+	}
+	pr.Labels = appendNew(pr.Labels, edit.AddLabels...)
+	pr.Reviewers = appendNew(pr.Reviewers, edit.AddReviewers...)
+	for _, a := range edit.AddAssignees {
+		pr.Assignees = appendNew(pr.Assignees, gh.login(a))
+	}
+	return gh.toPullRequest(repoName, pr), nil
+}
+
+func (gh *fakeGitHub) ClosePullRequest(_ context.Context, repoName string, number int, comment string) error {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	path := fmt.Sprintf("/repos/%s/pulls/%d", repoName, number)
+	repo, err := gh.repo(http.MethodPatch, repoName)
+	if err != nil {
+		return err
+	}
+	pr := repo.pullRequest(number)
+	if pr == nil {
+		return fakeGitHubError(http.MethodPatch, path, http.StatusNotFound, "Not Found")
+	}
+	if comment != "" {
+		pr.Comments = append(pr.Comments, comment)
+	}
+	pr.State = "closed"
+	return nil
+}
+
+func (gh *fakeGitHub) ListIssues(_ context.Context, repoName string, q IssueQuery) ([]Issue, error) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	repo, err := gh.repo(http.MethodGet, repoName)
+	if err != nil {
+		return nil, err
+	}
+	var issues []Issue
+	for _, i := range repo.Issues {
+		if matchesState(i.State, q.State) && matchesSearch(i, q.Search) {
+			issues = append(issues, gh.toIssue(repoName, i))
+		}
+	}
+	return issues, nil
+}
+
+func (gh *fakeGitHub) CreateIssue(_ context.Context, repoName string, issue NewIssue) (Issue, error) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	repo, err := gh.repo(http.MethodPost, repoName)
+	if err != nil {
+		return Issue{}, err
+	}
+	if err := repo.checkLabels(issue.Labels); err != nil {
+		return Issue{}, err
+	}
+	created := &fakeIssue{
+		Number: repo.nextNumber(),
+		Title:  issue.Title,
+		Body:   issue.Body,
+		State:  "open",
+		Author: gh.User,
+		Labels: slices.Clone(issue.Labels),
+	}
+	repo.Issues = append(repo.Issues, created)
+	return gh.toIssue(repoName, created), nil
+}
+
+func (gh *fakeGitHub) AddAssignees(_ context.Context, repoName string, number int, assignees []string) error {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	repo, err := gh.repo(http.MethodPost, repoName)
+	if err != nil {
+		return err
+	}
+	i := repo.issue(number)
+	if i == nil {
+		return fakeGitHubError(http.MethodPost,
+			fmt.Sprintf("/repos/%s/issues/%d/assignees", repoName, number), http.StatusNotFound, "Not Found")
+	}
+	for _, a := range assignees {
+		i.Assignees = appendNew(i.Assignees, gh.login(a))
+	}
+	return nil
+}
+
+func (gh *fakeGitHub) ListReleases(_ context.Context, repoName string) ([]Release, error) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	repo, err := gh.repo(http.MethodGet, repoName)
+	if err != nil {
+		return nil, err
+	}
+	// Like the API, list the newest releases first.
+	releases := slices.Clone(repo.Releases)
+	sort.SliceStable(releases, func(i, j int) bool {
+		return releases[i].PublishedAt.After(releases[j].PublishedAt)
+	})
+	return releases, nil
+}
+
+func (gh *fakeGitHub) LatestRelease(_ context.Context, repoName string) (*Release, error) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	repo, err := gh.repo(http.MethodGet, repoName)
+	if err != nil {
+		return nil, err
+	}
+	if latest := repo.latestRelease(); latest != nil {
+		rel := *latest
+		return &rel, nil
+	}
+	return nil, nil
+}
+
+func (gh *fakeGitHub) GetRepository(_ context.Context, repoName string) (*Repository, error) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	repo, ok := gh.Repos[repoName]
+	if !ok {
+		return nil, nil
+	}
+	return &Repository{FullName: repoName, Fork: repo.Parent != "", Parent: repo.Parent}, nil
+}
+
+func (gh *fakeGitHub) CreateFork(_ context.Context, repoName, fork string) (Repository, error) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	path := "/repos/" + repoName + "/forks"
+	if _, err := gh.repo(http.MethodPost, repoName); err != nil {
+		return Repository{}, err
+	}
+	if _, exists := gh.Repos[fork]; exists {
+		return Repository{}, fakeGitHubError(http.MethodPost, path, http.StatusUnprocessableEntity,
+			fmt.Sprintf("Validation Failed: %s already exists", fork))
+	}
+	if gh.onFork != nil {
+		if err := gh.onFork(repoName, fork); err != nil {
+			return Repository{}, err
+		}
+	}
+	gh.Repos[fork] = &fakeGitHubRepo{Parent: repoName}
+	return Repository{FullName: fork, Fork: true, Parent: repoName}, nil
+}
+
+func appendNew(list []string, values ...string) []string {
+	for _, v := range values {
+		if v != "" && !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// runWithGitHub runs f in a pipeline against gh, failing the test if the pipeline fails.
+func runWithGitHub(t *testing.T, gh GitHub, f func(ctx context.Context)) {
+	t.Helper()
+	ctx := WithGitHub(context.Background(), gh)
+	require.NoError(t, stepv2.PipelineCtx(ctx, t.Name(), f, stepv2.NullDisplay))
+}
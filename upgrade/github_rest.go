@@ -0,0 +1,633 @@
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// githubAPIURL returns the base URL of the REST API of the GitHub instance at host.
+func githubAPIURL(host string) string {
+	if host == "github.com" {
+		return "https://api.github.com"
+	}
+	// GitHub Enterprise Server serves the API under the instance's own host.
+	return "https://" + host + "/api/v3"
+}
+
+// githubToken finds a token for the GitHub API at host: $GITHUB_TOKEN or $GH_TOKEN for
+// github.com, $GH_ENTERPRISE_TOKEN or $GITHUB_ENTERPRISE_TOKEN for other hosts or, if the
+// GitHub CLI is installed, the token it is logged in to host with.
+func githubToken(host string) (string, error) {
+	keys := []string{"GITHUB_TOKEN", "GH_TOKEN"}
+	if host != "github.com" {
+		keys = []string{"GH_ENTERPRISE_TOKEN", "GITHUB_ENTERPRISE_TOKEN"}
+	}
+	for _, key := range keys {
+		if token := os.Getenv(key); token != "" {
+			return token, nil
+		}
+	}
+	if _, err := exec.LookPath("gh"); err == nil {
+		out, err := exec.Command("gh", "auth", "token", "--hostname", host).Output()
+		if token := strings.TrimSpace(string(out)); err == nil && token != "" {
+			return token, nil
+		}
+	}
+	return "", fmt.Errorf("no token found for %s: set %s or %s, or log in with `gh auth login --hostname %[1]s`",
+		host, keys[0], keys[1])
+}
+
+// restGitHub implements GitHub with the GitHub REST API, following pagination so that
+// lists are complete.
+type restGitHub struct {
+	baseURL string
+	client  *http.Client
+	token   func() (string, error)
+
+	mu    sync.Mutex
+	login string
+}
+
+func newRESTGitHub(baseURL string, client *http.Client, token func() (string, error)) *restGitHub {
+	return &restGitHub{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  client,
+		token:   token,
+	}
+}
+
+var _ GitHub = (*restGitHub)(nil)
+
+// githubError is an unsuccessful response from the GitHub API.
+type githubError struct {
+	Method, Path string
+	StatusCode   int
+	Message      string
+	// Details from the "errors" field of the response.
+	Details []string
+	// Set when the request was rate limited.
+	rateLimited bool
+	// How long the API asked to wait before retrying, if it did.
+	retryAfter time.Duration
+}
+
+func (e *githubError) Error() string {
+	msg := fmt.Sprintf("GitHub API: %s %s: %d", e.Method, e.Path, e.StatusCode)
+	if e.Message != "" {
+		msg += " " + e.Message
+	}
+	if len(e.Details) > 0 {
+		msg += ": " + strings.Join(e.Details, "; ")
+	}
+	return msg
+}
+
+// Whether a request with method that failed with err may succeed if it is retried.
+//
+// Server and network errors are retried, unless the request is a POST: it may have taken
+// effect before it failed, and sending it again would, for example, open a second PR. A
+// rate limited request did not take effect, so it is always retried.
+func githubRetryable(ctx context.Context, method string, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *githubError
+	if errors.As(err, &apiErr) {
+		return apiErr.rateLimited || (apiErr.StatusCode >= 500 && method != http.MethodPost)
+	}
+	if method == http.MethodPost {
+		return false
+	}
+	// Errors sending the request or reading the response. Others, such as a missing
+	// token or a response that cannot be decoded, recur when retried.
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// do sends a request to the API, retrying transient failures according to
+// networkRetryPolicy. It decodes the response into out, if out is not nil, and returns
+// the URL of the next page of results, if there is one.
+func (g *restGitHub) do(ctx context.Context, method, path string, in, out any) (string, error) {
+	policy := networkRetryPolicy(ctx)
+	for attempt := 1; ; attempt++ {
+		next, err := g.try(ctx, method, path, in, out)
+		if err == nil || attempt >= policy.Attempts || !githubRetryable(ctx, method, err) {
+			return next, err
+		}
+		var wait time.Duration
+		if policy.Backoff != nil {
+			wait = policy.Backoff(attempt)
+		}
+		// A rate limited request succeeds only once the limit resets, so backing off
+		// for less than the API asks for would waste the attempt.
+		var apiErr *githubError
+		if errors.As(err, &apiErr) && apiErr.retryAfter > wait {
+			wait = apiErr.retryAfter
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return "", err
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+func (g *restGitHub) try(ctx context.Context, method, path string, in, out any) (string, error) {
+	token, err := g.token()
+	if err != nil {
+		return "", err
+	}
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return "", err
+		}
+		body = bytes.NewReader(b)
+	}
+	u := path
+	if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+		u = g.baseURL + path
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 300 {
+		return "", newGitHubError(req, resp, data)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return "", fmt.Errorf("GitHub API: %s %s: %w", method, req.URL.Path, err)
+		}
+	}
+	return nextPage(resp.Header.Get("Link")), nil
+}
+
+func newGitHubError(req *http.Request, resp *http.Response, data []byte) *githubError {
+	var body struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Message string `json:"message"`
+			Field   string `json:"field"`
+			Code    string `json:"code"`
+		} `json:"errors"`
+	}
+	_ = json.Unmarshal(data, &body)
+	err := &githubError{
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		StatusCode: resp.StatusCode,
+		Message:    body.Message,
+	}
+	for _, e := range body.Errors {
+		switch {
+		case e.Message != "":
+			err.Details = append(err.Details, e.Message)
+		case e.Field != "":
+			err.Details = append(err.Details, e.Field+" "+e.Code)
+		}
+	}
+	err.rateLimited = resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode == http.StatusForbidden &&
+			(resp.Header.Get("X-RateLimit-Remaining") == "0" ||
+				strings.Contains(strings.ToLower(body.Message), "rate limit")))
+	if err.rateLimited {
+		err.retryAfter = rateLimitWait(resp.Header, time.Now())
+	}
+	return err
+}
+
+// rateLimitWait returns how long the headers of a rate limited response ask to wait
+// before retrying, from Retry-After or, failing that, X-RateLimit-Reset.
+func rateLimitWait(header http.Header, now time.Time) time.Duration {
+	var wait time.Duration
+	if after := header.Get("Retry-After"); after != "" {
+		if seconds, err := strconv.Atoi(after); err == nil {
+			wait = time.Duration(seconds) * time.Second
+		} else if at, err := http.ParseTime(after); err == nil {
+			wait = at.Sub(now)
+		}
+	} else if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		wait = time.Unix(reset, 0).Sub(now)
+	}
+	return max(wait, 0)
+}
+
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// nextPage returns the URL of the next page in a Link header.
+func nextPage(link string) string {
+	if m := nextLink.FindStringSubmatch(link); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// getAll requests path and every following page, decoding each page into a T and passing
+// it to add.
+func getAll[T any](ctx context.Context, g *restGitHub, path string, add func(T)) error {
+	for path != "" {
+		var page T
+		next, err := g.do(ctx, http.MethodGet, path, nil, &page)
+		if err != nil {
+			return err
+		}
+		add(page)
+		path = next
+	}
+	return nil
+}
+
+// me resolves "@me" to the login of the authenticated user.
+func (g *restGitHub) me(ctx context.Context, login string) (string, error) {
+	if login != "@me" {
+		return login, nil
+	}
+	g.mu.Lock()
+	cached := g.login
+	g.mu.Unlock()
+	if cached != "" {
+		return cached, nil
+	}
+	// The lock is not held across the request, so concurrent callers may both look up
+	// the login. They get the same answer.
+	var user struct {
+		Login string `json:"login"`
+	}
+	if _, err := g.do(ctx, http.MethodGet, "/user", nil, &user); err != nil {
+		return "", err
+	}
+	g.mu.Lock()
+	g.login = user.Login
+	g.mu.Unlock()
+	return user.Login, nil
+}
+
+func (g *restGitHub) logins(ctx context.Context, logins []string) ([]string, error) {
+	out := make([]string, 0, len(logins))
+	for _, l := range logins {
+		l, err := g.me(ctx, l)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+type restUser struct {
+	Login string `json:"login"`
+}
+
+type restPullRequest struct {
+	Number  int      `json:"number"`
+	Title   string   `json:"title"`
+	Body    string   `json:"body"`
+	HTMLURL string   `json:"html_url"`
+	State   string   `json:"state"`
+	User    restUser `json:"user"`
+	Base    struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+	Head struct {
+		Ref string `json:"ref"`
+		// The owner of the head repository.
+		User restUser `json:"user"`
+	} `json:"head"`
+}
+
+func (pr restPullRequest) toPullRequest() PullRequest {
+	return PullRequest{
+		Number:      pr.Number,
+		Title:       pr.Title,
+		Body:        pr.Body,
+		URL:         pr.HTMLURL,
+		State:       pr.State,
+		Author:      pr.User.Login,
+		BaseRefName: pr.Base.Ref,
+		HeadRefName: pr.Head.Ref,
+
+		HeadRepositoryOwner: pr.Head.User.Login,
+	}
+}
+
+type restIssue struct {
+	Number      int             `json:"number"`
+	Title       string          `json:"title"`
+	Body        string          `json:"body"`
+	HTMLURL     string          `json:"html_url"`
+	State       string          `json:"state"`
+	PullRequest json.RawMessage `json:"pull_request"`
+}
+
+func (i restIssue) toIssue() Issue {
+	return Issue{Number: i.Number, Title: i.Title, Body: i.Body, URL: i.HTMLURL, State: i.State}
+}
+
+type restRelease struct {
+	Name        string    `json:"name"`
+	TagName     string    `json:"tag_name"`
+	PublishedAt time.Time `json:"published_at"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	Body        string    `json:"body"`
+}
+
+func (r restRelease) toRelease() Release {
+	return Release{
+		Name:        r.Name,
+		TagName:     r.TagName,
+		PublishedAt: r.PublishedAt,
+		Draft:       r.Draft,
+		Prerelease:  r.Prerelease,
+		Body:        r.Body,
+	}
+}
+
+func queryState(state string) string {
+	if state == "" {
+		return "open"
+	}
+	return state
+}
+
+func (g *restGitHub) ListPullRequests(
+	ctx context.Context, repo string, q PullRequestQuery,
+) ([]PullRequest, error) {
+	author, err := g.me(ctx, q.Author)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{"state": {queryState(q.State)}, "per_page": {"100"}}
+	if head := q.Head; head != "" {
+		if !strings.Contains(head, ":") {
+			owner, _, _ := strings.Cut(repo, "/")
+			head = owner + ":" + head
+		}
+		params.Set("head", head)
+	}
+
+	var prs []PullRequest
+	err = getAll(ctx, g, "/repos/"+repo+"/pulls?"+params.Encode(), func(page []restPullRequest) {
+		for _, pr := range page {
+			if author != "" && !strings.EqualFold(pr.User.Login, author) {
+				continue
+			}
+			if q.Title != "" && !strings.Contains(strings.ToLower(pr.Title), strings.ToLower(q.Title)) {
+				continue
+			}
+			prs = append(prs, pr.toPullRequest())
+		}
+	})
+	return prs, err
+}
+
+func (g *restGitHub) CreatePullRequest(
+	ctx context.Context, repo string, pr NewPullRequest,
+) (PullRequest, error) {
+	var created restPullRequest
+	_, err := g.do(ctx, http.MethodPost, "/repos/"+repo+"/pulls", map[string]string{
+		"base":  pr.Base,
+		"head":  pr.Head,
+		"title": pr.Title,
+		"body":  pr.Body,
+	}, &created)
+	if err != nil {
+		return PullRequest{}, err
+	}
+	err = g.addMetadata(ctx, repo, created.Number, pr.Reviewers, pr.Assignees, pr.Labels)
+	return created.toPullRequest(), err
+}
+
+func (g *restGitHub) EditPullRequest(
+	ctx context.Context, repo string, number int, edit PullRequestEdit,
+) (PullRequest, error) {
+	update := map[string]string{}
+	if edit.Title != "" {
+		update["title"] = edit.Title
+	}
+	if edit.Body != "" {
+		update["body"] = edit.Body
+	}
+	var pr restPullRequest
+	_, err := g.do(ctx, http.MethodPatch, fmt.Sprintf("/repos/%s/pulls/%d", repo, number), update, &pr)
+	if err != nil {
+		return PullRequest{}, err
+	}
+	err = g.addMetadata(ctx, repo, number, edit.AddReviewers, edit.AddAssignees, edit.AddLabels)
+	return pr.toPullRequest(), err
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// teamSlug returns the slug GitHub gives the team called name, such as "providers" for
+// "Providers" or "platform-team" for "Platform Team".
+func teamSlug(name string) string {
+	return strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// addMetadata requests reviews from reviewers and adds assignees and labels to a pull
+// request.
+func (g *restGitHub) addMetadata(
+	ctx context.Context, repo string, number int, reviewers, assignees, labels []string,
+) error {
+	if len(reviewers) > 0 {
+		// Teams are given as "org/team", but requested by their slug.
+		users, teams := []string{}, []string{}
+		for _, r := range reviewers {
+			if _, team, ok := strings.Cut(r, "/"); ok {
+				teams = append(teams, teamSlug(team))
+			} else {
+				users = append(users, r)
+			}
+		}
+		_, err := g.do(ctx, http.MethodPost,
+			fmt.Sprintf("/repos/%s/pulls/%d/requested_reviewers", repo, number),
+			map[string][]string{"reviewers": users, "team_reviewers": teams}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if err := g.AddAssignees(ctx, repo, number, assignees); err != nil {
+		return err
+	}
+	if len(labels) > 0 {
+		_, err := g.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/labels", repo, number),
+			map[string][]string{"labels": labels}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *restGitHub) ClosePullRequest(ctx context.Context, repo string, number int, comment string) error {
+	if comment != "" {
+		_, err := g.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/comments", repo, number),
+			map[string]string{"body": comment}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := g.do(ctx, http.MethodPatch, fmt.Sprintf("/repos/%s/pulls/%d", repo, number),
+		map[string]string{"state": "closed"}, nil)
+	return err
+}
+
+func (g *restGitHub) ListIssues(ctx context.Context, repo string, q IssueQuery) ([]Issue, error) {
+	var issues []Issue
+	add := func(page []restIssue) {
+		for _, i := range page {
+			// The issues API also lists pull requests.
+			if i.PullRequest == nil {
+				issues = append(issues, i.toIssue())
+			}
+		}
+	}
+
+	if q.Search == "" {
+		params := url.Values{"state": {queryState(q.State)}, "per_page": {"100"}}
+		err := getAll(ctx, g, "/repos/"+repo+"/issues?"+params.Encode(), add)
+		return issues, err
+	}
+
+	search := "repo:" + repo + " is:issue"
+	if state := queryState(q.State); state != "all" {
+		search += " state:" + state
+	}
+	params := url.Values{"q": {search + " " + q.Search}, "per_page": {"100"}}
+	err := getAll(ctx, g, "/search/issues?"+params.Encode(), func(page struct {
+		Items []restIssue `json:"items"`
+	},
+	) {
+		add(page.Items)
+	})
+	return issues, err
+}
+
+func (g *restGitHub) CreateIssue(ctx context.Context, repo string, issue NewIssue) (Issue, error) {
+	var created restIssue
+	_, err := g.do(ctx, http.MethodPost, "/repos/"+repo+"/issues", map[string]any{
+		"title":  issue.Title,
+		"body":   issue.Body,
+		"labels": issue.Labels,
+	}, &created)
+	return created.toIssue(), err
+}
+
+func (g *restGitHub) AddAssignees(ctx context.Context, repo string, number int, assignees []string) error {
+	if len(assignees) == 0 {
+		return nil
+	}
+	logins, err := g.logins(ctx, assignees)
+	if err != nil {
+		return err
+	}
+	_, err = g.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/assignees", repo, number),
+		map[string][]string{"assignees": logins}, nil)
+	return err
+}
+
+func (g *restGitHub) ListReleases(ctx context.Context, repo string) ([]Release, error) {
+	var releases []Release
+	err := getAll(ctx, g, "/repos/"+repo+"/releases?per_page=100", func(page []restRelease) {
+		for _, r := range page {
+			releases = append(releases, r.toRelease())
+		}
+	})
+	return releases, err
+}
+
+func (g *restGitHub) LatestRelease(ctx context.Context, repo string) (*Release, error) {
+	var latest restRelease
+	_, err := g.do(ctx, http.MethodGet, "/repos/"+repo+"/releases/latest", nil, &latest)
+	var apiErr *githubError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := latest.toRelease()
+	return &r, nil
+}
+
+type restRepository struct {
+	FullName string `json:"full_name"`
+	Fork     bool   `json:"fork"`
+	Parent   *struct {
+		FullName string `json:"full_name"`
+	} `json:"parent"`
+}
+
+func (r restRepository) toRepository() Repository {
+	repo := Repository{FullName: r.FullName, Fork: r.Fork}
+	if r.Parent != nil {
+		repo.Parent = r.Parent.FullName
+	}
+	return repo
+}
+
+func (g *restGitHub) GetRepository(ctx context.Context, repo string) (*Repository, error) {
+	var r restRepository
+	_, err := g.do(ctx, http.MethodGet, "/repos/"+repo, nil, &r)
+	var apiErr *githubError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	found := r.toRepository()
+	return &found, nil
+}
+
+func (g *restGitHub) CreateFork(ctx context.Context, repo, fork string) (Repository, error) {
+	owner, name, _ := strings.Cut(fork, "/")
+	me, err := g.me(ctx, "@me")
+	if err != nil {
+		return Repository{}, err
+	}
+	req := map[string]any{"name": name, "default_branch_only": true}
+	// Forks are created for the authenticated user unless an organization is given.
+	if !strings.EqualFold(owner, me) {
+		req["organization"] = owner
+	}
+	var created restRepository
+	_, err = g.do(ctx, http.MethodPost, "/repos/"+repo+"/forks", req, &created)
+	return created.toRepository(), err
+}
@@ -0,0 +1,392 @@
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGitHubAPI serves canned responses for the GitHub REST API, keyed by method and
+// request URI, and records the requests it receives.
+type fakeGitHubAPI struct {
+	t         *testing.T
+	responses map[string][]fakeGitHubResponse
+
+	mu       sync.Mutex
+	requests []string
+	bodies   map[string]any
+}
+
+type fakeGitHubResponse struct {
+	status int
+	// The Link header, in which "{server}" is replaced by the server's URL.
+	link string
+	body string
+}
+
+func newFakeGitHubAPI(t *testing.T, responses map[string][]fakeGitHubResponse) (*restGitHub, *fakeGitHubAPI) {
+	api := &fakeGitHubAPI{t: t, responses: responses, bodies: map[string]any{}}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	return newRESTGitHub(server.URL, server.Client(), func() (string, error) { return "secret", nil }), api
+}
+
+func (api *fakeGitHubAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	assert.Equal(api.t, "Bearer secret", r.Header.Get("Authorization"))
+	key := r.Method + " " + r.URL.RequestURI()
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.requests = append(api.requests, key)
+	if r.Body != nil {
+		data, err := io.ReadAll(r.Body)
+		require.NoError(api.t, err)
+		if len(data) > 0 {
+			var body any
+			require.NoError(api.t, json.Unmarshal(data, &body))
+			api.bodies[key] = body
+		}
+	}
+
+	queue := api.responses[key]
+	if len(queue) == 0 {
+		api.t.Errorf("unexpected request %s", key)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	resp := queue[0]
+	// The last response is repeated.
+	if len(queue) > 1 {
+		api.responses[key] = queue[1:]
+	}
+	if resp.link != "" {
+		w.Header().Set("Link", strings.ReplaceAll(resp.link, "{server}", "http://"+r.Host))
+	}
+	if resp.status != 0 {
+		w.WriteHeader(resp.status)
+	}
+	fmt.Fprint(w, resp.body)
+}
+
+func testGitHubContext() context.Context {
+	return (&Context{NetworkRetries: 2, NetworkRetryBackoff: time.Millisecond}).Wrap(context.Background())
+}
+
+func TestGitHubAPIURL(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "https://api.github.com", githubAPIURL("github.com"))
+	assert.Equal(t, "https://github.example.com/api/v3", githubAPIURL("github.example.com"))
+}
+
+func TestRESTGitHubListPullRequestsPaginates(t *testing.T) {
+	t.Parallel()
+
+	gh, _ := newFakeGitHubAPI(t, map[string][]fakeGitHubResponse{
+		"GET /user": {{body: `{"login": "pulumi-bot"}`}},
+		"GET /repos/pulumi/pulumi-xyz/pulls?per_page=100&state=open": {{
+			link: `<{server}/repos/pulumi/pulumi-xyz/pulls?page=2&per_page=100&state=open>; rel="next", ` +
+				`<{server}/repos/pulumi/pulumi-xyz/pulls?page=2&per_page=100&state=open>; rel="last"`,
+			body: `[
+				{"number": 1, "title": "Upgrade pulumi-terraform-bridge to v3.1.0", "user": {"login": "pulumi-bot"},
+				 "head": {"ref": "upgrade-1"}, "base": {"ref": "main"}, "html_url": "https://github.com/pulumi/pulumi-xyz/pull/1"},
+				{"number": 2, "title": "Upgrade pulumi-terraform-bridge to v3.1.0", "user": {"login": "someone-else"},
+				 "head": {"ref": "upgrade-2"}, "base": {"ref": "main"}}
+			]`,
+		}},
+		"GET /repos/pulumi/pulumi-xyz/pulls?page=2&per_page=100&state=open": {{
+			body: `[
+				{"number": 3, "title": "Bump golang.org/x/net", "user": {"login": "pulumi-bot"},
+				 "head": {"ref": "dependabot"}, "base": {"ref": "main"}},
+				{"number": 4, "title": "upgrade PULUMI-TERRAFORM-BRIDGE to v3.2.0", "user": {"login": "pulumi-bot"},
+				 "head": {"ref": "upgrade-4"}, "base": {"ref": "main"}}
+			]`,
+		}},
+	})
+	prs, err := gh.ListPullRequests(testGitHubContext(), "pulumi/pulumi-xyz", PullRequestQuery{
+		Author: "@me",
+		Title:  "Upgrade pulumi-terraform-bridge",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []PullRequest{
+		{
+			Number:      1,
+			Title:       "Upgrade pulumi-terraform-bridge to v3.1.0",
+			URL:         "https://github.com/pulumi/pulumi-xyz/pull/1",
+			Author:      "pulumi-bot",
+			BaseRefName: "main",
+			HeadRefName: "upgrade-1",
+		},
+		{
+			Number:      4,
+			Title:       "upgrade PULUMI-TERRAFORM-BRIDGE to v3.2.0",
+			Author:      "pulumi-bot",
+			BaseRefName: "main",
+			HeadRefName: "upgrade-4",
+		},
+	}, prs)
+}
+
+func TestRESTGitHubListIssues(t *testing.T) {
+	t.Parallel()
+
+	gh, _ := newFakeGitHubAPI(t, map[string][]fakeGitHubResponse{
+		// Pull requests are issues too, but are not listed.
+		"GET /repos/pulumi/pulumi-xyz/issues?per_page=100&state=open": {{body: `[
+			{"number": 1, "title": "Upgrade terraform-provider-xyz to v1.2.3"},
+			{"number": 2, "title": "Upgrade terraform-provider-xyz to v1.2.3", "pull_request": {}}
+		]`}},
+		"GET /search/issues?per_page=100&q=repo%3Apulumi%2Fpulumi-xyz+is%3Aissue+state%3Aopen+pulumiupgradeproviderissue": {{
+			body: `{"total_count": 1, "items": [{"number": 1, "title": "Upgrade terraform-provider-xyz to v1.2.3"}]}`,
+		}},
+	})
+
+	ctx := testGitHubContext()
+	issues, err := gh.ListIssues(ctx, "pulumi/pulumi-xyz", IssueQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []Issue{{Number: 1, Title: "Upgrade terraform-provider-xyz to v1.2.3"}}, issues)
+
+	issues, err = gh.ListIssues(ctx, "pulumi/pulumi-xyz", IssueQuery{Search: upgradeIssueToken})
+	require.NoError(t, err)
+	assert.Equal(t, []Issue{{Number: 1, Title: "Upgrade terraform-provider-xyz to v1.2.3"}}, issues)
+}
+
+func TestRESTGitHubCreatePullRequest(t *testing.T) {
+	t.Parallel()
+
+	gh, api := newFakeGitHubAPI(t, map[string][]fakeGitHubResponse{
+		"GET /user": {{body: `{"login": "pulumi-bot"}`}},
+		"POST /repos/pulumi/pulumi-xyz/pulls": {{
+			status: http.StatusCreated,
+			body:   `{"number": 7, "html_url": "https://github.com/pulumi/pulumi-xyz/pull/7", "state": "open"}`,
+		}},
+		"POST /repos/pulumi/pulumi-xyz/pulls/7/requested_reviewers": {{status: http.StatusCreated, body: `{}`}},
+		"POST /repos/pulumi/pulumi-xyz/issues/7/assignees":          {{status: http.StatusCreated, body: `{}`}},
+		"POST /repos/pulumi/pulumi-xyz/issues/7/labels":             {{body: `[]`}},
+	})
+
+	pr, err := gh.CreatePullRequest(testGitHubContext(), "pulumi/pulumi-xyz", NewPullRequest{
+		Base:      "main",
+		Head:      "upgrade",
+		Title:     "Upgrade",
+		Body:      "Body",
+		Reviewers: []string{"pulumi/Providers", "lukehoban"},
+		Assignees: []string{"@me"},
+		Labels:    []string{"needs-release/patch"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/pulumi/pulumi-xyz/pull/7", pr.URL)
+	assert.Equal(t, map[string]any{
+		"POST /repos/pulumi/pulumi-xyz/pulls": map[string]any{
+			"base": "main", "head": "upgrade", "title": "Upgrade", "body": "Body",
+		},
+		// Teams are requested by their slug.
+		"POST /repos/pulumi/pulumi-xyz/pulls/7/requested_reviewers": map[string]any{
+			"reviewers": []any{"lukehoban"}, "team_reviewers": []any{"providers"},
+		},
+		"POST /repos/pulumi/pulumi-xyz/issues/7/assignees": map[string]any{
+			"assignees": []any{"pulumi-bot"},
+		},
+		"POST /repos/pulumi/pulumi-xyz/issues/7/labels": map[string]any{
+			"labels": []any{"needs-release/patch"},
+		},
+	}, api.bodies)
+}
+
+func TestRESTGitHubForks(t *testing.T) {
+	t.Parallel()
+
+	gh, api := newFakeGitHubAPI(t, map[string][]fakeGitHubResponse{
+		"GET /user": {{body: `{"login": "pulumi-bot"}`}},
+		"GET /repos/pulumi-bot/pulumi-xyz": {{
+			body: `{"full_name": "pulumi-bot/pulumi-xyz", "fork": true, "parent": {"full_name": "pulumi/pulumi-xyz"}}`,
+		}},
+		"GET /repos/pulumi-bot/pulumi-abc": {{status: http.StatusNotFound, body: `{"message": "Not Found"}`}},
+		"POST /repos/pulumi/pulumi-abc/forks": {{
+			status: http.StatusAccepted,
+			body:   `{"full_name": "pulumi-bot/pulumi-abc", "fork": true, "parent": {"full_name": "pulumi/pulumi-abc"}}`,
+		}},
+		"POST /repos/pulumi/pulumi-def/forks": {{
+			status: http.StatusAccepted,
+			body:   `{"full_name": "pulumi-forks/pulumi-def", "fork": true, "parent": {"full_name": "pulumi/pulumi-def"}}`,
+		}},
+		"GET /repos/pulumi/pulumi-xyz/pulls?head=pulumi-bot%3Aupgrade&per_page=100&state=open": {{body: `[
+			{"number": 1, "head": {"ref": "upgrade", "user": {"login": "pulumi-bot"}}, "base": {"ref": "main"}}
+		]`}},
+	})
+
+	ctx := testGitHubContext()
+	repo, err := gh.GetRepository(ctx, "pulumi-bot/pulumi-xyz")
+	require.NoError(t, err)
+	assert.Equal(t, &Repository{FullName: "pulumi-bot/pulumi-xyz", Fork: true, Parent: "pulumi/pulumi-xyz"}, repo)
+
+	repo, err = gh.GetRepository(ctx, "pulumi-bot/pulumi-abc")
+	require.NoError(t, err)
+	assert.Nil(t, repo)
+
+	_, err = gh.CreateFork(ctx, "pulumi/pulumi-abc", "pulumi-bot/pulumi-abc")
+	require.NoError(t, err)
+	_, err = gh.CreateFork(ctx, "pulumi/pulumi-def", "pulumi-forks/pulumi-def")
+	require.NoError(t, err)
+	// Forks owned by the authenticated user are created without an organization.
+	assert.Equal(t, map[string]any{"name": "pulumi-abc", "default_branch_only": true},
+		api.bodies["POST /repos/pulumi/pulumi-abc/forks"])
+	assert.Equal(t, map[string]any{"name": "pulumi-def", "default_branch_only": true, "organization": "pulumi-forks"},
+		api.bodies["POST /repos/pulumi/pulumi-def/forks"])
+
+	// Heads in forks are passed as they are.
+	prs, err := gh.ListPullRequests(ctx, "pulumi/pulumi-xyz", PullRequestQuery{Head: "pulumi-bot:upgrade"})
+	require.NoError(t, err)
+	require.Len(t, prs, 1)
+	assert.True(t, prs[0].hasHead("pulumi/pulumi-xyz", "pulumi-bot:upgrade"))
+	assert.False(t, prs[0].hasHead("pulumi/pulumi-xyz", "upgrade"))
+}
+
+func TestRESTGitHubLatestRelease(t *testing.T) {
+	t.Parallel()
+
+	gh, _ := newFakeGitHubAPI(t, map[string][]fakeGitHubResponse{
+		"GET /repos/pulumi/pulumi-xyz/releases/latest": {{
+			body: `{"name": "v1.4.0", "tag_name": "v1.4.0", "published_at": "2023-01-04T21:03:48Z"}`,
+		}},
+		"GET /repos/pulumi/pulumi-new/releases/latest": {{
+			status: http.StatusNotFound,
+			body:   `{"message": "Not Found"}`,
+		}},
+	})
+
+	ctx := testGitHubContext()
+	rel, err := gh.LatestRelease(ctx, "pulumi/pulumi-xyz")
+	require.NoError(t, err)
+	assert.Equal(t, &Release{
+		Name:        "v1.4.0",
+		TagName:     "v1.4.0",
+		PublishedAt: time.Date(2023, 1, 4, 21, 3, 48, 0, time.UTC),
+	}, rel)
+
+	// A repository without releases has no latest release.
+	rel, err = gh.LatestRelease(ctx, "pulumi/pulumi-new")
+	require.NoError(t, err)
+	assert.Nil(t, rel)
+}
+
+func TestRESTGitHubRetries(t *testing.T) {
+	t.Parallel()
+
+	gh, api := newFakeGitHubAPI(t, map[string][]fakeGitHubResponse{
+		"GET /repos/pulumi/pulumi-xyz/releases?per_page=100": {
+			{status: http.StatusBadGateway},
+			{status: http.StatusForbidden, body: `{"message": "You have exceeded a secondary rate limit."}`},
+			{body: `[{"name": "v1.4.0", "tag_name": "v1.4.0"}]`},
+		},
+		"POST /repos/pulumi/pulumi-xyz/issues": {{
+			status: http.StatusUnprocessableEntity,
+			body: `{"message": "Validation Failed",
+				"errors": [{"resource": "Issue", "field": "title", "code": "missing_field"}]}`,
+		}},
+	})
+
+	ctx := testGitHubContext()
+	releases, err := gh.ListReleases(ctx, "pulumi/pulumi-xyz")
+	require.NoError(t, err)
+	assert.Equal(t, []Release{{Name: "v1.4.0", TagName: "v1.4.0"}}, releases)
+
+	// Client errors are not retried.
+	_, err = gh.CreateIssue(ctx, "pulumi/pulumi-xyz", NewIssue{})
+	assert.EqualError(t, err,
+		"GitHub API: POST /repos/pulumi/pulumi-xyz/issues: 422 Validation Failed: title missing_field")
+	assert.Equal(t, []string{
+		"GET /repos/pulumi/pulumi-xyz/releases?per_page=100",
+		"GET /repos/pulumi/pulumi-xyz/releases?per_page=100",
+		"GET /repos/pulumi/pulumi-xyz/releases?per_page=100",
+		"POST /repos/pulumi/pulumi-xyz/issues",
+	}, api.requests)
+}
+
+// A POST may have taken effect before it failed, so only rate limited POSTs are retried.
+func TestRESTGitHubRetriesPost(t *testing.T) {
+	t.Parallel()
+
+	gh, api := newFakeGitHubAPI(t, map[string][]fakeGitHubResponse{
+		"POST /repos/pulumi/pulumi-xyz/pulls": {
+			{status: http.StatusTooManyRequests},
+			{status: http.StatusBadGateway},
+		},
+	})
+
+	_, err := gh.CreatePullRequest(testGitHubContext(), "pulumi/pulumi-xyz", NewPullRequest{})
+	assert.EqualError(t, err, "GitHub API: POST /repos/pulumi/pulumi-xyz/pulls: 502")
+	assert.Equal(t, []string{
+		"POST /repos/pulumi/pulumi-xyz/pulls",
+		"POST /repos/pulumi/pulumi-xyz/pulls",
+	}, api.requests)
+}
+
+// Errors that are not caused by the network recur, so they are not retried.
+func TestRESTGitHubDoesNotRetry(t *testing.T) {
+	t.Parallel()
+
+	gh, api := newFakeGitHubAPI(t, map[string][]fakeGitHubResponse{
+		"GET /repos/pulumi/pulumi-xyz/releases/latest": {{body: `{"tag_name": 1}`}},
+	})
+	_, err := gh.LatestRelease(testGitHubContext(), "pulumi/pulumi-xyz")
+	assert.ErrorContains(t, err, "GitHub API: GET /repos/pulumi/pulumi-xyz/releases/latest: json: cannot unmarshal")
+	assert.Len(t, api.requests, 1)
+
+	var tokens int
+	gh.token = func() (string, error) {
+		tokens++
+		return "", errors.New("no token found")
+	}
+	_, err = gh.LatestRelease(testGitHubContext(), "pulumi/pulumi-xyz")
+	assert.EqualError(t, err, "no token found")
+	assert.Equal(t, 1, tokens)
+}
+
+func TestRateLimitWait(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 1, 4, 21, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"seconds", http.Header{"Retry-After": {"30"}}, 30 * time.Second},
+		{"date", http.Header{"Retry-After": {"Wed, 04 Jan 2023 21:01:00 GMT"}}, time.Minute},
+		{"reset", http.Header{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {fmt.Sprint(now.Add(90 * time.Second).Unix())},
+		}, 90 * time.Second},
+		{"retry after wins", http.Header{
+			"Retry-After":       {"5"},
+			"X-Ratelimit-Reset": {fmt.Sprint(now.Add(time.Hour).Unix())},
+		}, 5 * time.Second},
+		{"already reset", http.Header{"X-Ratelimit-Reset": {fmt.Sprint(now.Add(-time.Minute).Unix())}}, 0},
+		{"none", http.Header{}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, rateLimitWait(tc.header, now))
+		})
+	}
+}
+
+func TestTeamSlug(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "providers", teamSlug("Providers"))
+	assert.Equal(t, "platform-team", teamSlug("Platform Team"))
+	assert.Equal(t, "providers", teamSlug("providers"))
+}
@@ -1,4 +1,4 @@
-// HTTP-related effects.
+// Network-related effects.
 package upgrade
 
 import (
@@ -6,22 +6,62 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"time"
+
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
 )
 
-// Use this function in steps to perform HTTP GET.
-func getHTTP(ctx context.Context, url string) ([]byte, error) {
+// Use this function in steps to perform HTTP GET. The request is a step of its own, so
+// that replays return the recorded response instead of making it.
+var getHTTP = stepv2.Func11E("HTTP GET", func(ctx context.Context, url string) (string, error) {
+	stepv2.MarkImpure(ctx)
 	h, ok := ctx.Value(httpHandlerKey).(httpHandler)
 	if !ok {
+		policy := networkRetryPolicy(ctx)
 		h = &defaultHttpHandler{
-			retryAttempts: 5,
+			retryAttempts: max(policy.Attempts, 1),
 			delay: func(attempt int) time.Duration {
-				n := (attempt + 1) * (attempt + 1)
-				return time.Duration(n) * time.Second
+				return policy.Backoff(attempt + 1)
 			},
 		}
 	}
-	return h.getHTTP(url)
+	body, err := h.getHTTP(url)
+	return string(body), err
+})
+
+// Run a network-touching command, retrying transient failures according to
+// networkRetryPolicy.
+func networkCmd(ctx context.Context, name string, args ...string) string {
+	return stepv2.Cmd(stepv2.WithRetry(ctx, networkRetryPolicy(ctx)), name, args...)
+}
+
+// Stderr output from git that indicates a transient network failure.
+var transientNetworkErrors = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)could not resolve host`),
+	regexp.MustCompile(`(?i)connection (reset|refused|timed out)`),
+	regexp.MustCompile(`(?i)operation timed out`),
+	regexp.MustCompile(`(?i)i/o timeout`),
+	regexp.MustCompile(`(?i)TLS handshake timeout`),
+	regexp.MustCompile(`(?i)the remote end hung up unexpectedly`),
+	regexp.MustCompile(`(?i)early EOF`),
+	regexp.MustCompile(`(?i)RPC failed`),
+	regexp.MustCompile(`(?i)HTTP (500|502|503|504)`),
+	regexp.MustCompile(`(?i)(bad gateway|service unavailable|gateway timeout)`),
+	regexp.MustCompile(`(?i)secondary rate limit`),
+}
+
+// The retry policy for network-touching commands and HTTP requests.
+func networkRetryPolicy(ctx context.Context) stepv2.RetryPolicy {
+	attempts, backoff := 5, time.Second
+	if c, ok := ctx.Value(contextKey).(*Context); ok {
+		attempts, backoff = c.NetworkRetries+1, c.NetworkRetryBackoff
+	}
+	return stepv2.RetryPolicy{
+		Attempts: attempts,
+		Backoff:  stepv2.QuadraticBackoff(backoff),
+		Stderr:   transientNetworkErrors,
+	}
 }
 
 // Set this to a mock in tests to avoid hitting actual HTTP.
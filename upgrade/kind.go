@@ -2,6 +2,7 @@ package upgrade
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"strings"
@@ -73,12 +74,9 @@ var getRepoKind = stepv2.Func11E("Get Repo Kind", func(ctx context.Context, repo
 		return nil, fmt.Errorf("go.mod: %w", err)
 	}
 
-	bridge, ok, err := originalGoVersionOf(ctx, repo, filepath.Join("provider", "go.mod"), "github.com/pulumi/pulumi-terraform-bridge")
-	bridgeMissingMsg := "Unable to discover pulumi-terraform-bridge version"
-	if err != nil {
-		return nil, fmt.Errorf("%s: %w", bridgeMissingMsg, err)
-	} else if !ok {
-		return nil, fmt.Errorf("%s", bridgeMissingMsg)
+	bridge, ok := originalGoVersionOfV2(ctx, repo, filepath.Join("provider", "go.mod"), "github.com/pulumi/pulumi-terraform-bridge")
+	if !ok {
+		return nil, errors.New("Unable to discover pulumi-terraform-bridge version")
 	}
 
 	tfProviderRepoName := GetContext(ctx).UpstreamProviderName
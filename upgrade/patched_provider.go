@@ -9,23 +9,31 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/pulumi/upgrade-provider/step"
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
 )
 
 const patchCheckoutBranch = "pulumi/patch-checkout"
 
-func patchedProviderUpgradeStep(repo ProviderRepo, targetRef string) step.Step {
+var patchedProviderUpgrade = stepv2.Func20("update patched provider", func(
+	ctx context.Context, repo ProviderRepo, targetRef string,
+) {
 	upstreamDir := filepath.Join(repo.root, "upstream")
-	steps := []step.Step{
-		step.F("Check patched provider state", func(ctx context.Context) (string, error) {
-			return checkPatchedProviderPreflight(ctx, upstreamDir, targetRef)
-		}),
-	}
-	for _, command := range patchedProviderUpgradeCommands(targetRef) {
-		steps = append(steps, step.Cmd(command[0], command[1:]...).In(&repo.root))
-	}
-	return step.Combined("update patched provider", steps...)
-}
+	checkPatchedProviderState(ctx, upstreamDir, targetRef)
+	stepv2.WithCwd(ctx, repo.root, func(ctx context.Context) {
+		for _, command := range patchedProviderUpgradeCommands(targetRef) {
+			stepv2.Cmd(ctx, command[0], command[1:]...)
+		}
+	})
+})
+
+var checkPatchedProviderState = stepv2.Func21E("Check patched provider state", func(
+	ctx context.Context, upstreamDir, targetRef string,
+) (string, error) {
+	stepv2.MarkImpure(ctx)
+	result, err := checkPatchedProviderPreflight(ctx, upstreamDir, targetRef)
+	stepv2.SetLabel(ctx, result)
+	return result, err
+})
 
 func patchedProviderUpgradeCommands(targetRef string) [][]string {
 	return [][]string{
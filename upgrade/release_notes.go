@@ -0,0 +1,142 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
+)
+
+// ReleaseNotes are the notes of an upstream release, as shown in the PR body.
+type ReleaseNotes struct {
+	// The version, without a "v" prefix.
+	Version string
+	// The release's page on GitHub.
+	URL string
+	// The notes, in Markdown, with notable lines highlighted.
+	Notes string
+	// The number of highlighted lines, including those cut from Notes.
+	Highlights int
+	// Set when Notes were shortened to keep the PR body small.
+	Truncated bool
+	// The number of older releases left out of the PR body. Only set on the oldest
+	// release that is shown.
+	Older int
+}
+
+// releaseNotesLimit caps the combined size of the release notes in a PR body. GitHub
+// rejects bodies over 65536 characters, and long digests are not read anyway.
+const releaseNotesLimit = 20000
+
+// releaseNotesCount caps the number of releases in a PR body. Even without notes, each
+// release takes a few hundred characters.
+const releaseNotesCount = 25
+
+var (
+	// Lines that reviewers should not miss.
+	notableReleaseNote = regexp.MustCompile(`(?i)breaking|deprecated|removed`)
+	// The list marker or heading that a line of Markdown starts with, if any.
+	markdownLinePrefix = regexp.MustCompile(`^\s*(?:(?:[-*+]|\d+[.)]|#{1,6})\s+)?`)
+)
+
+// upstreamReleaseNotes collects the release notes of every release of the upstream
+// provider repo after from and up to and including to, newest first.
+//
+// The notes only inform reviewers, so they are left out instead of failing the upgrade
+// when the releases cannot be listed. Only the notes that are returned are recorded.
+var upstreamReleaseNotes = stepv2.Func31("Upstream Release Notes", func(
+	ctx context.Context, repo string, from, to *semver.Version,
+) []ReleaseNotes {
+	stepv2.MarkImpure(ctx)
+	host := GetContext(ctx).upstreamHost()
+	releases, err := fetchReleases(ctx, host, repo)
+	if err != nil {
+		stepv2.SetLabelf(ctx, "unavailable: %s", err)
+		return nil
+	}
+	notes := releaseNotesBetween(host, repo, releases, from, to)
+	stepv2.SetLabelf(ctx, "%d releases", len(notes))
+	return notes
+})
+
+// releaseNotesBetween returns the notes of releases in (from, to], newest first.
+//
+// Drafts are skipped, as are prereleases other than to itself. Only the newest
+// releaseNotesCount releases are returned, and their notes are cut, last release first,
+// once they exceed releaseNotesLimit.
+func releaseNotesBetween(host, repo string, releases []Release, from, to *semver.Version) []ReleaseNotes {
+	type release struct {
+		Release
+		version *semver.Version
+	}
+	var selected []release
+	for _, r := range releases {
+		if r.Draft {
+			continue
+		}
+		v, err := semver.NewVersion(r.TagName)
+		if err != nil {
+			continue
+		}
+		if !v.GreaterThan(from) || v.GreaterThan(to) || (v.Prerelease() != "" && !v.Equal(to)) {
+			continue
+		}
+		selected = append(selected, release{r, v})
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[j].version.LessThan(selected[i].version)
+	})
+	var older int
+	if len(selected) > releaseNotesCount {
+		older = len(selected) - releaseNotesCount
+		selected = selected[:releaseNotesCount]
+	}
+
+	notes := make([]ReleaseNotes, 0, len(selected))
+	remaining := releaseNotesLimit
+	for _, r := range selected {
+		n := ReleaseNotes{
+			Version: r.version.String(),
+			URL:     fmt.Sprintf("https://%s/%s/releases/tag/%s", host, repo, r.TagName),
+		}
+		n.Notes, n.Highlights = highlightReleaseNotes(r.Body)
+		if len(n.Notes) > remaining {
+			// Cut at the end of a line, so Markdown is not split mid-token.
+			cut := max(strings.LastIndex(n.Notes[:remaining], "\n"), 0)
+			n.Notes = strings.TrimSpace(n.Notes[:cut])
+			n.Truncated = true
+		}
+		remaining -= len(n.Notes)
+		notes = append(notes, n)
+	}
+	notes[len(notes)-1].Older = older
+	return notes
+}
+
+// highlightReleaseNotes marks each notable line of the release notes body, returning the
+// marked notes and the number of lines marked.
+func highlightReleaseNotes(body string) (string, int) {
+	body = strings.TrimSpace(strings.ReplaceAll(body, "\r\n", "\n"))
+	if body == "" {
+		return "", 0
+	}
+	lines := strings.Split(body, "\n")
+	var highlights int
+	for i, line := range lines {
+		if !notableReleaseNote.MatchString(line) {
+			continue
+		}
+		prefix := len(markdownLinePrefix.FindString(line))
+		lines[i] = line[:prefix] + ":warning: " + line[prefix:]
+		highlights++
+	}
+	return strings.Join(lines, "\n"), highlights
+}
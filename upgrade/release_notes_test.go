@@ -0,0 +1,170 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	step "github.com/pulumi/upgrade-provider/step/v2"
+)
+
+func TestReleaseNotesBetween(t *testing.T) {
+	t.Parallel()
+
+	releases := []Release{
+		{TagName: "v1.3.0", Body: "too new"},
+		{TagName: "v1.3.0-beta.1", Body: "- beta", Prerelease: true},
+		{TagName: "v1.2.0", Body: "- Add `a`\r\n- BREAKING: rename `b`\r\n"},
+		{TagName: "v1.1.1", Body: "draft", Draft: true},
+		{TagName: "not-a-version", Body: "ignored"},
+		{TagName: "v1.1.0", Body: ""},
+		{TagName: "v1.0.0", Body: "already included"},
+	}
+	notes := releaseNotesBetween("github.com", "org/terraform-provider-x", releases,
+		semver.MustParse("1.0.0"), semver.MustParse("1.3.0-beta.1"))
+	assert.Equal(t, []ReleaseNotes{
+		{
+			Version:    "1.3.0-beta.1",
+			URL:        "https://github.com/org/terraform-provider-x/releases/tag/v1.3.0-beta.1",
+			Notes:      "- beta",
+			Highlights: 0,
+		},
+		{
+			Version:    "1.2.0",
+			URL:        "https://github.com/org/terraform-provider-x/releases/tag/v1.2.0",
+			Notes:      "- Add `a`\n- :warning: BREAKING: rename `b`",
+			Highlights: 1,
+		},
+		{
+			Version: "1.1.0",
+			URL:     "https://github.com/org/terraform-provider-x/releases/tag/v1.1.0",
+		},
+	}, notes)
+
+	assert.Nil(t, releaseNotesBetween("github.com", "org/terraform-provider-x", releases,
+		semver.MustParse("1.3.0"), semver.MustParse("1.3.0")))
+}
+
+func TestReleaseNotesLimit(t *testing.T) {
+	t.Parallel()
+
+	line := "- " + strings.Repeat("x", 98) + "\n"
+	big := strings.Repeat(line, releaseNotesLimit/len(line)*2/3)
+	releases := []Release{
+		{TagName: "v1.3.0", Body: big},
+		{TagName: "v1.2.0", Body: big + "- removed `c`"},
+		{TagName: "v1.1.0", Body: big},
+	}
+	notes := releaseNotesBetween("github.com", "org/repo", releases,
+		semver.MustParse("1.0.0"), semver.MustParse("1.3.0"))
+	assert.Len(t, notes, 3)
+
+	var total int
+	for _, n := range notes {
+		total += len(n.Notes)
+		assert.True(t, n.Notes == "" || strings.HasSuffix(n.Notes, strings.Repeat("x", 98)),
+			"notes should be cut at the end of a line")
+	}
+	assert.LessOrEqual(t, total, releaseNotesLimit)
+
+	assert.False(t, notes[0].Truncated)
+	assert.True(t, notes[1].Truncated)
+	// Highlights count the lines that were cut.
+	assert.Equal(t, 1, notes[1].Highlights)
+	assert.True(t, notes[2].Truncated)
+	assert.Empty(t, notes[2].Notes)
+}
+
+func TestReleaseNotesCount(t *testing.T) {
+	t.Parallel()
+
+	var releases []Release
+	for i := releaseNotesCount + 5; i > 0; i-- {
+		releases = append(releases, Release{TagName: fmt.Sprintf("v1.%d.0", i), Body: "- Fix"})
+	}
+	notes := releaseNotesBetween("github.com", "org/repo", releases,
+		semver.MustParse("1.0.0"), semver.MustParse(fmt.Sprintf("1.%d.0", releaseNotesCount+5)))
+	require.Len(t, notes, releaseNotesCount)
+	assert.Equal(t, fmt.Sprintf("1.%d.0", releaseNotesCount+5), notes[0].Version)
+	assert.Equal(t, "1.6.0", notes[releaseNotesCount-1].Version)
+	assert.Equal(t, 5, notes[releaseNotesCount-1].Older)
+	assert.Zero(t, notes[0].Older)
+}
+
+// countReleaseLists counts the calls to ListReleases.
+type countReleaseLists struct {
+	GitHub
+	calls int
+}
+
+func (c *countReleaseLists) ListReleases(ctx context.Context, repo string) ([]Release, error) {
+	c.calls++
+	return c.GitHub.ListReleases(ctx, repo)
+}
+
+// The upstream's releases pick the upgrade target and make the release notes, but are
+// listed once.
+func TestUpstreamReleaseNotes(t *testing.T) {
+	t.Setenv("GITHUB_OUTPUT", filepath.Join(t.TempDir(), "output"))
+
+	c := &Context{UpstreamProviderOrg: "example", UpstreamProviderName: "terraform-provider-example"}
+	gh := &countReleaseLists{GitHub: &fakeGitHub{Repos: map[string]*fakeGitHubRepo{
+		"example/terraform-provider-example": {Releases: []Release{
+			{TagName: "v1.1.0", Body: "- Fix"},
+			{TagName: "v1.0.0", Body: "- Initial release"},
+		}},
+	}}}
+	ctx := WithGitHub(c.Wrap(context.Background()), gh)
+
+	var notes []ReleaseNotes
+	require.NoError(t, step.PipelineCtx(ctx, t.Name(), func(ctx context.Context) {
+		target := getExpectedTargetLatest(ctx)
+		notes = upstreamReleaseNotes(ctx, "example/terraform-provider-example",
+			semver.MustParse("1.0.0"), target.Version)
+		// The notes are not recorded with the list.
+		for _, r := range listReleases(ctx, "github.com", "example/terraform-provider-example") {
+			assert.Empty(t, r.Body)
+		}
+	}, step.NullDisplay))
+
+	assert.Equal(t, 1, gh.calls)
+	require.Len(t, notes, 1)
+	assert.Equal(t, "- Fix", notes[0].Notes)
+}
+
+// Release notes that cannot be listed are left out, without failing the upgrade.
+func TestUpstreamReleaseNotesUnavailable(t *testing.T) {
+	t.Setenv("GITHUB_OUTPUT", filepath.Join(t.TempDir(), "output"))
+
+	ctx := WithGitHub((&Context{}).Wrap(context.Background()), &fakeGitHub{})
+	var notes []ReleaseNotes
+	require.NoError(t, step.PipelineCtx(ctx, t.Name(), func(ctx context.Context) {
+		notes = upstreamReleaseNotes(ctx, "example/terraform-provider-example",
+			semver.MustParse("1.0.0"), semver.MustParse("1.1.0"))
+	}, step.NullDisplay))
+	assert.Nil(t, notes)
+}
+
+func TestHighlightReleaseNotes(t *testing.T) {
+	t.Parallel()
+
+	notes, n := highlightReleaseNotes(`## Breaking Changes
+
+* resource/x: Removed the ` + "`y`" + ` argument
+  1. Nested deprecated item
+Plain line about deprecated things
+- Not notable`)
+	assert.Equal(t, `## :warning: Breaking Changes
+
+* :warning: resource/x: Removed the `+"`y`"+` argument
+  1. :warning: Nested deprecated item
+:warning: Plain line about deprecated things
+- Not notable`, notes)
+	assert.Equal(t, 4, n)
+}
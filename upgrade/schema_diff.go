@@ -0,0 +1,554 @@
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
+)
+
+// SchemaDiff describes how the Pulumi schema of a provider changed in an upgrade.
+//
+// It is written to --schema-diff-out as JSON.
+type SchemaDiff struct {
+	Resources TokenChanges `json:"resources"`
+	Functions TokenChanges `json:"functions"`
+	// Changes to the properties of resources, functions and object types that are in both
+	// schemas.
+	Properties []PropertyChange `json:"properties"`
+	// The number of changes left out of the lists above. See truncate.
+	Omitted int `json:"omitted,omitempty"`
+}
+
+// TokenChanges are the resources or functions that were added, removed or renamed.
+type TokenChanges struct {
+	Added   []string      `json:"added"`
+	Removed []string      `json:"removed"`
+	Renamed []TokenRename `json:"renamed"`
+}
+
+type TokenRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// PropertyChange is a change to a property of a resource, function or object type.
+type PropertyChange struct {
+	// The token of the resource, function or type, after any rename.
+	Token string `json:"token"`
+	// The property, as "inputs.name" or "outputs.name", or "properties.name" for a type.
+	Property string `json:"property"`
+	// One of "added", "removed", "type", "required" or "optional".
+	Change string `json:"change"`
+	// The property's type before and after a "type" change.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Set when an "added" property is required.
+	Required bool `json:"required,omitempty"`
+}
+
+// SchemaChange is one row of the table of schema changes in the PR body.
+type SchemaChange struct {
+	// What changed, in Markdown.
+	Subject string
+	// How it changed.
+	Change string
+	// Set if the change can break programs that use the provider.
+	Breaking bool
+}
+
+// Empty reports if the schema did not change in any way that SchemaDiff tracks.
+func (d *SchemaDiff) Empty() bool {
+	return d.Count() == 0
+}
+
+// Count returns the number of changes, including those that were omitted.
+func (d *SchemaDiff) Count() int {
+	count := len(d.Properties) + d.Omitted
+	for _, t := range []TokenChanges{d.Resources, d.Functions} {
+		count += len(t.Added) + len(t.Removed) + len(t.Renamed)
+	}
+	return count
+}
+
+// More returns the number of changes after the first n.
+func (d *SchemaDiff) More(n int) int {
+	return max(d.Count()-n, 0)
+}
+
+// BreakingChanges lists the changes that can break programs that use the provider.
+func (d *SchemaDiff) BreakingChanges() []SchemaChange {
+	var breaking []SchemaChange
+	for _, c := range d.Changes(d.Count()) {
+		if c.Breaking {
+			breaking = append(breaking, c)
+		}
+	}
+	return breaking
+}
+
+// schemaDiffLimit caps the changes of each kind that diffSchema returns, so that a large
+// upgrade doesn't bloat replay recordings. The PR body shows fewer anyway.
+const schemaDiffLimit = 200
+
+// truncate returns d with at most n changes of each kind, counting the rest as omitted.
+// Breaking property changes are kept over others, so that a truncated diff is breaking
+// if d is.
+func (d *SchemaDiff) truncate(n int) *SchemaDiff {
+	t := &SchemaDiff{Omitted: d.Omitted}
+	tokens := func(c TokenChanges) TokenChanges {
+		return TokenChanges{
+			Added:   truncateList(c.Added, n, &t.Omitted),
+			Removed: truncateList(c.Removed, n, &t.Omitted),
+			Renamed: truncateList(c.Renamed, n, &t.Omitted),
+		}
+	}
+	t.Resources, t.Functions = tokens(d.Resources), tokens(d.Functions)
+
+	kept := make([]int, len(d.Properties))
+	for i := range kept {
+		kept[i] = i
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return d.Properties[kept[i]].breaking() && !d.Properties[kept[j]].breaking()
+	})
+	kept = truncateList(kept, n, &t.Omitted)
+	sort.Ints(kept)
+	for _, i := range kept {
+		t.Properties = append(t.Properties, d.Properties[i])
+	}
+	return t
+}
+
+func truncateList[T any](list []T, n int, omitted *int) []T {
+	if len(list) <= n {
+		return list
+	}
+	*omitted += len(list) - n
+	return list[:n]
+}
+
+// breaking reports if programs that use the provider can break because of p.
+//
+// Removing a property or changing its type breaks the programs that use it, and
+// programs that do not set an input break when it becomes required. Object types can be
+// inputs, so their properties are treated as inputs.
+func (p PropertyChange) breaking() bool {
+	input := strings.HasPrefix(p.Property, "inputs.") || strings.HasPrefix(p.Property, "properties.")
+	switch p.Change {
+	case "removed", "type":
+		return true
+	case "required":
+		return input
+	case "added":
+		return p.Required && input
+	default:
+		return false
+	}
+}
+
+// Changes lists the first n changes, removals and renames first.
+//
+// Removed and renamed resources and functions are breaking changes, since programs
+// refer to them by name.
+func (d *SchemaDiff) Changes(n int) []SchemaChange {
+	var changes []SchemaChange
+	for _, t := range []struct {
+		kind    string
+		changes TokenChanges
+	}{{"Resource", d.Resources}, {"Function", d.Functions}} {
+		for _, token := range t.changes.Removed {
+			changes = append(changes, SchemaChange{"`" + token + "`", t.kind + " removed", true})
+		}
+		for _, r := range t.changes.Renamed {
+			changes = append(changes, SchemaChange{
+				"`" + r.From + "` → `" + r.To + "`", t.kind + " renamed", true,
+			})
+		}
+		for _, token := range t.changes.Added {
+			changes = append(changes, SchemaChange{"`" + token + "`", t.kind + " added", false})
+		}
+	}
+	for _, p := range d.Properties {
+		change := SchemaChange{Subject: "`" + p.Token + "` `" + p.Property + "`", Breaking: p.breaking()}
+		switch p.Change {
+		case "type":
+			change.Change = fmt.Sprintf("Type changed from `%s` to `%s`", p.From, p.To)
+		case "required":
+			change.Change = "Now required"
+		case "optional":
+			change.Change = "Now optional"
+		case "added":
+			change.Change = "Property added"
+			if p.Required {
+				change.Change = "Required property added"
+			}
+		default:
+			change.Change = "Property " + p.Change
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) > n {
+		changes = changes[:n]
+	}
+	return changes
+}
+
+// schemaFile returns the path of the provider's Pulumi schema, relative to the root of
+// repo.
+func schemaFile(repo ProviderRepo) string {
+	name := strings.TrimPrefix(repo.Name, "pulumi-")
+	return path.Join("provider", "cmd", "pulumi-resource-"+name, "schema.json")
+}
+
+// diffSchema compares the schema on the default branch of repo with the checked out one.
+//
+// The diff only informs reviewers, so it is left out instead of failing the upgrade
+// when either schema cannot be read.
+//
+// Every change is written to --schema-diff-out, but the diff that is returned, and so
+// recorded, is truncated to schemaDiffLimit changes of each kind.
+var diffSchema = stepv2.Func11E("Diff Schema", func(ctx context.Context, repo ProviderRepo) (*SchemaDiff, error) {
+	stepv2.MarkImpure(ctx)
+	file := schemaFile(repo)
+	base, err := baseFileAt(ctx, repo, file)
+	if err != nil {
+		stepv2.SetLabelf(ctx, "unavailable: %s", err)
+		return nil, nil
+	}
+	upgraded, err := os.ReadFile(filepath.Join(repo.root, filepath.FromSlash(file)))
+	if err != nil {
+		stepv2.SetLabelf(ctx, "unavailable: %s", err)
+		return nil, nil
+	}
+	diff, err := compareSchemas(base, upgraded)
+	if err != nil {
+		stepv2.SetLabelf(ctx, "unavailable: %s", err)
+		return nil, nil
+	}
+	if err := writeSchemaDiff(GetContext(ctx), diff); err != nil {
+		return nil, err
+	}
+	stepv2.SetLabelf(ctx, "%d changes", diff.Count())
+	return diff.truncate(schemaDiffLimit), nil
+})
+
+// checkBreakingChanges reports breaking changes in diff that are not part of a major
+// version upgrade, failing if c.BlockBreakingChanges is set.
+var checkBreakingChanges = stepv2.Func10E("Check Breaking Changes", func(
+	ctx context.Context, diff *SchemaDiff,
+) error {
+	if diff == nil {
+		stepv2.SetLabel(ctx, "schema not compared")
+		return nil
+	}
+	breaking := diff.BreakingChanges()
+	c := GetContext(ctx)
+	switch {
+	case len(breaking) == 0:
+		stepv2.SetLabel(ctx, "none")
+	case c.MajorVersionBump:
+		stepv2.SetLabelf(ctx, "%d, in a major version", len(breaking))
+	case c.BlockBreakingChanges:
+		return errors.New(breakingChangesMessage(breaking))
+	default:
+		stepv2.SetLabelf(ctx, "%d", len(breaking))
+	}
+	return nil
+})
+
+// breakingChangesMessage explains that the breaking changes are not part of a major
+// version upgrade, and lists them.
+func breakingChangesMessage(breaking []SchemaChange) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "the schema has %d breaking changes, but the provider's major version is not bumped "+
+		"(pass --major to bump it):", len(breaking))
+	for _, c := range breaking {
+		fmt.Fprintf(&b, "\n  - %s: %s", strings.ReplaceAll(c.Subject, "`", ""), strings.ReplaceAll(c.Change, "`", ""))
+	}
+	return b.String()
+}
+
+// writeSchemaDiff writes diff to c.SchemaDiffOut, if it is set.
+func writeSchemaDiff(c *Context, diff *SchemaDiff) error {
+	if c.SchemaDiffOut == "" || diff == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.SchemaDiffOut, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write schema diff: %w", err)
+	}
+	return nil
+}
+
+// The parts of a Pulumi package schema that are compared.
+type pulumiSchema struct {
+	Resources map[string]schemaResource `json:"resources"`
+	Functions map[string]schemaFunction `json:"functions"`
+	Types     map[string]schemaType     `json:"types"`
+}
+
+type schemaResource struct {
+	InputProperties map[string]schemaProperty `json:"inputProperties"`
+	RequiredInputs  []string                  `json:"requiredInputs"`
+	Properties      map[string]schemaProperty `json:"properties"`
+	Required        []string                  `json:"required"`
+	Aliases         []struct {
+		Type string `json:"type"`
+	} `json:"aliases"`
+}
+
+// An object type has properties, while an enum type has none.
+type schemaType struct {
+	Properties map[string]schemaProperty `json:"properties"`
+	Required   []string                  `json:"required"`
+}
+
+type schemaFunction struct {
+	Inputs  *schemaObject `json:"inputs"`
+	Outputs *schemaObject `json:"outputs"`
+}
+
+type schemaObject struct {
+	Properties map[string]schemaProperty `json:"properties"`
+	Required   []string                  `json:"required"`
+}
+
+type schemaProperty struct {
+	Type                 string           `json:"type"`
+	Ref                  string           `json:"$ref"`
+	Items                *schemaProperty  `json:"items"`
+	AdditionalProperties *schemaProperty  `json:"additionalProperties"`
+	OneOf                []schemaProperty `json:"oneOf"`
+}
+
+// String renders the type of p, such as "[]string" or "map[string]aws:s3/Rule:Rule".
+func (p *schemaProperty) String() string { return p.render(nil) }
+
+// render renders the type of p as String does, with the types it refers to renamed
+// from the keys of renamed to their values.
+func (p *schemaProperty) render(renamed map[string]string) string {
+	switch {
+	case p == nil:
+		return "any"
+	case p.Ref != "":
+		token := strings.TrimPrefix(p.Ref, "#/types/")
+		// Refs escape the "/" in tokens, as in "#/types/aws:s3%2FRule:Rule".
+		if unescaped, err := url.PathUnescape(token); err == nil {
+			token = unescaped
+		}
+		if to, ok := renamed[token]; ok {
+			return to
+		}
+		return token
+	case len(p.OneOf) > 0:
+		types := make([]string, len(p.OneOf))
+		for i := range p.OneOf {
+			types[i] = p.OneOf[i].render(renamed)
+		}
+		return strings.Join(types, "|")
+	case p.Type == "array":
+		return "[]" + p.Items.render(renamed)
+	case p.Type == "object" && p.AdditionalProperties != nil:
+		return "map[string]" + p.AdditionalProperties.render(renamed)
+	case p.Type == "":
+		return "any"
+	default:
+		return p.Type
+	}
+}
+
+func (o *schemaObject) properties() (map[string]schemaProperty, []string) {
+	if o == nil {
+		return nil, nil
+	}
+	return o.Properties, o.Required
+}
+
+// compareSchemas computes the changes from the base schema to the upgraded one.
+func compareSchemas(base, upgraded []byte) (*SchemaDiff, error) {
+	var from, to pulumiSchema
+	if err := json.Unmarshal(base, &from); err != nil {
+		return nil, fmt.Errorf("base schema: %w", err)
+	}
+	if err := json.Unmarshal(upgraded, &to); err != nil {
+		return nil, fmt.Errorf("upgraded schema: %w", err)
+	}
+
+	diff := &SchemaDiff{}
+
+	// Types are renamed when they move between modules, as resources are. A property
+	// that refers to a renamed type has not changed type.
+	renamed := map[string]string{}
+	_, types := diffTokens(from.Types, to.Types, nil)
+	for _, pair := range types {
+		if pair[0] != pair[1] {
+			renamed[pair[0]] = pair[1]
+		}
+	}
+
+	aliases := map[string][]string{}
+	for token, r := range to.Resources {
+		for _, a := range r.Aliases {
+			aliases[token] = append(aliases[token], a.Type)
+		}
+	}
+	var resources [][2]string
+	diff.Resources, resources = diffTokens(from.Resources, to.Resources, aliases)
+	for _, pair := range resources {
+		old, new := from.Resources[pair[0]], to.Resources[pair[1]]
+		diff.Properties = append(diff.Properties, diffProperties(pair[1], "inputs", renamed,
+			old.InputProperties, old.RequiredInputs, new.InputProperties, new.RequiredInputs)...)
+		diff.Properties = append(diff.Properties, diffProperties(pair[1], "outputs", renamed,
+			old.Properties, old.Required, new.Properties, new.Required)...)
+	}
+
+	var functions [][2]string
+	diff.Functions, functions = diffTokens(from.Functions, to.Functions, nil)
+	for _, pair := range functions {
+		old, new := from.Functions[pair[0]], to.Functions[pair[1]]
+		for _, io := range []struct {
+			name     string
+			old, new *schemaObject
+		}{{"inputs", old.Inputs, new.Inputs}, {"outputs", old.Outputs, new.Outputs}} {
+			oldProps, oldRequired := io.old.properties()
+			newProps, newRequired := io.new.properties()
+			diff.Properties = append(diff.Properties,
+				diffProperties(pair[1], io.name, renamed, oldProps, oldRequired, newProps, newRequired)...)
+		}
+	}
+
+	// Most changes to Terraform schemas are in nested blocks, which are object types.
+	for _, pair := range types {
+		old, new := from.Types[pair[0]], to.Types[pair[1]]
+		diff.Properties = append(diff.Properties, diffProperties(pair[1], "properties", renamed,
+			old.Properties, old.Required, new.Properties, new.Required)...)
+	}
+
+	return diff, nil
+}
+
+// diffTokens finds the tokens that were added, removed or renamed, and returns the
+// pairs of old and new tokens that are in both schemas, possibly under a new name.
+//
+// A token is renamed if the new token has the old one as an alias, or if it is the only
+// removed token with the same name as the only added token with that name, as when a
+// resource moves between modules.
+func diffTokens[T any](from, to map[string]T, aliases map[string][]string) (TokenChanges, [][2]string) {
+	var changes TokenChanges
+	var pairs [][2]string
+	removed := map[string]bool{}
+	for token := range from {
+		if _, ok := to[token]; ok {
+			pairs = append(pairs, [2]string{token, token})
+		} else {
+			removed[token] = true
+		}
+	}
+	var added []string
+	for token := range to {
+		if _, ok := from[token]; !ok {
+			added = append(added, token)
+		}
+	}
+	sort.Strings(added)
+
+	rename := func(old, new string) {
+		changes.Renamed = append(changes.Renamed, TokenRename{From: old, To: new})
+		pairs = append(pairs, [2]string{old, new})
+		delete(removed, old)
+	}
+
+	// Renames recorded as aliases are certain, so match those first.
+	var unmatched []string
+	for _, token := range added {
+		if i := slices.IndexFunc(aliases[token], func(a string) bool { return removed[a] }); i >= 0 {
+			rename(aliases[token][i], token)
+		} else {
+			unmatched = append(unmatched, token)
+		}
+	}
+
+	byName := func(tokens []string) map[string][]string {
+		m := map[string][]string{}
+		for _, token := range tokens {
+			name := token[strings.LastIndex(token, ":")+1:]
+			m[name] = append(m[name], token)
+		}
+		return m
+	}
+	removedByName := byName(sortedKeys(removed))
+	addedByName := byName(unmatched)
+	for _, token := range unmatched {
+		name := token[strings.LastIndex(token, ":")+1:]
+		if old := removedByName[name]; len(old) == 1 && len(addedByName[name]) == 1 {
+			rename(old[0], token)
+		} else {
+			changes.Added = append(changes.Added, token)
+		}
+	}
+	changes.Removed = sortedKeys(removed)
+
+	sort.Slice(changes.Renamed, func(i, j int) bool { return changes.Renamed[i].To < changes.Renamed[j].To })
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][1] < pairs[j][1] })
+	return changes, pairs
+}
+
+// diffProperties compares the properties of token named kind, such as "inputs". The
+// types that old properties refer to are renamed as in renamed before comparing them.
+func diffProperties(
+	token, kind string, renamed map[string]string,
+	oldProps map[string]schemaProperty, oldRequired []string,
+	newProps map[string]schemaProperty, newRequired []string,
+) []PropertyChange {
+	var changes []PropertyChange
+	change := func(name, c string) *PropertyChange {
+		changes = append(changes, PropertyChange{Token: token, Property: kind + "." + name, Change: c})
+		return &changes[len(changes)-1]
+	}
+	for _, name := range sortedKeys(oldProps) {
+		if _, ok := newProps[name]; !ok {
+			change(name, "removed")
+		}
+	}
+	for _, name := range sortedKeys(newProps) {
+		newProp := newProps[name]
+		oldProp, ok := oldProps[name]
+		if !ok {
+			change(name, "added").Required = slices.Contains(newRequired, name)
+			continue
+		}
+		if to := newProp.String(); oldProp.render(renamed) != to {
+			c := change(name, "type")
+			c.From, c.To = oldProp.String(), to
+		}
+		switch wasRequired, isRequired := slices.Contains(oldRequired, name), slices.Contains(newRequired, name); {
+		case isRequired && !wasRequired:
+			change(name, "required")
+		case wasRequired && !isRequired:
+			change(name, "optional")
+		}
+	}
+	return changes
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
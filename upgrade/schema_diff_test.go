@@ -0,0 +1,352 @@
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/upgrade-provider/step/v2"
+)
+
+func TestCompareSchemas(t *testing.T) {
+	t.Parallel()
+
+	base := `{
+  "resources": {
+    "x:index/kept:Kept": {
+      "inputProperties": {
+        "name": {"type": "string"},
+        "size": {"type": "integer"},
+        "tags": {"type": "object", "additionalProperties": {"type": "string"}},
+        "legacy": {"type": "boolean"}
+      },
+      "requiredInputs": ["name"],
+      "properties": {"arn": {"type": "string"}},
+      "required": ["arn"]
+    },
+    "x:index/oldName:OldName": {"inputProperties": {"a": {"type": "string"}}},
+    "x:compute/moved:Moved": {},
+    "x:index/gone:Gone": {}
+  },
+  "functions": {
+    "x:index/getKept:getKept": {
+      "inputs": {"properties": {"id": {"type": "string"}}, "required": ["id"]},
+      "outputs": {"properties": {"rules": {"type": "array", "items": {"$ref": "#/types/x:index/Rule:Rule"}}}}
+    },
+    "x:index/getGone:getGone": {}
+  }
+}`
+	upgraded := `{
+  "resources": {
+    "x:index/kept:Kept": {
+      "inputProperties": {
+        "name": {"type": "string"},
+        "size": {"type": "number"},
+        "tags": {"type": "object", "additionalProperties": {"type": "string"}},
+        "zone": {"type": "string"}
+      },
+      "requiredInputs": ["zone"],
+      "properties": {"arn": {"type": "string"}},
+      "required": ["arn"]
+    },
+    "x:index/newName:NewName": {
+      "inputProperties": {"a": {"type": "string"}},
+      "requiredInputs": ["a"],
+      "aliases": [{"type": "x:index/oldName:OldName"}]
+    },
+    "x:network/moved:Moved": {},
+    "x:index/fresh:Fresh": {}
+  },
+  "functions": {
+    "x:index/getKept:getKept": {
+      "inputs": {"properties": {"id": {"type": "string"}}, "required": ["id"]},
+      "outputs": {"properties": {"rules": {"type": "array", "items": {"type": "string"}}}}
+    },
+    "x:index/getFresh:getFresh": {}
+  }
+}`
+
+	diff, err := compareSchemas([]byte(base), []byte(upgraded))
+	require.NoError(t, err)
+	assert.Equal(t, &SchemaDiff{
+		Resources: TokenChanges{
+			Added:   []string{"x:index/fresh:Fresh"},
+			Removed: []string{"x:index/gone:Gone"},
+			Renamed: []TokenRename{
+				{From: "x:index/oldName:OldName", To: "x:index/newName:NewName"},
+				{From: "x:compute/moved:Moved", To: "x:network/moved:Moved"},
+			},
+		},
+		Functions: TokenChanges{
+			Added:   []string{"x:index/getFresh:getFresh"},
+			Removed: []string{"x:index/getGone:getGone"},
+		},
+		Properties: []PropertyChange{
+			{Token: "x:index/kept:Kept", Property: "inputs.legacy", Change: "removed"},
+			{Token: "x:index/kept:Kept", Property: "inputs.name", Change: "optional"},
+			{Token: "x:index/kept:Kept", Property: "inputs.size", Change: "type", From: "integer", To: "number"},
+			{Token: "x:index/kept:Kept", Property: "inputs.zone", Change: "added", Required: true},
+			{Token: "x:index/newName:NewName", Property: "inputs.a", Change: "required"},
+			{
+				Token: "x:index/getKept:getKept", Property: "outputs.rules", Change: "type",
+				From: "[]x:index/Rule:Rule", To: "[]string",
+			},
+		},
+	}, diff)
+	assert.Equal(t, 12, diff.Count())
+
+	assert.Equal(t, []SchemaChange{
+		{"`x:index/gone:Gone`", "Resource removed", true},
+		{"`x:index/oldName:OldName` → `x:index/newName:NewName`", "Resource renamed", true},
+		{"`x:compute/moved:Moved` → `x:network/moved:Moved`", "Resource renamed", true},
+	}, diff.Changes(3))
+	assert.Equal(t, 9, diff.More(3))
+	assert.Equal(t, 0, diff.More(20))
+
+	// Added resources and functions, inputs that become optional and added optional
+	// properties are not breaking.
+	var subjects []string
+	for _, c := range diff.BreakingChanges() {
+		subjects = append(subjects, c.Subject)
+	}
+	assert.Equal(t, []string{
+		"`x:index/gone:Gone`",
+		"`x:index/oldName:OldName` → `x:index/newName:NewName`",
+		"`x:compute/moved:Moved` → `x:network/moved:Moved`",
+		"`x:index/getGone:getGone`",
+		"`x:index/kept:Kept` `inputs.legacy`",
+		"`x:index/kept:Kept` `inputs.size`",
+		"`x:index/kept:Kept` `inputs.zone`",
+		"`x:index/newName:NewName` `inputs.a`",
+		"`x:index/getKept:getKept` `outputs.rules`",
+	}, subjects)
+
+	_, err = compareSchemas([]byte(base), []byte("{"))
+	assert.ErrorContains(t, err, "upgraded schema")
+
+	// A property whose type moves between modules has not changed type.
+	diff, err = compareSchemas([]byte(`{
+  "resources": {"x:index/kept:Kept": {"inputProperties": {
+    "rule": {"$ref": "#/types/x:index%2FRule:Rule"},
+    "rules": {"type": "array", "items": {"$ref": "#/types/x:index%2FRule:Rule"}}
+  }}},
+  "types": {"x:index/Rule:Rule": {}}
+}`), []byte(`{
+  "resources": {"x:index/kept:Kept": {"inputProperties": {
+    "rule": {"$ref": "#/types/x:network%2FRule:Rule"},
+    "rules": {"type": "array", "items": {"$ref": "#/types/x:index%2FOther:Other"}}
+  }}},
+  "types": {"x:network/Rule:Rule": {}, "x:index/Other:Other": {}}
+}`))
+	require.NoError(t, err)
+	assert.Equal(t, []PropertyChange{{
+		Token: "x:index/kept:Kept", Property: "inputs.rules", Change: "type",
+		From: "[]x:index/Rule:Rule", To: "[]x:index/Other:Other",
+	}}, diff.Properties)
+
+	// The properties of object types, such as Terraform's nested blocks, are compared
+	// like inputs.
+	diff, err = compareSchemas([]byte(`{
+  "types": {
+    "x:s3/BucketRule:BucketRule": {
+      "type": "object",
+      "properties": {
+        "days": {"type": "integer"},
+        "legacy": {"type": "boolean"},
+        "prefix": {"type": "string"}
+      },
+      "required": ["prefix"]
+    },
+    "x:s3/Mode:Mode": {"type": "string", "enum": [{"value": "a"}]}
+  }
+}`), []byte(`{
+  "types": {
+    "x:s3/BucketRule:BucketRule": {
+      "type": "object",
+      "properties": {
+        "days": {"type": "number"},
+        "id": {"type": "string"},
+        "prefix": {"type": "string"}
+      },
+      "required": ["id", "prefix"]
+    },
+    "x:s3/Mode:Mode": {"type": "string", "enum": [{"value": "a"}, {"value": "b"}]}
+  }
+}`))
+	require.NoError(t, err)
+	assert.Equal(t, []PropertyChange{
+		{Token: "x:s3/BucketRule:BucketRule", Property: "properties.legacy", Change: "removed"},
+		{
+			Token: "x:s3/BucketRule:BucketRule", Property: "properties.days", Change: "type",
+			From: "integer", To: "number",
+		},
+		{Token: "x:s3/BucketRule:BucketRule", Property: "properties.id", Change: "added", Required: true},
+	}, diff.Properties)
+	assert.Len(t, diff.BreakingChanges(), 3)
+}
+
+func TestTruncateSchemaDiff(t *testing.T) {
+	t.Parallel()
+
+	diff := &SchemaDiff{
+		Resources: TokenChanges{
+			Added:   []string{"x:index/a:A", "x:index/b:B", "x:index/c:C"},
+			Removed: []string{"x:index/d:D"},
+		},
+		Properties: []PropertyChange{
+			{Token: "x:index/e:E", Property: "inputs.a", Change: "added"},
+			{Token: "x:index/e:E", Property: "inputs.b", Change: "removed"},
+			{Token: "x:index/e:E", Property: "inputs.c", Change: "optional"},
+			{Token: "x:index/e:E", Property: "inputs.d", Change: "required"},
+		},
+	}
+
+	truncated := diff.truncate(2)
+	// Breaking property changes are kept over others, in their original order.
+	assert.Equal(t, &SchemaDiff{
+		Resources: TokenChanges{
+			Added:   []string{"x:index/a:A", "x:index/b:B"},
+			Removed: []string{"x:index/d:D"},
+		},
+		Properties: []PropertyChange{
+			{Token: "x:index/e:E", Property: "inputs.b", Change: "removed"},
+			{Token: "x:index/e:E", Property: "inputs.d", Change: "required"},
+		},
+		Omitted: 3,
+	}, truncated)
+	assert.Equal(t, diff.Count(), truncated.Count())
+	assert.Equal(t, diff.More(100), truncated.More(100))
+	assert.Equal(t, diff, diff.truncate(4))
+}
+
+func TestWriteSchemaDiff(t *testing.T) {
+	t.Parallel()
+
+	out := filepath.Join(t.TempDir(), "schema-diff.json")
+	diff := &SchemaDiff{Resources: TokenChanges{Added: []string{"x:index/fresh:Fresh"}}}
+
+	require.NoError(t, writeSchemaDiff(&Context{}, diff))
+	require.NoError(t, writeSchemaDiff(&Context{SchemaDiffOut: out}, nil))
+	_, err := os.Stat(out)
+	assert.True(t, os.IsNotExist(err), "no file should be written without a diff")
+
+	require.NoError(t, writeSchemaDiff(&Context{SchemaDiffOut: out}, diff))
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	var got SchemaDiff
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, *diff, got)
+}
+
+func TestDiffSchema(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	repo := ProviderRepo{Name: "pulumi-x", root: root, defaultBranch: "main"}
+	file := filepath.Join(root, "provider", "cmd", "pulumi-resource-x", "schema.json")
+	runGitTestCommand(t, root, "init", "--initial-branch=main")
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o700))
+	require.NoError(t, os.WriteFile(file, []byte(`{"resources": {"x:index/a:A": {}}}`), 0o600))
+	runGitTestCommand(t, root, "add", ".")
+	runGitTestCommand(t, root, "-c", "user.name=Test", "-c", "user.email=test@example.com",
+		"commit", "--quiet", "-m", "initial")
+	require.NoError(t, os.WriteFile(file, []byte(`{"resources": {"x:index/b:B": {}}}`), 0o600))
+
+	c := &Context{SchemaDiffOut: filepath.Join(t.TempDir(), "schema-diff.json")}
+	var diff *SchemaDiff
+	err := step.Pipeline(t.Name(), func(ctx context.Context) {
+		diff = diffSchema(c.Wrap(ctx), repo)
+	})
+	require.NoError(t, err)
+	expected := &SchemaDiff{Resources: TokenChanges{
+		Added:   []string{"x:index/b:B"},
+		Removed: []string{"x:index/a:A"},
+	}}
+	assert.Equal(t, expected, diff)
+	data, err := os.ReadFile(c.SchemaDiffOut)
+	require.NoError(t, err)
+	var written SchemaDiff
+	require.NoError(t, json.Unmarshal(data, &written))
+	assert.Equal(t, *expected, written)
+
+	// A provider without a schema on its default branch is not compared.
+	repo.Name = "pulumi-y"
+	err = step.Pipeline(t.Name(), func(ctx context.Context) {
+		diff = diffSchema(c.Wrap(ctx), repo)
+	})
+	require.NoError(t, err)
+	assert.Nil(t, diff)
+}
+
+func TestCheckBreakingChanges(t *testing.T) {
+	t.Parallel()
+
+	breaking := &SchemaDiff{
+		Resources: TokenChanges{Removed: []string{"x:index/gone:Gone"}},
+		Properties: []PropertyChange{
+			{Token: "x:index/kept:Kept", Property: "inputs.size", Change: "type", From: "integer", To: "number"},
+			{Token: "x:index/kept:Kept", Property: "outputs.arn", Change: "required"},
+		},
+	}
+	check := func(c Context, diff *SchemaDiff) error {
+		return step.Pipeline(t.Name(), func(ctx context.Context) {
+			checkBreakingChanges(c.Wrap(ctx), diff)
+		})
+	}
+
+	assert.NoError(t, check(Context{BlockBreakingChanges: true}, nil))
+	assert.NoError(t, check(Context{BlockBreakingChanges: true}, &SchemaDiff{
+		Properties: breaking.Properties[1:],
+	}))
+	assert.NoError(t, check(Context{}, breaking))
+	assert.NoError(t, check(Context{BlockBreakingChanges: true, MajorVersionBump: true}, breaking))
+
+	err := check(Context{BlockBreakingChanges: true}, breaking)
+	assert.ErrorContains(t, err, `the schema has 2 breaking changes, but the provider's major version is not bumped (pass --major to bump it):
+  - x:index/gone:Gone: Resource removed
+  - x:index/kept:Kept inputs.size: Type changed from integer to number`)
+}
+
+func TestProposedPRLabelFromSchema(t *testing.T) {
+	t.Parallel()
+
+	target := &UpstreamUpgradeTarget{
+		Version:  semver.MustParse("2.0.0"),
+		GHIssues: []UpgradeTargetIssue{{Number: 1}},
+	}
+	repo := ProviderRepo{currentUpstreamVersion: semver.MustParse("1.4.0")}
+	c := &Context{UpgradeProviderVersion: true}
+	assert.Equal(t, "needs-release/major", proposedPRLabel(c, repo, target))
+
+	// A major upstream release that changes nothing breaking for users is a minor release.
+	repo.schemaDiff = &SchemaDiff{Resources: TokenChanges{Added: []string{"x:index/fresh:Fresh"}}}
+	assert.Equal(t, "needs-release/minor", proposedPRLabel(c, repo, target))
+	assert.Equal(t, "needs-release/major", proposedPRLabel(&Context{
+		UpgradeProviderVersion: true,
+		MajorVersionBump:       true,
+	}, repo, target))
+
+	// Breaking changes only make a major release when the major version is bumped.
+	// Otherwise they make a minor release, whatever the upstream's version.
+	target.Version = semver.MustParse("1.5.0")
+	repo.schemaDiff = &SchemaDiff{Resources: TokenChanges{Removed: []string{"x:index/gone:Gone"}}}
+	assert.Equal(t, "needs-release/minor", proposedPRLabel(c, repo, target))
+	assert.Equal(t, "needs-release/major", proposedPRLabel(&Context{
+		UpgradeProviderVersion: true,
+		MajorVersionBump:       true,
+	}, repo, target))
+
+	target.Version = semver.MustParse("2.0.0")
+	assert.Equal(t, "needs-release/minor", proposedPRLabel(c, repo, target))
+
+	// A maintenance patch with breaking changes is not a patch.
+	assert.Equal(t, "needs-release/minor", proposedPRLabel(&Context{MaintenancePatch: true}, repo, nil))
+	repo.schemaDiff = &SchemaDiff{}
+	assert.Equal(t, "needs-release/patch", proposedPRLabel(&Context{MaintenancePatch: true}, repo, nil))
+}
@@ -4,10 +4,9 @@ package upgrade
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io/fs"
-	"os"
+	"net/url"
 	"path"
 	"path/filepath"
 	"reflect"
@@ -25,7 +24,7 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
 
 	"github.com/pulumi/upgrade-provider/colorize"
-	"github.com/pulumi/upgrade-provider/step"
+	"github.com/pulumi/upgrade-provider/step/redact"
 	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
 )
 
@@ -83,16 +82,16 @@ var ensureUpstreamRepo = stepv2.Func11("Ensure Upstream Repo", func(ctx context.
 	return expectedLocation
 })
 
-func UpgradeProviderVersion(
-	ctx context.Context, goMod *GoMod, target *semver.Version,
-	repo ProviderRepo, targetSHA string,
-) step.Step {
-	steps := []step.Step{}
+// UpgradeProviderVersion updates the provider's reference to the upstream provider to
+// target.
+var UpgradeProviderVersion = stepv2.Func30("Update TF Provider", func(
+	ctx context.Context, goMod *GoMod, target *semver.Version, repo ProviderRepo,
+) {
 	if goMod.Kind.IsPatched() {
 		// Patched providers use a submodule and a local patch stack. Refuse to
 		// modify interrupted workflows; recovery is deliberately manual.
 		targetRef := "refs/tags/v" + target.String()
-		steps = append(steps, patchedProviderUpgradeStep(repo, targetRef))
+		patchedProviderUpgrade(ctx, repo, targetRef)
 	}
 
 	// We have an upstream we don't control, so we need to get it's SHA. We do this
@@ -100,26 +99,7 @@ func UpgradeProviderVersion(
 	// versioning their go modules correctly.
 	//
 	// If they are versioning correctly, `go mod tidy` will resolve the SHA to a tag.
-	steps = append(steps,
-		step.F("Lookup Tag SHA", func(context.Context) (string, error) {
-			upstreamOrg := GetContext(ctx).UpstreamProviderOrg
-			upstreamRepo := GetContext(ctx).UpstreamProviderName
-			gitHostPath := "https://github.com/" + upstreamOrg + "/" + upstreamRepo
-
-			// special case: we need to use the GitLab url for getting git refs.
-			if upstreamOrg == "terraform-provider-gitlab" {
-				gitHostPath = "https://gitlab.com/gitlab-org/terraform-provider-gitlab"
-			}
-
-			refs, err := gitRefsOf(ctx, gitHostPath, "tags")
-			if err != nil {
-				return "", err
-			}
-			if ref, ok := refs.shaOf("refs/tags/v" + target.String()); ok {
-				return ref, nil
-			}
-			return "", fmt.Errorf("could not find SHA for tag '%s'", target.Original())
-		}).AssignTo(&targetSHA))
+	targetSHA := lookupTagSHA(ctx, target)
 
 	// goModDir is the directory of the go.mod where we reference the upstream provider.
 	goModDir := *repo.providerDir()
@@ -134,34 +114,54 @@ func UpgradeProviderVersion(
 	// this correct can break on major version updates. We just leave it if its not
 	// necessary to touch.
 	if !goMod.Kind.IsPatched() {
-		steps = append(steps, step.Computed(func() step.Step {
-			targetV := "v" + target.String()
-			if targetSHA != "" {
-				targetV = targetSHA
-			}
+		targetV := "v" + target.String()
+		if targetSHA != "" {
+			targetV = targetSHA
+		}
 
-			upstreamPath := goMod.Upstream.Path
-			// We do this only when we already have a version suffix, since
-			// that confirms that we have a correctly versioned provider.
-			if prefix, major, ok := module.SplitPathVersion(upstreamPath); ok && major != "" {
-				// If we have a version suffix, and we are doing a major
-				// version bump, we need to apply the new suffix.
-				upstreamPath = fmt.Sprintf("%s/v%d",
-					prefix, target.Major())
-			}
+		upstreamPath := goMod.Upstream.Path
+		// We do this only when we already have a version suffix, since
+		// that confirms that we have a correctly versioned provider.
+		if prefix, major, ok := module.SplitPathVersion(upstreamPath); ok && major != "" {
+			// If we have a version suffix, and we are doing a major
+			// version bump, we need to apply the new suffix.
+			upstreamPath = fmt.Sprintf("%s/v%d",
+				prefix, target.Major())
+		}
 
-			return step.Cmd("go", "get", upstreamPath+"@"+targetV)
-		}).In(&goModDir))
+		stepv2.WithCwd(ctx, goModDir, func(ctx context.Context) {
+			stepv2.Cmd(ctx, "go", "get", upstreamPath+"@"+targetV)
+		})
 	}
 
 	if goMod.Kind.IsShimmed() {
 		// When shimmed, we also run `go mod tidy` in the shim directory, and we want to
 		// run that before running `go mod tidy` in the main `provider` directory.
-		steps = append(steps, step.Cmd("go", "mod", "tidy").In(&goModDir))
+		stepv2.WithCwd(ctx, goModDir, func(ctx context.Context) {
+			stepv2.Cmd(ctx, "go", "mod", "tidy")
+		})
 	}
+})
 
-	return step.Combined("Update TF Provider", steps...)
-}
+var lookupTagSHA = stepv2.Func11E("Lookup Tag SHA", func(
+	ctx context.Context, target *semver.Version,
+) (string, error) {
+	upstreamOrg := GetContext(ctx).UpstreamProviderOrg
+	upstreamRepo := GetContext(ctx).UpstreamProviderName
+	gitHostPath := "https://" + GetContext(ctx).upstreamHost() + "/" + upstreamOrg + "/" + upstreamRepo
+
+	// special case: we need to use the GitLab url for getting git refs.
+	if upstreamOrg == "terraform-provider-gitlab" {
+		gitHostPath = "https://gitlab.com/gitlab-org/terraform-provider-gitlab"
+	}
+
+	refs := gitRefsOfV2(ctx, gitHostPath, "tags")
+	if ref, ok := refs.shaOf("refs/tags/v" + target.String()); ok {
+		stepv2.SetLabel(ctx, ref)
+		return ref, nil
+	}
+	return "", fmt.Errorf("could not find SHA for tag '%s'", target.Original())
+})
 
 var maintenanceRelease = stepv2.Func11E("Check if we should release a maintenance patch", func(
 	ctx context.Context,
@@ -172,30 +172,23 @@ var maintenanceRelease = stepv2.Func11E("Check if we should release a maintenanc
 	// There are 24 * 7 * 8 = 1344 hours in 8 weeks.
 	releaseCadence := time.Hour * 24 * 7 * 8
 
-	relInfo, err := latestReleaseInfo(ctx, repoWithOrg)
-	if err != nil {
-		return false, err
-	}
+	latest := latestRelease(ctx, repoWithOrg)
 
-	// If relInfo.Latest is nil, that means that no releases were detected.
+	// If latest is nil, that means that no releases were detected.
 	//
 	// We don't suggest a release, since we assume that the author wants to control
 	// the first release by hand.
-	if relInfo.Latest == nil {
+	if latest == nil {
 		return false, nil
 	}
 
-	releaseDate, err := time.Parse(time.RFC3339, relInfo.Latest.PublishedAt)
-	if err != nil {
-		return false, err
-	}
-
-	stepv2.SetLabelf(ctx, "Last provider release date: %s", relInfo.Latest.PublishedAt)
-	ago := time.Since(releaseDate).Abs()
+	releaseDate := latest.PublishedAt.Format(time.RFC3339)
+	stepv2.SetLabelf(ctx, "Last provider release date: %s", releaseDate)
+	ago := time.Since(latest.PublishedAt).Abs()
 
 	if ago > releaseCadence {
 		stepv2.SetLabelf(
-			ctx, "Last provider release date: %s. Marking for patch release.", relInfo.Latest.PublishedAt,
+			ctx, "Last provider release date: %s. Marking for patch release.", releaseDate,
 		)
 		return true, nil
 	}
@@ -208,7 +201,7 @@ var maintenanceRelease = stepv2.Func11E("Check if we should release a maintenanc
 func submissionSkippedMessage(branch string) string {
 	return fmt.Sprintf(
 		"Submission skipped: upgrade completed locally; branch %q and its commits are ready for review. "+
-			"Use git push and gh to create or update the PR",
+			"Push it and create or update the PR on GitHub",
 		branch,
 	)
 }
@@ -216,20 +209,29 @@ func submissionSkippedMessage(branch string) string {
 // githubSubmissionPlan is the single source of truth for both the GitHub
 // mutations performed by a normal run and the preview printed by --no-submit.
 type githubSubmissionPlan struct {
-	// Repository is the owner/name passed to GitHub CLI commands.
+	// Repository is the owner/name of the repository the PR is opened in.
 	Repository string
+	// PushRepository is the owner/name of the repository WorkingBranch is pushed
+	// to. It differs from Repository when the PR is opened from a fork.
+	PushRepository string
+	// PushRemote is the git remote or URL that WorkingBranch is pushed to.
+	PushRemote string
 	// BaseBranch and WorkingBranch identify the two sides of the proposed PR.
 	BaseBranch    string
 	WorkingBranch string
+	// Head identifies WorkingBranch as the head of the PR: "owner:branch" when it
+	// is in a fork.
+	Head string
 	// ExistingPR selects between creating a PR and updating the PR already open
 	// for WorkingBranch.
 	ExistingPR bool
-	// Title and Body are passed verbatim to GitHub and printed verbatim in the
-	// no-submit report.
+	// Title is passed verbatim to GitHub. Body is the generated region of the PR
+	// body, which GitHub gets wrapped in markers: see generatedPRBody. Both are
+	// printed as GitHub gets them in the no-submit report.
 	Title string
 	Body  string
 	// Label, Reviewers, and Assignee are optional PR metadata. Empty values are
-	// omitted from GitHub CLI commands.
+	// omitted from the GitHub requests.
 	Label     string
 	Reviewers string
 	Assignee  string
@@ -249,28 +251,40 @@ type githubSubmissionPlan struct {
 func newGitHubSubmissionPlan(
 	ctx context.Context, target *UpstreamUpgradeTarget, repo ProviderRepo,
 	goMod *GoMod, targetBridgeVersion Ref, tfSDKUpgrade string, osArgs []string,
-) githubSubmissionPlan {
+) (githubSubmissionPlan, error) {
 	c := GetContext(ctx)
 
-	// ProviderRepo stores the owner separately in normal runs, while some replay
-	// fixtures already contain an owner-qualified Name.
-	repository := repo.Name
-	if repo.Org != "" {
-		repository = repo.Org + "/" + repo.Name
+	data := newTemplateData(ctx, repo, target, goMod, targetBridgeVersion, tfSDKUpgrade, osArgs)
+	body, err := prBody(ctx, data)
+	if err != nil {
+		return githubSubmissionPlan{}, err
+	}
+
+	repository := githubRepository(repo)
+	pushRepo := pushRepository(c, repo)
+	pushRemote := "origin"
+	if pushRepo != repository {
+		if repo.forkRemote == "" {
+			return githubSubmissionPlan{}, fmt.Errorf("the URL of fork %s is unknown", pushRepo)
+		}
+		pushRemote = repo.forkRemote
 	}
 
 	plan := githubSubmissionPlan{
 		Repository:               repository,
+		PushRepository:           pushRepo,
+		PushRemote:               pushRemote,
 		BaseBranch:               repo.defaultBranch,
 		WorkingBranch:            repo.workingBranch,
+		Head:                     prHead(repository, pushRepo, repo.workingBranch),
 		ExistingPR:               repo.prAlreadyExists,
 		Title:                    repo.prTitle,
-		Body:                     prBody(ctx, repo, target, goMod, targetBridgeVersion, tfSDKUpgrade, osArgs),
+		Body:                     body,
 		Label:                    proposedPRLabel(c, repo, target),
 		Reviewers:                c.PrReviewers,
 		Assignee:                 c.PrAssign,
 		CloseSupersededBridgePRs: c.UpgradeBridgeVersion,
-		Targets:                  proposedUpgradeTargets(c, repo, target, goMod, targetBridgeVersion, tfSDKUpgrade),
+		Targets:                  data.Targets,
 	}
 
 	// Issue assignment is a post-PR side effect and only applies when both an
@@ -283,22 +297,118 @@ func newGitHubSubmissionPlan(
 		}
 	}
 
-	return plan
+	return plan, nil
+}
+
+// githubRepository returns the owner/name of repo on GitHub.
+func githubRepository(repo ProviderRepo) string {
+	// ProviderRepo stores the owner separately in normal runs, while some replay
+	// fixtures already contain an owner-qualified Name.
+	if repo.Org == "" {
+		return repo.Name
+	}
+	return repo.Org + "/" + repo.Name
+}
+
+// pushRepository returns the owner/name of the repository that the working branch of
+// repo is pushed to: the fork given by --push-remote, or repo itself.
+func pushRepository(c *Context, repo ProviderRepo) string {
+	if c.PushRemote == "" {
+		return githubRepository(repo)
+	}
+	owner, name, ok := strings.Cut(c.PushRemote, "/")
+	if !ok {
+		name = repo.Name
+	}
+	return owner + "/" + name
+}
+
+// forkRemote returns the URL to push to fork with. It is the URL of the remote origin,
+// which is repo, with repo replaced by fork, so the push uses the same scheme and
+// credentials as origin.
+var forkRemote = stepv2.Func21E("Fork Remote", func(ctx context.Context, repo, fork string) (string, error) {
+	// Unlike `git remote get-url`, this gives the URL before url.<base>.insteadOf
+	// rewrites it, which git push applies again.
+	origin := strings.TrimSpace(stepv2.Cmd(ctx, "git", "config", "--get", "remote.origin.url"))
+	remote, err := forkRemoteURL(origin, repo, fork)
+	if err != nil {
+		return "", err
+	}
+	if u, err := url.Parse(remote); err == nil {
+		// The URL is passed to git push, so its credentials must not be logged.
+		if password, ok := u.User.Password(); ok {
+			redact.FromContext(ctx).AddSecret(password)
+		}
+	}
+	stepv2.SetLabel(ctx, fork)
+	return remote, nil
+})
+
+// forkRemoteURL replaces repo with fork in origin, a git remote URL for repo.
+//
+// origin may be a URL, such as "https://github.com/pulumi/pulumi-xyz.git", or use scp-like
+// syntax, such as "git@github.com:pulumi/pulumi-xyz.git".
+func forkRemoteURL(origin, repo, fork string) (string, error) {
+	replace := func(p string) (string, bool) {
+		name := strings.TrimSuffix(p, ".git")
+		if !strings.EqualFold(strings.Trim(name, "/"), repo) {
+			return "", false
+		}
+		return "/" + fork + p[len(name):], true
+	}
+	if !strings.Contains(origin, "://") {
+		if host, p, ok := strings.Cut(origin, ":"); ok && !strings.Contains(host, "/") {
+			if p, ok := replace(p); ok {
+				return host + ":" + strings.TrimPrefix(p, "/"), nil
+			}
+		}
+	} else if u, err := url.Parse(origin); err == nil {
+		if p, ok := replace(u.Path); ok {
+			u.Path = p
+			return u.String(), nil
+		}
+	}
+	// origin is not printed, since it may hold credentials.
+	return "", fmt.Errorf("cannot push to fork %s: the remote origin is not a URL for %s", fork, repo)
+}
+
+// prHead returns how a PR in repo refers to branch in pushRepo.
+func prHead(repo, pushRepo, branch string) string {
+	if pushRepo == repo {
+		return branch
+	}
+	owner, _, _ := strings.Cut(pushRepo, "/")
+	return owner + ":" + branch
 }
 
 // proposedPRLabel reproduces the release-label policy used for submitted PRs.
 func proposedPRLabel(c *Context, repo ProviderRepo, target *UpstreamUpgradeTarget) string {
+	var label string
 	switch {
 	// Provider upgrades use the semantic version delta to choose the release label.
 	case c.UpgradeProviderVersion && target != nil && len(target.GHIssues) > 0:
-		return upgradeLabel(repo.currentUpstreamVersion, target.Version)
+		label = upgradeLabel(repo.currentUpstreamVersion, target.Version)
 	// Bridge-only maintenance upgrades request a patch release when the release
 	// cadence check marked one as necessary.
 	case c.MaintenancePatch && !c.UpgradeProviderVersion:
-		return "needs-release/patch"
-	default:
-		return ""
+		label = "needs-release/patch"
+	}
+
+	// When the schema was compared, its changes say more about what the upgrade means
+	// for users than the upstream's version does. Only a bump of the provider's major
+	// version can be released as a major release. Without one, breaking changes ask for a
+	// minor release, and checkBreakingChanges warns about them or fails the upgrade.
+	diff := repo.schemaDiff
+	switch {
+	case diff == nil:
+	case c.MajorVersionBump:
+		if len(diff.BreakingChanges()) > 0 {
+			return "needs-release/major"
+		}
+	case label == "needs-release/major" || len(diff.BreakingChanges()) > 0:
+		return "needs-release/minor"
 	}
+	return label
 }
 
 // proposedUpgradeTargets builds human-readable dependency transitions for the
@@ -331,47 +441,79 @@ func proposedUpgradeTargets(
 	return targets
 }
 
-// createPRArgs translates a submission plan into gh pr create arguments.
-func createPRArgs(plan githubSubmissionPlan) []string {
-	args := []string{
-		"pr", "create",
-		"--base", plan.BaseBranch,
-		"--head", plan.WorkingBranch,
-	}
-	if plan.Reviewers != "" {
-		args = append(args, "--reviewer", plan.Reviewers)
-	}
-	args = append(args,
-		"--title", plan.Title,
-		"--body", plan.Body,
-	)
-	if plan.Assignee != "" {
-		args = append(args, "--assignee", plan.Assignee)
+// Hidden HTML comments delimit the part of a PR body that upgrade-provider generated, so
+// that rerunning an upgrade can refresh it without touching text that was added around it.
+const (
+	prBodyStartMarker = "<!-- upgrade-provider:start -->"
+	prBodyEndMarker   = "<!-- upgrade-provider:end -->"
+)
+
+// generatedPRBody wraps body in the markers of the generated region.
+func generatedPRBody(body string) string {
+	if body == "" {
+		return ""
 	}
-	if plan.Label != "" {
-		args = append(args, "--label", plan.Label)
+	if !strings.HasSuffix(body, "\n") {
+		body += "\n"
 	}
-	return args
+	return prBodyStartMarker + "\n" + body + prBodyEndMarker
 }
 
-// editPRArgs translates a submission plan into gh pr edit arguments. Existing
-// PRs receive the same configured metadata as newly created PRs.
-func editPRArgs(plan githubSubmissionPlan) []string {
-	args := []string{
-		"pr", "edit", plan.WorkingBranch,
-		"--title", plan.Title,
-		"--body", plan.Body,
-	}
-	if plan.Reviewers != "" {
-		args = append(args, "--add-reviewer", plan.Reviewers)
+// updatePRBody replaces the generated region of existing with body, keeping everything
+// outside of the region.
+//
+// PRs opened before the markers were introduced have no region. Their whole body was
+// generated, so it is replaced. If the end marker has been removed, the region extends
+// to the end of the body.
+func updatePRBody(existing, body string) string {
+	generated := generatedPRBody(body)
+	start := strings.Index(existing, prBodyStartMarker)
+	if start == -1 || generated == "" {
+		return generated
+	}
+	rest := existing[start+len(prBodyStartMarker):]
+	end := strings.Index(rest, prBodyEndMarker)
+	if end == -1 {
+		return existing[:start] + generated
+	}
+	return existing[:start] + generated + rest[end+len(prBodyEndMarker):]
+}
+
+// newPullRequest translates a submission plan into a new PR.
+func newPullRequest(plan githubSubmissionPlan) NewPullRequest {
+	return NewPullRequest{
+		Base:      plan.BaseBranch,
+		Head:      plan.Head,
+		Title:     plan.Title,
+		Body:      generatedPRBody(plan.Body),
+		Reviewers: splitList(plan.Reviewers),
+		Assignees: splitList(plan.Assignee),
+		Labels:    splitList(plan.Label),
 	}
-	if plan.Assignee != "" {
-		args = append(args, "--add-assignee", plan.Assignee)
+}
+
+// pullRequestEdit translates a submission plan into an update of an existing PR, whose
+// body is currently existingBody. Existing PRs receive the same configured metadata as
+// newly created PRs.
+func pullRequestEdit(plan githubSubmissionPlan, existingBody string) PullRequestEdit {
+	return PullRequestEdit{
+		Title:        plan.Title,
+		Body:         updatePRBody(existingBody, plan.Body),
+		AddReviewers: splitList(plan.Reviewers),
+		AddAssignees: splitList(plan.Assignee),
+		AddLabels:    splitList(plan.Label),
 	}
-	if plan.Label != "" {
-		args = append(args, "--add-label", plan.Label)
+}
+
+// splitList splits a comma separated list, as given on the command line.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
 	}
-	return args
+	return list
 }
 
 var InformGitHub = stepv2.Func61E("Inform Github", func(
@@ -389,7 +531,14 @@ var InformGitHub = stepv2.Func61E("Inform Github", func(
 		return "", nil
 	}
 
-	plan := newGitHubSubmissionPlan(ctx, target, repo, goMod, targetBridgeVersion, tfSDKUpgrade, osArgs)
+	plan, err := newGitHubSubmissionPlan(ctx, target, repo, goMod, targetBridgeVersion, tfSDKUpgrade, osArgs)
+	if err != nil {
+		return "", err
+	}
+
+	if plan.PushRepository != plan.Repository {
+		ensureFork(ctx, plan.Repository, plan.PushRepository)
+	}
 
 	// --force:
 	//
@@ -397,29 +546,28 @@ var InformGitHub = stepv2.Func61E("Inform Github", func(
 	//
 	// If there is an existing branch, then we will want to override it since we don't
 	// attempt to build on existing branches.
-	stepv2.Cmd(ctx, "git", "push", "--set-upstream", "origin", plan.WorkingBranch, "--force")
+	stepv2.Cmd(ctx, "git", "push", "--set-upstream", plan.PushRemote, plan.WorkingBranch, "--force")
 
-	var newPrURL string
+	var pr PullRequest
 	if plan.ExistingPR {
 		// Update all configured PR metadata so rerunning an upgrade repairs an
-		// existing PR as well as refreshing its title and body.
-		stepv2.Cmd(ctx, "gh", editPRArgs(plan)...)
-		newPrURL = strings.TrimSpace(stepv2.Cmd(ctx, "gh",
-			"pr", "view", plan.WorkingBranch,
-			"--repo", plan.Repository,
-			"--json", "url",
-			"--jq", ".url",
-		))
+		// existing PR as well as refreshing its title and the generated part of its
+		// body.
+		existing := listPullRequests(ctx, plan.Repository, PullRequestQuery{Head: plan.Head})
+		if len(existing) == 0 {
+			return "", fmt.Errorf("no open pull request found for branch %q", plan.Head)
+		}
+		pr = editPullRequest(ctx, plan.Repository, existing[0].Number, pullRequestEdit(plan, existing[0].Body))
 	} else {
-		newPrURL = strings.TrimSpace(stepv2.Cmd(ctx, "gh", createPRArgs(plan)...))
+		pr = createPullRequest(ctx, plan.Repository, newPullRequest(plan))
 	}
+	newPrURL := pr.URL
 
 	if len(plan.IssueAssignments) > 0 {
 		stepv2.Func00("Assign Issues", func(ctx context.Context) {
 			// This PR will close issues, so assign them to the same assignee as the PR.
 			for _, issue := range plan.IssueAssignments {
-				stepv2.Cmd(ctx, "gh", "issue", "edit", fmt.Sprintf("%d", issue),
-					"--add-assignee", plan.Assignee)
+				addAssignees(ctx, plan.Repository, issue, splitList(plan.Assignee))
 			}
 		})(ctx)
 	}
@@ -427,7 +575,7 @@ var InformGitHub = stepv2.Func61E("Inform Github", func(
 	// Close superseded bridge PRs last so a failure here does not skip any
 	// earlier side effects (issue assignment, PR body update, etc.).
 	if plan.CloseSupersededBridgePRs && newPrURL != "" {
-		closeSupersededBridgePRs(ctx, plan.Repository, plan.WorkingBranch, newPrURL)
+		closeSupersededBridgePRs(ctx, plan.Repository, plan.Head, newPrURL)
 	}
 
 	return newPrURL, nil
@@ -464,71 +612,41 @@ func upgradeLabel(from, to *semver.Version) string {
 	return ""
 }
 
-func fileExists(path string) bool {
-	_, err := os.Stat(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return false
-		}
-	}
-	return true
-}
-
 // Most if not all of our TF SDK based providers use a "replace" based version of
 // github.com/hashicorp/terraform-plugin-sdk/v2. To avoid compile errors, we want
 // to be using the most up to date version of this plugin.
 //
 // This is predicated on updating to the latest version being safe. We will need to
 // revisit this when a new major version of the plugin SDK is released.
-func setTFPluginSDKReplace(ctx context.Context, repo ProviderRepo, targetSHA string) step.Step {
-	// We do discover in a step.Computed so if the fork isn't present, it isn't
-	// displayed to the user.
-	updateModReplace := func(path string) error {
-		goModFile, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("could not find go.mod: %w", err)
-		}
-		goMod, err := modfile.Parse(path, goModFile, nil)
-		if err != nil {
-			return fmt.Errorf("failed to parse go.mod: %w", err)
-		}
-
-		// Otherwise, we need to replace the old version. goMod.AddReplace
-		// will handle replacing existing `replace` directives.
-		err = goMod.AddReplace("github.com/hashicorp/terraform-plugin-sdk/v2", "",
-			"github.com/pulumi/terraform-plugin-sdk/v2", targetSHA)
-		if err != nil {
-			return fmt.Errorf("failed to update version: %w", err)
-		}
-
-		// We now write out the new file over the old file.
-		goMod.Cleanup()
-		goModFile, err = goMod.Format()
-		if err != nil {
-			return fmt.Errorf("failed to format file as bytes: %w", err)
-		}
-		err = os.WriteFile(path, goModFile, 0o600)
-		return err
+var setTFPluginSDKReplace = stepv2.Func20("Update TF Plugin SDK Fork", func(
+	ctx context.Context, repo ProviderRepo, targetSHA string,
+) {
+	updateModReplace := func(ctx context.Context, path string) {
+		updateFile(ctx, path, func(ctx context.Context, content string) string {
+			goMod, err := modfile.Parse(path, []byte(content), nil)
+			stepv2.HaltOnError(ctx, err)
+
+			// goMod.AddReplace will handle replacing existing `replace` directives.
+			err = goMod.AddReplace("github.com/hashicorp/terraform-plugin-sdk/v2", "",
+				"github.com/pulumi/terraform-plugin-sdk/v2", targetSHA)
+			stepv2.HaltOnError(ctx, err)
+
+			goMod.Cleanup()
+			updated, err := goMod.Format()
+			stepv2.HaltOnError(ctx, err)
+			return string(updated)
+		})
 	}
-	return step.F("Update TF Plugin SDK Fork", func(context.Context) (string, error) {
-		// update go.mod in the root directory of the provider.
-		err := updateModReplace("go.mod")
-		if err != nil {
-			return "", fmt.Errorf("failed to update go.mod: %w", err)
-		}
 
-		// if we have an examples directory, we also update the go.mod in there.
-		exampleGoMod := filepath.Join(repo.root, "examples", "go.mod")
-		if fileExists(filepath.Join(exampleGoMod)) {
-			err = updateModReplace(exampleGoMod)
-			if err != nil {
-				return "", fmt.Errorf("failed to update examples/go.mod: %w", err)
-			}
-		}
+	// update go.mod in the root directory of the provider.
+	updateModReplace(ctx, filepath.Join(*repo.providerDir(), "go.mod"))
 
-		return "updated", nil
-	}).In(repo.providerDir())
-}
+	// if we have an examples directory, we also update the go.mod in there.
+	exampleGoMod := filepath.Join(repo.root, "examples", "go.mod")
+	if _, ok := stepv2.Stat(ctx, exampleGoMod); ok {
+		updateModReplace(ctx, exampleGoMod)
+	}
+})
 
 var ensureBranchCheckedOut = stepv2.Func10("Ensure Branch", func(ctx context.Context, branchName string) {
 	branches := stepv2.Cmd(ctx, "git", "branch")
@@ -558,17 +676,11 @@ var ensureBranchCheckedOut = stepv2.Func10("Ensure Branch", func(ctx context.Con
 	}
 })
 
-var hasExistingPr = stepv2.Func21("Has Existing PR", func(ctx context.Context, branchName, repo string) bool {
-	prBytes := []byte(stepv2.Cmd(ctx, "gh", "pr", "list", "--json=title,headRefName", fmt.Sprintf("--repo=%s", repo)))
-	prs := []struct {
-		Title       string `json:"title"`
-		HeadRefName string `json:"headRefName"`
-	}{}
-	err := json.Unmarshal(prBytes, &prs)
-	stepv2.HaltOnError(ctx, err)
-
+// hasExistingPr reports if repo has an open PR from head, given as in PullRequestQuery.
+var hasExistingPr = stepv2.Func21("Has Existing PR", func(ctx context.Context, head, repo string) bool {
+	prs := listPullRequests(ctx, repo, PullRequestQuery{Head: head})
 	for _, pr := range prs {
-		if pr.HeadRefName == branchName {
+		if pr.hasHead(repo, head) {
 			stepv2.SetLabel(ctx, fmt.Sprintf("yes %q", pr.Title))
 			return true
 		}
@@ -578,49 +690,75 @@ var hasExistingPr = stepv2.Func21("Has Existing PR", func(ctx context.Context, b
 	return false
 })
 
-var getWorkingBranch = stepv2.Func41E("Working Branch Name", func(ctx context.Context, c Context,
-	targetBridgeVersion Ref, upgradeTarget *UpstreamUpgradeTarget,
-	prTitlePrefix string,
-) (string, error) {
-	ret := func(format string, a ...any) (string, error) {
-		s := fmt.Sprintf(format, a...)
+// ensureFork ensures that fork exists and is a fork of repo, creating it if it does not
+// exist.
+var ensureFork = stepv2.Func20E("Ensure Fork", func(ctx context.Context, repo, fork string) error {
+	stepv2.SetLabel(ctx, fork)
+	r := getRepository(ctx, fork)
+	if r == nil {
+		createFork(ctx, repo, fork)
+		r = waitForFork(ctx, repo, fork)
+	}
+	if !r.Fork || !strings.EqualFold(r.Parent, repo) {
+		return fmt.Errorf("cannot open a pull request from %s: it is not a fork of %s", fork, repo)
+	}
+	return nil
+})
 
-		if prTitlePrefix != "" {
-			prTitlePrefix = strings.ToLower(prTitlePrefix)
-			prTitlePrefix = regexp.MustCompile(`[^a-zA-Z0-9]`).ReplaceAllString(prTitlePrefix, "")
-			s += "-" + prTitlePrefix
+// waitForFork waits for fork of repo to appear, since GitHub creates forks
+// asynchronously, and returns it.
+//
+// The wait is its own impure step, so that replays don't wait.
+var waitForFork = stepv2.Func21E("Wait For Fork", func(
+	ctx context.Context, repo, fork string,
+) (*Repository, error) {
+	stepv2.MarkImpure(ctx)
+	policy := networkRetryPolicy(ctx)
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(policy.Backoff(attempt)):
 		}
-
-		if stepv2.GetEnv(ctx, "CI") == "true" {
-			s += "-ci"
+		r, err := getGitHub(ctx).GetRepository(ctx, fork)
+		if err != nil || r != nil {
+			return r, err
+		}
+		if attempt >= policy.Attempts {
+			return nil, fmt.Errorf("fork %s of %s was not ready in time", fork, repo)
 		}
-
-		stepv2.SetLabel(ctx, s)
-		return s, nil
 	}
+})
 
-	switch {
-	case c.MajorVersionBump:
-		return ret("upgrade-%s-to-v%s-major", c.UpstreamProviderName, upgradeTarget.Version)
-	case c.UpgradeProviderVersion:
-		return ret("upgrade-%s-to-v%s", c.UpstreamProviderName, upgradeTarget.Version)
-	case c.UpgradeBridgeVersion:
-		contract.Assertf(targetBridgeVersion != nil,
-			"We are upgrading the bridge, so we must have a target version")
-		return ret("upgrade-pulumi-terraform-bridge-to-%s", targetBridgeVersion)
-	case c.TargetPulumiVersion != nil:
-		return ret("upgrade-pulumi-version-to-%s", c.TargetPulumiVersion)
-	default:
+// getWorkingBranch names the branch that the upgrade described by data is committed to.
+var getWorkingBranch = stepv2.Func11E("Working Branch Name", func(
+	ctx context.Context, data TemplateData,
+) (string, error) {
+	if !data.hasAction() {
 		return "", fmt.Errorf("calculating branch name: unknown action")
 	}
+	data.CI = stepv2.GetEnv(ctx, "CI") == "true"
+
+	text := GetContext(ctx).BranchTemplate
+	branch, err := executeTemplate("branch name", text, defaultBranchTemplate, data)
+	if err != nil {
+		return "", err
+	}
+	branch = strings.TrimSpace(branch)
+	if branch == "" {
+		return "", fmt.Errorf("the branch name template produced an empty name")
+	}
+
+	stepv2.SetLabel(ctx, branch)
+	return branch, nil
 })
 
 func OrgProviderRepos(ctx context.Context, org, repo string) string {
-	return ensureUpstreamRepo(ctx, path.Join("github.com", org, repo))
+	return ensureUpstreamRepo(ctx, path.Join(GetContext(ctx).providerHost(), org, repo))
 }
 
 var findDefaultBranch = stepv2.Func11E("Find default Branch", func(ctx context.Context, remote string) (string, error) {
-	lsRemoteHeads := stepv2.Cmd(ctx, "git", "ls-remote", "--heads", remote)
+	lsRemoteHeads := networkCmd(ctx, "git", "ls-remote", "--heads", remote)
 	var hasMaster bool
 	lines := strings.Split(lsRemoteHeads, "\n")
 	for _, line := range lines {
@@ -649,7 +787,7 @@ var findDefaultBranch = stepv2.Func11E("Find default Branch", func(ctx context.C
 var pullDefaultBranch = stepv2.Func11("Pull Default Branch", func(ctx context.Context, remote string) string {
 	defaultBranch := findDefaultBranch(ctx, remote)
 
-	stepv2.Cmd(ctx, "git", "fetch")
+	networkCmd(ctx, "git", "fetch")
 	stepv2.Cmd(ctx, "git", "checkout", defaultBranch)
 	stepv2.Cmd(ctx, "git", "pull", remote)
 
@@ -683,6 +821,7 @@ var majorVersionBump = stepv2.Func30("Increment Major Version", func(
 	updateFile := buildReplaceInFile(prev, next)
 
 	name := filepath.Base(repo.root)
+	modPrefix := GetContext(ctx).providerHost() + "/" + repo.Org + "/" + name
 
 	nextMajorVersion := stepv2.NamedValue(ctx, "Next major version",
 		versionNewMajor.String())
@@ -696,12 +835,12 @@ var majorVersionBump = stepv2.Func30("Increment Major Version", func(
 
 	stepv2.WithCwd(ctx, *repo.providerDir(), func(ctx context.Context) {
 		updateFile(ctx, "Update Go Module (provider)", "go.mod",
-			"module github.com/"+repo.Org+"/"+name+"/provider{}")
+			"module "+modPrefix+"/provider{}")
 	})
 
 	stepv2.WithCwd(ctx, *repo.sdkDir(), func(ctx context.Context) {
 		updateFile(ctx, "Update Go Module (sdk)", "go.mod",
-			"module github.com/"+repo.Org+"/"+name+"/sdk{}")
+			"module "+modPrefix+"/sdk{}")
 	})
 
 	stepv2.Func00E("Update Go Imports", func(ctx context.Context) error {
@@ -714,8 +853,8 @@ var majorVersionBump = stepv2.Func30("Increment Major Version", func(
 			data := stepv2.ReadFile(ctx, path)
 
 			new := strings.ReplaceAll(data,
-				"github.com/"+repo.Org+"/"+name+"/provider"+prev,
-				"github.com/"+repo.Org+"/"+name+"/provider"+next,
+				modPrefix+"/provider"+prev,
+				modPrefix+"/provider"+next,
 			)
 
 			if !goMod.Kind.IsPatched() {
@@ -885,25 +1024,24 @@ func updateFile(ctx context.Context, path string, update func(context.Context, s
 // applyPulumiVersion reads the current Pulumi SDK version from provider/go.mod and applies it to:
 // sdk/go.mod
 // examples/go.mod - we also infer the `pkg` version here and add it.
-func applyPulumiVersion(ctx context.Context, repo ProviderRepo) step.Step {
+var applyPulumiVersion = stepv2.Func10("Upgrade Pulumi version in all places", func(
+	ctx context.Context, repo ProviderRepo,
+) {
 	// When we've updated the bridge version, we need to update the corresponding pulumi version in sdk/go.mod.
 	// It needs to match the version used in provider/go.mod, which is *not* necessarily `latest`.
-	var newSdkVersion string
+	newSdkVersion := getPulumiVersionFromProvider(ctx, repo)
 
-	goGet := func(pack string) step.Step {
-		return step.Computed(func() step.Step {
-			return step.Cmd("go", "get",
+	goGet := func(dir, pack string) {
+		stepv2.WithCwd(ctx, dir, func(ctx context.Context) {
+			stepv2.Cmd(ctx, "go", "get",
 				"github.com/pulumi/pulumi/"+pack+"/v3@"+newSdkVersion)
 		})
 	}
 
-	return step.Combined("Upgrade Pulumi version in all places",
-		getPulumiVersionFromProvider(repo, &newSdkVersion),
-		goGet("sdk").In(repo.sdkDir()),
-		goGet("sdk").In(repo.examplesDir()),
-		goGet("pkg").In(repo.examplesDir()),
-	)
-}
+	goGet(*repo.sdkDir(), "sdk")
+	goGet(*repo.examplesDir(), "sdk")
+	goGet(*repo.examplesDir(), "pkg")
+})
 
 func pulumiVersionFromProvider(repo ProviderRepo) (string, error) {
 	modFile := filepath.Join(repo.root, "provider", "go.mod")
@@ -918,11 +1056,21 @@ func pulumiVersionFromProvider(repo ProviderRepo) (string, error) {
 	return pulumiMod.Version, nil
 }
 
-func getPulumiVersionFromProvider(repo ProviderRepo, dest *string) step.Step {
-	return step.F("Get Pulumi SDK version", func(context.Context) (string, error) {
-		return pulumiVersionFromProvider(repo)
-	}).AssignTo(dest)
-}
+var getPulumiVersionFromProvider = stepv2.Func11E("Get Pulumi SDK version", func(
+	ctx context.Context, repo ProviderRepo,
+) (string, error) {
+	modFile := filepath.Join(repo.root, "provider", "go.mod")
+	lookupModule := "github.com/pulumi/pulumi/sdk/v3"
+	pulumiMod, found, err := requiredVersionOf(modFile, []byte(stepv2.ReadFile(ctx, modFile)), lookupModule)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("%s: %s not found", modFile, lookupModule)
+	}
+	stepv2.SetLabel(ctx, pulumiMod.Version)
+	return pulumiMod.Version, nil
+})
 
 func goVersionFromProvider(repo ProviderRepo) (string, error) {
 	modFile := filepath.Join(repo.root, "provider", "go.mod")
@@ -1034,7 +1182,8 @@ var planBridgeUpgrade = stepv2.Func11E("Planning Bridge Upgrade", func(
 
 		return found(v)
 	case *Latest:
-		refs := gitRefsOfV2(ctx, "https://github.com/pulumi/pulumi-terraform-bridge.git", "tags")
+		refs := gitRefsOfV2(ctx,
+			"https://"+GetContext(ctx).bridgeHost()+"/pulumi/pulumi-terraform-bridge.git", "tags")
 		latest := latestSemverTag("", refs)
 		// If our target upgrade version is the same as our
 		// current version, we skip the update.
@@ -1073,14 +1222,9 @@ var planPluginSDKUpgrade = stepv2.Func12E("Planning Plugin SDK Upgrade", func(
 		contract.Failf("Unsupported type of Ref: incomplete case match")
 	}
 
-	url := fmt.Sprintf("https://raw.githubusercontent.com/pulumi/pulumi-terraform-bridge/%s/go.mod", r)
+	url := fmt.Sprintf("https://%s/pulumi/pulumi-terraform-bridge/%s/go.mod", GetContext(ctx).rawHost(), r)
 
-	gomodBytes, err := getHTTP(ctx, url)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to get %v: %w", url, err)
-	}
-
-	goMod, err := modfile.Parse("go.mod", gomodBytes, nil)
+	goMod, err := modfile.Parse("go.mod", []byte(getHTTP(ctx, url)), nil)
 	if err != nil {
 		return "", "", fmt.Errorf("failed parse go.mod: %w", err)
 	}
@@ -1117,42 +1261,24 @@ var parseUpstreamProviderOrg = stepv2.Func11E("Get UpstreamOrg from module versi
 })
 
 // closeSupersededBridgePRs closes any open "Upgrade pulumi-terraform-bridge" PRs
-// authored by the current user, except the PR on keepBranch (the PR we just created or updated).
+// authored by the current user, except the PR from keepHead (the PR we just created or updated).
+// keepHead is given as in PullRequestQuery, so PRs from forks are told apart by their owner.
 // Each closed PR gets a comment pointing at newPrURL.
 //
-// A failing gh call halts the upgrade, matching the style of other gh operations in this file.
+// A failing GitHub call halts the upgrade, matching the style of other GitHub operations in this file.
 var closeSupersededBridgePRs = stepv2.Func30E("Close superseded bridge PRs", func(
-	ctx context.Context, repo, keepBranch, newPrURL string,
+	ctx context.Context, repo, keepHead, newPrURL string,
 ) error {
-	raw := stepv2.Cmd(ctx, "gh",
-		"pr", "list",
-		"--repo", repo,
-		"--state", "open",
-		"--search", `author:@me in:title "Upgrade pulumi-terraform-bridge"`,
-		"--json", "number,headRefName",
-	)
-
-	var prs []struct {
-		Number      int    `json:"number"`
-		HeadRefName string `json:"headRefName"`
-	}
-	if err := json.Unmarshal([]byte(raw), &prs); err != nil {
-		return fmt.Errorf("parse gh pr list output: %w", err)
-	}
+	prs := listPullRequests(ctx, repo, PullRequestQuery{
+		Author: "@me",
+		Title:  "Upgrade pulumi-terraform-bridge",
+	})
 
 	for _, pr := range prs {
-		if pr.HeadRefName == keepBranch {
+		if pr.hasHead(repo, keepHead) {
 			continue
 		}
-		pr := pr
-		stepv2.Func10E(fmt.Sprintf("Close #%d", pr.Number), func(ctx context.Context, num string) error {
-			stepv2.Cmd(ctx, "gh",
-				"pr", "close", num,
-				"--repo", repo,
-				"--comment", "Superseded by "+newPrURL,
-			)
-			return nil
-		})(ctx, fmt.Sprintf("%d", pr.Number))
+		closePullRequest(ctx, repo, pr.Number, "Superseded by "+newPrURL)
 	}
 	return nil
 })
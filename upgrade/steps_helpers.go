@@ -2,7 +2,6 @@ package upgrade
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -29,40 +28,6 @@ func modPathWithoutVersion(path string) string {
 
 // Find the go module version of needleModule, searching from the default repo branch, not
 // the currently checked out code.
-func originalGoVersionOf(ctx context.Context, repo ProviderRepo, file, needleModule string) (module.Version, bool, error) {
-	data, err := baseFileAt(ctx, repo, file)
-	if err != nil {
-		return module.Version{}, false, err
-	}
-
-	goMod, err := modfile.Parse(file, data, nil)
-	if err != nil {
-		return module.Version{}, false, fmt.Errorf("%s:%s: %w",
-			repo.defaultBranch, file, err)
-	}
-
-	needleModule = modPathWithoutVersion(needleModule)
-
-	for _, req := range goMod.Replace {
-		path := modPathWithoutVersion(req.New.Path)
-		if path == needleModule {
-			return req.New, true, nil
-		}
-	}
-	for _, req := range goMod.Require {
-		path := modPathWithoutVersion(req.Mod.Path)
-		if path == needleModule {
-			return req.Mod, true, nil
-		}
-	}
-	return module.Version{}, false, nil
-}
-
-// Find the go module version of needleModule, searching from the default repo branch, not
-// the currently checked out code.
-//
-// originalGoVersionOfV2 performs the same function as originalGoVersionOf, except that it
-// is stepv2 compatible.
 var originalGoVersionOfV2 = stepv2.Func32E("Original Go Version of", func(ctx context.Context,
 	repo ProviderRepo, file, needleModule string,
 ) (module.Version, bool, error) {
@@ -119,6 +84,12 @@ func currentGoVersionOf(modFile, lookupModule string) (module.Version, bool, err
 	if err != nil {
 		return module.Version{}, false, err
 	}
+	return requiredVersionOf(modFile, fileData, lookupModule)
+}
+
+// Look up the version of the go dependency requirement of a given module in the contents
+// of a modfile.
+func requiredVersionOf(modFile string, fileData []byte, lookupModule string) (module.Version, bool, error) {
 	goMod, err := modfile.Parse(modFile, fileData, nil)
 	if err != nil {
 		return module.Version{}, false, fmt.Errorf("%s: %w",
@@ -157,81 +128,6 @@ func baseFileAtV2(ctx context.Context, repo ProviderRepo, file string) string {
 	return stepv2.Cmd(ctx, "git", "show", repo.defaultBranch+":"+file)
 }
 
-func prTitle(ctx context.Context, target *UpstreamUpgradeTarget, targetBridgeVersion Ref) (string, error) {
-	c := GetContext(ctx)
-	title := c.PRTitlePrefix
-
-	switch {
-	case c.UpgradeProviderVersion:
-		title += fmt.Sprintf("Upgrade %s to v%s", c.UpstreamProviderName, target.Version)
-	case c.UpgradeBridgeVersion:
-		title += "Upgrade pulumi-terraform-bridge to " + targetBridgeVersion.String()
-	case c.TargetPulumiVersion != nil:
-		title += "Test: Upgrade pulumi/{pkg,sdk} to " + c.TargetPulumiVersion.String()
-	default:
-		return "", fmt.Errorf("unknown action")
-	}
-
-	return title, nil
-}
-
-func prBody(ctx context.Context, repo ProviderRepo,
-	upgradeTarget *UpstreamUpgradeTarget, goMod *GoMod,
-	targetBridge Ref, tfSDKUpgrade string, osArgs []string,
-) string {
-	b := new(strings.Builder)
-
-	// We strip out --pr-description since it will appear later in the pr body.
-	for i, v := range osArgs {
-		if v == "--pr-description" {
-			osArgs = append(osArgs[:i], osArgs[i+2:]...)
-			break
-		} else if strings.HasPrefix(v, "--pr-description=") {
-			osArgs = append(osArgs[:i], osArgs[i+1:]...)
-			break
-		}
-	}
-	argsSpliced := strings.Join(osArgs[1:], " ")
-
-	fmt.Fprintf(b, "This PR was generated via `$ upgrade-provider %s`.\n", argsSpliced)
-
-	fmt.Fprintf(b, "\n---\n\n")
-
-	if GetContext(ctx).MajorVersionBump {
-		fmt.Fprintf(b, "- Updating major version from %s to %s.\n", repo.currentVersion, repo.currentVersion.IncMajor())
-	}
-
-	if GetContext(ctx).UpgradeProviderVersion {
-		contract.Assertf(upgradeTarget != nil, "upgradeTarget should always be non-nil")
-		var prev string
-		if repo.currentUpstreamVersion != nil {
-			prev = fmt.Sprintf("from %s ", repo.currentUpstreamVersion)
-		}
-		fmt.Fprintf(b, "- Upgrading %s %s to %s.\n",
-			GetContext(ctx).UpstreamProviderName, prev, upgradeTarget.Version)
-		for _, t := range upgradeTarget.GHIssues {
-			if t.Number > 0 {
-				fmt.Fprintf(b, "\tFixes #%d\n", t.Number)
-			}
-		}
-	}
-	if GetContext(ctx).UpgradeBridgeVersion {
-		fmt.Fprintf(b, "- Upgrading pulumi-terraform-bridge from %s to %s.\n",
-			goMod.Bridge.Version, targetBridge)
-	}
-
-	if parts := strings.Split(tfSDKUpgrade, " -> "); len(parts) == 2 {
-		fmt.Fprintf(b, "- Upgrading pulumi/terraform-plugin-sdk from %s to %s.\n",
-			parts[0], parts[1])
-	}
-
-	if d := GetContext(ctx).PRDescription; d != "" {
-		fmt.Fprintf(b, "\n\n%s\n\n", d)
-	}
-
-	return b.String()
-}
-
 // setCurrentUpstreamFromPatched sets repo.currentUpstreamVersion to the version pointed to in the
 // submodule in the default branch.
 //
@@ -256,7 +152,7 @@ var setCurrentUpstreamFromPatched = stepv2.Func10E("Set Upstream From Patched",
 	remoteURL := strings.TrimSpace(stepv2.Cmd(ctx,
 		"git", "config", "--get", "submodule.upstream.url"))
 
-	allTags := stepv2.Cmd(ctx,
+	allTags := networkCmd(ctx,
 		"git", "ls-remote", "--tags", remoteURL)
 
 	var version string
@@ -293,20 +189,17 @@ var setCurrentUpstreamFromPatched = stepv2.Func10E("Set Upstream From Patched",
 // We don't use the current branch, since applying a partial update could change the current branch,
 // leading to a non idempotent result.
 func setCurrentUpstreamFromPlain(ctx context.Context, repo *ProviderRepo, goMod *GoMod) {
-	f := stepv2.Func50E("Set Current Upstream From Plain", setUpstreamFromRemoteRepo)
-	f(ctx, repo, "tags", filepath.Join("provider", "go.mod"), goMod.Upstream.Path,
-		semver.NewVersion)
+	f := stepv2.Func40E("Set Current Upstream From Plain", setUpstreamFromRemoteRepo)
+	f(ctx, repo, "tags", filepath.Join("provider", "go.mod"), goMod.Upstream.Path)
 }
 
 func setCurrentUpstreamFromShimmed(ctx context.Context, repo *ProviderRepo, goMod *GoMod) {
-	f := stepv2.Func50E("Set Current Upstream From Shimmed", setUpstreamFromRemoteRepo)
-	f(ctx, repo, "tags", filepath.Join("provider", "shim", "go.mod"), goMod.Upstream.Path,
-		semver.NewVersion)
+	f := stepv2.Func40E("Set Current Upstream From Shimmed", setUpstreamFromRemoteRepo)
+	f(ctx, repo, "tags", filepath.Join("provider", "shim", "go.mod"), goMod.Upstream.Path)
 }
 
 func setUpstreamFromRemoteRepo(
 	ctx context.Context, repo *ProviderRepo, kind, goModPath, upstream string,
-	parse func(string) (*semver.Version, error),
 ) error {
 	version, found := originalGoVersionOfV2(ctx, *repo, goModPath, upstream)
 	if !found {
@@ -338,7 +231,7 @@ func setUpstreamFromRemoteRepo(
 	url := "https://" + modPathWithoutVersion(upstream) + ".git"
 	var tagCommits string
 	stepv2.WithCwd(ctx, repo.root, func(ctx context.Context) {
-		tagCommits = stepv2.Cmd(ctx, "git", "ls-remote", "--"+kind, "--quiet", url)
+		tagCommits = networkCmd(ctx, "git", "ls-remote", "--"+kind, "--quiet", url)
 	})
 	for _, line := range strings.Split(tagCommits, "\n") {
 		line = strings.TrimSpace(line)
@@ -358,7 +251,7 @@ func setUpstreamFromRemoteRepo(
 		//	be a tag, and dereference the tag recursively until a non-tag
 		//	object is found.
 		versionComponent = strings.TrimSuffix(versionComponent, "^{}")
-		version, err := parse(versionComponent)
+		version, err := semver.NewVersion(versionComponent)
 		if err != nil {
 			// Its possible that this error is valid, for example if the tag has a path,
 			// such as 'refs/tags/sdk/v2.3.2'. If we needed this to be 100% **correct**,
@@ -376,15 +269,8 @@ func setUpstreamFromRemoteRepo(
 	return fmt.Errorf("no tag commit that matched '%s' in '%s'", rev, url)
 }
 
-func gitRefsOf(ctx context.Context, url, kind string) (refs gitRepoRefs, err error) {
-	err = stepv2.PipelineCtx(ctx, "shim", func(ctx context.Context) {
-		refs = gitRefsOfV2(ctx, url, kind)
-	})
-	return
-}
-
 var gitRefsOfV2 = stepv2.Func21("git refs of", func(ctx context.Context, url, kind string) gitRepoRefs {
-	out := stepv2.Cmd(ctx, "git", "ls-remote", "--"+kind, url)
+	out := networkCmd(ctx, "git", "ls-remote", "--"+kind, url)
 
 	branchesToRefs := map[string]string{}
 	for i, line := range strings.Split(out, "\n") {
@@ -436,33 +322,14 @@ var findCurrentMajorVersion = stepv2.Func21E("Find Current Major Version",
 	},
 )
 
-type releaseInfo struct {
-	Latest *struct {
-		TagName     string `json:"tagName"`
-		PublishedAt string `json:"publishedAt"`
-	} `json:"latestRelease"`
-}
-
-func latestReleaseInfo(ctx context.Context, repo string) (releaseInfo, error) {
-	var info releaseInfo
-	resultString := stepv2.Cmd(ctx, "gh", "repo", "view",
-		repo, "--json=latestRelease")
-	err := json.Unmarshal([]byte(resultString), &info)
-
-	return info, err
-}
-
 var latestReleaseVersion = stepv2.Func12E("Latest Release Version",
 	func(ctx context.Context, repo string) (*semver.Version, bool, error) {
 		stepv2.SetLabelf(ctx, "of %s", repo)
-		rel, err := latestReleaseInfo(ctx, repo)
-		if err != nil {
-			return nil, false, err
-		}
-		if rel.Latest == nil {
+		rel := latestRelease(ctx, repo)
+		if rel == nil {
 			return nil, false, nil
 		}
-		v, err := semver.NewVersion(rel.Latest.TagName)
+		v, err := semver.NewVersion(rel.TagName)
 		if err == nil {
 			stepv2.SetLabelf(ctx, "of %s: %s", repo, v)
 		}
@@ -539,31 +406,17 @@ var getExpectedTarget = stepv2.Func11("Get Expected Target", func(ctx context.Co
 // getExpectedTargetLatest discovers the latest stable release and sets it on UpstreamUpgradeTarget.Version.
 // There is a lot of human error and differing conventions when discovering and defining the "latest" upstream version.
 // For our purposes, we always want to discover the highest, stable, valid semver, version of the upstream provider.
-// We do so by listing the last 30 GitHub releases, extracting the tags from the output result (we eagerly await being
-// able to get this result in json), parsing the tags into versions (filtering out any invalid or non-stable tags),
-// and sorting them.
+// We do so by listing the GitHub releases, parsing their tags into versions (filtering out any drafts, prereleases,
+// invalid or non-stable tags), and sorting them.
 // This is a best-effort approach. There may be edge cases in which these steps do not yield the correct latest release.
 var getExpectedTargetLatest = stepv2.Func01E("From Upstream Releases", func(ctx context.Context) (*UpstreamUpgradeTarget, error) {
 	upstreamRepo := GetContext(ctx).UpstreamProviderOrg + "/" + GetContext(ctx).UpstreamProviderName
-	// TODO: use --json once https://github.com/cli/cli/issues/4572 is fixed
-	releases := stepv2.Cmd(ctx, "gh", "release", "list",
-		"--repo="+upstreamRepo,
-		"--exclude-drafts",
-		"--exclude-pre-releases")
-
-	resultLines := strings.Split(releases, "\n")
-	// Get version tags. This will become much less laborious once we can use json.
 	var tags []string
-	for _, line := range resultLines {
-		// split the result line by tabs - there are four fields
-		fields := strings.Split(line, "\t")
-		// handle empty newlines and other nonstandard output
-		if len(fields) != 4 {
+	for _, release := range listReleases(ctx, GetContext(ctx).upstreamHost(), upstreamRepo) {
+		if release.Draft || release.Prerelease {
 			continue
 		}
-		// the tag name for the release is the third field of the result line.
-		tag := fields[2]
-		tags = append(tags, tag)
+		tags = append(tags, release.TagName)
 	}
 
 	// Parse tags into versions
@@ -602,23 +455,11 @@ var getExpectedTargetLatest = stepv2.Func01E("From Upstream Releases", func(ctx
 var getExpectedTargetFromIssues = stepv2.Func11E("From Issues", func(ctx context.Context,
 	name string,
 ) (*UpstreamUpgradeTarget, error) {
-	issueList := stepv2.Cmd(ctx, "gh", "issue", "list",
-		"--state=open",
-		"--repo="+name,
-		"--limit=100",
-		"--json=title,number")
-	titles := []struct {
-		Title  string `json:"title"`
-		Number int    `json:"number"`
-	}{}
-	err := json.Unmarshal([]byte(issueList), &titles)
-	if err != nil {
-		return nil, err
-	}
+	issues := listIssues(ctx, name, IssueQuery{})
 
 	var upgradeTargetIssues []UpgradeTargetIssue
-	for _, title := range titles {
-		_, nameToVersion, found := strings.Cut(title.Title, "Upgrade terraform-provider-")
+	for _, issue := range issues {
+		_, nameToVersion, found := strings.Cut(issue.Title, "Upgrade terraform-provider-")
 		if !found {
 			continue
 		}
@@ -632,7 +473,7 @@ var getExpectedTargetFromIssues = stepv2.Func11E("From Issues", func(ctx context
 		}
 		upgradeTargetIssues = append(upgradeTargetIssues, UpgradeTargetIssue{
 			Version: v,
-			Number:  title.Number,
+			Number:  issue.Number,
 		})
 	}
 	if len(upgradeTargetIssues) == 0 {
@@ -667,10 +508,7 @@ var createUpstreamUpgradeIssue = stepv2.Func30E("Ensure Upstream Issue", func(ct
 	upstreamOrg := GetContext(ctx).UpstreamProviderOrg
 	title := fmt.Sprintf("Upgrade %s to v%s", upstreamProviderName, version)
 
-	issueAlreadyExists, err := upgradeIssueExits(ctx, title, repoOrg, repoName)
-	if err != nil {
-		return err
-	}
+	issueAlreadyExists := upgradeIssueExits(ctx, title, repoOrg, repoName)
 
 	// Write latest_version=$VERSION to GITHUB_OUTPUT, if it exists for CI control flow.
 	if GITHUB_OUTPUT, found := os.LookupEnv("GITHUB_OUTPUT"); found {
@@ -689,46 +527,24 @@ var createUpstreamUpgradeIssue = stepv2.Func30E("Ensure Upstream Issue", func(ct
 		return nil
 	}
 
-	stepv2.Cmd(ctx,
-		"gh", "issue", "create",
-		"--repo="+repoOrg+"/"+repoName,
-		"--body=Release details: https://github.com/"+upstreamOrg+"/"+upstreamProviderName+"/releases/tag/v"+version+"\n"+upgradeIssueBodyTemplate,
-		"--title="+title,
-		"--label="+"kind/enhancement",
-	)
+	createIssue(ctx, repoOrg+"/"+repoName, NewIssue{
+		Title:  title,
+		Body:   "Release details: https://" + GetContext(ctx).upstreamHost() + "/" + upstreamOrg + "/" + upstreamProviderName + "/releases/tag/v" + version + "\n" + upgradeIssueBodyTemplate,
+		Labels: []string{"kind/enhancement"},
+	})
 
 	return nil
 })
 
-func upgradeIssueExits(ctx context.Context, title, repoOrg, repoName string) (bool, error) {
+func upgradeIssueExits(ctx context.Context, title, repoOrg, repoName string) bool {
 	// Search through existing pulumiupgradeproviderissue issues to see if we've already created one for this version.
-	issues, err := searchIssues(ctx,
-		fmt.Sprintf("--repo=%s", repoOrg+"/"+repoName),
-		fmt.Sprintf("--search=%q", upgradeIssueToken),
-		"--state=open")
-	if err != nil {
-		return false, err
-	}
+	issues := listIssues(ctx, repoOrg+"/"+repoName, IssueQuery{Search: upgradeIssueToken})
 
 	// check for exact title match from search results
 	for _, issue := range issues {
 		if issue.Title == title {
-			return true, nil
+			return true
 		}
 	}
-	return false, nil
-}
-
-type issue struct {
-	Title  string `json:"title"`
-	Number int    `json:"number"`
-}
-
-func searchIssues(ctx context.Context, args ...string) ([]issue, error) {
-	cmdArgs := []string{"issue", "list", "--json=title,number"}
-	cmdArgs = append(cmdArgs, args...)
-	issueList := stepv2.Cmd(ctx, "gh", cmdArgs...)
-	var issues []issue
-	err := json.Unmarshal([]byte(issueList), &issues)
-	return issues, err
+	return false
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/Masterminds/semver/v3"
 	"github.com/hexops/autogold/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/mod/module"
 
 	"github.com/pulumi/upgrade-provider/step/v2"
@@ -86,7 +87,9 @@ func TestPullRequestBody(t *testing.T) {
 		ctx := context.Background()
 		uc := Context{PRDescription: "Some extra description here with links to pulumi/repo#123"}
 		args := []string{"upgrade-provider", "--kind", "bridge", "--pr-description", uc.PRDescription}
-		got := prBody(uc.Wrap(ctx), ProviderRepo{}, nil, nil, nil, "", args)
+		ctx = uc.Wrap(ctx)
+		got, err := prBody(ctx, newTemplateData(ctx, ProviderRepo{}, nil, nil, nil, "", args))
+		require.NoError(t, err)
 		autogold.ExpectFile(t, got)
 	})
 
@@ -94,7 +97,9 @@ func TestPullRequestBody(t *testing.T) {
 		ctx := context.Background()
 		uc := Context{PRDescription: "Some extra description here with links to pulumi/repo#123"}
 		args := []string{"upgrade-provider", "--kind", "bridge", "--pr-description=" + uc.PRDescription}
-		got := prBody(uc.Wrap(ctx), ProviderRepo{}, nil, nil, nil, "", args)
+		ctx = uc.Wrap(ctx)
+		got, err := prBody(ctx, newTemplateData(ctx, ProviderRepo{}, nil, nil, nil, "", args))
+		require.NoError(t, err)
 		autogold.ExpectFile(t, got)
 	})
 
@@ -104,10 +109,86 @@ func TestPullRequestBody(t *testing.T) {
 			UpgradeBridgeVersion: true,
 		}
 		args := []string{"upgrade-provider", "--kind", "bridge", "--pr-description", uc.PRDescription}
-		got := prBody(uc.Wrap(ctx), ProviderRepo{}, nil, &GoMod{
+		ctx = uc.Wrap(ctx)
+		got, err := prBody(ctx, newTemplateData(ctx, ProviderRepo{}, nil, &GoMod{
 			Bridge: module.Version{Version: "v1.2.2"},
 		},
-			&Version{SemVer: semver.MustParse("v1.2.3")}, "", args)
+			&Version{SemVer: semver.MustParse("v1.2.3")}, "", args))
+		require.NoError(t, err)
+		autogold.ExpectFile(t, got)
+	})
+
+	t.Run("release-notes", func(t *testing.T) {
+		ctx := context.Background()
+		uc := Context{
+			UpgradeProviderVersion: true,
+			UpstreamProviderName:   "terraform-provider-example",
+		}
+		args := []string{"upgrade-provider", "pulumi/pulumi-example"}
+		ctx = uc.Wrap(ctx)
+		repo := ProviderRepo{
+			currentUpstreamVersion: semver.MustParse("1.0.0"),
+			upstreamReleaseNotes: []ReleaseNotes{
+				{
+					Version:    "1.2.0",
+					URL:        "https://github.com/example/terraform-provider-example/releases/tag/v1.2.0",
+					Notes:      "- :warning: BREAKING: rename `a`\n- Add `b`",
+					Highlights: 1,
+					Truncated:  true,
+				},
+				{
+					Version: "1.1.0",
+					URL:     "https://github.com/example/terraform-provider-example/releases/tag/v1.1.0",
+					Notes:   "- Fix `c`",
+					Older:   3,
+				},
+			},
+		}
+		got, err := prBody(ctx, newTemplateData(ctx, repo,
+			&UpstreamUpgradeTarget{Version: semver.MustParse("1.2.0")}, nil, nil, "", args))
+		require.NoError(t, err)
+		autogold.ExpectFile(t, got)
+	})
+
+	t.Run("schema", func(t *testing.T) {
+		ctx := context.Background()
+		uc := Context{UpgradeBridgeVersion: true}
+		args := []string{"upgrade-provider", "pulumi/pulumi-example", "--kind", "bridge"}
+		ctx = uc.Wrap(ctx)
+		repo := ProviderRepo{schemaDiff: &SchemaDiff{
+			Resources: TokenChanges{
+				Added:   []string{"example:index/fresh:Fresh"},
+				Renamed: []TokenRename{{From: "example:index/old:Old", To: "example:index/new:New"}},
+			},
+			Functions: TokenChanges{Removed: []string{"example:index/getGone:getGone"}},
+			Properties: []PropertyChange{
+				{Token: "example:index/kept:Kept", Property: "inputs.size", Change: "type", From: "integer", To: "number"},
+				{Token: "example:index/kept:Kept", Property: "inputs.zone", Change: "added", Required: true},
+			},
+		}}
+		got, err := prBody(ctx, newTemplateData(ctx, repo, nil, &GoMod{
+			Bridge: module.Version{Version: "v1.2.2"},
+		}, &Version{SemVer: semver.MustParse("v1.2.3")}, "", args))
+		require.NoError(t, err)
+		autogold.ExpectFile(t, got)
+	})
+
+	t.Run("upstream-resources", func(t *testing.T) {
+		ctx := context.Background()
+		uc := Context{UpgradeBridgeVersion: true}
+		args := []string{"upgrade-provider", "pulumi/pulumi-example", "--kind", "bridge"}
+		ctx = uc.Wrap(ctx)
+		repo := ProviderRepo{newUpstreamResources: &UpstreamResources{
+			Resources: []UpstreamResource{
+				{Name: "example_fresh", Token: "example:index/fresh:Fresh"},
+				{Name: "example_unmapped"},
+			},
+			DataSources: []UpstreamResource{{Name: "example_lookup"}},
+		}}
+		got, err := prBody(ctx, newTemplateData(ctx, repo, nil, &GoMod{
+			Bridge: module.Version{Version: "v1.2.2"},
+		}, &Version{SemVer: semver.MustParse("v1.2.3")}, "", args))
+		require.NoError(t, err)
 		autogold.ExpectFile(t, got)
 	})
 }
@@ -118,7 +199,8 @@ func TestPullRequestTitle(t *testing.T) {
 		uc := Context{PRTitlePrefix: "[TEST]", UpgradeBridgeVersion: true}
 		bridgeVersion, err := ParseRef("v5.3.1")
 		assert.Nil(t, err)
-		got, err := prTitle(uc.Wrap(ctx), nil, bridgeVersion)
+		ctx = uc.Wrap(ctx)
+		got, err := prTitle(ctx, newTemplateData(ctx, ProviderRepo{}, nil, nil, bridgeVersion, "", nil))
 		assert.Nil(t, err)
 		autogold.ExpectFile(t, got)
 	})
@@ -128,7 +210,8 @@ func TestPullRequestTitle(t *testing.T) {
 		uc := Context{PRTitlePrefix: "", UpgradeBridgeVersion: true}
 		bridgeVersion, err := ParseRef("v5.3.1")
 		assert.Nil(t, err)
-		got, err := prTitle(uc.Wrap(ctx), nil, bridgeVersion)
+		ctx = uc.Wrap(ctx)
+		got, err := prTitle(ctx, newTemplateData(ctx, ProviderRepo{}, nil, nil, bridgeVersion, "", nil))
 		assert.Nil(t, err)
 		autogold.ExpectFile(t, got)
 	})
@@ -136,7 +219,9 @@ func TestPullRequestTitle(t *testing.T) {
 	t.Run("provider-upgrade", func(t *testing.T) {
 		ctx := context.Background()
 		uc := Context{PRTitlePrefix: "", UpgradeProviderVersion: true, UpstreamProviderName: "terraform-provider-aws"}
-		got, err := prTitle(uc.Wrap(ctx), &UpstreamUpgradeTarget{Version: semver.MustParse("5.3.0")}, nil)
+		ctx = uc.Wrap(ctx)
+		got, err := prTitle(ctx, newTemplateData(ctx, ProviderRepo{},
+			&UpstreamUpgradeTarget{Version: semver.MustParse("5.3.0")}, nil, nil, "", nil))
 		assert.Nil(t, err)
 		autogold.ExpectFile(t, got)
 	})
@@ -175,19 +260,15 @@ func TestGetExpectedTargetFromUpstream(t *testing.T) {
     ]
   },
   {
-    "name": "gh",
+    "name": "List Releases",
     "inputs": [
-      "gh",
-      [
-        "release",
-        "list",
-        "--repo=cloudflare/terraform-provider-cloudflare",
-        "--exclude-drafts",
-        "--exclude-pre-releases"
-      ]
+      "github.com",
+      "cloudflare/terraform-provider-cloudflare"
     ],
     "outputs": [
-      "v4.19.0\tLatest\tv4.19.0\t2023-11-14T23:37:22Z\n",
+      [
+        {"Name": "v4.19.0", "TagName": "v4.19.0", "PublishedAt": "2023-11-14T23:37:22Z", "Draft": false, "Prerelease": false}
+      ],
       null
     ],
     "impure": true
@@ -263,20 +344,16 @@ func TestGetExpectedTargetFromTarget(t *testing.T) {
     ]
   },
   {
-    "name": "gh",
+    "name": "List Issues",
     "inputs": [
-      "gh",
-      [
-        "issue",
-        "list",
-        "--state=open",
-        "--repo=pulumi/pulumi-cloudflare",
-        "--limit=100",
-        "--json=title,number"
-      ]
+      "pulumi/pulumi-cloudflare",
+      {"State": "", "Search": ""}
     ],
     "outputs": [
-      "[{\"number\":540,\"title\":\"Upgrade terraform-provider-cloudflare to v2.32.0\",\"author\":{\"login\":\"pulumi-bot\"}},{\"number\":538,\"title\":\"Upgrade terraform-provider-cloudflare to v2.31.0\",\"author\":{\"login\":\"app/github-actions\"}}]\n",
+      [
+        {"Number": 540, "Title": "Upgrade terraform-provider-cloudflare to v2.32.0", "Body": "", "URL": "https://github.com/pulumi/pulumi-cloudflare/issues/540", "State": "open"},
+        {"Number": 538, "Title": "Upgrade terraform-provider-cloudflare to v2.31.0", "Body": "", "URL": "https://github.com/pulumi/pulumi-cloudflare/issues/538", "State": "open"}
+      ],
       null
     ],
     "impure": true
@@ -326,22 +403,47 @@ func TestExpectedTargetLatest(t *testing.T) {
 	  ]
 	},
 	{
-	  "name": "gh",
-	  "inputs": [
-		"gh",
-		[
-		  "release",
-		  "list",
-		  "--repo=akamai/terraform-provider-akamai",
-		  "--exclude-drafts",
-		  "--exclude-pre-releases"
-		]
-	  ],
-	  "outputs": [
-		"v5.5.0\tLatest\tv5.5.0\t2023-12-07T15:22:04Z\nv5.4.0\t\tv5.4.0\t2023-10-31T13:18:57Z\nv5.3.0\t\tv5.3.0\t2023-09-26T13:28:16Z\nv5.2.0\t\tv5.2.0\t2023-08-29T14:27:47Z\nv5.1.0\t\tv5.1.0\t2023-08-01T09:37:02Z\nv5.0.1\t\tv5.0.1\t2023-07-12T09:34:26Z\nv5.0.0\t\tv5.0.0\t2023-07-05T11:29:09Z\nv4.1.0\t\tv4.1.0\t2023-06-01T13:02:18Z\nv4.0.0\t\tv4.0.0\t2023-05-30T13:02:37Z\nv3.6.0\t\tv3.6.0\t2023-04-27T08:59:25Z\nv3.5.0\t\tv3.5.0\t2023-03-30T14:03:22Z\nv3.4.0\t\tv3.4.0\t2023-03-02T13:42:38Z\nv3.3.0\t\tv3.3.0\t2023-02-02T09:56:51Z\nv3.2.1\t\tv3.2.1\t2022-12-16T14:06:02Z\nv3.2.0\t\tv3.2.0\t2022-12-15T15:04:40Z\nv3.1.0\t\tv3.1.0\t2022-12-01T12:52:03Z\nv3.0.0\t\tv3.0.0\t2022-10-27T10:24:21Z\nv2.4.2\t\tv2.4.2\t2022-10-04T08:46:49Z\nv2.4.1\t\tv2.4.1\t2022-09-29T13:36:45Z\nv2.3.0\t\tv2.3.0\t2022-08-25T09:06:18Z\nv2.2.0\t\tv2.2.0\t2022-06-30T09:24:03Z\nv2.1.1\t\tv2.1.1\t2022-06-09T11:13:10Z\nv2.1.0\t\tv2.1.0\t2022-06-02T07:44:28Z\nv2.0.0\t\tv2.0.0\t2022-04-28T09:28:19Z\nv1.12.1\t\tv1.12.1\t2022-04-06T08:34:22Z\nv1.12.0\t\tv1.12.0\t2022-04-04T10:52:09Z\nv1.11.0\t\tv1.11.0\t2022-03-03T12:41:25Z\nv1.10.1\t\tv1.10.1\t2022-02-10T10:11:56Z\nv1.10.0\t\tv1.10.0\t2022-01-27T10:39:23Z\nv1.9.1\t\tv1.9.1\t2021-12-16T10:42:24Z\n",
-		null
-	  ],
-	  "impure": true
+		"name": "List Releases",
+		"inputs": [
+			"github.com",
+			"akamai/terraform-provider-akamai"
+		],
+		"outputs": [
+			[
+				{"Name": "v5.5.0", "TagName": "v5.5.0", "PublishedAt": "2023-12-07T15:22:04Z", "Draft": false, "Prerelease": false},
+				{"Name": "v5.4.0", "TagName": "v5.4.0", "PublishedAt": "2023-10-31T13:18:57Z", "Draft": false, "Prerelease": false},
+				{"Name": "v5.3.0", "TagName": "v5.3.0", "PublishedAt": "2023-09-26T13:28:16Z", "Draft": false, "Prerelease": false},
+				{"Name": "v5.2.0", "TagName": "v5.2.0", "PublishedAt": "2023-08-29T14:27:47Z", "Draft": false, "Prerelease": false},
+				{"Name": "v5.1.0", "TagName": "v5.1.0", "PublishedAt": "2023-08-01T09:37:02Z", "Draft": false, "Prerelease": false},
+				{"Name": "v5.0.1", "TagName": "v5.0.1", "PublishedAt": "2023-07-12T09:34:26Z", "Draft": false, "Prerelease": false},
+				{"Name": "v5.0.0", "TagName": "v5.0.0", "PublishedAt": "2023-07-05T11:29:09Z", "Draft": false, "Prerelease": false},
+				{"Name": "v4.1.0", "TagName": "v4.1.0", "PublishedAt": "2023-06-01T13:02:18Z", "Draft": false, "Prerelease": false},
+				{"Name": "v4.0.0", "TagName": "v4.0.0", "PublishedAt": "2023-05-30T13:02:37Z", "Draft": false, "Prerelease": false},
+				{"Name": "v3.6.0", "TagName": "v3.6.0", "PublishedAt": "2023-04-27T08:59:25Z", "Draft": false, "Prerelease": false},
+				{"Name": "v3.5.0", "TagName": "v3.5.0", "PublishedAt": "2023-03-30T14:03:22Z", "Draft": false, "Prerelease": false},
+				{"Name": "v3.4.0", "TagName": "v3.4.0", "PublishedAt": "2023-03-02T13:42:38Z", "Draft": false, "Prerelease": false},
+				{"Name": "v3.3.0", "TagName": "v3.3.0", "PublishedAt": "2023-02-02T09:56:51Z", "Draft": false, "Prerelease": false},
+				{"Name": "v3.2.1", "TagName": "v3.2.1", "PublishedAt": "2022-12-16T14:06:02Z", "Draft": false, "Prerelease": false},
+				{"Name": "v3.2.0", "TagName": "v3.2.0", "PublishedAt": "2022-12-15T15:04:40Z", "Draft": false, "Prerelease": false},
+				{"Name": "v3.1.0", "TagName": "v3.1.0", "PublishedAt": "2022-12-01T12:52:03Z", "Draft": false, "Prerelease": false},
+				{"Name": "v3.0.0", "TagName": "v3.0.0", "PublishedAt": "2022-10-27T10:24:21Z", "Draft": false, "Prerelease": false},
+				{"Name": "v2.4.2", "TagName": "v2.4.2", "PublishedAt": "2022-10-04T08:46:49Z", "Draft": false, "Prerelease": false},
+				{"Name": "v2.4.1", "TagName": "v2.4.1", "PublishedAt": "2022-09-29T13:36:45Z", "Draft": false, "Prerelease": false},
+				{"Name": "v2.3.0", "TagName": "v2.3.0", "PublishedAt": "2022-08-25T09:06:18Z", "Draft": false, "Prerelease": false},
+				{"Name": "v2.2.0", "TagName": "v2.2.0", "PublishedAt": "2022-06-30T09:24:03Z", "Draft": false, "Prerelease": false},
+				{"Name": "v2.1.1", "TagName": "v2.1.1", "PublishedAt": "2022-06-09T11:13:10Z", "Draft": false, "Prerelease": false},
+				{"Name": "v2.1.0", "TagName": "v2.1.0", "PublishedAt": "2022-06-02T07:44:28Z", "Draft": false, "Prerelease": false},
+				{"Name": "v2.0.0", "TagName": "v2.0.0", "PublishedAt": "2022-04-28T09:28:19Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.12.1", "TagName": "v1.12.1", "PublishedAt": "2022-04-06T08:34:22Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.12.0", "TagName": "v1.12.0", "PublishedAt": "2022-04-04T10:52:09Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.11.0", "TagName": "v1.11.0", "PublishedAt": "2022-03-03T12:41:25Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.10.1", "TagName": "v1.10.1", "PublishedAt": "2022-02-10T10:11:56Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.10.0", "TagName": "v1.10.0", "PublishedAt": "2022-01-27T10:39:23Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.9.1", "TagName": "v1.9.1", "PublishedAt": "2021-12-16T10:42:24Z", "Draft": false, "Prerelease": false}
+			],
+			null
+		],
+		"impure": true
 	}
 ]`), "From Upstream Releases", getExpectedTargetLatest)
 }
@@ -366,22 +468,40 @@ func TestFromUpstreamReleasesBetaIgnored(t *testing.T) {
 	  ]
 	},
 	{
-	  "name": "gh",
-	  "inputs": [
-		"gh",
-		[
-		  "release",
-		  "list",
-		  "--repo=cyrilgdn/terraform-provider-postgresql",
-		  "--exclude-drafts",
-		  "--exclude-pre-releases"
-		]
-	  ],
-	  "outputs": [
-		"v1.21.1-beta.1\tLatest\tv1.21.1-beta.1\t2023-11-01T15:46:02Z\nv1.21.0\t\tv1.21.0\t2023-09-10T15:47:25Z\nv1.20.0\t\tv1.20.0\t2023-07-14T15:40:36Z\nv1.19.0\t\tv1.19.0\t2023-03-18T21:39:45Z\nv1.18.0\t\tv1.18.0\t2022-11-26T12:41:47Z\nv1.17.1\t\tv1.17.1\t2022-08-19T18:11:52Z\nv1.17.0\t\tv1.17.0\t2022-08-19T17:11:00Z\nv1.16.0\t\tv1.16.0\t2022-05-08T14:47:45Z\nv1.15.0\t\tv1.15.0\t2022-02-04T16:39:44Z\nv1.14.0\t\tv1.14.0\t2021-08-22T13:58:27Z\nv1.13.0\t\tv1.13.0\t2021-05-21T08:56:31Z\nv1.12.1\t\tv1.12.1\t2021-04-23T12:47:59Z\nv1.13.0-pre1\t\tv1.13.0-pre1\t2021-04-23T12:45:27Z\nv1.12.0\t\tv1.12.0\t2021-03-26T08:39:45Z\nv1.11.2\t\tv1.11.2\t2021-02-16T18:54:47Z\nv1.11.1\t\tv1.11.1\t2021-02-02T21:55:14Z\nv1.11.0\t\tv1.11.0\t2021-01-10T17:08:43Z\nv1.11.0-pre-gocloud\t\tv1.11.0-pre-gocloud\t2021-01-03T15:09:39Z\nv1.10.0\t\tv1.10.0\t2021-01-02T15:25:08Z\nv1.9.0\t\tv1.9.0\t2020-12-21T19:42:22Z\nv1.8.1\t\tv1.8.1\t2020-11-26T14:52:38Z\nv1.8.0\t\tv1.8.0\t2020-11-26T13:05:53Z\nv1.7.2\t\tv1.7.2\t2020-07-30T21:22:38Z\n",
-		null
-	  ],
-	  "impure": true
+		"name": "List Releases",
+		"inputs": [
+			"github.com",
+			"cyrilgdn/terraform-provider-postgresql"
+		],
+		"outputs": [
+			[
+				{"Name": "v1.21.1-beta.1", "TagName": "v1.21.1-beta.1", "PublishedAt": "2023-11-01T15:46:02Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.21.0", "TagName": "v1.21.0", "PublishedAt": "2023-09-10T15:47:25Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.20.0", "TagName": "v1.20.0", "PublishedAt": "2023-07-14T15:40:36Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.19.0", "TagName": "v1.19.0", "PublishedAt": "2023-03-18T21:39:45Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.18.0", "TagName": "v1.18.0", "PublishedAt": "2022-11-26T12:41:47Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.17.1", "TagName": "v1.17.1", "PublishedAt": "2022-08-19T18:11:52Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.17.0", "TagName": "v1.17.0", "PublishedAt": "2022-08-19T17:11:00Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.16.0", "TagName": "v1.16.0", "PublishedAt": "2022-05-08T14:47:45Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.15.0", "TagName": "v1.15.0", "PublishedAt": "2022-02-04T16:39:44Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.14.0", "TagName": "v1.14.0", "PublishedAt": "2021-08-22T13:58:27Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.13.0", "TagName": "v1.13.0", "PublishedAt": "2021-05-21T08:56:31Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.12.1", "TagName": "v1.12.1", "PublishedAt": "2021-04-23T12:47:59Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.13.0-pre1", "TagName": "v1.13.0-pre1", "PublishedAt": "2021-04-23T12:45:27Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.12.0", "TagName": "v1.12.0", "PublishedAt": "2021-03-26T08:39:45Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.11.2", "TagName": "v1.11.2", "PublishedAt": "2021-02-16T18:54:47Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.11.1", "TagName": "v1.11.1", "PublishedAt": "2021-02-02T21:55:14Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.11.0", "TagName": "v1.11.0", "PublishedAt": "2021-01-10T17:08:43Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.11.0-pre-gocloud", "TagName": "v1.11.0-pre-gocloud", "PublishedAt": "2021-01-03T15:09:39Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.10.0", "TagName": "v1.10.0", "PublishedAt": "2021-01-02T15:25:08Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.9.0", "TagName": "v1.9.0", "PublishedAt": "2020-12-21T19:42:22Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.8.1", "TagName": "v1.8.1", "PublishedAt": "2020-11-26T14:52:38Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.8.0", "TagName": "v1.8.0", "PublishedAt": "2020-11-26T13:05:53Z", "Draft": false, "Prerelease": false},
+				{"Name": "v1.7.2", "TagName": "v1.7.2", "PublishedAt": "2020-07-30T21:22:38Z", "Draft": false, "Prerelease": false}
+			],
+			null
+		],
+		"impure": true
 	}
 ]`), "From Upstream Releases", getExpectedTargetLatest)
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -21,7 +22,6 @@ func TestGetWorkingBranch(t *testing.T) {
 		c                   Context
 		targetBridgeVersion Ref
 		upgradeTarget       UpstreamUpgradeTarget
-		branchSuffix        string
 
 		expected    string
 		expectedErr string
@@ -32,7 +32,6 @@ func TestGetWorkingBranch(t *testing.T) {
 				UpgradeProviderVersion: true,
 				UpstreamProviderName:   "foo",
 			},
-			branchSuffix:  "",
 			upgradeTarget: UpstreamUpgradeTarget{Version: semver.MustParse("1.2.3")},
 			expected:      "upgrade-foo-to-v1.2.3",
 		},
@@ -41,7 +40,6 @@ func TestGetWorkingBranch(t *testing.T) {
 				UpgradeBridgeVersion: true,
 				TargetBridgeRef:      &Version{SemVer: semver.MustParse("v1.2.3")},
 			},
-			branchSuffix:        "",
 			targetBridgeVersion: &Version{SemVer: semver.MustParse("1.2.3")},
 			expected:            "upgrade-pulumi-terraform-bridge-to-1.2.3",
 		},
@@ -52,7 +50,6 @@ func TestGetWorkingBranch(t *testing.T) {
 				PRTitlePrefix:        "foo",
 			},
 			targetBridgeVersion: &Version{SemVer: semver.MustParse("1.2.3")},
-			branchSuffix:        "foo",
 			expected:            "upgrade-pulumi-terraform-bridge-to-1.2.3-foo",
 		},
 		{
@@ -62,7 +59,6 @@ func TestGetWorkingBranch(t *testing.T) {
 				PRTitlePrefix:        "[DOWNSTREAM TEST] [PLATFORM]",
 			},
 			targetBridgeVersion: &Version{SemVer: semver.MustParse("1.2.3")},
-			branchSuffix:        "[DOWNSTREAM TEST] [PLATFORM]",
 			expected:            "upgrade-pulumi-terraform-bridge-to-1.2.3-downstreamtestplatform",
 		},
 		{expectedErr: "unknown action"}, // If no action can be produced, we should error.
@@ -73,7 +69,9 @@ func TestGetWorkingBranch(t *testing.T) {
 			t.Parallel()
 
 			err := step.Pipeline(t.Name(), func(ctx context.Context) {
-				actual := getWorkingBranch(ctx, tt.c, tt.targetBridgeVersion, &tt.upgradeTarget, tt.branchSuffix)
+				ctx = tt.c.Wrap(ctx)
+				data := newTemplateData(ctx, ProviderRepo{}, &tt.upgradeTarget, nil, tt.targetBridgeVersion, "", nil)
+				actual := getWorkingBranch(ctx, data)
 				if os.Getenv("CI") == "true" {
 					assert.Regexp(t, "^"+tt.expected+"-ci$", actual)
 				} else {
@@ -108,20 +106,32 @@ func TestGetWorkingBranch(t *testing.T) {
 
 func TestHasRemoteBranch(t *testing.T) {
 	t.Parallel()
+	dependabot := []string{
+		"dependabot/go_modules/provider/golang.org/x/net-0.17.0",
+		"dependabot/go_modules/sdk/golang.org/x/net-0.17.0",
+		"dependabot/go_modules/examples/golang.org/x/net-0.17.0",
+	}
 	tests := []struct {
-		response   string
-		branchName string
-		expect     bool
+		openBranches   []string
+		closedBranches []string
+		branchName     string
+		expect         bool
 	}{
 		{
-			response:   `[{"headRefName":"dependabot/go_modules/provider/golang.org/x/net-0.17.0","title":"Bump golang.org/x/net from 0.13.0 to 0.17.0 in /provider"},{"headRefName":"dependabot/go_modules/sdk/golang.org/x/net-0.17.0","title":"bump golang.org/x/net from 0.10.0 to 0.17.0 in /sdk"},{"headRefName":"dependabot/go_modules/examples/golang.org/x/net-0.17.0","title":"Bump golang.org/x/net from 0.8.0 to 0.17.0 in /examples"}]`,
-			branchName: "upgrade-pulumi-terraform-bridge-to-v3.62.0",
-			expect:     false,
+			openBranches: dependabot,
+			branchName:   "upgrade-pulumi-terraform-bridge-to-v3.62.0",
+			expect:       false,
 		},
 		{
-			response:   `[{"headRefName":"upgrade-pulumi-terraform-bridge-to-v3.62.0","title":"Upgrade pulumi-terraform-bridge to v3.62.0"},{"headRefName":"dependabot/go_modules/provider/golang.org/x/net-0.17.0","title":"Bump golang.org/x/net from 0.13.0 to 0.17.0 in /provider"},{"headRefName":"dependabot/go_modules/sdk/golang.org/x/net-0.17.0","title":"bump golang.org/x/net from 0.10.0 to 0.17.0 in /sdk"},{"headRefName":"dependabot/go_modules/examples/golang.org/x/net-0.17.0","title":"Bump golang.org/x/net from 0.8.0 to 0.17.0 in /examples"}]`,
-			branchName: "upgrade-pulumi-terraform-bridge-to-v3.62.0",
-			expect:     true,
+			openBranches: append([]string{"upgrade-pulumi-terraform-bridge-to-v3.62.0"}, dependabot...),
+			branchName:   "upgrade-pulumi-terraform-bridge-to-v3.62.0",
+			expect:       true,
+		},
+		{
+			openBranches:   dependabot,
+			closedBranches: []string{"upgrade-pulumi-terraform-bridge-to-v3.62.0"},
+			branchName:     "upgrade-pulumi-terraform-bridge-to-v3.62.0",
+			expect:         false,
 		},
 	}
 
@@ -130,27 +140,25 @@ func TestHasRemoteBranch(t *testing.T) {
 		t.Run("", func(t *testing.T) {
 			t.Parallel()
 
-			encode := func(elem any) json.RawMessage {
-				b, err := json.Marshal(elem)
-				require.NoError(t, err)
-				return json.RawMessage(b)
+			repo := &fakeGitHubRepo{}
+			for _, branches := range []struct {
+				state string
+				names []string
+			}{{"open", tt.openBranches}, {"closed", tt.closedBranches}} {
+				for _, branch := range branches.names {
+					repo.PullRequests = append(repo.PullRequests, &fakePullRequest{
+						fakeIssue:   fakeIssue{Number: repo.nextNumber(), Title: branch, State: branches.state},
+						HeadRefName: branch,
+					})
+				}
 			}
 
-			testReplay(context.Background(), t, []*step.Step{
-				{
-					Name:    "Has Existing PR",
-					Inputs:  encode([]string{tt.branchName, "pulumi/pulumi-xyz"}),
-					Outputs: encode([]any{tt.expect, nil}),
-				},
-				{
-					Name: "gh",
-					Inputs: encode([]any{
-						"gh", []string{"pr", "list", "--json=title,headRefName", "--repo=pulumi/pulumi-xyz"},
-					}),
-					Outputs: encode([]any{tt.response, nil}),
-					Impure:  true,
-				},
-			}, "Has Existing PR", hasExistingPr)
+			var got bool
+			runWithGitHub(t, &fakeGitHub{Repos: map[string]*fakeGitHubRepo{"pulumi/pulumi-xyz": repo}},
+				func(ctx context.Context) {
+					got = hasExistingPr(ctx, tt.branchName, "pulumi/pulumi-xyz")
+				})
+			assert.Equal(t, tt.expect, got)
 		})
 	}
 }
@@ -391,18 +399,18 @@ func TestCheckMaintenancePatchWithinCadence(t *testing.T) {
           ]
         },
         {
-          "name": "gh",
+          "name": "Get Latest Release",
           "inputs": [
-            "gh",
-            [
-              "repo",
-              "view",
-              "pulumi/pulumi-cloudinit",
-              "--json=latestRelease"
-            ]
+            "pulumi/pulumi-cloudinit"
           ],
           "outputs": [
-            "{\"latestRelease\":{\"name\":\"v1.4.0\",\"tagName\":\"v1.4.0\",\"url\":\"https://github.com/pulumi/pulumi-cloudinit/releases/tag/v1.4.0\",\"publishedAt\":\"`+fourWeeksAgo+`\"}}\n",
+            {
+              "Name": "v1.4.0",
+              "TagName": "v1.4.0",
+              "PublishedAt": "`+fourWeeksAgo+`",
+              "Draft": false,
+              "Prerelease": false
+            },
             null
           ],
           "impure": true
@@ -429,18 +437,19 @@ func TestCheckMaintenancePatchExpiredCadence(t *testing.T) {
           ]
         },
         {
-          "name": "gh",
+          "name": "Get Latest Release",
           "inputs": [
-            "gh",
-            [
-              "repo",
-              "view",
-              "pulumi/pulumi-cloudinit",
-              "--json=latestRelease"
-            ]
+            "pulumi/pulumi-cloudinit"
           ],
           "outputs": [
-			"{\"latestRelease\":{\"name\":\"v1.4.0\",\"tagName\":\"v1.4.0\",\"url\":\"https://github.com/pulumi/pulumi-cloudinit/releases/tag/v1.4.0\",\"publishedAt\":\"2023-01-04T21:03:48Z\"}}\n",            null
+            {
+              "Name": "v1.4.0",
+              "TagName": "v1.4.0",
+              "PublishedAt": "2023-01-04T21:03:48Z",
+              "Draft": false,
+              "Prerelease": false
+            },
+            null
           ],
           "impure": true
         }
@@ -448,20 +457,7 @@ func TestCheckMaintenancePatchExpiredCadence(t *testing.T) {
 }
 
 func TestPluginSDKUpgrade(t *testing.T) {
-	ctx := context.WithValue(context.Background(), httpHandlerKey, simpleHttpHandler(func(url string) ([]byte, error) {
-		if url == "https://raw.githubusercontent.com/pulumi/pulumi-terraform-bridge/v3.73.0/go.mod" {
-			return []byte(`
-module github.com/pulumi/pulumi-terraform-bridge/v3
-
-go 1.20
-
-replace github.com/pulumi/pulumi-terraform-bridge/x/muxer => ./x/muxer
-
-replace github.com/hashicorp/terraform-plugin-sdk/v2 => github.com/pulumi/terraform-plugin-sdk/v2 v2.0.0-20240129205329-74776a5cd5f9
-`), nil
-		}
-		return nil, fmt.Errorf("not found")
-	}))
+	ctx := context.Background()
 	testReplay((&Context{GoPath: "/Users/myuser/go"}).Wrap(ctx), t, jsonMarshal[[]*step.Step](t, `
 	[
 	  {
@@ -474,10 +470,44 @@ replace github.com/hashicorp/terraform-plugin-sdk/v2 => github.com/pulumi/terraf
 	      "bridge 3.73.0 needs terraform-plugin-sdk v2.0.0-20240129205329-74776a5cd5f9",
 	      null
 	    ]
+	  },
+	  {
+	    "name": "HTTP GET",
+	    "inputs": [
+	      "https://raw.githubusercontent.com/pulumi/pulumi-terraform-bridge/v3.73.0/go.mod"
+	    ],
+	    "outputs": [
+	      "module github.com/pulumi/pulumi-terraform-bridge/v3\n\ngo 1.20\n\nreplace github.com/pulumi/pulumi-terraform-bridge/x/muxer => ./x/muxer\n\nreplace github.com/hashicorp/terraform-plugin-sdk/v2 => github.com/pulumi/terraform-plugin-sdk/v2 v2.0.0-20240129205329-74776a5cd5f9\n",
+	      null
+	    ],
+	    "impure": true
 	  }
 	]`), "Planning Plugin SDK Upgrade", planPluginSDKUpgrade)
 }
 
+func TestPluginSDKUpgradeRawHost(t *testing.T) {
+	var requested []string
+	ctx := context.WithValue(context.Background(), httpHandlerKey, simpleHttpHandler(func(url string) ([]byte, error) {
+		requested = append(requested, url)
+		return []byte(`
+module github.com/pulumi/pulumi-terraform-bridge/v3
+
+replace github.com/hashicorp/terraform-plugin-sdk/v2 => github.com/pulumi/terraform-plugin-sdk/v2 v2.0.0-20240129205329-74776a5cd5f9
+`), nil
+	}))
+	ctx = (&Context{RawHost: "github.example.com/raw/"}).Wrap(ctx)
+
+	var version string
+	err := step.PipelineCtx(ctx, "Plan", func(ctx context.Context) {
+		version, _ = planPluginSDKUpgrade(ctx, "3.73.0")
+	}, step.NullDisplay)
+	require.NoError(t, err)
+	assert.Equal(t, "v2.0.0-20240129205329-74776a5cd5f9", version)
+	assert.Equal(t, []string{
+		"https://github.example.com/raw/pulumi/pulumi-terraform-bridge/v3.73.0/go.mod",
+	}, requested)
+}
+
 type simpleHttpHandler func(string) ([]byte, error)
 
 var _ httpHandler = (*simpleHttpHandler)(nil)
@@ -489,112 +519,229 @@ func (sh simpleHttpHandler) getHTTP(url string) ([]byte, error) {
 func TestCloseSupersededBridgePRs_EmptyList(t *testing.T) {
 	t.Parallel()
 
-	encode := func(elem any) json.RawMessage {
-		b, err := json.Marshal(elem)
-		require.NoError(t, err)
-		return json.RawMessage(b)
+	repo := "pulumi/pulumi-xyz"
+	gh := &fakeGitHub{
+		User:  "pulumi-bot",
+		Repos: map[string]*fakeGitHubRepo{repo: {}},
 	}
+	runWithGitHub(t, gh, func(ctx context.Context) {
+		closeSupersededBridgePRs(ctx, repo,
+			"upgrade-pulumi-terraform-bridge-to-v3.99.0-ci",
+			"https://github.com/pulumi/pulumi-xyz/pull/42")
+	})
+}
+
+func TestCloseSupersededBridgePRs_Filters(t *testing.T) {
+	t.Parallel()
 
 	repo := "pulumi/pulumi-xyz"
 	keepBranch := "upgrade-pulumi-terraform-bridge-to-v3.99.0-ci"
 	newPrURL := "https://github.com/pulumi/pulumi-xyz/pull/42"
 
-	testReplay(context.Background(), t, []*step.Step{
-		{
-			Name:    "Close superseded bridge PRs",
-			Inputs:  encode([]string{repo, keepBranch, newPrURL}),
-			Outputs: encode([]any{nil}),
-		},
-		{
-			Name: "gh",
-			Inputs: encode([]any{
-				"gh", []string{
-					"pr", "list",
-					"--repo", repo,
-					"--state", "open",
-					"--search", `author:@me in:title "Upgrade pulumi-terraform-bridge"`,
-					"--json", "number,headRefName",
-				},
-			}),
-			Outputs: encode([]any{`[]`, nil}),
-			Impure:  true,
-		},
-	}, "Close superseded bridge PRs", closeSupersededBridgePRs)
+	pr := func(number int, author, title, branch string) *fakePullRequest {
+		return &fakePullRequest{
+			fakeIssue: fakeIssue{
+				Number: number,
+				Title:  title,
+				State:  "open",
+				Author: author,
+			},
+			HeadRefName: branch,
+		}
+	}
+	gh := &fakeGitHub{
+		User: "pulumi-bot",
+		Repos: map[string]*fakeGitHubRepo{repo: {PullRequests: []*fakePullRequest{
+			pr(10, "pulumi-bot", "Upgrade pulumi-terraform-bridge to v3.97.0",
+				"upgrade-pulumi-terraform-bridge-to-v3.97.0-ci"),
+			pr(11, "pulumi-bot", "Upgrade pulumi-terraform-bridge to v3.99.0", keepBranch),
+			pr(12, "pulumi-bot", "Upgrade pulumi-terraform-bridge to v3.98.0",
+				"upgrade-pulumi-terraform-bridge-to-v3.98.0-ci"),
+			// Bridge upgrades by other authors are left alone.
+			pr(13, "someone-else", "Upgrade pulumi-terraform-bridge to v3.98.0", "manual-upgrade"),
+			// So are the bot's other PRs.
+			pr(14, "pulumi-bot", "Upgrade terraform-provider-xyz to v1.2.3",
+				"upgrade-terraform-provider-xyz-to-v1.2.3"),
+		}}},
+	}
+
+	runWithGitHub(t, gh, func(ctx context.Context) {
+		closeSupersededBridgePRs(ctx, repo, keepBranch, newPrURL)
+	})
+
+	states := map[int]string{}
+	for _, pr := range gh.Repos[repo].PullRequests {
+		states[pr.Number] = pr.State
+	}
+	assert.Equal(t, map[int]string{10: "closed", 11: "open", 12: "closed", 13: "open", 14: "open"}, states)
+	for _, i := range []int{0, 2} {
+		assert.Equal(t, []string{"Superseded by " + newPrURL}, gh.Repos[repo].PullRequests[i].Comments)
+	}
 }
 
-func TestCloseSupersededBridgePRs_Filters(t *testing.T) {
+func TestCloseSupersededBridgePRs_Forks(t *testing.T) {
 	t.Parallel()
 
-	encode := func(elem any) json.RawMessage {
-		b, err := json.Marshal(elem)
-		require.NoError(t, err)
-		return json.RawMessage(b)
+	repo := "pulumi/pulumi-xyz"
+	branch := "upgrade-pulumi-terraform-bridge-to-v3.99.0"
+	pr := func(number int, headOwner string) *fakePullRequest {
+		return &fakePullRequest{
+			fakeIssue: fakeIssue{
+				Number: number,
+				Title:  "Upgrade pulumi-terraform-bridge to v3.99.0",
+				State:  "open",
+				Author: "pulumi-bot",
+			},
+			HeadRefName:         branch,
+			HeadRepositoryOwner: headOwner,
+		}
+	}
+	gh := &fakeGitHub{
+		User: "pulumi-bot",
+		Repos: map[string]*fakeGitHubRepo{repo: {PullRequests: []*fakePullRequest{
+			pr(10, ""),
+			pr(11, "pulumi-bot"),
+		}}},
 	}
 
-	repo := "pulumi/pulumi-xyz"
-	keepBranch := "upgrade-pulumi-terraform-bridge-to-v3.99.0-ci"
-	newPrURL := "https://github.com/pulumi/pulumi-xyz/pull/42"
+	// A branch of the same name in the repository itself is a different head.
+	runWithGitHub(t, gh, func(ctx context.Context) {
+		closeSupersededBridgePRs(ctx, repo, "pulumi-bot:"+branch, "https://github.com/pulumi/pulumi-xyz/pull/11")
+	})
+	assert.Equal(t, "closed", gh.Repos[repo].PullRequests[0].State)
+	assert.Equal(t, "open", gh.Repos[repo].PullRequests[1].State)
+}
 
-	listJSON := `[
-		{"number": 10, "headRefName": "upgrade-pulumi-terraform-bridge-to-v3.97.0-ci"},
-		{"number": 11, "headRefName": "upgrade-pulumi-terraform-bridge-to-v3.99.0-ci"},
-		{"number": 12, "headRefName": "upgrade-pulumi-terraform-bridge-to-v3.98.0-ci"}
-	]`
-	comment := "Superseded by " + newPrURL
+func TestHasExistingPrFromFork(t *testing.T) {
+	t.Parallel()
 
-	testReplay(context.Background(), t, []*step.Step{
-		{
-			Name:    "Close superseded bridge PRs",
-			Inputs:  encode([]string{repo, keepBranch, newPrURL}),
-			Outputs: encode([]any{nil}),
-		},
-		{
-			Name: "gh",
-			Inputs: encode([]any{
-				"gh", []string{
-					"pr", "list",
-					"--repo", repo,
-					"--state", "open",
-					"--search", `author:@me in:title "Upgrade pulumi-terraform-bridge"`,
-					"--json", "number,headRefName",
-				},
-			}),
-			Outputs: encode([]any{listJSON, nil}),
-			Impure:  true,
-		},
-		{
-			Name:    "Close #10",
-			Inputs:  encode([]string{"10"}),
-			Outputs: encode([]any{nil}),
-		},
-		{
-			Name: "gh",
-			Inputs: encode([]any{
-				"gh", []string{
-					"pr", "close", "10",
-					"--repo", repo,
-					"--comment", comment,
-				},
-			}),
-			Outputs: encode([]any{"", nil}),
-			Impure:  true,
-		},
-		{
-			Name:    "Close #12",
-			Inputs:  encode([]string{"12"}),
-			Outputs: encode([]any{nil}),
+	branch := "upgrade-pulumi-terraform-bridge-to-v3.99.0"
+	gh := &fakeGitHub{Repos: map[string]*fakeGitHubRepo{
+		"pulumi/pulumi-xyz": {PullRequests: []*fakePullRequest{{
+			fakeIssue:           fakeIssue{Number: 1, State: "open"},
+			HeadRefName:         branch,
+			HeadRepositoryOwner: "pulumi-bot",
+		}}},
+	}}
+
+	var fromFork, fromRepo, fromOtherFork bool
+	runWithGitHub(t, gh, func(ctx context.Context) {
+		fromFork = hasExistingPr(ctx, "pulumi-bot:"+branch, "pulumi/pulumi-xyz")
+		fromRepo = hasExistingPr(ctx, branch, "pulumi/pulumi-xyz")
+		fromOtherFork = hasExistingPr(ctx, "someone-else:"+branch, "pulumi/pulumi-xyz")
+	})
+	assert.True(t, fromFork)
+	assert.False(t, fromRepo)
+	assert.False(t, fromOtherFork)
+}
+
+func TestEnsureFork(t *testing.T) {
+	t.Parallel()
+
+	gh := &fakeGitHub{
+		User: "pulumi-bot",
+		Repos: map[string]*fakeGitHubRepo{
+			"pulumi/pulumi-xyz":        {},
+			"pulumi-bot/pulumi-abc":    {Parent: "pulumi/pulumi-abc"},
+			"pulumi-bot/not-a-fork":    {},
+			"pulumi-bot/pulumi-xyz-v2": {Parent: "pulumi/pulumi-xyz"},
 		},
-		{
-			Name: "gh",
-			Inputs: encode([]any{
-				"gh", []string{
-					"pr", "close", "12",
-					"--repo", repo,
-					"--comment", comment,
-				},
-			}),
-			Outputs: encode([]any{"", nil}),
-			Impure:  true,
+	}
+	ctx := WithGitHub((&Context{}).Wrap(context.Background()), gh)
+	ensure := func(fork string) error {
+		return step.PipelineCtx(ctx, "test", func(ctx context.Context) {
+			ensureFork(ctx, "pulumi/pulumi-xyz", fork)
+		}, step.NullDisplay)
+	}
+
+	// Missing forks are created.
+	require.NoError(t, ensure("pulumi-bot/pulumi-xyz"))
+	assert.Equal(t, "pulumi/pulumi-xyz", gh.Repos["pulumi-bot/pulumi-xyz"].Parent)
+
+	require.NoError(t, ensure("pulumi-bot/pulumi-xyz-v2"))
+	assert.ErrorContains(t, ensure("pulumi-bot/not-a-fork"),
+		"cannot open a pull request from pulumi-bot/not-a-fork: it is not a fork of pulumi/pulumi-xyz")
+	assert.ErrorContains(t, ensure("pulumi-bot/pulumi-abc"), "it is not a fork of pulumi/pulumi-xyz")
+
+	// Waiting for a new fork is replayed, rather than repeated.
+	slow := (&Context{NetworkRetries: 1, NetworkRetryBackoff: time.Hour}).Wrap(context.Background())
+	testReplay(slow, t, jsonMarshal[[]*step.Step](t, `[
+  {
+    "name": "Ensure Fork",
+    "inputs": ["pulumi/pulumi-xyz", "pulumi-bot/pulumi-new"],
+    "outputs": [null]
+  },
+  {
+    "name": "Get Repository",
+    "inputs": ["pulumi-bot/pulumi-new"],
+    "outputs": [null, null],
+    "impure": true
+  },
+  {
+    "name": "Create Fork",
+    "inputs": ["pulumi/pulumi-xyz", "pulumi-bot/pulumi-new"],
+    "outputs": [{"FullName": "pulumi-bot/pulumi-new", "Fork": true, "Parent": "pulumi/pulumi-xyz"}, null],
+    "impure": true
+  },
+  {
+    "name": "Wait For Fork",
+    "inputs": ["pulumi/pulumi-xyz", "pulumi-bot/pulumi-new"],
+    "outputs": [{"FullName": "pulumi-bot/pulumi-new", "Fork": true, "Parent": "pulumi/pulumi-xyz"}, null],
+    "impure": true
+  }
+]`), "Ensure Fork", ensureFork)
+}
+
+// The provider and its upstream may be on different hosts, such as a provider mirrored
+// to GitHub Enterprise with its upstream on github.com.
+func TestUpstreamHost(t *testing.T) {
+	t.Setenv("GITHUB_OUTPUT", filepath.Join(t.TempDir(), "output"))
+
+	upstream := "example/terraform-provider-example"
+	c := &Context{
+		ProviderHost:         "github.example.com",
+		UpstreamHost:         "git.example.org",
+		UpstreamProviderOrg:  "example",
+		UpstreamProviderName: "terraform-provider-example",
+	}
+	gh := &fakeGitHub{
+		User: "pulumi-bot",
+		Repos: map[string]*fakeGitHubRepo{
+			"pulumi/pulumi-example": {Labels: []string{"kind/enhancement"}},
+			upstream:                {Releases: []Release{{Name: "v1.1.0", TagName: "v1.1.0", Body: "Fixes"}}},
 		},
-	}, "Close superseded bridge PRs", closeSupersededBridgePRs)
+	}
+	ctx := WithGitHub(c.Wrap(context.Background()), gh)
+
+	var notes []ReleaseNotes
+	require.NoError(t, step.PipelineCtx(ctx, t.Name(), func(ctx context.Context) {
+		createUpstreamUpgradeIssue(ctx, "pulumi", "pulumi-example", "1.1.0")
+		notes = upstreamReleaseNotes(ctx, upstream, semver.MustParse("1.0.0"), semver.MustParse("1.1.0"))
+	}, step.NullDisplay))
+
+	issues := gh.Repos["pulumi/pulumi-example"].Issues
+	require.Len(t, issues, 1)
+	assert.Contains(t, issues[0].Body,
+		"Release details: https://git.example.org/example/terraform-provider-example/releases/tag/v1.1.0")
+	require.Len(t, notes, 1)
+	assert.Equal(t, "https://git.example.org/example/terraform-provider-example/releases/tag/v1.1.0", notes[0].URL)
+
+	testReplay(ctx, t, jsonMarshal[[]*step.Step](t, `[
+  {
+    "name": "Lookup Tag SHA",
+    "inputs": ["1.1.0"],
+    "outputs": ["deadbeefcafe0000000000000000000000000000", null]
+  },
+  {
+    "name": "git refs of",
+    "inputs": ["https://git.example.org/example/terraform-provider-example", "tags"],
+    "outputs": [{}, null]
+  },
+  {
+    "name": "git",
+    "inputs": ["git", ["ls-remote", "--tags", "https://git.example.org/example/terraform-provider-example"]],
+    "outputs": ["deadbeefcafe0000000000000000000000000000\trefs/tags/v1.1.0\n", null],
+    "impure": true
+  }
+]`), "Lookup Tag SHA", lookupTagSHA)
 }
@@ -0,0 +1,273 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is the data that the PR title, PR body and working branch templates are
+// executed with.
+//
+// Versions are given as they are written elsewhere in the upgrade: upstream and major
+// versions without a "v" prefix, and bridge and plugin SDK versions as they appear in
+// go.mod.
+type TemplateData struct {
+	// The "owner/name" of the provider's repository.
+	Repository string
+	// The name of the upstream provider, such as "terraform-provider-aws".
+	UpstreamProvider string
+
+	// Set when the upstream provider is upgraded. From is empty if the current
+	// version is not known.
+	Upstream *Transition
+	// Set when the bridge is upgraded. From is empty when the upgrade is not yet
+	// planned.
+	Bridge *Transition
+	// Set when the bridge upgrade requires a new pulumi/terraform-plugin-sdk.
+	PluginSDK *Transition
+	// The version that pulumi/{pkg,sdk} are upgraded to, if any.
+	Pulumi string
+	// Set when the provider moves to a new major version. From and To are empty if the
+	// provider's current version is not known.
+	Major *Transition
+
+	// The numbers of the upstream upgrade issues that the PR fixes.
+	Issues []int
+	// The notes of the upstream releases that the upgrade takes in, newest first.
+	ReleaseNotes []ReleaseNotes
+	// The arguments upgrade-provider was run with, without --pr-description.
+	CommandLine string
+	// The values of --pr-title-prefix and --pr-description.
+	TitlePrefix string
+	Description string
+	// A description of each dependency upgrade, as shown by --no-submit.
+	Targets []string
+	// How the provider's Pulumi schema changed. Nil if the schema was not compared.
+	Schema *SchemaDiff
+	// The upstream resources and data sources that the upgrade maps for the first time,
+	// and those that are not mapped. Nil if tfgen has not run.
+	NewUpstreamResources *UpstreamResources
+	// Set when running in CI.
+	CI bool
+}
+
+// Transition is an upgrade of a dependency from one version to another.
+type Transition struct {
+	From, To string
+}
+
+// The built-in templates, which are used unless others are configured.
+const (
+	defaultTitleTemplate = `{{.TitlePrefix}}
+{{- if .Upstream}}Upgrade {{.UpstreamProvider}} to v{{.Upstream.To}}
+{{- else if .Bridge}}Upgrade pulumi-terraform-bridge to {{.Bridge.To}}
+{{- else if .Pulumi}}Test: Upgrade pulumi/{pkg,sdk} to {{.Pulumi}}
+{{- end}}`
+
+	defaultBodyTemplate = "This PR was generated via `$ upgrade-provider {{.CommandLine}}`." + `
+
+---
+
+{{with .Major}}- Updating major version from {{.From}} to {{.To}}.
+{{end}}
+{{- with .Upstream}}- Upgrading {{$.UpstreamProvider}} {{with .From}}from {{.}} {{end}} to {{.To}}.
+{{range $.Issues}}	Fixes #{{.}}
+{{end}}{{end}}
+{{- with .Bridge}}- Upgrading pulumi-terraform-bridge from {{.From}} to {{.To}}.
+{{end}}
+{{- with .PluginSDK}}- Upgrading pulumi/terraform-plugin-sdk from {{.From}} to {{.To}}.
+{{end}}
+{{- with .ReleaseNotes}}
+### Upstream release notes
+{{range .}}
+<details>
+<summary>v{{.Version}}{{with .Highlights}} (:warning: {{.}} notable){{end}}</summary>
+
+{{with .Notes}}{{.}}
+
+{{end}}{{if .Truncated}}Truncated, see the [full release notes]({{.URL}}).{{else}}[Release]({{.URL}}){{end}}
+
+</details>
+{{with .Older}}
+{{.}} older releases are not shown.
+{{end}}{{end}}{{end}}
+{{- with .Schema}}{{if not .Empty}}
+### Schema changes
+
+| | Added | Removed | Renamed |
+| --- | --- | --- | --- |
+| Resources | {{len .Resources.Added}} | {{len .Resources.Removed}} | {{len .Resources.Renamed}} |
+| Functions | {{len .Functions.Added}} | {{len .Functions.Removed}} | {{len .Functions.Renamed}} |
+
+{{len .Properties}} changes to the properties of existing resources and functions.
+{{with .BreakingChanges}}
+:warning: **{{len .}} of the changes can break programs that use the provider.**
+{{end}}
+<details>
+<summary>All changes</summary>
+
+| | Change | Breaking |
+| --- | --- | --- |
+{{range .Changes 100}}| {{.Subject}} | {{.Change}} | {{if .Breaking}}Yes{{end}} |
+{{end}}{{with .More 100}}
+And {{.}} more changes.
+{{end}}
+</details>
+{{end}}{{end}}
+{{- with .NewUpstreamResources}}{{if not .Empty}}
+### New and unmapped upstream resources
+
+| Terraform | Kind | Pulumi token |
+| --- | --- | --- |
+{{range .Resources}}| ` + "`{{.Name}}`" + ` | Resource | {{with .Token}}` + "`{{.}}`" + `{{else}}:warning: Not mapped{{end}} |
+{{end}}{{range .DataSources}}| ` + "`{{.Name}}`" + ` | Data source | {{with .Token}}` + "`{{.}}`" + `{{else}}:warning: Not mapped{{end}} |
+{{end}}{{with .Unmapped}}
+:warning: **{{len .}} upstream resources and data sources are not mapped by the provider.**
+{{end}}{{end}}{{end}}
+{{- with .Description}}
+
+{{.}}
+
+{{end}}`
+
+	defaultBranchTemplate = `
+{{- if .Upstream}}upgrade-{{.UpstreamProvider}}-to-v{{.Upstream.To}}{{if .Major}}-major{{end}}
+{{- else if .Bridge}}upgrade-pulumi-terraform-bridge-to-{{.Bridge.To}}
+{{- else if .Pulumi}}upgrade-pulumi-version-to-{{.Pulumi}}
+{{- end}}
+{{- with slug .TitlePrefix}}-{{.}}{{end}}
+{{- if .CI}}-ci{{end}}`
+)
+
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]`)
+
+var templateFuncs = template.FuncMap{
+	// slug lowercases s and removes everything but letters and digits.
+	"slug": func(s string) string {
+		return nonAlphanumeric.ReplaceAllString(strings.ToLower(s), "")
+	},
+}
+
+// ParseTemplate checks that text is a valid template for name.
+func ParseTemplate(name, text string) error {
+	_, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	return err
+}
+
+// executeTemplate executes text, or def if text is empty, with data.
+func executeTemplate(name, text, def string, data TemplateData) (string, error) {
+	if text == "" {
+		text = def
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// newTemplateData collects the data for templates about upgrading repo.
+func newTemplateData(ctx context.Context, repo ProviderRepo,
+	target *UpstreamUpgradeTarget, goMod *GoMod,
+	targetBridge Ref, tfSDKUpgrade string, osArgs []string,
+) TemplateData {
+	c := GetContext(ctx)
+	data := TemplateData{
+		Repository:           githubRepository(repo),
+		UpstreamProvider:     c.UpstreamProviderName,
+		CommandLine:          commandLine(osArgs),
+		TitlePrefix:          c.PRTitlePrefix,
+		Description:          c.PRDescription,
+		Targets:              proposedUpgradeTargets(c, repo, target, goMod, targetBridge, tfSDKUpgrade),
+		Schema:               repo.schemaDiff,
+		NewUpstreamResources: repo.newUpstreamResources,
+	}
+
+	if c.MajorVersionBump {
+		data.Major = &Transition{}
+		if repo.currentVersion != nil {
+			data.Major.From = repo.currentVersion.String()
+			data.Major.To = repo.currentVersion.IncMajor().String()
+		}
+	}
+	if c.UpgradeProviderVersion && target != nil {
+		data.Upstream = &Transition{To: target.Version.String()}
+		if repo.currentUpstreamVersion != nil {
+			data.Upstream.From = repo.currentUpstreamVersion.String()
+		}
+		data.ReleaseNotes = repo.upstreamReleaseNotes
+		for _, issue := range target.GHIssues {
+			if issue.Number > 0 {
+				data.Issues = append(data.Issues, issue.Number)
+			}
+		}
+	}
+	if c.UpgradeBridgeVersion && targetBridge != nil {
+		data.Bridge = &Transition{To: targetBridge.String()}
+		if goMod != nil {
+			data.Bridge.From = goMod.Bridge.Version
+		}
+	}
+	if parts := strings.Split(tfSDKUpgrade, " -> "); len(parts) == 2 {
+		data.PluginSDK = &Transition{From: parts[0], To: parts[1]}
+	}
+	if c.TargetPulumiVersion != nil {
+		data.Pulumi = c.TargetPulumiVersion.String()
+	}
+	return data
+}
+
+// commandLine joins the arguments of osArgs, leaving out --pr-description since it
+// appears later in the PR body.
+func commandLine(osArgs []string) string {
+	if len(osArgs) == 0 {
+		return ""
+	}
+	args := slices.Clone(osArgs[1:])
+	for i, v := range args {
+		if v == "--pr-description" {
+			args = slices.Delete(args, i, min(i+2, len(args)))
+			break
+		} else if strings.HasPrefix(v, "--pr-description=") {
+			args = slices.Delete(args, i, i+1)
+			break
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// hasAction reports if data describes any upgrade.
+func (data TemplateData) hasAction() bool {
+	return data.Upstream != nil || data.Bridge != nil || data.Pulumi != ""
+}
+
+func prTitle(ctx context.Context, data TemplateData) (string, error) {
+	if !data.hasAction() {
+		return "", fmt.Errorf("unknown action")
+	}
+	text := GetContext(ctx).PRTitleTemplate
+	title, err := executeTemplate("PR title", text, defaultTitleTemplate, data)
+	if err != nil {
+		return "", err
+	}
+	// Template files usually end with a newline, which is not part of the title.
+	if text != "" {
+		title = strings.TrimSpace(title)
+	}
+	if title == "" {
+		return "", fmt.Errorf("the PR title template produced an empty title")
+	}
+	return title, nil
+}
+
+func prBody(ctx context.Context, data TemplateData) (string, error) {
+	return executeTemplate("PR body", GetContext(ctx).PRBodyTemplate, defaultBodyTemplate, data)
+}
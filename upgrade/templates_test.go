@@ -0,0 +1,87 @@
+package upgrade
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/module"
+
+	"github.com/pulumi/upgrade-provider/step/v2"
+)
+
+func TestCustomTemplates(t *testing.T) {
+	t.Setenv("CI", "false")
+
+	c := Context{
+		UpgradeProviderVersion: true,
+		UpgradeBridgeVersion:   true,
+		UpstreamProviderName:   "terraform-provider-example",
+		PRTitlePrefix:          "[auto]",
+		PRTitleTemplate:        "{{.TitlePrefix}} example {{.Upstream.From}} → {{.Upstream.To}}\n",
+		PRBodyTemplate: "Upgrades {{.Repository}}:\n" +
+			"{{range .Targets}}* {{.}}\n{{end}}" +
+			"{{range .Issues}}Closes #{{.}}\n{{end}}",
+		BranchTemplate: "bot/{{.UpstreamProvider}}/{{.Upstream.To}}/{{slug .TitlePrefix}}\n",
+	}
+	ctx := c.Wrap(context.Background())
+	repo := ProviderRepo{
+		Org:                    "pulumi",
+		Name:                   "pulumi-example",
+		currentUpstreamVersion: semver.MustParse("1.2.2"),
+	}
+	data := newTemplateData(ctx, repo,
+		&UpstreamUpgradeTarget{
+			Version:  semver.MustParse("1.2.3"),
+			GHIssues: []UpgradeTargetIssue{{Number: 12}, {Number: 13}},
+		},
+		&GoMod{Bridge: module.Version{Version: "v3.80.0"}},
+		&Version{SemVer: semver.MustParse("v3.81.0")}, "",
+		[]string{"upgrade-provider", "pulumi/pulumi-example"})
+
+	title, err := prTitle(ctx, data)
+	require.NoError(t, err)
+	assert.Equal(t, "[auto] example 1.2.2 → 1.2.3", title)
+
+	body, err := prBody(ctx, data)
+	require.NoError(t, err)
+	assert.Equal(t, `Upgrades pulumi/pulumi-example:
+* terraform-provider-example: 1.2.2 -> 1.2.3
+* pulumi-terraform-bridge: v3.80.0 -> v3.81.0
+Closes #12
+Closes #13
+`, body)
+
+	err = step.Pipeline(t.Name(), func(ctx context.Context) {
+		ctx = c.Wrap(ctx)
+		assert.Equal(t, "bot/terraform-provider-example/1.2.3/auto", getWorkingBranch(ctx, data))
+	})
+	require.NoError(t, err)
+}
+
+func TestTemplateErrors(t *testing.T) {
+	t.Parallel()
+
+	assert.ErrorContains(t, ParseTemplate("pr-title-template", "{{.Upstream.To"), "unclosed action")
+	assert.NoError(t, ParseTemplate("branch-template", defaultBranchTemplate))
+
+	// Fields that TemplateData does not have are reported when the template runs.
+	ctx := (&Context{
+		UpgradeBridgeVersion: true,
+		PRTitleTemplate:      "{{.Nope}}",
+	}).Wrap(context.Background())
+	data := newTemplateData(ctx, ProviderRepo{}, nil, nil,
+		&Version{SemVer: semver.MustParse("v3.81.0")}, "", nil)
+	_, err := prTitle(ctx, data)
+	assert.ErrorContains(t, err, "can't evaluate field Nope")
+
+	// A title that is only whitespace is not a title.
+	ctx = (&Context{
+		UpgradeBridgeVersion: true,
+		PRTitleTemplate:      "{{if .Upstream}}upstream{{end}}\n",
+	}).Wrap(context.Background())
+	_, err = prTitle(ctx, data)
+	assert.ErrorContains(t, err, "empty title")
+}
@@ -0,0 +1,34 @@
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pulumi/upgrade-provider/step/trace"
+)
+
+// The number of steps shown in the summary printed at the end of a traced run.
+const slowestStepsShown = 10
+
+// writeTrace writes the trace of t to --trace-out and prints its slowest steps. Nothing
+// is written or printed unless --trace-out was set.
+func writeTrace(c *Context, t *trace.Tracer) error {
+	if c.TraceOut == "" {
+		return nil
+	}
+	f, err := os.Create(c.TraceOut)
+	if err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	format := c.TraceFormat
+	if format == "" {
+		format = trace.FormatChrome
+	}
+	if err := errors.Join(t.Write(f, format), f.Close()); err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	fmt.Printf("Trace written to %s\n", c.TraceOut)
+	fmt.Println("\nSlowest steps:")
+	return t.WriteSummary(os.Stdout, slowestStepsShown)
+}
@@ -0,0 +1,30 @@
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/upgrade-provider/step/trace"
+)
+
+func TestWriteTrace(t *testing.T) {
+	tracer := trace.New()
+	_, end := trace.Start(trace.WithTracer(context.Background(), tracer), "step", "foo")
+	end(true)
+
+	// Without --trace-out, nothing is written.
+	require.NoError(t, writeTrace(&Context{}, tracer))
+
+	out := filepath.Join(t.TempDir(), "trace.json")
+	require.NoError(t, writeTrace(&Context{TraceOut: out}, tracer))
+	b, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.True(t, json.Valid(b))
+	assert.Contains(t, string(b), `"foo"`)
+}
@@ -11,7 +11,9 @@ import (
 	"strings"
 
 	"github.com/pulumi/upgrade-provider/colorize"
-	"github.com/pulumi/upgrade-provider/step"
+	"github.com/pulumi/upgrade-provider/step/cmdlog"
+	"github.com/pulumi/upgrade-provider/step/redact"
+	"github.com/pulumi/upgrade-provider/step/trace"
 	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
 )
 
@@ -50,18 +52,61 @@ func applyMajorVersionPolicy(ctx context.Context, repo *ProviderRepo, upgradeTar
 	return nil
 }
 
-func UpgradeProvider(ctx context.Context, repoOrg, repoName string) (err error) {
-	// Setup ctx to enable replay tests with stepv2:
-	if file := os.Getenv("PULUMI_REPLAY"); file != "" {
-		var write io.Closer
-		ctx, write = stepv2.WithRecord(ctx, file)
-		defer func() { err = errors.Join(err, write.Close()) }()
+// UpgradeProvider upgrades the provider github.com/<repoOrg>/<repoName>. args is the
+// command line that requested the upgrade, as in os.Args, and is shown in the PR body.
+func UpgradeProvider(ctx context.Context, repoOrg, repoName string, args []string) (err error) {
+	if timeout := GetContext(ctx).Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout,
+			fmt.Errorf("%w: --timeout of %s", context.DeadlineExceeded, timeout))
+		defer cancel()
+	}
+	ctx = stepv2.WithStepTimeouts(ctx, GetContext(ctx).StepTimeouts)
+
+	if redactor := GetContext(ctx).Redactor; redactor != nil {
+		ctx = redact.WithRedactor(ctx, redactor)
+	}
+
+	// Setup ctx to enable replay tests with stepv2. We always record, so that the
+	// recording can be included in diagnostics, but it is only written to disk when
+	// PULUMI_REPLAY is set.
+	var write io.Closer
+	ctx, write = stepv2.WithRecord(ctx, os.Getenv("PULUMI_REPLAY"))
+	defer func() { err = errors.Join(err, write.Close()) }()
+
+	ctx = cmdlog.WithHistory(ctx, cmdlog.NewHistory(diagnosticsHistorySize))
+	logs := cmdlog.NewDir(GetContext(ctx).LogDir)
+	ctx = cmdlog.WithDir(ctx, logs)
+	defer func() {
+		if path := logs.Path(); path != "" {
+			fmt.Printf("Command logs written to %s\n", path)
+		}
+	}()
+	if GetContext(ctx).Verbose {
+		ctx = cmdlog.WithVerbose(ctx)
 	}
+	if target := GetContext(ctx).Events; target != "" {
+		events, err := openEvents(target)
+		if err != nil {
+			return fmt.Errorf("failed to open --events: %w", err)
+		}
+		defer events.Close()
+		ctx = stepv2.WithDisplay(ctx, stepv2.NewEventDisplay(events))
+	}
+
+	tracer := trace.New()
+	ctx = trace.WithTracer(ctx, tracer)
+	defer func() { err = errors.Join(err, writeTrace(GetContext(ctx), tracer)) }()
 
 	repo := ProviderRepo{
 		Name: repoName,
 		Org:  repoOrg,
 	}
+	defer func() {
+		if err != nil {
+			err = withDiagnostics(ctx, &repo, err)
+		}
+	}()
 	var targetBridgeVersion Ref
 	var tfSDKUpgrade string
 	var tfSDKTargetSHA string
@@ -84,6 +129,7 @@ func UpgradeProvider(ctx context.Context, repoOrg, repoName string) (err error)
 
 	err = stepv2.PipelineCtx(ctx, "Discover Provider", func(ctx context.Context) {
 		repo.root = OrgProviderRepos(ctx, repoOrg, repoName)
+		ctx = stepv2.WithEnv(ctx, &stepv2.SetCwd{To: repo.root})
 		// If the user set --repo-path as CWD, assume all git content is already in-place; simply infer the main
 		// branch without pulling anything. Otherwise, pull.
 		if GetContext(ctx).IsCWD() {
@@ -138,6 +184,13 @@ func UpgradeProvider(ctx context.Context, repoOrg, repoName string) (err error)
 		if GetContext(ctx).UpgradeProviderVersion {
 			err := applyMajorVersionPolicy(ctx, &repo, upgradeTarget)
 			stepv2.HaltOnError(ctx, err)
+
+			if repo.currentUpstreamVersion != nil {
+				c := GetContext(ctx)
+				repo.upstreamReleaseNotes = upstreamReleaseNotes(ctx,
+					c.UpstreamProviderOrg+"/"+c.UpstreamProviderName,
+					repo.currentUpstreamVersion, upgradeTarget.Version)
+			}
 		}
 
 		if GetContext(ctx).MajorVersionBump {
@@ -156,19 +209,24 @@ func UpgradeProvider(ctx context.Context, repoOrg, repoName string) (err error)
 		return nil
 	}
 
-	if prTitle, err := prTitle(ctx, upgradeTarget, targetBridgeVersion); err != nil {
+	templateData := newTemplateData(ctx, repo, upgradeTarget, goMod, targetBridgeVersion, tfSDKUpgrade, args)
+	if prTitle, err := prTitle(ctx, templateData); err != nil {
 		return err
 	} else {
 		repo.prTitle = prTitle
 	}
 
-	prTitlePrefix := GetContext(ctx).PRTitlePrefix
-
-	var targetSHA string
 	err = stepv2.PipelineCtx(ctx, "Setup working branch", func(ctx context.Context) {
-		repo.workingBranch = getWorkingBranch(ctx, *GetContext(ctx), targetBridgeVersion, upgradeTarget, prTitlePrefix)
+		ctx = stepv2.WithEnv(ctx, &stepv2.SetCwd{To: repo.root})
+		repo.workingBranch = getWorkingBranch(ctx, templateData)
 		ensureBranchCheckedOut(ctx, repo.workingBranch)
-		repo.prAlreadyExists = hasExistingPr(ctx, repo.workingBranch, repo.Org+"/"+repo.Name)
+		repository := githubRepository(repo)
+		pushRepo := pushRepository(GetContext(ctx), repo)
+		if pushRepo != repository {
+			repo.forkRemote = forkRemote(ctx, repository, pushRepo)
+		}
+		head := prHead(repository, pushRepo, repo.workingBranch)
+		repo.prAlreadyExists = hasExistingPr(ctx, head, repository)
 	})
 	if err != nil {
 		return err
@@ -190,87 +248,6 @@ func UpgradeProvider(ctx context.Context, repoOrg, repoName string) (err error)
 		}()
 	}
 
-	var steps []step.Step
-
-	steps = append(steps, step.Computed(func() step.Step {
-		// No upgrade was planned, so exit
-		if tfSDKTargetSHA == "" {
-			return nil
-		}
-		steps := []step.Step{}
-
-		// If a provider is patched, running `go mod tidy` without running `make
-		// upstream` may be invalid.
-		if goMod.Kind.IsPatched() {
-			steps = append(steps, step.Cmd("make", "upstream").In(&repo.root))
-		}
-
-		steps = append(steps,
-			setTFPluginSDKReplace(ctx, repo, tfSDKTargetSHA),
-			step.Cmd("go", "mod", "tidy").In(repo.providerDir()))
-
-		return step.Combined("Update Plugin SDK", steps...)
-	}))
-
-	if GetContext(ctx).UpgradeProviderVersion {
-		steps = append(steps, UpgradeProviderVersion(ctx, goMod, upgradeTarget.Version, repo, targetSHA))
-	} else if goMod.Kind.IsPatched() {
-		// If we are upgrading the provider version, then the upgrade will leave
-		// `upstream` in a usable state. Otherwise, we need to call `make
-		// upstream` to ensure that the module is valid (for `go get` and `go mod
-		// tidy`.
-		steps = append(steps, step.Cmd("make", "upstream").In(&repo.root))
-	}
-
-	if GetContext(ctx).UpgradeBridgeVersion {
-		steps = append(steps, step.Combined("Upgrade Bridge Version",
-			step.Cmd("go", "get",
-				"github.com/pulumi/pulumi-terraform-bridge/v3@"+targetBridgeVersion.String()),
-			step.Cmd("go", "get", "github.com/hashicorp/terraform-plugin-framework"),
-			step.Cmd("go", "get", "github.com/hashicorp/terraform-plugin-mux"),
-			// pulumi-java was renamed from pulumi-java/pkg to pulumi-java in
-			// pulumi-java#2121. The legacy module's last release (v1.22.0) is
-			// incompatible with pulumi/pulumi v3.232.0+. Go resolves the import
-			// path github.com/pulumi/pulumi-java/pkg/codegen/java to whichever
-			// module has the longest matching prefix, so as long as a stale
-			// `pulumi-java/pkg` require remains, it shadows the new module.
-			// No-op once the require is gone.
-			step.Cmd("go", "mod", "edit",
-				"-droprequire", "github.com/pulumi/pulumi-java/pkg"),
-			step.Cmd("go", "mod", "tidy"),
-		).In(repo.providerDir()))
-	}
-
-	if ref := GetContext(ctx).TargetPulumiVersion; ref != nil {
-		r := func(kind string) string {
-			mod := "github.com/pulumi/pulumi/" + kind + "/v3"
-			return fmt.Sprintf("%[1]s=%[1]s@%s", mod, ref)
-		}
-
-		upgrade := func(name string) step.Step {
-			return step.Combined(name,
-				step.Cmd("go", "mod", "edit",
-					"-replace", r("pkg"),
-					"-replace", r("sdk")),
-				step.Cmd("go", "mod", "tidy"))
-		}
-
-		steps = append(steps, step.Combined("Upgrade Pulumi Version",
-			upgrade("provider").In(repo.providerDir()),
-			upgrade("examples").In(repo.examplesDir()),
-			upgrade("sdk").In(repo.sdkDir()),
-		))
-	}
-
-	if GetContext(ctx).UpgradeBridgeVersion && GetContext(ctx).TargetPulumiVersion == nil {
-		// Having changed the version of pulumi/{sdk,pkg} that we are using, we
-		// need to propagate that change to the go.mod in {sdk,examples}/go.mod
-		//
-		// We make sure that TargetPulumiVersion == "", since we cannot discover
-		// the version of a replace statement.
-		steps = append(steps, applyPulumiVersion(ctx, repo))
-	}
-
 	// Patched-provider upgrades run scripts/upstream.sh, whose checkout command
 	// creates commits inside the upstream submodule. Resolve identity immediately
 	// before entering that flow so git am and the later Git commands can commit.
@@ -281,25 +258,40 @@ func UpgradeProvider(ctx context.Context, repoOrg, repoName string) (err error)
 		}
 	}
 
-	ok := step.Run(ctx, step.Combined("Update Repository", steps...))
-	if !ok {
-		return ErrHandled
+	err = stepv2.PipelineCtx(ctx, "Update Repository",
+		updateRepository(repo, upgradeTarget, goMod, targetBridgeVersion, tfSDKTargetSHA))
+	if err != nil {
+		return err
 	}
 
 	var newPrURL string
 	err = stepv2.PipelineCtx(ctx, "Tfgen & Build SDKs",
-		tfgenAndBuildSDKs(repo, repoName, upgradeTarget, goMod,
-			targetBridgeVersion, tfSDKUpgrade, &newPrURL))
+		tfgenAndBuildSDKs(&repo, repoName, upgradeTarget, goMod,
+			targetBridgeVersion, tfSDKUpgrade, args, &newPrURL))
 	if err != nil {
 		return err
 	}
 
+	if diff := repo.schemaDiff; diff != nil && !GetContext(ctx).MajorVersionBump {
+		if breaking := diff.BreakingChanges(); len(breaking) > 0 {
+			fmt.Printf("\n%s\n", colorize.Warn("Warning: "+breakingChangesMessage(breaking)))
+		}
+	}
+	if resources := repo.newUpstreamResources; resources != nil {
+		if unmapped := resources.Unmapped(); len(unmapped) > 0 {
+			fmt.Printf("\n%s\n", colorize.Warn("Warning: "+unmappedResourcesMessage(unmapped)))
+		}
+	}
+
 	if GetContext(ctx).NoSubmit {
 		// Build the same plan used by InformGitHub, but render it only after the
 		// pipeline and spinner have completed.
-		plan := newGitHubSubmissionPlan(
-			ctx, upgradeTarget, repo, goMod, targetBridgeVersion, tfSDKUpgrade, os.Args,
+		plan, err := newGitHubSubmissionPlan(
+			ctx, upgradeTarget, repo, goMod, targetBridgeVersion, tfSDKUpgrade, args,
 		)
+		if err != nil {
+			return err
+		}
 		fmt.Print(noSubmitOutput(repo, plan, inspectLocalUpgrade(ctx, repo)))
 	} else if newPrURL != "" {
 		fmt.Printf("Link to PR created: %s\n", newPrURL)
@@ -353,7 +345,7 @@ func inspectLocalUpgrade(ctx context.Context, repo ProviderRepo) localUpgradeSta
 }
 
 // noSubmitOutput renders a complete, copyable review and submission checklist.
-// The PR body is emitted verbatim so it matches normal GitHub submission.
+// The PR body is emitted as a new PR would get it, markers included.
 func noSubmitOutput(repo ProviderRepo, plan githubSubmissionPlan, state localUpgradeState) string {
 	var b strings.Builder
 	fmt.Fprintln(&b, "Upgrade completed locally; no branch was pushed and no PR was created or updated.")
@@ -371,6 +363,9 @@ func noSubmitOutput(repo ProviderRepo, plan githubSubmissionPlan, state localUpg
 	field("Local path", repo.root)
 	field("Base branch", plan.BaseBranch)
 	field("Working branch", plan.WorkingBranch)
+	if plan.PushRepository != plan.Repository {
+		field("Push repository", plan.PushRepository)
+	}
 	field("Working tree", state.WorkingTree)
 	field("Commits ahead", fmt.Sprintf("%s (%s)", state.CommitsAhead, state.BaseRef))
 	// Combined upgrades can have more than one target, so render one transition
@@ -382,7 +377,7 @@ func noSubmitOutput(repo ProviderRepo, plan githubSubmissionPlan, state localUpg
 		}
 	}
 
-	// Everything in this section comes from the same plan consumed by gh.
+	// Everything in this section comes from the same plan submitted to GitHub.
 	fmt.Fprintln(&b)
 	fmt.Fprintln(&b, "Proposed PR")
 	fmt.Fprintln(&b, "-----------")
@@ -410,11 +405,13 @@ func noSubmitOutput(repo ProviderRepo, plan githubSubmissionPlan, state localUpg
 		field("Superseded bridge PRs", "(none)")
 	}
 	// Do not indent or otherwise transform the body: users should be able to
-	// compare or copy the exact text that gh would receive.
+	// compare or copy the exact text that a new PR would get. An existing PR keeps
+	// the text around its markers, and only has the region between them replaced.
+	body := generatedPRBody(plan.Body)
 	fmt.Fprintln(&b)
 	fmt.Fprintln(&b, "Body:")
-	fmt.Fprint(&b, plan.Body)
-	if !strings.HasSuffix(plan.Body, "\n") {
+	fmt.Fprint(&b, body)
+	if !strings.HasSuffix(body, "\n") {
 		fmt.Fprintln(&b)
 	}
 
@@ -429,13 +426,19 @@ func noSubmitOutput(repo ProviderRepo, plan githubSubmissionPlan, state localUpg
 	// after merely creating or updating the PR.
 	fmt.Fprintln(&b)
 	fmt.Fprintln(&b, "Default submission actions skipped:")
-	fmt.Fprintf(&b, "  1. git push --set-upstream origin %s --force\n", plan.WorkingBranch)
+	nextAction := 1
+	if plan.PushRepository != plan.Repository {
+		fmt.Fprintf(&b, "  %d. Fork %s to %s, unless it is already forked.\n", nextAction, plan.Repository, plan.PushRepository)
+		nextAction++
+	}
+	fmt.Fprintf(&b, "  %d. git push --set-upstream %s %s --force\n", nextAction, plan.PushRemote, plan.WorkingBranch)
+	nextAction++
 	if plan.ExistingPR {
-		fmt.Fprintln(&b, "  2. Update the existing PR with the title, body, labels, reviewers, and assignee above.")
+		fmt.Fprintf(&b, "  %d. Update the existing PR with the title, body, labels, reviewers, and assignee above.\n", nextAction)
 	} else {
-		fmt.Fprintln(&b, "  2. Create the PR with the base, head, title, body, labels, reviewers, and assignee above.")
+		fmt.Fprintf(&b, "  %d. Create the PR with the base, head, title, body, labels, reviewers, and assignee above.\n", nextAction)
 	}
-	nextAction := 3
+	nextAction++
 	if len(plan.IssueAssignments) > 0 {
 		fmt.Fprintf(&b, "  %d. Assign the listed issues to the PR assignee.\n", nextAction)
 		nextAction++
@@ -451,9 +454,114 @@ func noSubmitOutput(repo ProviderRepo, plan githubSubmissionPlan, state localUpg
 	return b.String()
 }
 
+// updateRepository updates the go.mod files of the provider to the planned versions of
+// the upstream provider, the plugin SDK fork, the bridge and Pulumi.
+func updateRepository(
+	repo ProviderRepo, upgradeTarget *UpstreamUpgradeTarget, goMod *GoMod,
+	targetBridgeVersion Ref, tfSDKTargetSHA string,
+) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		// An empty tfSDKTargetSHA means that no plugin SDK upgrade was planned.
+		if tfSDKTargetSHA != "" {
+			updatePluginSDK(ctx, repo, goMod, tfSDKTargetSHA)
+		}
+
+		if GetContext(ctx).UpgradeProviderVersion {
+			UpgradeProviderVersion(ctx, goMod, upgradeTarget.Version, repo)
+		} else if goMod.Kind.IsPatched() {
+			// If we are upgrading the provider version, then the upgrade will leave
+			// `upstream` in a usable state. Otherwise, we need to call `make
+			// upstream` to ensure that the module is valid (for `go get` and `go mod
+			// tidy`.
+			stepv2.WithCwd(ctx, repo.root, func(ctx context.Context) {
+				stepv2.Cmd(ctx, "make", "upstream")
+			})
+		}
+
+		if GetContext(ctx).UpgradeBridgeVersion {
+			upgradeBridgeVersion(ctx, repo, targetBridgeVersion.String())
+		}
+
+		if ref := GetContext(ctx).TargetPulumiVersion; ref != nil {
+			upgradePulumiVersion(ctx, repo, ref.String())
+		}
+
+		if GetContext(ctx).UpgradeBridgeVersion && GetContext(ctx).TargetPulumiVersion == nil {
+			// Having changed the version of pulumi/{sdk,pkg} that we are using, we
+			// need to propagate that change to the go.mod in {sdk,examples}/go.mod
+			//
+			// We make sure that TargetPulumiVersion == "", since we cannot discover
+			// the version of a replace statement.
+			applyPulumiVersion(ctx, repo)
+		}
+	}
+}
+
+var updatePluginSDK = stepv2.Func30("Update Plugin SDK", func(
+	ctx context.Context, repo ProviderRepo, goMod *GoMod, targetSHA string,
+) {
+	// If a provider is patched, running `go mod tidy` without running `make
+	// upstream` may be invalid.
+	if goMod.Kind.IsPatched() {
+		stepv2.WithCwd(ctx, repo.root, func(ctx context.Context) {
+			stepv2.Cmd(ctx, "make", "upstream")
+		})
+	}
+
+	setTFPluginSDKReplace(ctx, repo, targetSHA)
+	stepv2.WithCwd(ctx, *repo.providerDir(), func(ctx context.Context) {
+		stepv2.Cmd(ctx, "go", "mod", "tidy")
+	})
+})
+
+var upgradeBridgeVersion = stepv2.Func20("Upgrade Bridge Version", func(
+	ctx context.Context, repo ProviderRepo, targetBridgeVersion string,
+) {
+	stepv2.WithCwd(ctx, *repo.providerDir(), func(ctx context.Context) {
+		stepv2.Cmd(ctx, "go", "get",
+			"github.com/pulumi/pulumi-terraform-bridge/v3@"+targetBridgeVersion)
+		stepv2.Cmd(ctx, "go", "get", "github.com/hashicorp/terraform-plugin-framework")
+		stepv2.Cmd(ctx, "go", "get", "github.com/hashicorp/terraform-plugin-mux")
+		// pulumi-java was renamed from pulumi-java/pkg to pulumi-java in
+		// pulumi-java#2121. The legacy module's last release (v1.22.0) is
+		// incompatible with pulumi/pulumi v3.232.0+. Go resolves the import
+		// path github.com/pulumi/pulumi-java/pkg/codegen/java to whichever
+		// module has the longest matching prefix, so as long as a stale
+		// `pulumi-java/pkg` require remains, it shadows the new module.
+		// No-op once the require is gone.
+		stepv2.Cmd(ctx, "go", "mod", "edit",
+			"-droprequire", "github.com/pulumi/pulumi-java/pkg")
+		stepv2.Cmd(ctx, "go", "mod", "tidy")
+	})
+})
+
+var upgradePulumiVersion = stepv2.Func20("Upgrade Pulumi Version", func(
+	ctx context.Context, repo ProviderRepo, ref string,
+) {
+	r := func(kind string) string {
+		mod := "github.com/pulumi/pulumi/" + kind + "/v3"
+		return fmt.Sprintf("%[1]s=%[1]s@%s", mod, ref)
+	}
+
+	for _, dir := range []struct{ name, path string }{
+		{"provider", *repo.providerDir()},
+		{"examples", *repo.examplesDir()},
+		{"sdk", *repo.sdkDir()},
+	} {
+		stepv2.Func00(dir.name, func(ctx context.Context) {
+			stepv2.WithCwd(ctx, dir.path, func(ctx context.Context) {
+				stepv2.Cmd(ctx, "go", "mod", "edit",
+					"-replace", r("pkg"),
+					"-replace", r("sdk"))
+				stepv2.Cmd(ctx, "go", "mod", "tidy")
+			})
+		})(ctx)
+	}
+})
+
 func tfgenAndBuildSDKs(
-	repo ProviderRepo, repoName string, upgradeTarget *UpstreamUpgradeTarget, goMod *GoMod,
-	targetBridgeVersion Ref, tfSDKUpgrade string, newPrURL *string,
+	repo *ProviderRepo, repoName string, upgradeTarget *UpstreamUpgradeTarget, goMod *GoMod,
+	targetBridgeVersion Ref, tfSDKUpgrade string, args []string, newPrURL *string,
 ) func(ctx context.Context) {
 	return func(ctx context.Context) {
 		env := []stepv2.Env{&stepv2.SetCwd{To: repo.root}}
@@ -462,7 +570,7 @@ func tfgenAndBuildSDKs(
 		miseEnvVars := map[string]*stepv2.EnvVar{}
 
 		miseAvailable := false
-		ctx, miseAvailable = runMiseUpgrade(ctx, repo, &env, miseEnvVars)
+		ctx, miseAvailable = runMiseUpgrade(ctx, *repo, &env, miseEnvVars)
 		if miseAvailable {
 			ctx = refreshMiseEnv(ctx, &env, miseEnvVars)
 		}
@@ -480,7 +588,7 @@ func tfgenAndBuildSDKs(
 		})
 
 		if miseAvailable {
-			if updatedCtx, ok := runMiseUpgrade(ctx, repo, &env, miseEnvVars); ok {
+			if updatedCtx, ok := runMiseUpgrade(ctx, *repo, &env, miseEnvVars); ok {
 				ctx = updatedCtx
 				ctx = refreshMiseEnv(ctx, &env, miseEnvVars)
 			}
@@ -488,11 +596,17 @@ func tfgenAndBuildSDKs(
 
 		stepv2.Cmd(ctx, "pulumi", "plugin", "rm", "--all", "--yes")
 
-		stepv2.Cmd(ctx, "make", "tfgen")
+		unmapped := stepv2.CmdParse(ctx, unmappedUpstreamResources, "make", "tfgen")
 
 		stepv2.Cmd(ctx, "git", "add", "--all")
 		gitCommit(ctx, "make tfgen")
 
+		repo.schemaDiff = diffSchema(ctx, *repo)
+		checkBreakingChanges(ctx, repo.schemaDiff)
+
+		repo.newUpstreamResources = newUpstreamResources(ctx, *repo, unmapped)
+		checkUnmappedResources(ctx, repo.newUpstreamResources)
+
 		gen := "generate_sdks"
 
 		stepv2.Cmd(ctx, "make", gen)
@@ -500,7 +614,7 @@ func tfgenAndBuildSDKs(
 		// Update sdk/go.mod's module after rebuilding the go SDK
 		if GetContext(ctx).MajorVersionBump {
 			update := func(_ context.Context, s string) string {
-				base := "module github.com/" + repoName + "/sdk"
+				base := "module " + GetContext(ctx).providerHost() + "/" + repoName + "/sdk"
 				old := base
 				if repo.currentVersion.Major() > 1 {
 					old += fmt.Sprintf("/v%d", repo.currentVersion.Major())
@@ -519,7 +633,7 @@ func tfgenAndBuildSDKs(
 
 		gitCommit(ctx, fmt.Sprintf("make %s", gen))
 
-		*newPrURL = InformGitHub(ctx, upgradeTarget, repo, goMod, targetBridgeVersion, tfSDKUpgrade, os.Args)
+		*newPrURL = InformGitHub(ctx, upgradeTarget, *repo, goMod, targetBridgeVersion, tfSDKUpgrade, args)
 	}
 }
 
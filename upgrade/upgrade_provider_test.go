@@ -9,8 +9,10 @@ import (
 	"runtime"
 	"testing"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/mod/module"
 
 	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
 )
@@ -127,3 +129,103 @@ func TestRunMiseUpgradeScopesInstallAndUpgradeToGoAndPulumi(t *testing.T) {
 		assert.NotContains(t, argv, "node")
 	}
 }
+
+// The root of the provider repo that update_repository_*.json were recorded in.
+const updateRepositoryRoot = "/work/pulumi-example"
+
+type updateRepositoryCase struct {
+	name           string
+	context        Context
+	goMod          GoMod
+	target         string
+	bridge         string
+	tfSDKTargetSHA string
+}
+
+func updateRepositoryCases() []updateRepositoryCase {
+	bridge := module.Version{
+		Path:    "github.com/pulumi/pulumi-terraform-bridge/v3",
+		Version: "v3.80.0",
+	}
+	return []updateRepositoryCase{
+		{
+			name: "plain",
+			context: Context{
+				UpstreamProviderOrg:    "example",
+				UpstreamProviderName:   "terraform-provider-example",
+				UpgradeProviderVersion: true,
+				UpgradeBridgeVersion:   true,
+			},
+			goMod: GoMod{
+				Kind: Plain,
+				Upstream: module.Version{
+					Path:    "github.com/example/terraform-provider-example/v2",
+					Version: "v2.1.0",
+				},
+				Bridge: bridge,
+			},
+			target:         "2.2.0",
+			bridge:         "v3.81.0",
+			tfSDKTargetSHA: "v2.0.0-20240101000000-0123456789ab",
+		},
+		{
+			name: "shimmed",
+			context: Context{
+				UpstreamProviderOrg:    "example",
+				UpstreamProviderName:   "terraform-provider-example",
+				UpgradeProviderVersion: true,
+				UpgradeBridgeVersion:   true,
+				TargetPulumiVersion:    &Version{SemVer: semver.MustParse("v3.110.0")},
+			},
+			goMod: GoMod{
+				Kind: Shimmed,
+				Upstream: module.Version{
+					Path:    "github.com/example/terraform-provider-example",
+					Version: "v1.4.0",
+				},
+				Bridge: bridge,
+			},
+			target: "1.5.0",
+			bridge: "v3.81.0",
+		},
+		{
+			name: "patched",
+			context: Context{
+				UpstreamProviderOrg:    "example",
+				UpstreamProviderName:   "terraform-provider-example",
+				UpgradeProviderVersion: true,
+				UpgradeBridgeVersion:   true,
+			},
+			goMod: GoMod{
+				Kind: Patched,
+				Upstream: module.Version{
+					Path:    "github.com/example/terraform-provider-example",
+					Version: "v1.4.0",
+				},
+				Bridge: bridge,
+			},
+			target:         "1.5.0",
+			bridge:         "v3.81.0",
+			tfSDKTargetSHA: "v2.0.0-20240101000000-0123456789ab",
+		},
+	}
+}
+
+func (tt updateRepositoryCase) run(ctx context.Context, root string) error {
+	c := tt.context
+	repo := ProviderRepo{root: root, Name: "pulumi-example", Org: "pulumi"}
+	target := &UpstreamUpgradeTarget{Version: semver.MustParse(tt.target)}
+	return stepv2.PipelineCtx(c.Wrap(ctx), "Update Repository",
+		updateRepository(repo, target, &tt.goMod,
+			&Version{SemVer: semver.MustParse(tt.bridge)}, tt.tfSDKTargetSHA))
+}
+
+func TestUpdateRepositoryReplay(t *testing.T) {
+	for _, tt := range updateRepositoryCases() {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newReplay(t, "update_repository_"+tt.name)
+			require.NoError(t, tt.run(ctx, updateRepositoryRoot))
+		})
+	}
+}
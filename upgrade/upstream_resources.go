@@ -0,0 +1,196 @@
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	stepv2 "github.com/pulumi/upgrade-provider/step/v2"
+)
+
+// UpstreamResources are the Terraform resources and data sources that an upgrade adds to
+// the provider, and those that the provider does not map.
+type UpstreamResources struct {
+	Resources   []UpstreamResource
+	DataSources []UpstreamResource
+}
+
+// UpstreamResource is a Terraform resource or data source.
+type UpstreamResource struct {
+	// The Terraform name, such as "aws_s3_bucket".
+	Name string
+	// The Pulumi token that Name is mapped to, or empty if it is not mapped.
+	Token string
+}
+
+// Empty reports if there are no new or unmapped resources or data sources.
+func (u *UpstreamResources) Empty() bool {
+	return len(u.Resources) == 0 && len(u.DataSources) == 0
+}
+
+// Unmapped lists the resources and data sources that the provider does not map, as
+// "resource name" or "data source name".
+func (u *UpstreamResources) Unmapped() []string {
+	var unmapped []string
+	for _, r := range u.Resources {
+		if r.Token == "" {
+			unmapped = append(unmapped, "resource "+r.Name)
+		}
+	}
+	for _, d := range u.DataSources {
+		if d.Token == "" {
+			unmapped = append(unmapped, "data source "+d.Name)
+		}
+	}
+	return unmapped
+}
+
+// tfgen warns about each resource and data source that the provider does not map, such as:
+//
+//	warning: TF resource "aws_new_thing" not mapped to the Pulumi provider
+var unmappedWarning = regexp.MustCompile(`TF (resource|data source) "?([\w-]+)"? not mapped`)
+
+// unmappedUpstreamResources finds the resources and data sources that tfgen warned are
+// not mapped in its output.
+func unmappedUpstreamResources(stdout, stderr string) UpstreamResources {
+	var unmapped UpstreamResources
+	seen := map[string]bool{}
+	for _, m := range unmappedWarning.FindAllStringSubmatch(stdout+stderr, -1) {
+		if seen[m[1]+" "+m[2]] {
+			continue
+		}
+		seen[m[1]+" "+m[2]] = true
+		if m[1] == "resource" {
+			unmapped.Resources = append(unmapped.Resources, UpstreamResource{Name: m[2]})
+		} else {
+			unmapped.DataSources = append(unmapped.DataSources, UpstreamResource{Name: m[2]})
+		}
+	}
+	return unmapped
+}
+
+// bridgeMetadataFile returns the path of the metadata that tfgen generates for the
+// provider, relative to the root of repo.
+func bridgeMetadataFile(repo ProviderRepo) string {
+	return path.Join(path.Dir(schemaFile(repo)), "bridge-metadata.json")
+}
+
+// newUpstreamResources lists the resources and data sources that tfgen mapped for the
+// first time, from the bridge metadata on the default branch of repo and the checked
+// out one, together with the unmapped ones that tfgen warned about.
+//
+// tfgen does not remember which resources it warned about before, so unmapped ones are
+// listed whether or not they are new. The list only informs reviewers, so mapped
+// resources are left out instead of failing the upgrade when the metadata cannot be
+// read.
+var newUpstreamResources = stepv2.Func21("New Upstream Resources", func(
+	ctx context.Context, repo ProviderRepo, unmapped UpstreamResources,
+) *UpstreamResources {
+	stepv2.MarkImpure(ctx)
+	file := bridgeMetadataFile(repo)
+	resources, err := func() (*UpstreamResources, error) {
+		base, err := baseFileAt(ctx, repo, file)
+		if err != nil {
+			return nil, err
+		}
+		upgraded, err := os.ReadFile(filepath.Join(repo.root, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, err
+		}
+		return compareBridgeMetadata(base, upgraded)
+	}()
+	if err != nil {
+		stepv2.SetLabelf(ctx, "mapped resources unavailable: %s", err)
+		resources = &UpstreamResources{}
+	}
+	resources.Resources = mergeUpstreamResources(resources.Resources, unmapped.Resources)
+	resources.DataSources = mergeUpstreamResources(resources.DataSources, unmapped.DataSources)
+	if err == nil {
+		stepv2.SetLabelf(ctx, "%d new, %d unmapped",
+			len(resources.Resources)+len(resources.DataSources)-len(resources.Unmapped()),
+			len(resources.Unmapped()))
+	}
+	return resources
+})
+
+// checkUnmappedResources reports the resources and data sources that the provider does
+// not map, failing if c.BlockUnmappedResources is set.
+var checkUnmappedResources = stepv2.Func10E("Check Unmapped Resources", func(
+	ctx context.Context, resources *UpstreamResources,
+) error {
+	var unmapped []string
+	if resources != nil {
+		unmapped = resources.Unmapped()
+	}
+	switch {
+	case len(unmapped) == 0:
+		stepv2.SetLabel(ctx, "none")
+	case GetContext(ctx).BlockUnmappedResources:
+		return errors.New(unmappedResourcesMessage(unmapped))
+	default:
+		stepv2.SetLabelf(ctx, "%d", len(unmapped))
+	}
+	return nil
+})
+
+// unmappedResourcesMessage explains that the provider does not map some upstream
+// resources or data sources, and lists them.
+func unmappedResourcesMessage(unmapped []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "the provider does not map %d upstream resources and data sources:", len(unmapped))
+	for _, u := range unmapped {
+		fmt.Fprintf(&b, "\n  - %s", u)
+	}
+	return b.String()
+}
+
+// The parts of the bridge metadata that are compared.
+type bridgeMetadata struct {
+	AutoAliasing struct {
+		Resources   map[string]bridgeMetadataToken `json:"resources"`
+		DataSources map[string]bridgeMetadataToken `json:"datasources"`
+	} `json:"auto-aliasing"`
+}
+
+type bridgeMetadataToken struct {
+	Current string `json:"current"`
+}
+
+// compareBridgeMetadata lists the resources and data sources mapped in the upgraded
+// bridge metadata but not in base.
+func compareBridgeMetadata(base, upgraded []byte) (*UpstreamResources, error) {
+	var b, u bridgeMetadata
+	if err := json.Unmarshal(base, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse base bridge metadata: %w", err)
+	}
+	if err := json.Unmarshal(upgraded, &u); err != nil {
+		return nil, fmt.Errorf("failed to parse upgraded bridge metadata: %w", err)
+	}
+	added := func(base, upgraded map[string]bridgeMetadataToken) []UpstreamResource {
+		var added []UpstreamResource
+		for _, name := range sortedKeys(upgraded) {
+			if _, ok := base[name]; !ok {
+				added = append(added, UpstreamResource{Name: name, Token: upgraded[name].Current})
+			}
+		}
+		return added
+	}
+	return &UpstreamResources{
+		Resources:   added(b.AutoAliasing.Resources, u.AutoAliasing.Resources),
+		DataSources: added(b.AutoAliasing.DataSources, u.AutoAliasing.DataSources),
+	}, nil
+}
+
+// mergeUpstreamResources adds the unmapped resources to mapped ones, sorted by name.
+func mergeUpstreamResources(mapped, unmapped []UpstreamResource) []UpstreamResource {
+	merged := append(mapped, unmapped...)
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Name < merged[j].Name })
+	return merged
+}
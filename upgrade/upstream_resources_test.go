@@ -0,0 +1,116 @@
+package upgrade
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/upgrade-provider/step/v2"
+)
+
+func TestUnmappedUpstreamResources(t *testing.T) {
+	t.Parallel()
+
+	output := `Generating Pulumi schema...
+warning: TF resource "x_new" not mapped to the Pulumi provider
+warning: TF data source "x_lookup" not mapped to the Pulumi provider
+warning: TF resource "x_new" not mapped to the Pulumi provider
+warning: resource x_other has no description
+`
+	assert.Equal(t, UpstreamResources{
+		Resources:   []UpstreamResource{{Name: "x_new"}},
+		DataSources: []UpstreamResource{{Name: "x_lookup"}},
+	}, unmappedUpstreamResources("", output))
+	assert.Equal(t, UpstreamResources{}, unmappedUpstreamResources("Finished generating schema", ""))
+
+	// tfgen writes its warnings to stderr, among the output of make and the build.
+	stderr, err := os.ReadFile(filepath.Join("testdata", "tfgen-unmapped.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, UpstreamResources{
+		Resources:   []UpstreamResource{{Name: "aws_bedrockagent_flow"}, {Name: "aws_s3tables_table"}},
+		DataSources: []UpstreamResource{{Name: "aws_s3tables_table_bucket"}},
+	}, unmappedUpstreamResources("make tfgen_no_deps\n", string(stderr)))
+}
+
+func TestNewUpstreamResources(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	repo := ProviderRepo{Name: "pulumi-x", root: root, defaultBranch: "main"}
+	file := filepath.Join(root, "provider", "cmd", "pulumi-resource-x", "bridge-metadata.json")
+	runGitTestCommand(t, root, "init", "--initial-branch=main")
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o700))
+	require.NoError(t, os.WriteFile(file, []byte(`{
+  "auto-aliasing": {
+    "resources": {"x_kept": {"current": "x:index/kept:Kept"}},
+    "datasources": {"x_get": {"current": "x:index/getGet:getGet"}}
+  }
+}`), 0o600))
+	runGitTestCommand(t, root, "add", ".")
+	runGitTestCommand(t, root, "-c", "user.name=Test", "-c", "user.email=test@example.com",
+		"commit", "--quiet", "-m", "initial")
+	require.NoError(t, os.WriteFile(file, []byte(`{
+  "auto-aliasing": {
+    "resources": {
+      "x_kept": {"current": "x:index/kept:Kept"},
+      "x_fresh": {"current": "x:index/fresh:Fresh", "majorVersion": 1}
+    },
+    "datasources": {"x_get": {"current": "x:index/getGet:getGet"}}
+  },
+  "mux": {}
+}`), 0o600))
+
+	unmapped := UpstreamResources{
+		Resources:   []UpstreamResource{{Name: "x_a_unmapped"}},
+		DataSources: []UpstreamResource{{Name: "x_lookup"}},
+	}
+	var resources *UpstreamResources
+	err := step.Pipeline(t.Name(), func(ctx context.Context) {
+		resources = newUpstreamResources(ctx, repo, unmapped)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &UpstreamResources{
+		Resources: []UpstreamResource{
+			{Name: "x_a_unmapped"},
+			{Name: "x_fresh", Token: "x:index/fresh:Fresh"},
+		},
+		DataSources: []UpstreamResource{{Name: "x_lookup"}},
+	}, resources)
+	assert.Equal(t, []string{"resource x_a_unmapped", "data source x_lookup"}, resources.Unmapped())
+
+	// Unmapped resources are still reported without bridge metadata on the default branch.
+	repo.Name = "pulumi-y"
+	err = step.Pipeline(t.Name(), func(ctx context.Context) {
+		resources = newUpstreamResources(ctx, repo, unmapped)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &unmapped, resources)
+}
+
+func TestCheckUnmappedResources(t *testing.T) {
+	t.Parallel()
+
+	check := func(c Context, resources *UpstreamResources) error {
+		return step.Pipeline(t.Name(), func(ctx context.Context) {
+			checkUnmappedResources(c.Wrap(ctx), resources)
+		})
+	}
+	mapped := &UpstreamResources{Resources: []UpstreamResource{{Name: "x_fresh", Token: "x:index/fresh:Fresh"}}}
+	unmapped := &UpstreamResources{
+		Resources:   []UpstreamResource{{Name: "x_new"}},
+		DataSources: []UpstreamResource{{Name: "x_lookup"}},
+	}
+
+	assert.NoError(t, check(Context{BlockUnmappedResources: true}, nil))
+	assert.NoError(t, check(Context{BlockUnmappedResources: true}, mapped))
+	assert.NoError(t, check(Context{}, unmapped))
+
+	err := check(Context{BlockUnmappedResources: true}, unmapped)
+	assert.ErrorContains(t, err, `the provider does not map 2 upstream resources and data sources:
+  - resource x_new
+  - data source x_lookup`)
+}
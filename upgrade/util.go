@@ -4,11 +4,14 @@ import (
 	"context"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
 	"golang.org/x/mod/module"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+
+	"github.com/pulumi/upgrade-provider/step/redact"
 )
 
 type contextKeyType struct{}
@@ -67,6 +70,19 @@ type Context struct {
 	// Then UpstreamProviderOrg should be `my-org`.
 	UpstreamProviderOrg string
 
+	// The host of the provider's repository, such as "github.com" or a GitHub Enterprise
+	// host. Empty means "github.com".
+	ProviderHost string
+	// The host of the upstream provider's repository. Empty means "github.com".
+	UpstreamHost string
+	// The host of the pulumi/pulumi-terraform-bridge repository. Empty means "github.com".
+	BridgeHost string
+	// The host, optionally followed by a path, that serves raw files from repositories on
+	// BridgeHost. Empty means "raw.githubusercontent.com".
+	//
+	// GitHub Enterprise serves raw files at "{host}/raw".
+	RawHost string
+
 	// The desired version of pulumi/{pkg,sdk} to link to.
 	//
 	// If TargetPulumiVersion is nil, then pulumi/{pkg,sdk} should follow the bridge.
@@ -81,8 +97,75 @@ type Context struct {
 	PRDescription string
 	PRTitlePrefix string
 
+	// Go text/template sources for the PR title, the PR body and the name of the
+	// working branch, executed with TemplateData. Empty means the built-in template.
+	PRTitleTemplate string
+	PRBodyTemplate  string
+	BranchTemplate  string
+
 	// If true, complete the upgrade locally but skip git push and all GitHub mutations.
 	NoSubmit bool
+
+	// If true, fail when the regenerated schema has breaking changes but the provider's
+	// major version is not bumped. Otherwise, only warn.
+	BlockBreakingChanges bool
+
+	// If true, fail when tfgen reports upstream resources or data sources that the
+	// provider does not map. Otherwise, only warn.
+	BlockUnmappedResources bool
+
+	// The fork to push the working branch to and open the PR from, as "owner" or
+	// "owner/name". The name defaults to the provider repository's name, and the fork is
+	// created if it does not exist. If empty, the branch is pushed to the provider
+	// repository itself.
+	PushRemote string
+
+	// The maximum duration of the whole upgrade. A zero value means no limit.
+	Timeout time.Duration
+	// The maximum duration of individual steps, keyed by step name or command line.
+	//
+	// See stepv2.WithStepTimeouts for how keys are matched.
+	StepTimeouts map[string]time.Duration
+
+	// The number of times to retry network operations that fail with a transient error.
+	NetworkRetries int
+	// The base delay between retries of network operations. The delay grows
+	// quadratically with each retry.
+	NetworkRetryBackoff time.Duration
+
+	// The directory to write a diagnostics archive to when the upgrade fails. If empty,
+	// the system's temporary directory is used.
+	DiagnosticsDir string
+
+	// The directory to create this run's log directory in. If empty, the system's
+	// temporary directory is used.
+	LogDir string
+	// If true, show the latest output of running commands.
+	Verbose bool
+
+	// Where to write a stream of JSON progress events: a file path or "fd:N". If empty,
+	// no events are written.
+	//
+	// See stepv2.NewEventDisplay for the schema.
+	Events string
+
+	// The file to write a trace of the upgrade's pipelines and steps to. If empty, no
+	// trace is written.
+	TraceOut string
+	// The format of the trace: one of trace.Formats.
+	TraceFormat string
+
+	// The file to write the changes to the provider's Pulumi schema to, as JSON. If
+	// empty, the changes are only summarized in the PR body.
+	SchemaDiffOut string
+
+	// Masks secrets in replay recordings, command logs, diagnostics, events and traces.
+	// If nil, only the secrets that are always masked are. See redact.Redactor.
+	Redactor *redact.Redactor
+
+	// The releases of each repository, keyed by "host/owner/name", once listed. See
+	// fetchReleases.
+	releases map[string][]Release
 }
 
 // Check if the user specified operating in the current working directory (CWD) with `--repo-path=.`. In this case the
@@ -106,6 +189,34 @@ func (c *Context) SetRepoPath(p string) {
 	c.repoPath = p
 }
 
+func (c *Context) providerHost() string {
+	if c.ProviderHost == "" {
+		return "github.com"
+	}
+	return c.ProviderHost
+}
+
+func (c *Context) upstreamHost() string {
+	if c.UpstreamHost == "" {
+		return "github.com"
+	}
+	return c.UpstreamHost
+}
+
+func (c *Context) bridgeHost() string {
+	if c.BridgeHost == "" {
+		return "github.com"
+	}
+	return c.BridgeHost
+}
+
+func (c *Context) rawHost() string {
+	if c.RawHost == "" {
+		return "raw.githubusercontent.com"
+	}
+	return strings.TrimSuffix(c.RawHost, "/")
+}
+
 type HandledError struct{}
 
 var ErrHandled = HandledError{}
@@ -125,6 +236,9 @@ type ProviderRepo struct {
 	prTitle string
 	// If there is already a PR on GitHub who is merging from `repo.workingBranch`.
 	prAlreadyExists bool
+	// The URL of the fork that the working branch is pushed to, if it is pushed to a
+	// fork. See forkRemote.
+	forkRemote string
 
 	// The highest version tag released on the repo
 	currentVersion *semver.Version
@@ -132,6 +246,12 @@ type ProviderRepo struct {
 	// The upstream version we are upgrading from.  Because not all upstream providers
 	// are go module compliment, we might not be able to always resolve this version.
 	currentUpstreamVersion *semver.Version
+	// The notes of the upstream releases between currentUpstreamVersion and the target.
+	upstreamReleaseNotes []ReleaseNotes
+	// How the Pulumi schema changed, once it has been regenerated.
+	schemaDiff *SchemaDiff
+	// The new and unmapped upstream resources, once the schema has been regenerated.
+	newUpstreamResources *UpstreamResources
 
 	Name string
 	Org  string